@@ -3,9 +3,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"assignment-2/constants"
@@ -36,6 +41,28 @@ func main() {
 		}
 	}()
 
+	// Restrict the URLs webhooks may target; private and reserved addresses are refused
+	// unless they are allow-listed
+	err := handlers.ConfigureWebhookPolicy(
+		tools.GetEnvList("WEBHOOK_ALLOWED_SCHEMES", strings.Split(constants.WEBHOOK_ALLOWED_SCHEMES, ",")),
+		tools.GetEnvList("WEBHOOK_ALLOW_HOSTS", nil),
+		tools.GetEnvList("WEBHOOK_DENY_HOSTS", nil),
+	)
+	if err != nil {
+		log.Fatalf("Invalid webhook URL policy: %v", err)
+	}
+
+	// Webhooks stored with a single 'event' or a country name are moved to 'events' and the
+	// ISO code, which listings filter on
+	if n, err := handlers.MigrateLegacyNotifications(context.Background()); err != nil {
+		log.Printf("Migration of legacy webhooks failed: %v\n", err)
+	} else if n > 0 {
+		log.Printf("Migrated %d legacy webhooks\n", n)
+	}
+
+	// Start the workers that deliver webhooks; deliveries left by the previous run are sent first
+	handlers.StartWebhookQueue(tools.GetEnvInt("WEBHOOK_WORKERS", constants.WEBHOOK_WORKERS))
+
 	// Start a goroutine that periodically purges old cache data every hour
 	go func() {
 		for {
@@ -47,6 +74,34 @@ func main() {
 			} else {
 				log.Println("Periodic cache purge successful")
 			}
+			if err := firebase.PurgeExpiredIdempotencyKeys(ctx); err != nil {
+				log.Printf("Periodic idempotency key purge failed: %v\n", err)
+			}
+			retention := time.Duration(tools.GetEnvInt("REGISTRATION_RETENTION_DAYS", constants.REGISTRATION_RETENTION_DAYS)) * 24 * time.Hour
+			if err := firebase.PurgeDeletedRegistrations(ctx, retention); err != nil {
+				log.Printf("Periodic purge of deleted registrations failed: %v\n", err)
+			}
+			snapshotRetention := time.Duration(tools.GetEnvInt("SNAPSHOT_RETENTION_DAYS", constants.SNAPSHOT_RETENTION_DAYS)) * 24 * time.Hour
+			if err := firebase.PurgeDashboardSnapshots(ctx, snapshotRetention); err != nil {
+				log.Printf("Periodic purge of dashboard snapshots failed: %v\n", err)
+			}
+			deliveryRetention := time.Duration(tools.GetEnvInt("DELIVERY_LOG_RETENTION_DAYS", constants.DELIVERY_LOG_RETENTION_DAYS)) * 24 * time.Hour
+			if err := firebase.PurgeDeliveryAttempts(ctx, deliveryRetention); err != nil {
+				log.Printf("Periodic purge of the webhook delivery log failed: %v\n", err)
+			}
+		}
+	}()
+
+	// Start a goroutine that stores the dashboard snapshots of scheduled registrations
+	go func() {
+		for {
+			time.Sleep(constants.SNAPSHOT_CHECK_MINUTES * time.Minute)
+			ctx := context.Background()
+			if n, err := handlers.TakeDueSnapshots(ctx, time.Now()); err != nil {
+				log.Printf("Dashboard snapshots failed: %v\n", err)
+			} else if n > 0 {
+				log.Printf("Stored %d dashboard snapshots\n", n)
+			}
 		}
 	}()
 
@@ -62,7 +117,32 @@ func main() {
 	// Determine the port from environment or use default
 	port := tools.GetServerPort(constants.DefaultPort)
 
-	// Start the HTTP server
-	fmt.Printf("Server running on port %s (version: %s)\n", port, constants.ServiceVersion)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	// Start the HTTP server, and stop it gracefully on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Evaluate the condition webhooks on a schedule and when cached data is refreshed
+	handlers.StartConditionChecks(ctx, constants.CONDITION_CHECK_MINUTES*time.Minute)
+
+	server := &http.Server{Addr: ":" + port}
+	server.RegisterOnShutdown(handlers.CloseDashboardStreams)
+	go func() {
+		fmt.Printf("Server running on port %s (version: %s)\n", port, constants.ServiceVersion)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+	<-ctx.Done()
+
+	// Finish the requests in progress first, as they may still queue webhooks, then drain
+	// the webhook queue. Deliveries that are not sent in time stay in Firestore.
+	log.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), constants.SHUTDOWN_TIMEOUT_SECONDS*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown: %v\n", err)
+	}
+	if err := handlers.StopWebhookQueue(shutdownCtx); err != nil {
+		log.Printf("Webhook queue shutdown: %v\n", err)
+	}
 }
@@ -15,12 +15,131 @@ const DASHBOARDS_PATH = BASE_PATH + "dashboards/"
 const NOTIFICATIONS_PATH = BASE_PATH + "notifications/"
 const STATUS_PATH = BASE_PATH + "status/"
 
+// Bulk endpoints below REGISTRATIONS_PATH
+const REGISTRATIONS_IMPORT_PATH = REGISTRATIONS_PATH + "import"
+const REGISTRATIONS_EXPORT_PATH = REGISTRATIONS_PATH + "export"
+
+// Ad-hoc dashboards below DASHBOARDS_PATH
+const DASHBOARDS_QUERY_PATH = DASHBOARDS_PATH + "query"
+const DASHBOARDS_BATCH_PATH = DASHBOARDS_PATH + "batch"
+
+// Content types understood by the registration endpoints
+const CONTENT_TYPE_JSON = "application/json"
+const CONTENT_TYPE_MERGE_PATCH = "application/merge-patch+json"
+const CONTENT_TYPE_JSON_PATCH = "application/json-patch+json"
+const CONTENT_TYPE_NDJSON = "application/x-ndjson"
+const CONTENT_TYPE_CSV = "text/csv"
+
+// MAX_PATCH_ATTEMPTS is how often a PATCH without If-Match is retried after losing a concurrent write race
+const MAX_PATCH_ATTEMPTS = 3
+
+// MAX_EXPAND_CONCURRENCY bounds how many dashboards are built in parallel for ?expand=dashboard
+const MAX_EXPAND_CONCURRENCY = 8
+
+// MAX_IMPORT_ROWS is the largest number of registrations accepted by a single bulk import
+const MAX_IMPORT_ROWS = 1000
+
+// MAX_BATCH_DASHBOARDS is the largest number of registration IDs in one batch dashboard request
+const MAX_BATCH_DASHBOARDS = 50
+
+// MAX_COMPARISON_COUNTRIES limits the countries listed in a comparison registration, and
+// the countries a comparison dashboard covers once its region has been expanded
+const MAX_COMPARISON_COUNTRIES = 50
+
+// MAX_TAGS limits the tags of a registration, and the registrations and tags a webhook
+// can be scoped to. MAX_TAG_LENGTH limits the length of a single tag.
+const MAX_TAGS = 20
+const MAX_TAG_LENGTH = 64
+
+// IDEMPOTENCY_TTL_HOURS is how long an Idempotency-Key and its response are kept.
+// It can be overridden with the environment variable of the same name.
+const IDEMPOTENCY_TTL_HOURS = 24
+
+// IDEMPOTENCY_LOCK_SECONDS is how long a request holds its Idempotency-Key before another
+// request may take it over, in case the first one never finished. It covers a few
+// webhook verification timeouts, the slowest step of an idempotent request.
+const IDEMPOTENCY_LOCK_SECONDS = 6 * WEBHOOK_TIMEOUT_SECONDS
+
+// REGISTRATION_RETENTION_DAYS is how long deleted registrations can be restored.
+// It can be overridden with the environment variable of the same name.
+const REGISTRATION_RETENTION_DAYS = 30
+
+// SNAPSHOT_RETENTION_DAYS is how long scheduled dashboard snapshots are kept.
+// It can be overridden with the environment variable of the same name.
+const SNAPSHOT_RETENTION_DAYS = 365
+
+// DELIVERY_LOG_RETENTION_DAYS is how long logged webhook delivery attempts are kept.
+// It can be overridden with the environment variable of the same name.
+const DELIVERY_LOG_RETENTION_DAYS = 30
+
+// SNAPSHOT_CHECK_MINUTES is how often the scheduler looks for registrations due a snapshot
+const SNAPSHOT_CHECK_MINUTES = 5
+
+// MAX_SNAPSHOT_POINTS is the largest number of snapshots returned by one history query
+const MAX_SNAPSHOT_POINTS = 1000
+
+// HTML_REFRESH_SECONDS is the longest interval between reloads of the HTML dashboard page
+const HTML_REFRESH_SECONDS = 900
+
+// Dashboard streams: how often subscribed dashboards are rebuilt, how often an idle
+// stream gets a heartbeat, and the reconnection delay suggested to clients
+const STREAM_REFRESH_SECONDS = 60
+const STREAM_HEARTBEAT_SECONDS = 15
+const STREAM_RETRY_MILLIS = 5000
+
+// Webhook delivery queue: the number of workers (overridable with WEBHOOK_WORKERS), how
+// many deliveries (and events) wait in memory, the timeout of one delivery, how long
+// matching an event against the webhooks may take, how often deliveries left in Firestore
+// are picked up again, and how long shutdown waits for the queue to drain
+const WEBHOOK_WORKERS = 4
+const WEBHOOK_QUEUE_SIZE = 256
+const WEBHOOK_TIMEOUT_SECONDS = 10
+const WEBHOOK_FANOUT_TIMEOUT_SECONDS = 10
+const WEBHOOK_SWEEP_SECONDS = 60
+const SHUTDOWN_TIMEOUT_SECONDS = 20
+
+// Headers of signed webhook deliveries, and how long a rotated secret stays valid by
+// default and at most
+const WEBHOOK_SIGNATURE_HEADER = "X-Webhook-Signature"
+const WEBHOOK_TIMESTAMP_HEADER = "X-Webhook-Timestamp"
+const WEBHOOK_SECRET_GRACE_SECONDS = 86400
+const WEBHOOK_SECRET_MAX_GRACE_SECONDS = 7 * 86400
+
+// How much of a receiver's response is read for the verification echo and the test ping
+const WEBHOOK_RESPONSE_LIMIT_BYTES = 4096
+
+// Webhook payloads: the schema version new webhooks get, the CloudEvents version, the
+// content type of structured CloudEvents and the prefix of their event types
+const WEBHOOK_PAYLOAD_VERSION = 2
+const CLOUDEVENTS_SPEC_VERSION = "1.0"
+const CONTENT_TYPE_CLOUDEVENTS = "application/cloudevents+json"
+const CLOUDEVENTS_TYPE_PREFIX = "dashboard.v1."
+
+// URL schemes webhooks may use (overridable with WEBHOOK_ALLOWED_SCHEMES), and how many
+// redirects a delivery follows
+const WEBHOOK_ALLOWED_SCHEMES = "https,http"
+const WEBHOOK_MAX_REDIRECTS = 3
+
+// Condition webhooks are evaluated every CONDITION_CHECK_MINUTES, and for a country at
+// most every CONDITION_REFRESH_SECONDS when its dashboards are refreshed
+const CONDITION_CHECK_MINUTES = 15
+const CONDITION_REFRESH_SECONDS = 60
+
+// Default retry policy of webhooks that do not set their own, and the limits of a policy
+const WEBHOOK_MAX_ATTEMPTS = 5
+const WEBHOOK_INITIAL_BACKOFF_SECONDS = 30
+const WEBHOOK_MAX_BACKOFF_SECONDS = 3600
+const WEBHOOK_MAX_ATTEMPTS_LIMIT = 20
+const WEBHOOK_BACKOFF_LIMIT_SECONDS = 86400
+
 // DefaultPort defines the default port for the service
 const DefaultPort = "8080"
 
 // Production external API endpoints
 const REST_COUNTRIES_ALPHA = "http://129.241.150.113:8080/v3.1/alpha/"
 const REST_COUNTRIES_NAME = "http://129.241.150.113:8080/v3.1/name/"
+const REST_COUNTRIES_REGION = "http://129.241.150.113:8080/v3.1/region/"
+const REST_COUNTRIES_SUBREGION = "http://129.241.150.113:8080/v3.1/subregion/"
 const CURRENCY_API = "http://129.241.150.113:9090/currency/"
 const OPEN_METEO_API = "https://api.open-meteo.com/v1/forecast"
 
@@ -28,6 +147,21 @@ const OPEN_METEO_API = "https://api.open-meteo.com/v1/forecast"
 const REGISTRATIONS_COLLECTION = "registrations"
 const NOTIFICATIONS_COLLECTION = "notifications"
 const CACHE_COLLECTION = "cache"
+const IDEMPOTENCY_COLLECTION = "idempotency"
+const DELETED_REGISTRATIONS_COLLECTION = "deleted_registrations"
+const SNAPSHOTS_COLLECTION = "dashboard_snapshots"
+const WEBHOOK_QUEUE_COLLECTION = "webhook_queue"
+const WEBHOOK_DEAD_LETTERS_COLLECTION = "webhook_dead_letters"
+const WEBHOOK_DELIVERIES_COLLECTION = "webhook_deliveries"
+
+// REVISIONS_SUBCOLLECTION holds the history below each registration document
+const REVISIONS_SUBCOLLECTION = "revisions"
+
+// Cache lifetimes (in hours) for data fetched from the external APIs.
+// They also bound the Cache-Control max-age of dashboards built from that data.
+const COUNTRY_CACHE_TTL_HOURS = 24
+const METEO_CACHE_TTL_HOURS = 1
+const CURRENCY_CACHE_TTL_HOURS = 6
 
 // Local mock_data file paths
 const MOCKDATA_RESTCOUNTRIES_NORWAY = "mock_data/restcountries_norway.json"
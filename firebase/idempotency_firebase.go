@@ -0,0 +1,99 @@
+// File: assignment-2/firebase/idempotency_firebase.go
+package firebase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"assignment-2/constants"
+	"assignment-2/structs"
+)
+
+// FUNCTION VARIABLES
+//
+// These can be overridden in tests. By default, they point to the 'real' Firestore implementations.
+
+var ReserveIdempotencyKey func(ctx context.Context, docID string, rec structs.IdempotencyRecord) (*structs.IdempotencyRecord, error) = realReserveIdempotencyKey
+
+var CompleteIdempotencyKey func(ctx context.Context, docID string, rec structs.IdempotencyRecord) error = realCompleteIdempotencyKey
+
+var ReleaseIdempotencyKey func(ctx context.Context, docID string) error = realReleaseIdempotencyKey
+
+// realReserveIdempotencyKey stores 'rec' (not yet completed) under 'docID', unless a
+// record that is not reusable yet already exists. In that case nothing is written and the
+// existing record is returned. Check and write run in one transaction, so of two
+// concurrent requests with the same key only one gets to execute.
+func realReserveIdempotencyKey(ctx context.Context, docID string, rec structs.IdempotencyRecord) (*structs.IdempotencyRecord, error) {
+	if err := ensureClient(); err != nil {
+		return nil, err
+	}
+	docRef := FirestoreClient.Collection(constants.IDEMPOTENCY_COLLECTION).Doc(docID)
+	var existing *structs.IdempotencyRecord
+	err := FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		existing = nil
+		snaps, err := tx.GetAll([]*firestore.DocumentRef{docRef})
+		if err != nil {
+			return err
+		}
+		if len(snaps) > 0 && snaps[0].Exists() {
+			var stored structs.IdempotencyRecord
+			if err := snaps[0].DataTo(&stored); err != nil {
+				return fmt.Errorf("failed to parse idempotency record: %v", err)
+			}
+			if !stored.Reusable(time.Now()) {
+				existing = &stored
+				return nil
+			}
+		}
+		return tx.Set(docRef, rec)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %v", err)
+	}
+	return existing, nil
+}
+
+// realCompleteIdempotencyKey stores the final record, including the response, under 'docID'.
+func realCompleteIdempotencyKey(ctx context.Context, docID string, rec structs.IdempotencyRecord) error {
+	if err := ensureClient(); err != nil {
+		return err
+	}
+	_, err := FirestoreClient.Collection(constants.IDEMPOTENCY_COLLECTION).Doc(docID).Set(ctx, rec)
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %v", err)
+	}
+	return nil
+}
+
+// realReleaseIdempotencyKey removes a reservation, so the key can be used again.
+func realReleaseIdempotencyKey(ctx context.Context, docID string) error {
+	if err := ensureClient(); err != nil {
+		return err
+	}
+	_, err := FirestoreClient.Collection(constants.IDEMPOTENCY_COLLECTION).Doc(docID).Delete(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %v", err)
+	}
+	return nil
+}
+
+// PurgeExpiredIdempotencyKeys deletes idempotency records whose window has passed.
+func PurgeExpiredIdempotencyKeys(ctx context.Context) error {
+	if err := ensureClient(); err != nil {
+		return err
+	}
+	q := FirestoreClient.Collection(constants.IDEMPOTENCY_COLLECTION).Where("expiresAt", "<", time.Now())
+	snaps, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to query expired idempotency keys: %v", err)
+	}
+	for _, s := range snaps {
+		if _, delErr := s.Ref.Delete(ctx); delErr != nil {
+			fmt.Printf("Warning: failed to delete idempotency key %s: %v\n", s.Ref.ID, delErr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+// File: assignment-2/firebase/idempotency_firebase_test.go
+package firebase
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"assignment-2/structs"
+)
+
+// TestIdempotencyFirebase reserves, completes and releases a key against a real Firestore instance.
+func TestIdempotencyFirebase(t *testing.T) {
+	if FirestoreClient == nil {
+		t.Skip("FirestoreClient is not initialized. Skipping idempotency Firebase tests.")
+	}
+	ctx := context.Background()
+	docID := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	rec := structs.IdempotencyRecord{
+		Key:         "key",
+		RequestHash: "hash",
+		Created:     time.Now(),
+		ExpiresAt:   time.Now().Add(time.Hour),
+		LockedUntil: time.Now().Add(time.Minute),
+	}
+	defer ReleaseIdempotencyKey(ctx, docID)
+
+	existing, err := ReserveIdempotencyKey(ctx, docID, rec)
+	if err != nil || existing != nil {
+		t.Fatalf("Expected a fresh reservation, got %v, %v", existing, err)
+	}
+
+	rec.Completed = true
+	rec.StatusCode = 201
+	if err := CompleteIdempotencyKey(ctx, docID, rec); err != nil {
+		t.Fatalf("CompleteIdempotencyKey failed: %v", err)
+	}
+
+	existing, err = ReserveIdempotencyKey(ctx, docID, rec)
+	if err != nil || existing == nil || existing.StatusCode != 201 {
+		t.Fatalf("Expected the completed record, got %v, %v", existing, err)
+	}
+}
@@ -0,0 +1,85 @@
+// File: assignment-2/firebase/list_query.go
+package firebase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"cloud.google.com/go/firestore"
+
+	"assignment-2/structs"
+)
+
+// ErrInvalidCursor is returned when a listing cursor does not refer to an existing document.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// listPage runs a filtered, sorted and paginated query against 'collection'. Filters and
+// sorting are pushed down to Firestore; one extra document is fetched to find out whether
+// another page exists. The returned cursor is empty on the last page.
+//
+// Equality filters combined with a sort field need a composite index in Firestore.
+func listPage(ctx context.Context, collection string, opts structs.ListOptions) ([]*firestore.DocumentSnapshot, string, error) {
+	col := FirestoreClient.Collection(collection)
+	q := col.Query
+
+	// Sorted keys keep the generated query (and thus the required index) stable
+	fields := make([]string, 0, len(opts.Equals))
+	for field := range opts.Equals {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		q = q.Where(field, "==", opts.Equals[field])
+	}
+	fields = fields[:0]
+	for field := range opts.Contains {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		q = q.Where(field, "array-contains", opts.Contains[field])
+	}
+	if opts.RangeField != "" {
+		if !opts.From.IsZero() {
+			q = q.Where(opts.RangeField, ">=", opts.From)
+		}
+		if !opts.To.IsZero() {
+			q = q.Where(opts.RangeField, "<", opts.To)
+		}
+	}
+
+	dir := firestore.Asc
+	if opts.Descending {
+		dir = firestore.Desc
+	}
+	if opts.OrderBy != "" {
+		q = q.OrderBy(opts.OrderBy, dir)
+	}
+	// The document ID breaks ties, so the cursor position is unambiguous
+	q = q.OrderBy(firestore.DocumentID, dir)
+
+	if opts.Cursor != "" {
+		snap, err := col.Doc(opts.Cursor).Get(ctx)
+		if snap != nil && !snap.Exists() {
+			return nil, "", ErrInvalidCursor
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to resolve cursor: %v", err)
+		}
+		q = q.StartAfter(snap)
+	}
+
+	size := opts.PageSize()
+	snaps, err := q.Limit(size + 1).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, "", err
+	}
+	next := ""
+	if len(snaps) > size {
+		snaps = snaps[:size]
+		next = snaps[size-1].Ref.ID
+	}
+	return snaps, next, nil
+}
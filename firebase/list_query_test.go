@@ -0,0 +1,60 @@
+// File: assignment-2/firebase/list_query_test.go
+package firebase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"assignment-2/structs"
+)
+
+// TestListRegistrationsPaging walks through a filtered listing page by page against a
+// real Firestore instance.
+func TestListRegistrationsPaging(t *testing.T) {
+	if FirestoreClient == nil {
+		t.Skip("FirestoreClient is not initialized. Skipping listing Firebase tests.")
+	}
+	ctx := context.Background()
+
+	// A unique country keeps this test independent of other documents
+	country := fmt.Sprintf("ListTest-%d", time.Now().UnixNano())
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id, err := SaveRegistration(ctx, structs.Registration{Country: country, LastChange: time.Now()})
+		if err != nil {
+			t.Fatalf("SaveRegistration failed: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	defer func() {
+		for _, id := range ids {
+			_ = DeleteRegistration(ctx, id, AnyRevision)
+		}
+	}()
+
+	opts := structs.ListOptions{Limit: 2, Equals: map[string]string{"country": country}}
+	first, cursor, err := ListRegistrations(ctx, opts)
+	if err != nil {
+		t.Fatalf("ListRegistrations failed: %v", err)
+	}
+	if len(first) != 2 || cursor == "" {
+		t.Fatalf("Expected a full first page and a cursor, got %d items and cursor %q", len(first), cursor)
+	}
+
+	opts.Cursor = cursor
+	second, cursor, err := ListRegistrations(ctx, opts)
+	if err != nil {
+		t.Fatalf("ListRegistrations (page 2) failed: %v", err)
+	}
+	if len(second) != 1 || cursor != "" {
+		t.Errorf("Expected one item on the last page and no cursor, got %d items and cursor %q", len(second), cursor)
+	}
+
+	opts.Cursor = "does-not-exist"
+	if _, _, err := ListRegistrations(ctx, opts); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+}
@@ -3,9 +3,12 @@ package firebase
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"cloud.google.com/go/firestore"
+
 	"assignment-2/constants"
 	"assignment-2/structs"
 )
@@ -20,8 +23,72 @@ var GetNotificationByID func(ctx context.Context, docID string) (*structs.Notifi
 
 var GetAllNotifications func(ctx context.Context) ([]structs.Notification, error) = realGetAllNotifications
 
+var ListNotifications func(ctx context.Context, opts structs.ListOptions) ([]structs.Notification, string, error) = realListNotifications
+
 var DeleteNotification func(ctx context.Context, docID string) error = realDeleteNotification
 
+var RotateNotificationSecret func(ctx context.Context, docID, secret string, grace time.Duration) (*structs.Notification, error) = realRotateNotificationSecret
+
+var ActivateNotification func(ctx context.Context, docID string, verified time.Time) error = realActivateNotification
+
+var UpdateNotification func(ctx context.Context, notif structs.Notification) error = realUpdateNotification
+
+var SaveConditionState func(ctx context.Context, docID string, state structs.ConditionState) error = realSaveConditionState
+
+// ErrNotificationNotFound is returned when a notification does not exist.
+var ErrNotificationNotFound = errors.New("notification not found")
+
+// notificationDoc is the stored form of a notification.
+type notificationDoc struct {
+	URL      string                  `firestore:"url"`
+	Country  string                  `firestore:"country"`
+	Events   []string                `firestore:"events"`
+	Event    string                  `firestore:"event"` // Event is the only event of notifications stored before Events.
+	Created  time.Time               `firestore:"created"`
+	Retry    *structs.RetryPolicy    `firestore:"retry"`
+	Secrets  []structs.WebhookSecret `firestore:"secrets"`
+	Status   string                  `firestore:"status"`
+	Verified time.Time               `firestore:"verified"`
+
+	Registrations []string `firestore:"registrations"`
+	Tags          []string `firestore:"tags"`
+
+	PayloadVersion int    `firestore:"payloadVersion"` // PayloadVersion is 0 for notifications stored before it was introduced.
+	Format         string `firestore:"format"`
+	IncludeData    bool   `firestore:"includeData"`
+
+	Condition      string                  `firestore:"condition"`
+	Hysteresis     float64                 `firestore:"hysteresis"`
+	ConditionState *structs.ConditionState `firestore:"conditionState"`
+}
+
+// toNotification converts the stored document 'id' into a Notification. The event of a
+// notification stored before Events is moved into Events.
+func (d notificationDoc) toNotification(id string) structs.Notification {
+	return structs.Notification{
+		ID:       id,
+		URL:      d.URL,
+		Country:  d.Country,
+		Events:   structs.Notification{Event: d.Event, Events: d.Events}.EventList(),
+		Created:  d.Created,
+		Retry:    d.Retry,
+		Secrets:  d.Secrets,
+		Status:   d.Status,
+		Verified: d.Verified,
+
+		Registrations: d.Registrations,
+		Tags:          d.Tags,
+
+		PayloadVersion: d.PayloadVersion,
+		Format:         d.Format,
+		IncludeData:    d.IncludeData,
+
+		Condition:      d.Condition,
+		Hysteresis:     d.Hysteresis,
+		ConditionState: d.ConditionState,
+	}
+}
+
 // REAL IMPLEMENTATIONS
 //
 // The "real*" functions do the actual Firestore calls. The function variables
@@ -36,8 +103,21 @@ func realSaveNotification(ctx context.Context, notif structs.Notification) (stri
 	docRef, _, err := colRef.Add(ctx, map[string]interface{}{
 		"url":     notif.URL,
 		"country": notif.Country,
-		"event":   notif.Event,
+		"events":  notif.EventList(),
 		"created": notif.Created,
+		"retry":   notif.Retry,
+		"secrets": notif.Secrets,
+		"status":  notif.Status,
+
+		"registrations": notif.Registrations,
+		"tags":          notif.Tags,
+
+		"payloadVersion": notif.PayloadVersion,
+		"format":         notif.Format,
+		"includeData":    notif.IncludeData,
+
+		"condition":  notif.Condition,
+		"hysteresis": notif.Hysteresis,
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to save notification: %v", err)
@@ -52,29 +132,19 @@ func realGetNotificationByID(ctx context.Context, docID string) (*structs.Notifi
 	}
 	docRef := FirestoreClient.Collection(constants.NOTIFICATIONS_COLLECTION).Doc(docID)
 	snap, err := docRef.Get(ctx)
+	if snap != nil && !snap.Exists() {
+		return nil, ErrNotificationNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get notification doc: %v", err)
 	}
-	if !snap.Exists() {
-		return nil, fmt.Errorf("notification not found")
-	}
 
-	var data struct {
-		URL     string    `firestore:"url"`
-		Country string    `firestore:"country"`
-		Event   string    `firestore:"event"`
-		Created time.Time `firestore:"created"`
-	}
+	var data notificationDoc
 	if err := snap.DataTo(&data); err != nil {
 		return nil, fmt.Errorf("failed to parse notification data: %v", err)
 	}
-	return &structs.Notification{
-		ID:      snap.Ref.ID,
-		URL:     data.URL,
-		Country: data.Country,
-		Event:   data.Event,
-		Created: data.Created,
-	}, nil
+	n := data.toNotification(snap.Ref.ID)
+	return &n, nil
 }
 
 // realGetAllNotifications lists all notifications in the Firestore collection.
@@ -92,27 +162,41 @@ func realGetAllNotifications(ctx context.Context) ([]structs.Notification, error
 		if !snap.Exists() {
 			continue
 		}
-		var data struct {
-			URL     string    `firestore:"url"`
-			Country string    `firestore:"country"`
-			Event   string    `firestore:"event"`
-			Created time.Time `firestore:"created"`
-		}
+		var data notificationDoc
 		if err := snap.DataTo(&data); err != nil {
 			// Could log a warning, skip
 			continue
 		}
-		results = append(results, structs.Notification{
-			ID:      snap.Ref.ID,
-			URL:     data.URL,
-			Country: data.Country,
-			Event:   data.Event,
-			Created: data.Created,
-		})
+		results = append(results, data.toNotification(snap.Ref.ID))
 	}
 	return results, nil
 }
 
+// realListNotifications returns one page of notifications matching 'opts', together with
+// the cursor for the next page (empty on the last page).
+func realListNotifications(ctx context.Context, opts structs.ListOptions) ([]structs.Notification, string, error) {
+	if err := ensureClient(); err != nil {
+		return nil, "", err
+	}
+	snaps, next, err := listPage(ctx, constants.NOTIFICATIONS_COLLECTION, opts)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCursor) {
+			return nil, "", err
+		}
+		return nil, "", fmt.Errorf("failed to list notifications: %v", err)
+	}
+
+	results := make([]structs.Notification, 0, len(snaps))
+	for _, snap := range snaps {
+		var data notificationDoc
+		if err := snap.DataTo(&data); err != nil {
+			continue
+		}
+		results = append(results, data.toNotification(snap.Ref.ID))
+	}
+	return results, next, nil
+}
+
 // realDeleteNotification deletes a notification doc in Firestore by ID.
 func realDeleteNotification(ctx context.Context, docID string) error {
 	if err := ensureClient(); err != nil {
@@ -120,15 +204,193 @@ func realDeleteNotification(ctx context.Context, docID string) error {
 	}
 	docRef := FirestoreClient.Collection(constants.NOTIFICATIONS_COLLECTION).Doc(docID)
 	snap, err := docRef.Get(ctx)
+	if snap != nil && !snap.Exists() {
+		return ErrNotificationNotFound
+	}
 	if err != nil {
 		return fmt.Errorf("failed to get notification doc: %v", err)
 	}
-	if !snap.Exists() {
-		return fmt.Errorf("notification not found")
-	}
 	_, err = docRef.Delete(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to delete notification: %v", err)
 	}
 	return nil
 }
+
+// realRotateNotificationSecret makes 'secret' the signing secret of a notification. The
+// current secret stays valid for 'grace' (not at all if it is zero); any older secret is
+// dropped, so at most two secrets are active.
+func realRotateNotificationSecret(ctx context.Context, docID, secret string, grace time.Duration) (*structs.Notification, error) {
+	if err := ensureClient(); err != nil {
+		return nil, err
+	}
+	docRef := FirestoreClient.Collection(constants.NOTIFICATIONS_COLLECTION).Doc(docID)
+	var rotated structs.Notification
+	err := FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snaps, err := tx.GetAll([]*firestore.DocumentRef{docRef})
+		if err != nil {
+			return err
+		}
+		if len(snaps) == 0 || !snaps[0].Exists() {
+			return ErrNotificationNotFound
+		}
+		var data notificationDoc
+		if err := snaps[0].DataTo(&data); err != nil {
+			return fmt.Errorf("failed to parse notification data: %v", err)
+		}
+		now := time.Now()
+		secrets := []structs.WebhookSecret{{Value: secret, Created: now}}
+		for _, old := range data.Secrets {
+			if !old.ActiveAt(now) || len(secrets) == 2 {
+				continue
+			}
+			if old.Expires.IsZero() || old.Expires.After(now.Add(grace)) {
+				old.Expires = now.Add(grace)
+			}
+			if grace > 0 {
+				secrets = append(secrets, old)
+			}
+		}
+		data.Secrets = secrets
+		rotated = data.toNotification(docID)
+		return tx.Update(docRef, []firestore.Update{{Path: "secrets", Value: secrets}})
+	})
+	if errors.Is(err, ErrNotificationNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate notification secret: %v", err)
+	}
+	return &rotated, nil
+}
+
+// realActivateNotification marks a notification as verified at 'verified', so it starts
+// receiving events.
+func realActivateNotification(ctx context.Context, docID string, verified time.Time) error {
+	if err := ensureClient(); err != nil {
+		return err
+	}
+	docRef := FirestoreClient.Collection(constants.NOTIFICATIONS_COLLECTION).Doc(docID)
+	err := FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snaps, err := tx.GetAll([]*firestore.DocumentRef{docRef})
+		if err != nil {
+			return err
+		}
+		if len(snaps) == 0 || !snaps[0].Exists() {
+			return ErrNotificationNotFound
+		}
+		return tx.Update(docRef, []firestore.Update{
+			{Path: "status", Value: structs.WebhookActive},
+			{Path: "verified", Value: verified},
+		})
+	})
+	if errors.Is(err, ErrNotificationNotFound) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to activate notification: %v", err)
+	}
+	return nil
+}
+
+// MigrateLegacyNotifications brings notifications stored by older versions up to date:
+// the single 'event' is moved into 'events', and a country stored by name is replaced by
+// the ISO code 'resolveCountry' returns for it, so that queries on 'events' and 'country'
+// find them. Countries that cannot be resolved are kept. It returns how many notifications
+// were migrated.
+func MigrateLegacyNotifications(ctx context.Context, resolveCountry func(country string) (string, error)) (int, error) {
+	if err := ensureClient(); err != nil {
+		return 0, err
+	}
+	snaps, err := FirestoreClient.Collection(constants.NOTIFICATIONS_COLLECTION).Documents(ctx).GetAll()
+	if err != nil {
+		return 0, fmt.Errorf("failed to query legacy notifications: %v", err)
+	}
+	migrated := 0
+	for _, snap := range snaps {
+		var data notificationDoc
+		if err := snap.DataTo(&data); err != nil {
+			fmt.Printf("Warning: failed to parse notification %s: %v\n", snap.Ref.ID, err)
+			continue
+		}
+		var updates []firestore.Update
+		if data.Event != "" {
+			updates = append(updates,
+				firestore.Update{Path: "events", Value: structs.Notification{Event: data.Event, Events: data.Events}.EventList()},
+				firestore.Update{Path: "event", Value: firestore.Delete},
+			)
+		}
+		if data.Country != "" {
+			code, err := resolveCountry(data.Country)
+			if err != nil {
+				fmt.Printf("Warning: keeping the country of notification %s: %v\n", snap.Ref.ID, err)
+			} else if code != data.Country {
+				updates = append(updates, firestore.Update{Path: "country", Value: code})
+			}
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		if _, err := snap.Ref.Update(ctx, updates); err != nil {
+			fmt.Printf("Warning: failed to migrate notification %s: %v\n", snap.Ref.ID, err)
+			continue
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// realUpdateNotification replaces the settings of an existing notification with those of
+// 'notif': its URL, country, events, scope, retry policy, condition and verification status. The
+// ID, creation time and secrets are kept.
+func realUpdateNotification(ctx context.Context, notif structs.Notification) error {
+	if err := ensureClient(); err != nil {
+		return err
+	}
+	docRef := FirestoreClient.Collection(constants.NOTIFICATIONS_COLLECTION).Doc(notif.ID)
+	err := FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snaps, err := tx.GetAll([]*firestore.DocumentRef{docRef})
+		if err != nil {
+			return err
+		}
+		if len(snaps) == 0 || !snaps[0].Exists() {
+			return ErrNotificationNotFound
+		}
+		return tx.Update(docRef, []firestore.Update{
+			{Path: "url", Value: notif.URL},
+			{Path: "country", Value: notif.Country},
+			{Path: "events", Value: notif.EventList()},
+			{Path: "event", Value: firestore.Delete},
+			{Path: "registrations", Value: notif.Registrations},
+			{Path: "tags", Value: notif.Tags},
+			{Path: "payloadVersion", Value: notif.PayloadVersion},
+			{Path: "format", Value: notif.Format},
+			{Path: "includeData", Value: notif.IncludeData},
+			{Path: "retry", Value: notif.Retry},
+			{Path: "status", Value: notif.Status},
+			{Path: "verified", Value: notif.Verified},
+			{Path: "condition", Value: notif.Condition},
+			{Path: "hysteresis", Value: notif.Hysteresis},
+			{Path: "conditionState", Value: notif.ConditionState},
+		})
+	})
+	if errors.Is(err, ErrNotificationNotFound) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update notification: %v", err)
+	}
+	return nil
+}
+
+// realSaveConditionState stores the result of the last evaluation of a condition webhook.
+func realSaveConditionState(ctx context.Context, docID string, state structs.ConditionState) error {
+	if err := ensureClient(); err != nil {
+		return err
+	}
+	docRef := FirestoreClient.Collection(constants.NOTIFICATIONS_COLLECTION).Doc(docID)
+	if _, err := docRef.Update(ctx, []firestore.Update{{Path: "conditionState", Value: state}}); err != nil {
+		return fmt.Errorf("failed to save condition state: %v", err)
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"assignment-2/constants"
 	"assignment-2/structs"
 )
 
@@ -163,3 +164,159 @@ func TestNotificationsFirebase(t *testing.T) {
 		}
 	})
 }
+
+// TestRotateNotificationSecret rotates the secret of a stored notification twice against
+// a real Firestore instance.
+func TestRotateNotificationSecret(t *testing.T) {
+	if FirestoreClient == nil {
+		t.Skip("FirestoreClient is not initialized. Skipping secret rotation Firebase tests.")
+	}
+	ctx := context.Background()
+
+	id, err := SaveNotification(ctx, structs.Notification{
+		URL: "http://example.org/hook", Event: "REGISTER",
+		Secrets: []structs.WebhookSecret{{Value: "first", Created: time.Now()}},
+	})
+	if err != nil {
+		t.Fatalf("SaveNotification failed: %v", err)
+	}
+	defer func() { _ = DeleteNotification(ctx, id) }()
+
+	if _, err := RotateNotificationSecret(ctx, id, "second", time.Hour); err != nil {
+		t.Fatalf("RotateNotificationSecret failed: %v", err)
+	}
+	n, err := RotateNotificationSecret(ctx, id, "third", time.Hour)
+	if err != nil || len(n.Secrets) != 2 || n.Secrets[0].Value != "third" || n.Secrets[1].Value != "second" {
+		t.Errorf("Expected the new and the previous secret, got %+v %v", n, err)
+	}
+	if _, err := RotateNotificationSecret(ctx, "missing-notification", "x", 0); !errors.Is(err, ErrNotificationNotFound) {
+		t.Errorf("Expected ErrNotificationNotFound, got %v", err)
+	}
+}
+
+// TestActivateNotification activates a pending notification against a real Firestore
+// instance.
+func TestActivateNotification(t *testing.T) {
+	if FirestoreClient == nil {
+		t.Skip("FirestoreClient is not initialized. Skipping activation Firebase tests.")
+	}
+	ctx := context.Background()
+
+	id, err := SaveNotification(ctx, structs.Notification{
+		URL: "http://example.org/hook", Event: "REGISTER", Status: structs.WebhookPending,
+	})
+	if err != nil {
+		t.Fatalf("SaveNotification failed: %v", err)
+	}
+	defer func() { _ = DeleteNotification(ctx, id) }()
+
+	if err := ActivateNotification(ctx, id, time.Now()); err != nil {
+		t.Fatalf("ActivateNotification failed: %v", err)
+	}
+	n, err := GetNotificationByID(ctx, id)
+	if err != nil || n.Status != structs.WebhookActive || n.Verified.IsZero() {
+		t.Errorf("Expected an active notification, got %+v %v", n, err)
+	}
+	if err := ActivateNotification(ctx, "missing-notification", time.Now()); !errors.Is(err, ErrNotificationNotFound) {
+		t.Errorf("Expected ErrNotificationNotFound, got %v", err)
+	}
+}
+
+// TestSaveConditionState stores the state of a condition webhook against a real
+// Firestore instance.
+func TestSaveConditionState(t *testing.T) {
+	if FirestoreClient == nil {
+		t.Skip("FirestoreClient is not initialized. Skipping condition state Firebase tests.")
+	}
+	ctx := context.Background()
+
+	id, err := SaveNotification(ctx, structs.Notification{
+		URL: "http://example.org/hook", Event: "CONDITION", Country: "NO", Condition: "temperature < -10", Hysteresis: 1,
+	})
+	if err != nil {
+		t.Fatalf("SaveNotification failed: %v", err)
+	}
+	defer func() { _ = DeleteNotification(ctx, id) }()
+
+	state := structs.ConditionState{Value: -12, Baseline: -12, Triggered: true, Evaluated: time.Now(), Fired: time.Now()}
+	if err := SaveConditionState(ctx, id, state); err != nil {
+		t.Fatalf("SaveConditionState failed: %v", err)
+	}
+	n, err := GetNotificationByID(ctx, id)
+	if err != nil || n.Condition != "temperature < -10" || n.Hysteresis != 1 || n.ConditionState == nil || !n.ConditionState.Triggered {
+		t.Errorf("Expected the condition and its state, got %+v %v", n, err)
+	}
+}
+
+// TestUpdateNotification replaces the settings of a notification stored with a single
+// event against a real Firestore instance.
+func TestUpdateNotification(t *testing.T) {
+	if FirestoreClient == nil {
+		t.Skip("FirestoreClient is not initialized. Skipping update Firebase tests.")
+	}
+	ctx := context.Background()
+
+	id, err := SaveNotification(ctx, structs.Notification{
+		URL: "http://example.org/hook", Event: "REGISTER", Country: "NO", Created: time.Now(),
+		Secrets: []structs.WebhookSecret{{Value: "s3cret", Created: time.Now()}},
+	})
+	if err != nil {
+		t.Fatalf("SaveNotification failed: %v", err)
+	}
+	defer func() { _ = DeleteNotification(ctx, id) }()
+
+	update := structs.Notification{
+		ID: id, URL: "http://example.org/other", Country: "SE", Events: []string{"CHANGE", "DELETE"},
+		PayloadVersion: 2, Format: structs.FormatCloudEvents,
+	}
+	if err := UpdateNotification(ctx, update); err != nil {
+		t.Fatalf("UpdateNotification failed: %v", err)
+	}
+	n, err := GetNotificationByID(ctx, id)
+	if err != nil || n.URL != update.URL || n.Country != "SE" || len(n.Events) != 2 || n.Subscribes("REGISTER") ||
+		n.PayloadVersion != 2 || n.Format != structs.FormatCloudEvents {
+		t.Errorf("Expected the updated settings, got %+v %v", n, err)
+	}
+	if n != nil && (len(n.Secrets) != 1 || n.Created.IsZero()) {
+		t.Errorf("Expected the secret and creation time to be kept, got %+v", n)
+	}
+	update.ID = "missing-notification"
+	if err := UpdateNotification(ctx, update); !errors.Is(err, ErrNotificationNotFound) {
+		t.Errorf("Expected ErrNotificationNotFound, got %v", err)
+	}
+}
+
+// TestMigrateLegacyNotifications moves the event of a notification stored before 'events'
+// existed and replaces its country name by the code, against a real Firestore instance.
+func TestMigrateLegacyNotifications(t *testing.T) {
+	if FirestoreClient == nil {
+		t.Skip("FirestoreClient is not initialized. Skipping legacy migration Firebase tests.")
+	}
+	ctx := context.Background()
+
+	docRef, _, err := FirestoreClient.Collection(constants.NOTIFICATIONS_COLLECTION).Add(ctx, map[string]interface{}{
+		"url": "http://example.org/hook", "country": "Norway", "event": "DELETE", "created": time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Could not store the legacy notification: %v", err)
+	}
+	defer func() { _ = DeleteNotification(ctx, docRef.ID) }()
+
+	resolve := func(country string) (string, error) {
+		if country == "Norway" {
+			return "NO", nil
+		}
+		return country, nil
+	}
+	if n, err := MigrateLegacyNotifications(ctx, resolve); err != nil || n < 1 {
+		t.Fatalf("Expected the legacy notification to be migrated, got %d %v", n, err)
+	}
+	snap, err := docRef.Get(ctx)
+	if err != nil {
+		t.Fatalf("Could not read the migrated notification: %v", err)
+	}
+	var data notificationDoc
+	if err := snap.DataTo(&data); err != nil || data.Event != "" || len(data.Events) != 1 || data.Events[0] != "DELETE" || data.Country != "NO" {
+		t.Errorf("Expected events [DELETE], country NO and no event, got %+v %v", data, err)
+	}
+}
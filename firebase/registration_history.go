@@ -0,0 +1,267 @@
+// File: assignment-2/firebase/registration_history.go
+package firebase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"assignment-2/constants"
+	"assignment-2/structs"
+)
+
+// ErrRevisionNotFound is returned when the requested history entry does not exist.
+var ErrRevisionNotFound = errors.New("registration revision not found")
+
+// ErrRegistrationNotDeleted is returned when undeleting a registration that still exists.
+var ErrRegistrationNotDeleted = errors.New("registration is not deleted")
+
+// LatestRevision can be passed to RestoreRegistration to undelete the last deleted state.
+const LatestRevision int64 = 0
+
+// FUNCTION VARIABLES
+// These can be overridden in tests.
+
+var ListRegistrationRevisions func(ctx context.Context, docID string) ([]structs.RegistrationRevision, error) = realListRegistrationRevisions
+var GetRegistrationRevision func(ctx context.Context, docID string, revision int64) (*structs.RegistrationRevision, error) = realGetRegistrationRevision
+var RestoreRegistration func(ctx context.Context, docID string, revision int64, expectedRevision int64) (*structs.Registration, error) = realRestoreRegistration
+
+// actorKey is the context key under which the acting user is stored.
+type actorKey struct{}
+
+// WithActor returns a context that attributes the registration changes made with it to
+// 'actor'. An empty actor leaves the changes unattributed.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFrom returns the actor stored by WithActor, or "".
+func actorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// revisionDoc is the Firestore representation of a history entry.
+type revisionDoc struct {
+	Revision     int64           `firestore:"revision"`
+	Operation    string          `firestore:"operation"`
+	Actor        string          `firestore:"actor"`
+	Time         time.Time       `firestore:"time"`
+	Registration registrationDoc `firestore:"registration"`
+}
+
+// newRevisionDoc builds the history entry for 'reg' stored at 'revision'.
+func newRevisionDoc(ctx context.Context, operation string, reg structs.Registration, revision int64) revisionDoc {
+	return revisionDoc{
+		Revision:  revision,
+		Operation: operation,
+		Actor:     actorFrom(ctx),
+		Time:      time.Now(),
+		Registration: registrationDoc{
+			Country:    reg.Country,
+			ISOCode:    reg.ISOCode,
+			Countries:  reg.Countries,
+			Region:     reg.Region,
+			Snapshot:   reg.Snapshot,
+			Features:   reg.Features,
+			Tags:       reg.Tags,
+			LastChange: reg.LastChange,
+			Revision:   revision,
+		},
+	}
+}
+
+// toRevision converts the stored entry into the API struct.
+func (d revisionDoc) toRevision(docID string) structs.RegistrationRevision {
+	return structs.RegistrationRevision{
+		Revision:     d.Revision,
+		Operation:    d.Operation,
+		Actor:        d.Actor,
+		Time:         d.Time,
+		Registration: d.Registration.toRegistration(docID),
+	}
+}
+
+// revisionRef returns the history document of 'docID' at 'revision'. History entries live
+// below the registration, so they survive a (soft) delete of the registration itself.
+func revisionRef(docID string, revision int64) *firestore.DocumentRef {
+	return FirestoreClient.Collection(constants.REGISTRATIONS_COLLECTION).Doc(docID).
+		Collection(constants.REVISIONS_SUBCOLLECTION).Doc(strconv.FormatInt(revision, 10))
+}
+
+// realListRegistrationRevisions returns the history of a registration, newest first.
+// Deleted registrations keep their history until they are purged.
+func realListRegistrationRevisions(ctx context.Context, docID string) ([]structs.RegistrationRevision, error) {
+	if err := ensureClient(); err != nil {
+		return nil, err
+	}
+	snaps, err := FirestoreClient.Collection(constants.REGISTRATIONS_COLLECTION).Doc(docID).
+		Collection(constants.REVISIONS_SUBCOLLECTION).
+		OrderBy("revision", firestore.Desc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list revisions: %v", err)
+	}
+	if len(snaps) == 0 {
+		// Registrations created before the history existed have no entries
+		if _, err := GetRegistrationByID(ctx, docID); err != nil {
+			return nil, ErrRegistrationNotFound
+		}
+	}
+
+	revisions := make([]structs.RegistrationRevision, 0, len(snaps))
+	for _, snap := range snaps {
+		var data revisionDoc
+		if err := snap.DataTo(&data); err != nil {
+			continue
+		}
+		revisions = append(revisions, data.toRevision(docID))
+	}
+	return revisions, nil
+}
+
+// realGetRegistrationRevision returns a single history entry.
+func realGetRegistrationRevision(ctx context.Context, docID string, revision int64) (*structs.RegistrationRevision, error) {
+	if err := ensureClient(); err != nil {
+		return nil, err
+	}
+	snap, err := revisionRef(docID, revision).Get(ctx)
+	if snap != nil && !snap.Exists() {
+		return nil, ErrRevisionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get revision: %v", err)
+	}
+	var data revisionDoc
+	if err := snap.DataTo(&data); err != nil {
+		return nil, fmt.Errorf("failed to parse revision: %v", err)
+	}
+	rev := data.toRevision(docID)
+	return &rev, nil
+}
+
+// realRestoreRegistration brings back an earlier state of a registration as a new revision.
+//
+// For an existing registration, 'revision' selects the history entry to roll back to and
+// 'expectedRevision' (unless AnyRevision) must match the current revision. For a deleted
+// registration, LatestRevision undeletes the state it had when it was deleted; any other
+// revision restores that entry instead.
+func realRestoreRegistration(ctx context.Context, docID string, revision int64, expectedRevision int64) (*structs.Registration, error) {
+	if err := ensureClient(); err != nil {
+		return nil, err
+	}
+	docRef := FirestoreClient.Collection(constants.REGISTRATIONS_COLLECTION).Doc(docID)
+	trashRef := FirestoreClient.Collection(constants.DELETED_REGISTRATIONS_COLLECTION).Doc(docID)
+
+	var restored structs.Registration
+	err := FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		refs := []*firestore.DocumentRef{docRef, trashRef}
+		if revision != LatestRevision {
+			refs = append(refs, revisionRef(docID, revision))
+		}
+		// All reads of a transaction must happen before its writes
+		snaps, err := tx.GetAll(refs)
+		if err != nil {
+			return err
+		}
+		active, deleted := snaps[0], snaps[1]
+
+		var current registrationDoc
+		switch {
+		case active.Exists():
+			if revision == LatestRevision {
+				return ErrRegistrationNotDeleted
+			}
+			err = active.DataTo(&current)
+		case deleted.Exists():
+			err = deleted.DataTo(&current)
+		default:
+			return ErrRegistrationNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse registration data: %v", err)
+		}
+		if expectedRevision != AnyRevision && current.Revision != expectedRevision {
+			return ErrRevisionMismatch
+		}
+
+		state := current
+		if revision != LatestRevision {
+			if !snaps[2].Exists() {
+				return ErrRevisionNotFound
+			}
+			var entry revisionDoc
+			if err := snaps[2].DataTo(&entry); err != nil {
+				return fmt.Errorf("failed to parse revision: %v", err)
+			}
+			state = entry.Registration
+		}
+
+		newRevision := current.Revision + 1
+		restored = state.toRegistration(docID)
+		restored.LastChange = time.Now()
+		restored.Revision = newRevision
+		if err := tx.Set(docRef, registrationFields(restored, newRevision)); err != nil {
+			return err
+		}
+		if deleted.Exists() {
+			if err := tx.Delete(trashRef); err != nil {
+				return err
+			}
+		}
+		return tx.Create(revisionRef(docID, newRevision), newRevisionDoc(ctx, structs.OperationRestore, restored, newRevision))
+	})
+	if err != nil {
+		if errors.Is(err, ErrRegistrationNotFound) || errors.Is(err, ErrRevisionMismatch) ||
+			errors.Is(err, ErrRevisionNotFound) || errors.Is(err, ErrRegistrationNotDeleted) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to restore registration: %v", err)
+	}
+	return &restored, nil
+}
+
+// PurgeDeletedRegistrations permanently removes registrations that were deleted more than
+// 'olderThan' ago, together with their history and dashboard snapshots.
+func PurgeDeletedRegistrations(ctx context.Context, olderThan time.Duration) error {
+	if err := ensureClient(); err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-olderThan)
+	snaps, err := FirestoreClient.Collection(constants.DELETED_REGISTRATIONS_COLLECTION).
+		Where("deletedAt", "<", cutoff).Documents(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to query deleted registrations: %v", err)
+	}
+
+	bw := FirestoreClient.BulkWriter(ctx)
+	for _, s := range snaps {
+		history, err := FirestoreClient.Collection(constants.REGISTRATIONS_COLLECTION).Doc(s.Ref.ID).
+			Collection(constants.REVISIONS_SUBCOLLECTION).Documents(ctx).GetAll()
+		if err != nil {
+			fmt.Printf("Warning: failed to list history of deleted registration %s: %v\n", s.Ref.ID, err)
+			continue
+		}
+		for _, h := range history {
+			if _, err := bw.Delete(h.Ref); err != nil {
+				fmt.Printf("Warning: failed to queue deletion of %s: %v\n", h.Ref.Path, err)
+			}
+		}
+		if _, err := bw.Delete(s.Ref); err != nil {
+			fmt.Printf("Warning: failed to queue deletion of %s: %v\n", s.Ref.Path, err)
+		}
+	}
+	bw.End()
+
+	// Snapshots of the purged registrations are no longer reachable
+	for _, s := range snaps {
+		q := FirestoreClient.Collection(constants.SNAPSHOTS_COLLECTION).Where("registrationId", "==", s.Ref.ID)
+		if err := deleteSnapshots(ctx, q); err != nil {
+			fmt.Printf("Warning: failed to purge snapshots of %s: %v\n", s.Ref.ID, err)
+		}
+	}
+	return nil
+}
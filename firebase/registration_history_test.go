@@ -0,0 +1,35 @@
+// File: assignment-2/firebase/registration_history_test.go
+package firebase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"assignment-2/structs"
+)
+
+// TestNewRevisionDoc checks that history entries carry the actor from the context and
+// round-trip into the API struct.
+func TestNewRevisionDoc(t *testing.T) {
+	reg := structs.Registration{
+		Country:    "Norway",
+		ISOCode:    "NO",
+		Features:   structs.Features{Capital: true},
+		LastChange: time.Date(2025, 4, 10, 12, 0, 0, 0, time.UTC),
+	}
+
+	doc := newRevisionDoc(WithActor(context.Background(), "alice"), structs.OperationUpdate, reg, 4)
+	if doc.Actor != "alice" || doc.Operation != structs.OperationUpdate || doc.Registration.Revision != 4 {
+		t.Errorf("Unexpected revision doc: %+v", doc)
+	}
+
+	rev := doc.toRevision("abc")
+	if rev.Registration.ID != "abc" || rev.Registration.Country != "Norway" || !rev.Registration.Features.Capital {
+		t.Errorf("Unexpected revision: %+v", rev)
+	}
+
+	if anon := newRevisionDoc(context.Background(), structs.OperationCreate, reg, 1); anon.Actor != "" {
+		t.Errorf("Expected no actor without WithActor, got %q", anon.Actor)
+	}
+}
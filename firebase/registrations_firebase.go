@@ -2,37 +2,101 @@ package firebase
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"time"
 
+	"cloud.google.com/go/firestore"
+
 	"assignment-2/constants"
 	"assignment-2/structs"
 )
 
+// AnyRevision can be passed as the expected revision to skip the optimistic concurrency check.
+const AnyRevision int64 = -1
+
+// ErrRegistrationNotFound is returned when the registration document does not exist.
+var ErrRegistrationNotFound = errors.New("registration not found")
+
+// ErrRevisionMismatch is returned when the stored revision differs from the expected one,
+// meaning that another request modified the registration in the meantime.
+var ErrRevisionMismatch = errors.New("registration revision mismatch")
+
 // FUNCTION VARIABLES
 // These can be overridden in tests.
 // By default, they point to the real Firestore-based functions below.
 
 var SaveRegistration func(ctx context.Context, reg structs.Registration) (string, error) = realSaveRegistration
+var SaveRegistrations func(ctx context.Context, regs []structs.Registration) ([]string, []error) = realSaveRegistrations
 var GetRegistrationByID func(ctx context.Context, docID string) (*structs.Registration, error) = realGetRegistrationByID
+var GetRegistrationsByIDs func(ctx context.Context, docIDs []string) (map[string]structs.Registration, error) = realGetRegistrationsByIDs
 var GetAllRegistrations func(ctx context.Context) ([]structs.Registration, error) = realGetAllRegistrations
-var UpdateRegistration func(ctx context.Context, docID string, reg structs.Registration) error = realUpdateRegistration
-var DeleteRegistration func(ctx context.Context, docID string) error = realDeleteRegistration
-var PatchRegistration func(ctx context.Context, docID string, partial structs.Registration) error = realPatchRegistration
+var ListRegistrations func(ctx context.Context, opts structs.ListOptions) ([]structs.Registration, string, error) = realListRegistrations
+var UpdateRegistration func(ctx context.Context, docID string, reg structs.Registration, expectedRevision int64) (int64, error) = realUpdateRegistration
+var DeleteRegistration func(ctx context.Context, docID string, expectedRevision int64) error = realDeleteRegistration
+var PatchRegistration func(ctx context.Context, docID string, patched structs.Registration, expectedRevision int64) (int64, error) = realPatchRegistration
+
+// registrationDoc is the Firestore representation of a registration.
+type registrationDoc struct {
+	Country    string           `firestore:"country"`
+	ISOCode    string           `firestore:"isoCode"`
+	Countries  []string         `firestore:"countries"`
+	Region     string           `firestore:"region"`
+	Snapshot   string           `firestore:"snapshot"`
+	Features   structs.Features `firestore:"features"`
+	Tags       []string         `firestore:"tags"`
+	LastChange time.Time        `firestore:"lastChange"`
+	Revision   int64            `firestore:"revision"`
+}
+
+// toRegistration converts the stored document into the API struct.
+func (d registrationDoc) toRegistration(docID string) structs.Registration {
+	return structs.Registration{
+		ID:         docID,
+		Country:    d.Country,
+		ISOCode:    d.ISOCode,
+		Countries:  d.Countries,
+		Region:     d.Region,
+		Snapshot:   d.Snapshot,
+		Features:   d.Features,
+		Tags:       d.Tags,
+		LastChange: d.LastChange,
+		Revision:   d.Revision,
+	}
+}
+
+// registrationFields builds the map written to Firestore for 'reg' at the given revision.
+func registrationFields(reg structs.Registration, revision int64) map[string]interface{} {
+	return map[string]interface{}{
+		"country":    reg.Country,
+		"isoCode":    reg.ISOCode,
+		"countries":  reg.Countries,
+		"region":     reg.Region,
+		"snapshot":   reg.Snapshot,
+		"features":   reg.Features,
+		"tags":       reg.Tags,
+		"lastChange": reg.LastChange,
+		"revision":   revision,
+	}
+}
 
 // REAL IMPLEMENTATIONS
 // These are the actual Firestore-based functions we run in production.
 // They are called by the function variables above (unless overridden in tests).
 
+// realSaveRegistration creates the registration at revision 1 together with its first
+// history entry, in one transaction.
 func realSaveRegistration(ctx context.Context, reg structs.Registration) (string, error) {
 	if err := ensureClient(); err != nil {
 		return "", err
 	}
-	docRef, _, err := FirestoreClient.Collection(constants.REGISTRATIONS_COLLECTION).Add(ctx, map[string]interface{}{
-		"country":    reg.Country,
-		"isoCode":    reg.ISOCode,
-		"features":   reg.Features,
-		"lastChange": reg.LastChange,
+	docRef := FirestoreClient.Collection(constants.REGISTRATIONS_COLLECTION).NewDoc()
+	err := FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if err := tx.Create(docRef, registrationFields(reg, 1)); err != nil {
+			return err
+		}
+		return tx.Create(revisionRef(docRef.ID, 1), newRevisionDoc(ctx, structs.OperationCreate, reg, 1))
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to add registration: %v", err)
@@ -40,35 +104,99 @@ func realSaveRegistration(ctx context.Context, reg structs.Registration) (string
 	return docRef.ID, nil
 }
 
+// realSaveRegistrations stores many new registrations through a BulkWriter, which batches
+// the writes and retries throttled ones. The returned slices are index-aligned with 'regs':
+// either ids[i] is set or errs[i] explains why registration i was not stored.
+func realSaveRegistrations(ctx context.Context, regs []structs.Registration) ([]string, []error) {
+	ids := make([]string, len(regs))
+	errs := make([]error, len(regs))
+	if err := ensureClient(); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return ids, errs
+	}
+
+	col := FirestoreClient.Collection(constants.REGISTRATIONS_COLLECTION)
+	bw := FirestoreClient.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, len(regs))
+	for i, reg := range regs {
+		docRef := col.NewDoc()
+		job, err := bw.Create(docRef, registrationFields(reg, 1))
+		if err != nil {
+			errs[i] = fmt.Errorf("failed to queue registration: %v", err)
+			continue
+		}
+		ids[i] = docRef.ID
+		jobs[i] = job
+		// The BulkWriter is not atomic; a lost history entry is logged but not fatal
+		if _, err := bw.Create(revisionRef(docRef.ID, 1), newRevisionDoc(ctx, structs.OperationImport, reg, 1)); err != nil {
+			log.Printf("Warning: could not queue history of imported registration %s: %v\n", docRef.ID, err)
+		}
+	}
+	bw.End()
+
+	for i, job := range jobs {
+		if job == nil {
+			continue
+		}
+		if _, err := job.Results(); err != nil {
+			ids[i] = ""
+			errs[i] = fmt.Errorf("failed to add registration: %v", err)
+		}
+	}
+	return ids, errs
+}
+
 func realGetRegistrationByID(ctx context.Context, docID string) (*structs.Registration, error) {
 	if err := ensureClient(); err != nil {
 		return nil, err
 	}
 	docRef := FirestoreClient.Collection(constants.REGISTRATIONS_COLLECTION).Doc(docID)
 	snap, err := docRef.Get(ctx)
+	if snap != nil && !snap.Exists() {
+		return nil, ErrRegistrationNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get document: %v", err)
 	}
-	if !snap.Exists() {
-		return nil, fmt.Errorf("registration not found")
-	}
 
-	var data struct {
-		Country    string           `firestore:"country"`
-		ISOCode    string           `firestore:"isoCode"`
-		Features   structs.Features `firestore:"features"`
-		LastChange time.Time        `firestore:"lastChange"`
-	}
+	var data registrationDoc
 	if err := snap.DataTo(&data); err != nil {
 		return nil, fmt.Errorf("failed to parse registration data: %v", err)
 	}
-	return &structs.Registration{
-		ID:         docID,
-		Country:    data.Country,
-		ISOCode:    data.ISOCode,
-		Features:   data.Features,
-		LastChange: data.LastChange,
-	}, nil
+	reg := data.toRegistration(docID)
+	return &reg, nil
+}
+
+// realGetRegistrationsByIDs reads several registrations with a single multi-get. IDs
+// that do not exist are absent from the result.
+func realGetRegistrationsByIDs(ctx context.Context, docIDs []string) (map[string]structs.Registration, error) {
+	if err := ensureClient(); err != nil {
+		return nil, err
+	}
+	col := FirestoreClient.Collection(constants.REGISTRATIONS_COLLECTION)
+	refs := make([]*firestore.DocumentRef, len(docIDs))
+	for i, id := range docIDs {
+		refs[i] = col.Doc(id)
+	}
+	snaps, err := FirestoreClient.GetAll(ctx, refs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get registrations: %v", err)
+	}
+
+	regs := make(map[string]structs.Registration, len(snaps))
+	for _, snap := range snaps {
+		if !snap.Exists() {
+			continue
+		}
+		var data registrationDoc
+		if err := snap.DataTo(&data); err != nil {
+			return nil, fmt.Errorf("failed to parse registration %s: %v", snap.Ref.ID, err)
+		}
+		regs[snap.Ref.ID] = data.toRegistration(snap.Ref.ID)
+	}
+	return regs, nil
 }
 
 func realGetAllRegistrations(ctx context.Context) ([]structs.Registration, error) {
@@ -85,143 +213,140 @@ func realGetAllRegistrations(ctx context.Context) ([]structs.Registration, error
 		if !snap.Exists() {
 			continue
 		}
-		var data struct {
-			Country    string           `firestore:"country"`
-			ISOCode    string           `firestore:"isoCode"`
-			Features   structs.Features `firestore:"features"`
-			LastChange time.Time        `firestore:"lastChange"`
-		}
+		var data registrationDoc
 		if err := snap.DataTo(&data); err != nil {
 			// Could log a warning, but continue
 			continue
 		}
-		regs = append(regs, structs.Registration{
-			ID:         snap.Ref.ID,
-			Country:    data.Country,
-			ISOCode:    data.ISOCode,
-			Features:   data.Features,
-			LastChange: data.LastChange,
-		})
+		regs = append(regs, data.toRegistration(snap.Ref.ID))
 	}
 	return regs, nil
 }
 
-func realUpdateRegistration(ctx context.Context, docID string, reg structs.Registration) error {
+// realListRegistrations returns one page of registrations matching 'opts', together with
+// the cursor for the next page (empty on the last page).
+func realListRegistrations(ctx context.Context, opts structs.ListOptions) ([]structs.Registration, string, error) {
 	if err := ensureClient(); err != nil {
-		return err
+		return nil, "", err
 	}
-	docRef := FirestoreClient.Collection(constants.REGISTRATIONS_COLLECTION).Doc(docID)
-	snap, err := docRef.Get(ctx)
+	snaps, next, err := listPage(ctx, constants.REGISTRATIONS_COLLECTION, opts)
 	if err != nil {
-		return fmt.Errorf("failed to fetch doc for update: %v", err)
-	}
-	if !snap.Exists() {
-		return fmt.Errorf("document does not exist")
+		if errors.Is(err, ErrInvalidCursor) {
+			return nil, "", err
+		}
+		return nil, "", fmt.Errorf("failed to list registrations: %v", err)
 	}
 
-	_, err = docRef.Set(ctx, map[string]interface{}{
-		"country":    reg.Country,
-		"isoCode":    reg.ISOCode,
-		"features":   reg.Features,
-		"lastChange": reg.LastChange,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to update registration: %v", err)
+	regs := make([]structs.Registration, 0, len(snaps))
+	for _, snap := range snaps {
+		var data registrationDoc
+		if err := snap.DataTo(&data); err != nil {
+			continue
+		}
+		regs = append(regs, data.toRegistration(snap.Ref.ID))
 	}
-	return nil
+	return regs, next, nil
 }
 
-func realDeleteRegistration(ctx context.Context, docID string) error {
+// realUpdateRegistration overwrites the registration inside a transaction, so the
+// revision check and the write happen atomically. It returns the new revision.
+func realUpdateRegistration(ctx context.Context, docID string, reg structs.Registration, expectedRevision int64) (int64, error) {
 	if err := ensureClient(); err != nil {
-		return err
-	}
-	docRef := FirestoreClient.Collection(constants.REGISTRATIONS_COLLECTION).Doc(docID)
-	snap, err := docRef.Get(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to get document for deletion: %v", err)
+		return 0, err
 	}
-	if !snap.Exists() {
-		return fmt.Errorf("registration not found")
+	newRevision, err := writeRegistration(ctx, docID, reg, expectedRevision, structs.OperationUpdate)
+	if err != nil && !errors.Is(err, ErrRegistrationNotFound) && !errors.Is(err, ErrRevisionMismatch) {
+		return 0, fmt.Errorf("failed to update registration: %v", err)
 	}
-	_, err = docRef.Delete(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to delete registration: %v", err)
-	}
-	return nil
+	return newRevision, err
 }
 
-func realPatchRegistration(ctx context.Context, docID string, partial structs.Registration) error {
+// realDeleteRegistration soft-deletes a registration: the document is moved to the
+// deleted registrations collection, where it can be restored until it is purged, and
+// the deletion is recorded as a new revision.
+func realDeleteRegistration(ctx context.Context, docID string, expectedRevision int64) error {
 	if err := ensureClient(); err != nil {
 		return err
 	}
 	docRef := FirestoreClient.Collection(constants.REGISTRATIONS_COLLECTION).Doc(docID)
-	snap, err := docRef.Get(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to fetch document for patch: %v", err)
-	}
-	if !snap.Exists() {
-		return fmt.Errorf("document does not exist")
-	}
-
-	existingReg, err := realGetRegistrationByID(ctx, docID)
-	if err != nil {
-		return fmt.Errorf("failed to load existing registration: %v", err)
+	trashRef := FirestoreClient.Collection(constants.DELETED_REGISTRATIONS_COLLECTION).Doc(docID)
+	err := FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		current, err := readRegistrationDoc(tx, docRef)
+		if err != nil {
+			return err
+		}
+		if expectedRevision != AnyRevision && current.Revision != expectedRevision {
+			return ErrRevisionMismatch
+		}
+		deleted := current.toRegistration(docID)
+		newRevision := current.Revision + 1
+		fields := registrationFields(deleted, newRevision)
+		fields["deletedAt"] = time.Now()
+		if err := tx.Set(trashRef, fields); err != nil {
+			return err
+		}
+		if err := tx.Create(revisionRef(docID, newRevision), newRevisionDoc(ctx, structs.OperationDelete, deleted, newRevision)); err != nil {
+			return err
+		}
+		return tx.Delete(docRef)
+	})
+	if err != nil && !errors.Is(err, ErrRegistrationNotFound) && !errors.Is(err, ErrRevisionMismatch) {
+		return fmt.Errorf("failed to delete registration: %v", err)
 	}
-	updateMap := map[string]interface{}{}
+	return err
+}
 
-	// Only set fields if partial has a non-empty value
-	if partial.Country != "" {
-		updateMap["country"] = partial.Country
+// realPatchRegistration stores the result of a partial update. The caller has already
+// applied the patch to the stored document, so 'patched' holds every field, including
+// explicit false values and emptied currency lists. Passing the revision the patch was
+// computed from guarantees that no concurrent write is silently overwritten.
+func realPatchRegistration(ctx context.Context, docID string, patched structs.Registration, expectedRevision int64) (int64, error) {
+	if err := ensureClient(); err != nil {
+		return 0, err
 	}
-	if partial.ISOCode != "" {
-		updateMap["isoCode"] = partial.ISOCode
+	newRevision, err := writeRegistration(ctx, docID, patched, expectedRevision, structs.OperationPatch)
+	if err != nil && !errors.Is(err, ErrRegistrationNotFound) && !errors.Is(err, ErrRevisionMismatch) {
+		return 0, fmt.Errorf("failed to patch registration: %v", err)
 	}
+	return newRevision, err
+}
 
-	newFeatures := existingReg.Features
-	changed := false
-
-	if partial.Features.Temperature != existingReg.Features.Temperature {
-		newFeatures.Temperature = partial.Features.Temperature
-		changed = true
-	}
-	if partial.Features.Precipitation != existingReg.Features.Precipitation {
-		newFeatures.Precipitation = partial.Features.Precipitation
-		changed = true
-	}
-	if partial.Features.Capital != existingReg.Features.Capital {
-		newFeatures.Capital = partial.Features.Capital
-		changed = true
-	}
-	if partial.Features.Coordinates != existingReg.Features.Coordinates {
-		newFeatures.Coordinates = partial.Features.Coordinates
-		changed = true
-	}
-	if partial.Features.Population != existingReg.Features.Population {
-		newFeatures.Population = partial.Features.Population
-		changed = true
-	}
-	if partial.Features.Area != existingReg.Features.Area {
-		newFeatures.Area = partial.Features.Area
-		changed = true
-	}
-	if len(partial.Features.TargetCurrencies) > 0 {
-		newFeatures.TargetCurrencies = partial.Features.TargetCurrencies
-		changed = true
-	}
+// writeRegistration replaces an existing registration in a transaction, checking
+// 'expectedRevision' (unless AnyRevision), incrementing the stored revision and
+// recording the new state in the history under 'operation'.
+func writeRegistration(ctx context.Context, docID string, reg structs.Registration, expectedRevision int64, operation string) (int64, error) {
+	docRef := FirestoreClient.Collection(constants.REGISTRATIONS_COLLECTION).Doc(docID)
+	var newRevision int64
+	err := FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		current, err := readRegistrationDoc(tx, docRef)
+		if err != nil {
+			return err
+		}
+		if expectedRevision != AnyRevision && current.Revision != expectedRevision {
+			return ErrRevisionMismatch
+		}
+		newRevision = current.Revision + 1
+		if err := tx.Set(docRef, registrationFields(reg, newRevision)); err != nil {
+			return err
+		}
+		return tx.Create(revisionRef(docID, newRevision), newRevisionDoc(ctx, operation, reg, newRevision))
+	})
+	return newRevision, err
+}
 
-	if changed {
-		updateMap["features"] = newFeatures
+// readRegistrationDoc reads a registration within a transaction. Documents written
+// before revisions were introduced report revision 0.
+func readRegistrationDoc(tx *firestore.Transaction, docRef *firestore.DocumentRef) (registrationDoc, error) {
+	var data registrationDoc
+	snaps, err := tx.GetAll([]*firestore.DocumentRef{docRef})
+	if err != nil {
+		return data, err
 	}
-	if len(updateMap) > 0 {
-		updateMap["lastChange"] = time.Now()
-	} else {
-		// no changes... do nothing
-		return nil
+	if len(snaps) == 0 || !snaps[0].Exists() {
+		return data, ErrRegistrationNotFound
 	}
-
-	_, err = docRef.Set(ctx, updateMap)
-	if err != nil {
-		return fmt.Errorf("failed to patch registration: %v", err)
+	if err := snaps[0].DataTo(&data); err != nil {
+		return data, fmt.Errorf("failed to parse registration data: %v", err)
 	}
-	return nil
+	return data, nil
 }
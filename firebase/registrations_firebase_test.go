@@ -38,6 +38,7 @@ func TestRegistrationsFirebase(t *testing.T) {
 			idCounter++
 			docID := fmt.Sprintf("reg-%d", idCounter)
 			reg.ID = docID
+			reg.Revision = 1
 			regStore[docID] = reg
 			return docID, nil
 		}
@@ -65,41 +66,52 @@ func TestRegistrationsFirebase(t *testing.T) {
 		}
 
 		// UpdateRegistration
-		UpdateRegistration = func(ctx context.Context, docID string, reg structs.Registration) error {
+		UpdateRegistration = func(ctx context.Context, docID string, reg structs.Registration, expectedRevision int64) (int64, error) {
 			storeMutex.Lock()
 			defer storeMutex.Unlock()
 			oldReg, ok := regStore[docID]
 			if !ok {
-				return errors.New("doc not found")
+				return 0, ErrRegistrationNotFound
+			}
+			if expectedRevision != AnyRevision && oldReg.Revision != expectedRevision {
+				return 0, ErrRevisionMismatch
 			}
 			reg.ID = docID
+			reg.Revision = oldReg.Revision + 1
 			regStore[docID] = reg
 
 			// Return an error if to simulate something, or just nil
 			if oldReg.Country != "" && reg.Country == "" {
-				return errors.New("cannot remove country")
+				return 0, errors.New("cannot remove country")
 			}
-			return nil
+			return reg.Revision, nil
 		}
 
 		// DeleteRegistration
-		DeleteRegistration = func(ctx context.Context, docID string) error {
+		DeleteRegistration = func(ctx context.Context, docID string, expectedRevision int64) error {
 			storeMutex.Lock()
 			defer storeMutex.Unlock()
-			if _, ok := regStore[docID]; !ok {
-				return errors.New("doc not found")
+			existing, ok := regStore[docID]
+			if !ok {
+				return ErrRegistrationNotFound
+			}
+			if expectedRevision != AnyRevision && existing.Revision != expectedRevision {
+				return ErrRevisionMismatch
 			}
 			delete(regStore, docID)
 			return nil
 		}
 
 		// PatchRegistration
-		PatchRegistration = func(ctx context.Context, docID string, partial structs.Registration) error {
+		PatchRegistration = func(ctx context.Context, docID string, partial structs.Registration, expectedRevision int64) (int64, error) {
 			storeMutex.Lock()
 			defer storeMutex.Unlock()
 			existing, ok := regStore[docID]
 			if !ok {
-				return errors.New("doc not found")
+				return 0, ErrRegistrationNotFound
+			}
+			if expectedRevision != AnyRevision && existing.Revision != expectedRevision {
+				return 0, ErrRevisionMismatch
 			}
 			if partial.Country != "" {
 				existing.Country = partial.Country
@@ -115,8 +127,9 @@ func TestRegistrationsFirebase(t *testing.T) {
 				existing.Features.TargetCurrencies = partial.Features.TargetCurrencies
 			}
 			existing.LastChange = time.Now()
+			existing.Revision++
 			regStore[docID] = existing
-			return nil
+			return existing.Revision, nil
 		}
 	}
 
@@ -195,7 +208,7 @@ func TestRegistrationsFirebase(t *testing.T) {
 			Features:   structs.Features{Temperature: false},
 			LastChange: time.Now(),
 		}
-		if err := UpdateRegistration(context.Background(), docID, upd); err != nil {
+		if _, err := UpdateRegistration(context.Background(), docID, upd, AnyRevision); err != nil {
 			t.Errorf("UpdateRegistration returned error: %v", err)
 		}
 		got, _ := GetRegistrationByID(context.Background(), docID)
@@ -209,15 +222,28 @@ func TestRegistrationsFirebase(t *testing.T) {
 
 	t.Run("UpdateRegistration_NotFound", func(t *testing.T) {
 		upd := structs.Registration{Country: "Nowhere"}
-		err := UpdateRegistration(context.Background(), "doc-9999", upd)
+		_, err := UpdateRegistration(context.Background(), "doc-9999", upd, AnyRevision)
 		if err == nil {
 			t.Error("Expected error for docID=doc-9999 not found, got nil")
 		}
 	})
 
+	t.Run("UpdateRegistration_RevisionMismatch", func(t *testing.T) {
+		docID := createDoc(t, "Conflict")
+		upd := structs.Registration{Country: "Conflict"}
+		if _, err := UpdateRegistration(context.Background(), docID, upd, 1); err != nil {
+			t.Fatalf("First update at revision 1 failed: %v", err)
+		}
+		// A second writer still holding revision 1 must be rejected
+		_, err := UpdateRegistration(context.Background(), docID, upd, 1)
+		if !errors.Is(err, ErrRevisionMismatch) {
+			t.Errorf("Expected ErrRevisionMismatch, got %v", err)
+		}
+	})
+
 	t.Run("DeleteRegistration_Success", func(t *testing.T) {
 		docID := createDoc(t, "DeleteMe")
-		if err := DeleteRegistration(context.Background(), docID); err != nil {
+		if err := DeleteRegistration(context.Background(), docID, AnyRevision); err != nil {
 			t.Errorf("DeleteRegistration returned error: %v", err)
 		}
 		_, err := GetRegistrationByID(context.Background(), docID)
@@ -227,7 +253,7 @@ func TestRegistrationsFirebase(t *testing.T) {
 	})
 
 	t.Run("DeleteRegistration_NotFound", func(t *testing.T) {
-		err := DeleteRegistration(context.Background(), "doc-9999")
+		err := DeleteRegistration(context.Background(), "doc-9999", AnyRevision)
 		if err == nil {
 			t.Error("Expected error for doc-9999 not found, got nil")
 		}
@@ -241,7 +267,7 @@ func TestRegistrationsFirebase(t *testing.T) {
 				TargetCurrencies: []string{"EUR", "USD"},
 			},
 		}
-		err := PatchRegistration(context.Background(), docID, partial)
+		_, err := PatchRegistration(context.Background(), docID, partial, AnyRevision)
 		if err != nil {
 			t.Errorf("PatchRegistration error: %v", err)
 		}
@@ -256,7 +282,7 @@ func TestRegistrationsFirebase(t *testing.T) {
 
 	t.Run("PatchRegistration_NotFound", func(t *testing.T) {
 		partial := structs.Registration{Country: "PatchNowhere"}
-		err := PatchRegistration(context.Background(), "doc-9999", partial)
+		_, err := PatchRegistration(context.Background(), "doc-9999", partial, AnyRevision)
 		if err == nil {
 			t.Error("Expected error for doc-9999 not found, got nil")
 		}
@@ -0,0 +1,149 @@
+// File: assignment-2/firebase/snapshots_firebase.go
+package firebase
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"assignment-2/constants"
+	"assignment-2/structs"
+)
+
+// FUNCTION VARIABLES
+// These can be overridden in tests.
+
+var ListScheduledRegistrations func(ctx context.Context) ([]structs.Registration, error) = realListScheduledRegistrations
+var LatestDashboardSnapshot func(ctx context.Context, registrationID string) (*structs.DashboardSnapshot, error) = realLatestDashboardSnapshot
+var SaveDashboardSnapshots func(ctx context.Context, snapshots []structs.DashboardSnapshot) error = realSaveDashboardSnapshots
+var ListDashboardSnapshots func(ctx context.Context, registrationID string, from, to time.Time, limit int) ([]structs.DashboardSnapshot, error) = realListDashboardSnapshots
+
+// realListScheduledRegistrations returns the registrations that have a snapshot schedule.
+func realListScheduledRegistrations(ctx context.Context) ([]structs.Registration, error) {
+	if err := ensureClient(); err != nil {
+		return nil, err
+	}
+	snaps, err := FirestoreClient.Collection(constants.REGISTRATIONS_COLLECTION).
+		Where("snapshot", "in", []string{structs.SnapshotHourly, structs.SnapshotDaily}).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scheduled registrations: %v", err)
+	}
+	regs := make([]structs.Registration, 0, len(snaps))
+	for _, snap := range snaps {
+		var data registrationDoc
+		if err := snap.DataTo(&data); err != nil {
+			continue
+		}
+		regs = append(regs, data.toRegistration(snap.Ref.ID))
+	}
+	return regs, nil
+}
+
+// realLatestDashboardSnapshot returns the newest snapshot of a registration, or nil if it
+// has none.
+func realLatestDashboardSnapshot(ctx context.Context, registrationID string) (*structs.DashboardSnapshot, error) {
+	snapshots, err := querySnapshots(ctx, registrationID, time.Time{}, time.Time{}, 1)
+	if err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+	return &snapshots[0], nil
+}
+
+// realSaveDashboardSnapshots stores the snapshots through a BulkWriter.
+func realSaveDashboardSnapshots(ctx context.Context, snapshots []structs.DashboardSnapshot) error {
+	if err := ensureClient(); err != nil {
+		return err
+	}
+	col := FirestoreClient.Collection(constants.SNAPSHOTS_COLLECTION)
+	bw := FirestoreClient.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		job, err := bw.Create(col.NewDoc(), snapshot)
+		if err != nil {
+			return fmt.Errorf("failed to queue snapshot of %s: %v", snapshot.RegistrationID, err)
+		}
+		jobs = append(jobs, job)
+	}
+	bw.End()
+
+	failed := 0
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to store %d of %d snapshots", failed, len(snapshots))
+	}
+	return nil
+}
+
+// realListDashboardSnapshots returns up to 'limit' snapshots of a registration taken in
+// [from, to), oldest first. If there are more, the newest ones are returned. A zero
+// 'from' or 'to' leaves that end of the range open.
+func realListDashboardSnapshots(ctx context.Context, registrationID string, from, to time.Time, limit int) ([]structs.DashboardSnapshot, error) {
+	snapshots, err := querySnapshots(ctx, registrationID, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(snapshots)-1; i < j; i, j = i+1, j-1 {
+		snapshots[i], snapshots[j] = snapshots[j], snapshots[i]
+	}
+	return snapshots, nil
+}
+
+// querySnapshots reads the snapshots of a registration newest first.
+func querySnapshots(ctx context.Context, registrationID string, from, to time.Time, limit int) ([]structs.DashboardSnapshot, error) {
+	if err := ensureClient(); err != nil {
+		return nil, err
+	}
+	q := FirestoreClient.Collection(constants.SNAPSHOTS_COLLECTION).Where("registrationId", "==", registrationID)
+	if !from.IsZero() {
+		q = q.Where("time", ">=", from)
+	}
+	if !to.IsZero() {
+		q = q.Where("time", "<", to)
+	}
+	snaps, err := q.OrderBy("time", firestore.Desc).Limit(limit).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query snapshots: %v", err)
+	}
+	snapshots := make([]structs.DashboardSnapshot, 0, len(snaps))
+	for _, snap := range snaps {
+		var data structs.DashboardSnapshot
+		if err := snap.DataTo(&data); err != nil {
+			continue
+		}
+		data.ID = snap.Ref.ID
+		snapshots = append(snapshots, data)
+	}
+	return snapshots, nil
+}
+
+// PurgeDashboardSnapshots removes snapshots taken more than 'olderThan' ago.
+func PurgeDashboardSnapshots(ctx context.Context, olderThan time.Duration) error {
+	if err := ensureClient(); err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-olderThan)
+	return deleteSnapshots(ctx, FirestoreClient.Collection(constants.SNAPSHOTS_COLLECTION).Where("time", "<", cutoff))
+}
+
+// deleteSnapshots deletes every snapshot matched by 'q'.
+func deleteSnapshots(ctx context.Context, q firestore.Query) error {
+	snaps, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to query snapshots: %v", err)
+	}
+	bw := FirestoreClient.BulkWriter(ctx)
+	for _, s := range snaps {
+		if _, err := bw.Delete(s.Ref); err != nil {
+			fmt.Printf("Warning: failed to queue deletion of %s: %v\n", s.Ref.Path, err)
+		}
+	}
+	bw.End()
+	return nil
+}
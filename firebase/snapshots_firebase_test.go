@@ -0,0 +1,43 @@
+// File: assignment-2/firebase/snapshots_firebase_test.go
+package firebase
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"assignment-2/structs"
+)
+
+// TestDashboardSnapshots stores a few snapshots and reads them back against a real
+// Firestore instance.
+func TestDashboardSnapshots(t *testing.T) {
+	if FirestoreClient == nil {
+		t.Skip("FirestoreClient is not initialized. Skipping snapshot Firebase tests.")
+	}
+	ctx := context.Background()
+
+	regID := fmt.Sprintf("snapshot-test-%d", time.Now().UnixNano())
+	start := time.Now().Add(-3 * time.Hour).Truncate(time.Hour)
+	var snapshots []structs.DashboardSnapshot
+	for i := 0; i < 3; i++ {
+		snapshots = append(snapshots, structs.DashboardSnapshot{
+			RegistrationID: regID,
+			Time:           start.Add(time.Duration(i) * time.Hour),
+			Values:         map[string]map[string]float64{"NO": {"temperature": float64(i)}},
+		})
+	}
+	if err := SaveDashboardSnapshots(ctx, snapshots); err != nil {
+		t.Fatalf("SaveDashboardSnapshots failed: %v", err)
+	}
+
+	latest, err := LatestDashboardSnapshot(ctx, regID)
+	if err != nil || latest == nil || latest.Values["NO"]["temperature"] != 2 {
+		t.Fatalf("Expected the last snapshot, got %+v %v", latest, err)
+	}
+	got, err := ListDashboardSnapshots(ctx, regID, start, time.Time{}, 2)
+	if err != nil || len(got) != 2 || got[0].Values["NO"]["temperature"] != 1 {
+		t.Errorf("Expected the two newest snapshots oldest first, got %+v %v", got, err)
+	}
+}
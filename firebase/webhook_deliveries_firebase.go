@@ -0,0 +1,128 @@
+// File: assignment-2/firebase/webhook_deliveries_firebase.go
+package firebase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+
+	"assignment-2/constants"
+	"assignment-2/structs"
+)
+
+// FUNCTION VARIABLES
+// These can be overridden in tests.
+
+var SaveDeliveryAttempt func(ctx context.Context, attempt structs.DeliveryAttempt) error = realSaveDeliveryAttempt
+var ListDeliveryAttempts func(ctx context.Context, opts structs.ListOptions) ([]structs.DeliveryAttempt, string, error) = realListDeliveryAttempts
+var GetDeliverySummary func(ctx context.Context, notificationID string) (*structs.DeliverySummary, error) = realGetDeliverySummary
+
+// realSaveDeliveryAttempt adds an attempt to the delivery log.
+func realSaveDeliveryAttempt(ctx context.Context, attempt structs.DeliveryAttempt) error {
+	if err := ensureClient(); err != nil {
+		return err
+	}
+	if _, _, err := FirestoreClient.Collection(constants.WEBHOOK_DELIVERIES_COLLECTION).Add(ctx, attempt); err != nil {
+		return fmt.Errorf("failed to log delivery attempt: %v", err)
+	}
+	return nil
+}
+
+// realListDeliveryAttempts returns one page of logged attempts matching 'opts', together
+// with the cursor for the next page (empty on the last page).
+func realListDeliveryAttempts(ctx context.Context, opts structs.ListOptions) ([]structs.DeliveryAttempt, string, error) {
+	if err := ensureClient(); err != nil {
+		return nil, "", err
+	}
+	snaps, next, err := listPage(ctx, constants.WEBHOOK_DELIVERIES_COLLECTION, opts)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCursor) {
+			return nil, "", err
+		}
+		return nil, "", fmt.Errorf("failed to list delivery attempts: %v", err)
+	}
+	attempts := make([]structs.DeliveryAttempt, 0, len(snaps))
+	for _, snap := range snaps {
+		var data structs.DeliveryAttempt
+		if err := snap.DataTo(&data); err != nil {
+			continue
+		}
+		data.ID = snap.Ref.ID
+		attempts = append(attempts, data)
+	}
+	return attempts, next, nil
+}
+
+// realGetDeliverySummary counts the logged attempts of a webhook with aggregation queries,
+// so the log itself is not read, and fetches the latest attempt without its payload.
+func realGetDeliverySummary(ctx context.Context, notificationID string) (*structs.DeliverySummary, error) {
+	if err := ensureClient(); err != nil {
+		return nil, err
+	}
+	q := FirestoreClient.Collection(constants.WEBHOOK_DELIVERIES_COLLECTION).Where("notificationId", "==", notificationID)
+	attempts, err := countQuery(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	successes, err := countQuery(ctx, q.Where("success", "==", true))
+	if err != nil {
+		return nil, err
+	}
+	summary := &structs.DeliverySummary{Attempts: attempts, Successes: successes}
+	if attempts == 0 {
+		return summary, nil
+	}
+	summary.SuccessRate = float64(successes) / float64(attempts)
+
+	snaps, err := q.OrderBy("time", firestore.Desc).Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the last delivery attempt: %v", err)
+	}
+	if len(snaps) > 0 {
+		var last structs.DeliveryAttempt
+		if err := snaps[0].DataTo(&last); err == nil {
+			last.ID = snaps[0].Ref.ID
+			last.Payload = nil
+			summary.LastAttempt = &last
+		}
+	}
+	return summary, nil
+}
+
+// countQuery returns the number of documents matched by 'q'.
+func countQuery(ctx context.Context, q firestore.Query) (int, error) {
+	res, err := q.NewAggregationQuery().WithCount("count").Get(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count delivery attempts: %v", err)
+	}
+	v, ok := res["count"].(*firestorepb.Value)
+	if !ok {
+		return 0, fmt.Errorf("unexpected count result %T", res["count"])
+	}
+	return int(v.GetIntegerValue()), nil
+}
+
+// PurgeDeliveryAttempts removes logged attempts made more than 'olderThan' ago.
+func PurgeDeliveryAttempts(ctx context.Context, olderThan time.Duration) error {
+	if err := ensureClient(); err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-olderThan)
+	snaps, err := FirestoreClient.Collection(constants.WEBHOOK_DELIVERIES_COLLECTION).
+		Where("time", "<", cutoff).Documents(ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to query old delivery attempts: %v", err)
+	}
+	bw := FirestoreClient.BulkWriter(ctx)
+	for _, s := range snaps {
+		if _, err := bw.Delete(s.Ref); err != nil {
+			fmt.Printf("Warning: failed to queue deletion of %s: %v\n", s.Ref.Path, err)
+		}
+	}
+	bw.End()
+	return nil
+}
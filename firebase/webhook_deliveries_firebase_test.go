@@ -0,0 +1,44 @@
+// File: assignment-2/firebase/webhook_deliveries_firebase_test.go
+package firebase
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"assignment-2/structs"
+)
+
+// TestDeliveryAttempts logs a few attempts and reads them back against a real Firestore
+// instance.
+func TestDeliveryAttempts(t *testing.T) {
+	if FirestoreClient == nil {
+		t.Skip("FirestoreClient is not initialized. Skipping delivery log Firebase tests.")
+	}
+	ctx := context.Background()
+
+	notifID := fmt.Sprintf("delivery-log-test-%d", time.Now().UnixNano())
+	start := time.Now().Add(-time.Minute)
+	for i, ok := range []bool{false, true, true} {
+		attempt := structs.DeliveryAttempt{
+			NotificationID: notifID,
+			Attempt:        i + 1,
+			Time:           start.Add(time.Duration(i) * time.Second),
+			Payload:        []byte(`{}`),
+			Success:        ok,
+		}
+		if err := SaveDeliveryAttempt(ctx, attempt); err != nil {
+			t.Fatalf("SaveDeliveryAttempt failed: %v", err)
+		}
+	}
+
+	summary, err := GetDeliverySummary(ctx, notifID)
+	if err != nil || summary.Attempts != 3 || summary.Successes != 2 || summary.LastAttempt == nil || summary.LastAttempt.Attempt != 3 {
+		t.Fatalf("Expected 2 of 3 successful attempts, got %+v %v", summary, err)
+	}
+	page, next, err := ListDeliveryAttempts(ctx, structs.ListOptions{Limit: 2, Equals: map[string]string{"notificationId": notifID}})
+	if err != nil || len(page) != 2 || next == "" {
+		t.Errorf("Expected a first page of 2 attempts, got %d %q %v", len(page), next, err)
+	}
+}
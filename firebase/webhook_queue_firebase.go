@@ -0,0 +1,188 @@
+// File: assignment-2/firebase/webhook_queue_firebase.go
+package firebase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"assignment-2/constants"
+	"assignment-2/structs"
+)
+
+// FUNCTION VARIABLES
+// These can be overridden in tests.
+
+var SaveWebhookDelivery func(ctx context.Context, delivery structs.WebhookDelivery) (string, error) = realSaveWebhookDelivery
+var UpdateWebhookDelivery func(ctx context.Context, delivery structs.WebhookDelivery) error = realUpdateWebhookDelivery
+var ListWebhookDeliveries func(ctx context.Context, due time.Time, limit int) ([]structs.WebhookDelivery, error) = realListWebhookDeliveries
+var DeleteWebhookDelivery func(ctx context.Context, id string) error = realDeleteWebhookDelivery
+var DeadLetterWebhookDelivery func(ctx context.Context, delivery structs.WebhookDelivery) error = realDeadLetterWebhookDelivery
+var ListDeadLetters func(ctx context.Context, opts structs.ListOptions) ([]structs.WebhookDelivery, string, error) = realListDeadLetters
+var GetDeadLetter func(ctx context.Context, id string) (*structs.WebhookDelivery, error) = realGetDeadLetter
+var RequeueDeadLetter func(ctx context.Context, delivery structs.WebhookDelivery) error = realRequeueDeadLetter
+
+// ErrDeadLetterNotFound is returned when a dead letter does not exist.
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// realSaveWebhookDelivery adds a delivery to the queue collection and returns its ID.
+func realSaveWebhookDelivery(ctx context.Context, delivery structs.WebhookDelivery) (string, error) {
+	if err := ensureClient(); err != nil {
+		return "", err
+	}
+	docRef, _, err := FirestoreClient.Collection(constants.WEBHOOK_QUEUE_COLLECTION).Add(ctx, delivery)
+	if err != nil {
+		return "", fmt.Errorf("failed to queue webhook delivery: %v", err)
+	}
+	return docRef.ID, nil
+}
+
+// realUpdateWebhookDelivery stores the retry state of a queued delivery.
+func realUpdateWebhookDelivery(ctx context.Context, delivery structs.WebhookDelivery) error {
+	if err := ensureClient(); err != nil {
+		return err
+	}
+	if _, err := FirestoreClient.Collection(constants.WEBHOOK_QUEUE_COLLECTION).Doc(delivery.ID).Set(ctx, delivery); err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %v", err)
+	}
+	return nil
+}
+
+// realListWebhookDeliveries returns up to 'limit' queued deliveries whose next attempt is
+// due at 'due', the longest waiting first.
+func realListWebhookDeliveries(ctx context.Context, due time.Time, limit int) ([]structs.WebhookDelivery, error) {
+	if err := ensureClient(); err != nil {
+		return nil, err
+	}
+	snaps, err := FirestoreClient.Collection(constants.WEBHOOK_QUEUE_COLLECTION).
+		Where("nextAttempt", "<=", due).OrderBy("nextAttempt", firestore.Asc).
+		Limit(limit).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %v", err)
+	}
+	deliveries := make([]structs.WebhookDelivery, 0, len(snaps))
+	for _, snap := range snaps {
+		var data structs.WebhookDelivery
+		if err := snap.DataTo(&data); err != nil {
+			continue
+		}
+		data.ID = snap.Ref.ID
+		deliveries = append(deliveries, data)
+	}
+	return deliveries, nil
+}
+
+// realDeleteWebhookDelivery removes a delivery from the queue collection.
+func realDeleteWebhookDelivery(ctx context.Context, id string) error {
+	if err := ensureClient(); err != nil {
+		return err
+	}
+	if _, err := FirestoreClient.Collection(constants.WEBHOOK_QUEUE_COLLECTION).Doc(id).Delete(ctx); err != nil {
+		return fmt.Errorf("failed to delete webhook delivery: %v", err)
+	}
+	return nil
+}
+
+// realDeadLetterWebhookDelivery moves a delivery from the queue to the dead letters,
+// keeping its ID. A delivery that was never stored gets a new ID.
+func realDeadLetterWebhookDelivery(ctx context.Context, delivery structs.WebhookDelivery) error {
+	if err := ensureClient(); err != nil {
+		return err
+	}
+	dead := FirestoreClient.Collection(constants.WEBHOOK_DEAD_LETTERS_COLLECTION)
+	if delivery.ID == "" {
+		if _, _, err := dead.Add(ctx, delivery); err != nil {
+			return fmt.Errorf("failed to store dead letter: %v", err)
+		}
+		return nil
+	}
+	queued := FirestoreClient.Collection(constants.WEBHOOK_QUEUE_COLLECTION).Doc(delivery.ID)
+	err := FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if err := tx.Set(dead.Doc(delivery.ID), delivery); err != nil {
+			return err
+		}
+		return tx.Delete(queued)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store dead letter: %v", err)
+	}
+	return nil
+}
+
+// realListDeadLetters returns one page of dead letters matching 'opts', together with the
+// cursor for the next page (empty on the last page).
+func realListDeadLetters(ctx context.Context, opts structs.ListOptions) ([]structs.WebhookDelivery, string, error) {
+	if err := ensureClient(); err != nil {
+		return nil, "", err
+	}
+	snaps, next, err := listPage(ctx, constants.WEBHOOK_DEAD_LETTERS_COLLECTION, opts)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCursor) {
+			return nil, "", err
+		}
+		return nil, "", fmt.Errorf("failed to list dead letters: %v", err)
+	}
+	letters := make([]structs.WebhookDelivery, 0, len(snaps))
+	for _, snap := range snaps {
+		var data structs.WebhookDelivery
+		if err := snap.DataTo(&data); err != nil {
+			continue
+		}
+		data.ID = snap.Ref.ID
+		letters = append(letters, data)
+	}
+	return letters, next, nil
+}
+
+// realGetDeadLetter returns a single dead letter.
+func realGetDeadLetter(ctx context.Context, id string) (*structs.WebhookDelivery, error) {
+	if err := ensureClient(); err != nil {
+		return nil, err
+	}
+	snap, err := FirestoreClient.Collection(constants.WEBHOOK_DEAD_LETTERS_COLLECTION).Doc(id).Get(ctx)
+	if snap != nil && !snap.Exists() {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letter: %v", err)
+	}
+	var data structs.WebhookDelivery
+	if err := snap.DataTo(&data); err != nil {
+		return nil, fmt.Errorf("failed to parse dead letter: %v", err)
+	}
+	data.ID = id
+	return &data, nil
+}
+
+// realRequeueDeadLetter moves a dead letter back to the queue under its ID, storing the
+// given (reset) delivery.
+func realRequeueDeadLetter(ctx context.Context, delivery structs.WebhookDelivery) error {
+	if err := ensureClient(); err != nil {
+		return err
+	}
+	dead := FirestoreClient.Collection(constants.WEBHOOK_DEAD_LETTERS_COLLECTION).Doc(delivery.ID)
+	queued := FirestoreClient.Collection(constants.WEBHOOK_QUEUE_COLLECTION).Doc(delivery.ID)
+	err := FirestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snaps, err := tx.GetAll([]*firestore.DocumentRef{dead})
+		if err != nil {
+			return err
+		}
+		if len(snaps) == 0 || !snaps[0].Exists() {
+			return ErrDeadLetterNotFound
+		}
+		if err := tx.Set(queued, delivery); err != nil {
+			return err
+		}
+		return tx.Delete(dead)
+	})
+	if errors.Is(err, ErrDeadLetterNotFound) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to requeue dead letter: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// File: assignment-2/firebase/webhook_queue_firebase_test.go
+package firebase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"assignment-2/structs"
+)
+
+// TestWebhookDeliveries queues, lists and removes a delivery against a real Firestore
+// instance.
+func TestWebhookDeliveries(t *testing.T) {
+	if FirestoreClient == nil {
+		t.Skip("FirestoreClient is not initialized. Skipping webhook queue Firebase tests.")
+	}
+	ctx := context.Background()
+
+	id, err := SaveWebhookDelivery(ctx, structs.WebhookDelivery{
+		NotificationID: "queue-test",
+		URL:            "http://example.org/hook",
+		Event:          "REGISTER",
+		Payload:        []byte(`{"event":"REGISTER"}`),
+		Enqueued:       time.Unix(0, 0),
+		NextAttempt:    time.Unix(0, 0),
+	})
+	if err != nil {
+		t.Fatalf("SaveWebhookDelivery failed: %v", err)
+	}
+
+	deliveries, err := ListWebhookDeliveries(ctx, time.Now(), 1)
+	if err != nil || len(deliveries) != 1 || deliveries[0].ID != id || string(deliveries[0].Payload) != `{"event":"REGISTER"}` {
+		t.Errorf("Expected the queued delivery first, got %+v %v", deliveries, err)
+	}
+	if err := DeleteWebhookDelivery(ctx, id); err != nil {
+		t.Errorf("DeleteWebhookDelivery failed: %v", err)
+	}
+}
+
+// TestDeadLetters moves a delivery to the dead letters and back against a real Firestore
+// instance.
+func TestDeadLetters(t *testing.T) {
+	if FirestoreClient == nil {
+		t.Skip("FirestoreClient is not initialized. Skipping dead letter Firebase tests.")
+	}
+	ctx := context.Background()
+
+	delivery := structs.WebhookDelivery{NotificationID: "dead-letter-test", URL: "http://example.org/hook", Attempts: 3}
+	id, err := SaveWebhookDelivery(ctx, delivery)
+	if err != nil {
+		t.Fatalf("SaveWebhookDelivery failed: %v", err)
+	}
+	delivery.ID = id
+	if err := DeadLetterWebhookDelivery(ctx, delivery); err != nil {
+		t.Fatalf("DeadLetterWebhookDelivery failed: %v", err)
+	}
+	letter, err := GetDeadLetter(ctx, id)
+	if err != nil || letter.Attempts != 3 {
+		t.Fatalf("Expected the dead letter, got %+v %v", letter, err)
+	}
+
+	letter.Attempts = 0
+	if err := RequeueDeadLetter(ctx, *letter); err != nil {
+		t.Fatalf("RequeueDeadLetter failed: %v", err)
+	}
+	if _, err := GetDeadLetter(ctx, id); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Errorf("Expected the dead letter to be gone, got %v", err)
+	}
+	_ = DeleteWebhookDelivery(ctx, id)
+}
@@ -0,0 +1,92 @@
+// File: assignment-2/handlers/dashboard_batch.go
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"assignment-2/constants"
+	"assignment-2/firebase"
+	"assignment-2/structs"
+	"assignment-2/tools"
+)
+
+// dashboardBatchRequest is the body of POST /dashboards/batch.
+type dashboardBatchRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// dashboardBatchItem is the outcome for one requested ID: the dashboard, or the status
+// and message the single-dashboard endpoint would have answered with.
+type dashboardBatchItem struct {
+	Status    int                `json:"status"`
+	Dashboard *structs.Dashboard `json:"dashboard,omitempty"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// handleDashboardBatch handles POST /dashboards/batch. The registrations are read with one
+// Firestore multi-get and their dashboards built in parallel, sharing upstream lookups
+// (one currency call per base currency, one country call per country, ...). The response
+// maps every requested ID to its dashboard or error; an INVOKE event is triggered for
+// every dashboard returned.
+func handleDashboardBatch(w http.ResponseWriter, r *http.Request) {
+	var req dashboardBatchRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Invalid JSON request body")
+		return
+	}
+
+	// Duplicate IDs are answered once
+	var ids []string
+	seen := make(map[string]bool, len(req.IDs))
+	for _, id := range req.IDs {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "At least one registration ID is required in 'ids'")
+		return
+	}
+	if len(ids) > constants.MAX_BATCH_DASHBOARDS {
+		tools.WriteJsonErrorResponse(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("A batch may contain at most %d registration IDs", constants.MAX_BATCH_DASHBOARDS))
+		return
+	}
+
+	stored, err := firebase.GetRegistrationsByIDs(r.Context(), ids)
+	if err != nil {
+		log.Printf("Error retrieving registrations for dashboard batch: %v\n", err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not retrieve registrations")
+		return
+	}
+
+	result := make(map[string]dashboardBatchItem, len(ids))
+	var found []structs.Registration
+	var foundIDs []string
+	for _, id := range ids {
+		reg, ok := stored[id]
+		if !ok {
+			result[id] = dashboardBatchItem{Status: http.StatusNotFound, Error: "Registration not found"}
+			continue
+		}
+		found = append(found, reg)
+		foundIDs = append(foundIDs, id)
+	}
+	for i, dash := range buildDashboards(found) {
+		result[foundIDs[i]] = dashboardBatchItem{Status: http.StatusOK, Dashboard: &dash}
+	}
+	tools.WriteJsonResponse(w, http.StatusOK, result)
+
+	for _, reg := range found {
+		TriggerWebhookEventVar(registrationEvent("INVOKE", reg))
+	}
+}
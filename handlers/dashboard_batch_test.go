@@ -0,0 +1,109 @@
+// File: assignment-2/handlers/dashboard_batch_test.go
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"assignment-2/constants"
+	"assignment-2/services"
+	"assignment-2/structs"
+)
+
+// TestDashboardBatch tests POST /dashboard/v1/dashboards/batch.
+func TestDashboardBatch(t *testing.T) {
+	overrideStubs()
+	defer revertStubs()
+
+	features := structs.Features{Capital: true, TargetCurrencies: []string{"EUR"}}
+	storeRegistration("batch-1", structs.Registration{ID: "batch-1", ISOCode: "NO", Features: features})
+	storeRegistration("batch-2", structs.Registration{ID: "batch-2", Country: "Norway", Features: features})
+	storeRegistration("batch-3", structs.Registration{ID: "batch-3", ISOCode: "no", Features: features})
+
+	// Count the upstream calls to check that shared data is fetched once
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	count := func(key string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[key]++
+	}
+	countryStub, ratesStub := services.FetchCountryInfo, services.FetchCurrencyRates
+	services.FetchCountryInfo = func(c string) (*structs.CountryInfo, error) {
+		count("country:" + c)
+		return countryStub(c)
+	}
+	services.FetchCurrencyRates = func(base string) (structs.CurrencyRates, error) {
+		count("currency:" + base)
+		return ratesStub(base)
+	}
+
+	var events []string
+	TriggerWebhookEventVar = func(ev structs.WebhookEvent) { events = append(events, ev.Event) }
+
+	batch := func(body string) (*httptest.ResponseRecorder, map[string]dashboardBatchItem) {
+		req := httptest.NewRequest(http.MethodPost, constants.DASHBOARDS_BATCH_PATH, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		DashboardsRouter(rr, req)
+		var result map[string]dashboardBatchItem
+		_ = json.Unmarshal(rr.Body.Bytes(), &result)
+		return rr, result
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		rr, result := batch(`{"ids":["batch-1","batch-2","batch-3","missing","batch-1"]}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if len(result) != 4 {
+			t.Fatalf("Expected 4 entries, got %v", result)
+		}
+		for _, id := range []string{"batch-1", "batch-2", "batch-3"} {
+			item := result[id]
+			if item.Status != http.StatusOK || item.Dashboard == nil || item.Dashboard.Features.TargetCurrencies["EUR"] != 0.09 {
+				t.Errorf("%s: unexpected item %+v", id, item)
+			}
+		}
+		if item := result["missing"]; item.Status != http.StatusNotFound || item.Error == "" {
+			t.Errorf("Expected a 404 entry for the missing ID, got %+v", item)
+		}
+
+		// "NO" and "no" share one lookup; NOK rates are fetched once for all three
+		if calls["currency:NOK"] != 1 || calls["country:Norway"] != 1 || calls["country:NO"]+calls["country:no"] != 1 {
+			t.Errorf("Expected deduplicated upstream calls, got %v", calls)
+		}
+		if len(events) != 3 {
+			t.Errorf("Expected one INVOKE per dashboard, got %v", events)
+		}
+	})
+
+	t.Run("BadRequests", func(t *testing.T) {
+		if rr, _ := batch(`{"ids":[]}`); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an empty batch, got %d", rr.Code)
+		}
+		if rr, _ := batch(`{"registrations":["batch-1"]}`); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an unknown field, got %d", rr.Code)
+		}
+		ids := make([]string, constants.MAX_BATCH_DASHBOARDS+1)
+		for i := range ids {
+			ids[i] = fmt.Sprintf("%q", fmt.Sprintf("id-%d", i))
+		}
+		if rr, _ := batch(`{"ids":[` + strings.Join(ids, ",") + `]}`); rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected 413 for too many IDs, got %d", rr.Code)
+		}
+	})
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, constants.DASHBOARDS_BATCH_PATH, nil)
+		rr := httptest.NewRecorder()
+		DashboardsRouter(rr, req)
+		if rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected 405, got %d", rr.Code)
+		}
+	})
+}
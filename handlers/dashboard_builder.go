@@ -0,0 +1,500 @@
+// file: assignment-2/handlers/dashboard_builder.go
+package handlers
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"assignment-2/constants"
+	"assignment-2/services"
+	"assignment-2/structs"
+)
+
+// buildDashboard assembles the dashboard for a registration by fetching the requested
+// features from the external services. Upstream failures are logged and the affected
+// features are left out, so a partial dashboard is still returned.
+func buildDashboard(reg *structs.Registration) structs.Dashboard {
+	return buildDashboardFrom(directUpstream(), reg)
+}
+
+// buildDashboardFrom builds the dashboard of 'reg' with the fetchers in 'src'.
+func buildDashboardFrom(src upstream, reg *structs.Registration) structs.Dashboard {
+	dash, _ := measureDashboard(src, reg)
+	return dash
+}
+
+// measureDashboard builds the dashboard of 'reg' and also returns the numeric features
+// that were retrieved, per country (see fetchCountryFeatures).
+func measureDashboard(src upstream, reg *structs.Registration) (structs.Dashboard, map[string]map[string]float64) {
+	if reg.IsComparison() {
+		return buildComparisonDashboard(src, reg)
+	}
+	var dash structs.Dashboard
+	dash.Country = reg.Country
+	dash.ISOCode = reg.ISOCode
+
+	key := reg.Country
+	if key == "" {
+		key = reg.ISOCode
+	}
+	var metrics map[string]float64
+	dash.Features, metrics = fetchCountryFeatures(src, key, reg.Features)
+	dash.LastRetrieval = time.Now()
+	return dash, map[string]map[string]float64{key: metrics}
+}
+
+// fetchCountryFeatures fetches the requested features of one country. Alongside the
+// dashboard block it returns the numeric features that were actually retrieved, keyed as
+// in the comparison aggregates, so missing data can be told apart from a zero value.
+func fetchCountryFeatures(src upstream, key string, features structs.Features) (structs.DashboardFeatures, map[string]float64) {
+	var df structs.DashboardFeatures
+	metrics := make(map[string]float64)
+	var err error
+
+	units := make(map[string]string)
+
+	var cInfo *structs.CountryInfo
+	if needsCountry(features) || needsMeteo(features) || len(features.TargetCurrencies) > 0 {
+		cInfo, err = src.countryInfo(key)
+		if err != nil {
+			log.Printf("Warning: could not fetch country info for '%s': %v\n", key, err)
+		}
+	}
+	if cInfo != nil {
+		conditionChecks.watch(key, cInfo)
+		areaUnit := optionOrDefault(features.AreaUnit, structs.AreaSquareKilometres)
+		if features.Capital {
+			df.Capital = cInfo.Capital
+		}
+		if features.Coordinates {
+			df.Coordinates = &structs.Coordinates{
+				Lat: cInfo.Coordinates.Lat,
+				Lon: cInfo.Coordinates.Lon,
+			}
+		}
+		if features.Population {
+			df.Population = cInfo.Population
+			metrics["population"] = float64(cInfo.Population)
+		}
+		if features.Area {
+			df.Area = convertArea(cInfo.Area, areaUnit)
+			metrics["area"] = df.Area
+			units["area"] = areaUnitLabels[areaUnit]
+		}
+		if features.PopulationDensity && cInfo.Area > 0 {
+			area := cInfo.Area
+			if areaUnit == structs.AreaSquareMiles {
+				area /= squareKilometresPerSquareMile
+			}
+			df.PopulationDensity = round2(float64(cInfo.Population) / area)
+			metrics["populationDensity"] = df.PopulationDensity
+			units["populationDensity"] = "people/" + areaUnitLabels[areaUnit]
+		}
+
+		// The capital's location, or the country's if REST Countries has none
+		capital := cInfo.CapitalCoordinates
+		if capital == (structs.Coordinates{}) {
+			capital = cInfo.Coordinates
+		}
+		if features.LocalTime {
+			if zone, loc, ok := capitalTimeZone(cInfo.Timezones, capital.Lon); ok {
+				df.LocalTime = time.Now().In(loc).Format(time.RFC3339)
+				df.TimeZone = zone
+			}
+		}
+		if p := features.DistanceFrom; p != nil {
+			df.DistanceToCapital = round2(greatCircleKm(p.Latitude, p.Longitude, capital.Lat, capital.Lon))
+			metrics["distanceToCapital"] = df.DistanceToCapital
+			units["distanceToCapital"] = "km"
+		}
+	}
+
+	// If temperature/precipitation... call open-meteo
+	if needsMeteo(features) && cInfo != nil {
+		mData, errM := src.meteoData(cInfo.Coordinates.Lat, cInfo.Coordinates.Lon)
+		if errM == nil && mData != nil {
+			if features.Temperature {
+				unit := optionOrDefault(features.TemperatureUnit, structs.TemperatureCelsius)
+				df.Temperature = convertTemperature(mData.AverageTemp, unit)
+				metrics["temperature"] = df.Temperature
+				units["temperature"] = temperatureUnitLabels[unit]
+			}
+			if features.Precipitation {
+				mode := optionOrDefault(features.PrecipitationMode, structs.PrecipitationAverage)
+				df.Precipitation = mData.AveragePrecipitation
+				if mode == structs.PrecipitationTotal {
+					df.Precipitation = round2(mData.TotalPrecipitation)
+				}
+				metrics["precipitation"] = df.Precipitation
+				units["precipitation"] = precipitationUnitLabels[mode]
+			}
+		} else {
+			log.Printf("Warning: fetch meteo data lat=%.2f lon=%.2f: %v\n",
+				cInfo.Coordinates.Lat, cInfo.Coordinates.Lon, errM)
+		}
+	}
+
+	// If targetCurrencies... call currency API if cInfo.BaseCurrency is not empty
+	if len(features.TargetCurrencies) > 0 && cInfo != nil && cInfo.BaseCurrency != "" {
+		rates, errC := src.currencyRates(cInfo.BaseCurrency)
+		if errC == nil && rates != nil {
+			tcMap := make(map[string]float64)
+			for _, cur := range features.TargetCurrencies {
+				if val, ok := rates[cur]; ok {
+					tcMap[cur] = val
+					metrics["targetCurrencies."+cur] = val
+				}
+			}
+			df.TargetCurrencies = tcMap
+			units["targetCurrencies"] = "per 1 " + cInfo.BaseCurrency
+		} else {
+			log.Printf("Warning: fetch currency rates for base=%s: %v\n", cInfo.BaseCurrency, errC)
+		}
+	}
+	if len(units) > 0 {
+		df.Units = units
+	}
+	return df, metrics
+}
+
+// buildComparisonDashboard builds one feature block per compared country, fetching the
+// countries in parallel (at most MAX_EXPAND_CONCURRENCY at once), and summarises every
+// numeric feature across them.
+func buildComparisonDashboard(src upstream, reg *structs.Registration) (structs.Dashboard, map[string]map[string]float64) {
+	countries := comparisonCountries(src, reg)
+	blocks := make([]structs.CountryDashboard, len(countries))
+	metrics := make([]map[string]float64, len(countries))
+
+	sem := make(chan struct{}, constants.MAX_EXPAND_CONCURRENCY)
+	var wg sync.WaitGroup
+	for i := range countries {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			blocks[i].Country = countries[i]
+			blocks[i].Features, metrics[i] = fetchCountryFeatures(src, countries[i], reg.Features)
+		}(i)
+	}
+	wg.Wait()
+
+	values := make(map[string]map[string]float64, len(countries))
+	for i, country := range countries {
+		values[country] = metrics[i]
+	}
+	return structs.Dashboard{
+		Region:        reg.Region,
+		Countries:     blocks,
+		Aggregates:    aggregateMetrics(countries, metrics),
+		LastRetrieval: time.Now(),
+	}, values
+}
+
+// comparisonCountries returns the countries compared by 'reg': the listed countries
+// followed by the members of its region, without duplicates, and at most
+// MAX_COMPARISON_COUNTRIES of them. If the region cannot be resolved, only the listed
+// countries are compared.
+func comparisonCountries(src upstream, reg *structs.Registration) []string {
+	candidates := append([]string{}, reg.Countries...)
+	if reg.Region != "" {
+		members, err := src.regionCountries(reg.Region)
+		if err != nil {
+			log.Printf("Warning: could not fetch the countries of region '%s': %v\n", reg.Region, err)
+		}
+		candidates = append(candidates, members...)
+	}
+
+	seen := make(map[string]bool, len(candidates))
+	countries := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		key := strings.ToLower(strings.TrimSpace(c))
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		countries = append(countries, strings.TrimSpace(c))
+	}
+	if len(countries) > constants.MAX_COMPARISON_COUNTRIES {
+		log.Printf("Warning: comparing only the first %d of %d countries of region '%s'\n",
+			constants.MAX_COMPARISON_COUNTRIES, len(countries), reg.Region)
+		countries = countries[:constants.MAX_COMPARISON_COUNTRIES]
+	}
+	return countries
+}
+
+// aggregateMetrics computes min, max, mean and rank of every metric over the countries
+// that reported it. 'metrics' is index-aligned with 'countries'.
+func aggregateMetrics(countries []string, metrics []map[string]float64) map[string]structs.FeatureAggregate {
+	type entry struct {
+		country string
+		value   float64
+	}
+	byMetric := make(map[string][]entry)
+	for i, m := range metrics {
+		for name, value := range m {
+			byMetric[name] = append(byMetric[name], entry{countries[i], value})
+		}
+	}
+	if len(byMetric) == 0 {
+		return nil
+	}
+
+	aggregates := make(map[string]structs.FeatureAggregate, len(byMetric))
+	for name, entries := range byMetric {
+		// Highest value first; ties are ordered by country so the result is stable
+		sort.Slice(entries, func(a, b int) bool {
+			if entries[a].value != entries[b].value {
+				return entries[a].value > entries[b].value
+			}
+			return entries[a].country < entries[b].country
+		})
+		agg := structs.FeatureAggregate{
+			Max:  entries[0].value,
+			Min:  entries[len(entries)-1].value,
+			Rank: make([]string, len(entries)),
+		}
+		var sum float64
+		for i, e := range entries {
+			sum += e.value
+			agg.Rank[i] = e.country
+		}
+		agg.Mean = sum / float64(len(entries))
+		aggregates[name] = agg
+	}
+	return aggregates
+}
+
+// buildDashboards builds the dashboards for several registrations in parallel, with at
+// most MAX_EXPAND_CONCURRENCY builds running at once. Upstream data shared between the
+// registrations is fetched once. The result is index-aligned with 'regs'.
+func buildDashboards(regs []structs.Registration) []structs.Dashboard {
+	dashboards, _ := measureDashboards(regs)
+	return dashboards
+}
+
+// measureDashboards is buildDashboards that also returns the retrieved values of every
+// dashboard (see measureDashboard).
+func measureDashboards(regs []structs.Registration) ([]structs.Dashboard, []map[string]map[string]float64) {
+	src := memoizedUpstream()
+	dashboards := make([]structs.Dashboard, len(regs))
+	values := make([]map[string]map[string]float64, len(regs))
+	sem := make(chan struct{}, constants.MAX_EXPAND_CONCURRENCY)
+	var wg sync.WaitGroup
+	for i := range regs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			dashboards[i], values[i] = measureDashboard(src, &regs[i])
+		}(i)
+	}
+	wg.Wait()
+	return dashboards, values
+}
+
+// upstream bundles the external fetchers a dashboard is built from.
+type upstream struct {
+	countryInfo     func(countryOrISO string) (*structs.CountryInfo, error)
+	meteoData       func(lat, lon float64) (*structs.MeteoData, error)
+	currencyRates   func(base string) (structs.CurrencyRates, error)
+	regionCountries func(region string) ([]string, error)
+}
+
+// directUpstream calls the services fetchers for every lookup.
+func directUpstream() upstream {
+	return upstream{
+		countryInfo:     services.FetchCountryInfo,
+		meteoData:       services.FetchMeteoData,
+		currencyRates:   services.FetchCurrencyRates,
+		regionCountries: services.FetchRegionCountries,
+	}
+}
+
+// memoizedUpstream returns fetchers that remember their results, so dashboards built
+// together fetch each country, location, base currency and region only once. Concurrent
+// lookups of the same key wait for the first one; failures are remembered as well.
+func memoizedUpstream() upstream {
+	direct := directUpstream()
+	countries := newMemo[string, *structs.CountryInfo]()
+	meteo := newMemo[[2]float64, *structs.MeteoData]()
+	rates := newMemo[string, structs.CurrencyRates]()
+	regions := newMemo[string, []string]()
+	return upstream{
+		countryInfo: func(countryOrISO string) (*structs.CountryInfo, error) {
+			return countries.do(strings.ToLower(countryOrISO), func() (*structs.CountryInfo, error) {
+				return direct.countryInfo(countryOrISO)
+			})
+		},
+		meteoData: func(lat, lon float64) (*structs.MeteoData, error) {
+			return meteo.do([2]float64{lat, lon}, func() (*structs.MeteoData, error) {
+				return direct.meteoData(lat, lon)
+			})
+		},
+		currencyRates: func(base string) (structs.CurrencyRates, error) {
+			return rates.do(strings.ToUpper(base), func() (structs.CurrencyRates, error) {
+				return direct.currencyRates(base)
+			})
+		},
+		regionCountries: func(region string) ([]string, error) {
+			return regions.do(strings.ToLower(region), func() ([]string, error) {
+				return direct.regionCountries(region)
+			})
+		},
+	}
+}
+
+// cacheKeys records the cache keys of the upstream data a dashboard was built from.
+type cacheKeys struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+// add records 'key' if the lookup succeeded.
+func (c *cacheKeys) add(key string, err error) {
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys[key] = struct{}{}
+}
+
+// maxAge returns how many seconds are left at 'now' until the first of the recorded cache
+// entries expires. Zero means no upstream data was used or an expiry is unknown.
+func (c *cacheKeys) maxAge(now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	least := -1
+	for key := range c.keys {
+		expires, ok := services.CacheExpiry(key)
+		if !ok {
+			return 0
+		}
+		left := int(expires.Sub(now) / time.Second)
+		if left <= 0 {
+			return 0
+		}
+		if least < 0 || left < least {
+			least = left
+		}
+	}
+	if least < 0 {
+		return 0
+	}
+	return least
+}
+
+// trackedUpstream wraps 'src' so that the cache key of every successful lookup is recorded.
+func trackedUpstream(src upstream) (upstream, *cacheKeys) {
+	used := &cacheKeys{keys: make(map[string]struct{})}
+	return upstream{
+		countryInfo: func(countryOrISO string) (*structs.CountryInfo, error) {
+			info, err := src.countryInfo(countryOrISO)
+			used.add(services.CountryCacheKey(countryOrISO), err)
+			return info, err
+		},
+		meteoData: func(lat, lon float64) (*structs.MeteoData, error) {
+			data, err := src.meteoData(lat, lon)
+			used.add(services.MeteoCacheKey(lat, lon), err)
+			return data, err
+		},
+		currencyRates: func(base string) (structs.CurrencyRates, error) {
+			rates, err := src.currencyRates(base)
+			used.add(services.CurrencyCacheKey(base), err)
+			return rates, err
+		},
+		regionCountries: func(region string) ([]string, error) {
+			names, err := src.regionCountries(region)
+			used.add(services.RegionCacheKey(region), err)
+			return names, err
+		},
+	}, used
+}
+
+// memo caches the result of one fetch per key.
+type memo[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*memoCall[V]
+}
+
+// memoCall is a fetch that is in progress (done still open) or finished.
+type memoCall[V any] struct {
+	done chan struct{}
+	val  V
+	err  error
+}
+
+func newMemo[K comparable, V any]() *memo[K, V] {
+	return &memo[K, V]{calls: make(map[K]*memoCall[V])}
+}
+
+// do returns the result stored for 'key', calling 'fetch' if there is none yet.
+func (m *memo[K, V]) do(key K, fetch func() (V, error)) (V, error) {
+	m.mu.Lock()
+	if call, ok := m.calls[key]; ok {
+		m.mu.Unlock()
+		<-call.done
+		return call.val, call.err
+	}
+	call := &memoCall[V]{done: make(chan struct{})}
+	m.calls[key] = call
+	m.mu.Unlock()
+
+	call.val, call.err = fetch()
+	close(call.done)
+	return call.val, call.err
+}
+
+// needsMeteo reports whether any Open-Meteo backed feature is requested.
+func needsMeteo(f structs.Features) bool {
+	return f.Temperature || f.Precipitation
+}
+
+// dashboardContent returns 'dash' without the values that change on every build (the
+// retrieval time and local times), for content hashes that only change with the data.
+func dashboardContent(dash structs.Dashboard) structs.Dashboard {
+	dash.LastRetrieval = time.Time{}
+	dash.Features.LocalTime = ""
+	if len(dash.Countries) > 0 {
+		blocks := make([]structs.CountryDashboard, len(dash.Countries))
+		for i, block := range dash.Countries {
+			block.Features.LocalTime = ""
+			blocks[i] = block
+		}
+		dash.Countries = blocks
+	}
+	return dash
+}
+
+// needsCountry reports whether the features include data from REST Countries itself.
+func needsCountry(f structs.Features) bool {
+	return f.Capital || f.Population || f.Area || f.Coordinates ||
+		f.PopulationDensity || f.LocalTime || f.DistanceFrom != nil
+}
+
+// dashboardRefreshSeconds returns how often (in seconds) a dashboard for these features
+// can change: the shortest cache TTL among the external sources the dashboard draws from.
+// Zero means the dashboard uses no external data.
+func dashboardRefreshSeconds(f structs.Features) int {
+	ttlHours := 0
+	use := func(hours int) {
+		if ttlHours == 0 || hours < ttlHours {
+			ttlHours = hours
+		}
+	}
+	if needsCountry(f) {
+		use(constants.COUNTRY_CACHE_TTL_HOURS)
+	}
+	if needsMeteo(f) {
+		use(constants.METEO_CACHE_TTL_HOURS)
+	}
+	if len(f.TargetCurrencies) > 0 {
+		use(constants.CURRENCY_CACHE_TTL_HOURS)
+	}
+	return ttlHours * 3600
+}
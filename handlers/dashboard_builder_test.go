@@ -0,0 +1,174 @@
+// File: assignment-2/handlers/dashboard_builder_test.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"assignment-2/constants"
+	"assignment-2/services"
+	"assignment-2/structs"
+)
+
+// TestBuildDashboard checks the assembly of a dashboard from stubbed services.
+func TestBuildDashboard(t *testing.T) {
+	overrideStubs()
+	defer revertStubs()
+
+	t.Run("TemperatureOnly", func(t *testing.T) {
+		reg := &structs.Registration{ISOCode: "NO", Features: structs.Features{Temperature: true}}
+		dash := buildDashboard(reg)
+		if dash.Features.Temperature != 5.5 {
+			t.Errorf("Expected Temperature=5.5, got %f", dash.Features.Temperature)
+		}
+		if dash.Features.Capital != "" || dash.Features.Population != 0 {
+			t.Errorf("Expected only temperature to be filled, got %+v", dash.Features)
+		}
+	})
+
+	t.Run("UnknownCurrencyIsSkipped", func(t *testing.T) {
+		reg := &structs.Registration{ISOCode: "NO", Features: structs.Features{TargetCurrencies: []string{"EUR", "XXX"}}}
+		dash := buildDashboard(reg)
+		if len(dash.Features.TargetCurrencies) != 1 || dash.Features.TargetCurrencies["EUR"] != 0.09 {
+			t.Errorf("Expected only EUR=0.09, got %v", dash.Features.TargetCurrencies)
+		}
+	})
+
+	t.Run("UnitsAndDerivedMetrics", func(t *testing.T) {
+		reg := &structs.Registration{ISOCode: "NO", Features: structs.Features{
+			Temperature: true, Precipitation: true, Area: true, PopulationDensity: true, LocalTime: true,
+			TemperatureUnit: "F", AreaUnit: "mi2", PrecipitationMode: "total",
+			DistanceFrom: &structs.ReferencePoint{Name: "Bergen", Latitude: 60.39, Longitude: 5.32},
+		}}
+		dash, values := measureDashboard(directUpstream(), reg)
+		f := dash.Features
+		if f.Temperature != 41.9 || f.Area != 148729.25 || f.PopulationDensity != 36.12 {
+			t.Errorf("Unexpected converted values: %+v", f)
+		}
+		if f.Precipitation != 3.4 {
+			t.Errorf("Expected the precipitation total 3.4, got %v", f.Precipitation)
+		}
+		if f.DistanceToCapital < 300 || f.DistanceToCapital > 310 {
+			t.Errorf("Expected about 305 km from Bergen to Oslo, got %v", f.DistanceToCapital)
+		}
+		if f.TimeZone != "UTC+01:00" || !strings.HasSuffix(f.LocalTime, "+01:00") {
+			t.Errorf("Expected the local time in UTC+01:00, got %q %q", f.LocalTime, f.TimeZone)
+		}
+		want := map[string]string{
+			"temperature": "°F", "precipitation": "mm", "area": "mi²",
+			"populationDensity": "people/mi²", "distanceToCapital": "km",
+		}
+		if !reflect.DeepEqual(f.Units, want) {
+			t.Errorf("Expected units %v, got %v", want, f.Units)
+		}
+		if values["NO"]["temperature"] != 41.9 {
+			t.Errorf("Expected the snapshot values in the registration's units, got %v", values)
+		}
+	})
+}
+
+// TestBuildDashboards checks that parallel builds keep the order of the registrations.
+func TestBuildDashboards(t *testing.T) {
+	overrideStubs()
+	defer revertStubs()
+
+	regs := make([]structs.Registration, constants.MAX_EXPAND_CONCURRENCY*2+1)
+	for i := range regs {
+		regs[i] = structs.Registration{ID: fmt.Sprintf("reg-%d", i), Country: fmt.Sprintf("Country-%d", i)}
+	}
+	dashboards := buildDashboards(regs)
+	if len(dashboards) != len(regs) {
+		t.Fatalf("Expected %d dashboards, got %d", len(regs), len(dashboards))
+	}
+	for i, dash := range dashboards {
+		if dash.Country != regs[i].Country {
+			t.Errorf("Dashboard %d: expected country %s, got %s", i, regs[i].Country, dash.Country)
+		}
+	}
+}
+
+// TestBuildComparisonDashboard checks the per-country blocks and aggregates of a
+// registration that compares a list of countries and a region.
+func TestBuildComparisonDashboard(t *testing.T) {
+	overrideStubs()
+	defer revertStubs()
+	populations := map[string]int64{"Finland": 5600000, "sweden": 10500000, "Denmark": 5900000}
+	services.FetchCountryInfo = func(country string) (*structs.CountryInfo, error) {
+		pop, ok := populations[country]
+		if !ok {
+			return nil, errors.New("unknown country")
+		}
+		return &structs.CountryInfo{Name: country, Population: pop, BaseCurrency: "NOK"}, nil
+	}
+
+	reg := &structs.Registration{
+		Countries: []string{"Finland", "sweden"},
+		Region:    "Scandinavia",
+		Features:  structs.Features{Population: true},
+	}
+	dash := buildDashboard(reg)
+
+	// "sweden" and the region's "Sweden" are the same country
+	var countries []string
+	for _, block := range dash.Countries {
+		countries = append(countries, block.Country)
+	}
+	if !reflect.DeepEqual(countries, []string{"Finland", "sweden", "Denmark", "Norway"}) {
+		t.Fatalf("Unexpected countries: %v", countries)
+	}
+	if dash.Region != "Scandinavia" || dash.Countries[1].Features.Population != 10500000 {
+		t.Errorf("Unexpected dashboard: %+v", dash)
+	}
+
+	// Norway could not be fetched and is left out of the aggregates
+	agg, ok := dash.Aggregates["population"]
+	if !ok {
+		t.Fatalf("Expected a population aggregate, got %v", dash.Aggregates)
+	}
+	if agg.Min != 5600000 || agg.Max != 10500000 || agg.Mean != 7333333.333333333 {
+		t.Errorf("Unexpected min/max/mean: %+v", agg)
+	}
+	if !reflect.DeepEqual(agg.Rank, []string{"sweden", "Denmark", "Finland"}) {
+		t.Errorf("Unexpected rank: %v", agg.Rank)
+	}
+	if _, ok := dash.Aggregates["temperature"]; ok {
+		t.Error("Features that were not requested must not be aggregated")
+	}
+}
+
+// TestComparisonCountriesLimit checks that a large region is cut off after the listed
+// countries and the first members.
+func TestComparisonCountriesLimit(t *testing.T) {
+	var members []string
+	for i := 0; i < constants.MAX_COMPARISON_COUNTRIES+10; i++ {
+		members = append(members, fmt.Sprintf("Country %02d", i))
+	}
+	src := upstream{regionCountries: func(region string) ([]string, error) { return members, nil }}
+
+	countries := comparisonCountries(src, &structs.Registration{Countries: []string{"Listedland"}, Region: "Big"})
+	if len(countries) != constants.MAX_COMPARISON_COUNTRIES || countries[0] != "Listedland" || countries[1] != "Country 00" {
+		t.Errorf("Expected the listed country and the first members, got %d: %v", len(countries), countries)
+	}
+}
+
+// TestDashboardRefreshSeconds checks that the shortest TTL among the used sources wins.
+func TestDashboardRefreshSeconds(t *testing.T) {
+	cases := []struct {
+		name string
+		f    structs.Features
+		want int
+	}{
+		{"NoFeatures", structs.Features{}, 0},
+		{"CountryOnly", structs.Features{Capital: true}, constants.COUNTRY_CACHE_TTL_HOURS * 3600},
+		{"CountryAndCurrency", structs.Features{Area: true, TargetCurrencies: []string{"EUR"}}, constants.CURRENCY_CACHE_TTL_HOURS * 3600},
+		{"Everything", structs.Features{Capital: true, Temperature: true, TargetCurrencies: []string{"EUR"}}, constants.METEO_CACHE_TTL_HOURS * 3600},
+	}
+	for _, tc := range cases {
+		if got := dashboardRefreshSeconds(tc.f); got != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, got)
+		}
+	}
+}
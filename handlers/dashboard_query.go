@@ -0,0 +1,152 @@
+// File: assignment-2/handlers/dashboard_query.go
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"assignment-2/structs"
+	"assignment-2/tools"
+)
+
+// dashboardQuery is the body of POST /dashboards/query: the fields of a registration that
+// describe what to show, without anything that is only meaningful for stored registrations.
+type dashboardQuery struct {
+	Country   string           `json:"country,omitempty"`
+	ISOCode   string           `json:"isoCode,omitempty"`
+	Countries []string         `json:"countries,omitempty"`
+	Region    string           `json:"region,omitempty"`
+	Features  structs.Features `json:"features"`
+}
+
+// handleDashboardQuery handles GET and POST /dashboards/query. The dashboard is built
+// from the query parameters (GET) or the JSON body (POST) exactly like the dashboard of a
+// stored registration, but nothing is persisted and INVOKE webhooks are only triggered
+// with ?invoke=true.
+func handleDashboardQuery(w http.ResponseWriter, r *http.Request) {
+	var query dashboardQuery
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		query, err = parseDashboardQuery(r.URL.Query())
+	case http.MethodPost:
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if decodeErr := dec.Decode(&query); decodeErr != nil {
+			err = fmt.Errorf("invalid JSON request body: %v", decodeErr)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		tools.WriteJsonErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed on dashboard queries")
+		return
+	}
+	if err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	invoke := false
+	if v := r.URL.Query().Get("invoke"); v != "" {
+		if invoke, err = strconv.ParseBool(v); err != nil {
+			tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Parameter 'invoke' must be true or false")
+			return
+		}
+	}
+
+	reg := structs.Registration{
+		Country:   query.Country,
+		ISOCode:   query.ISOCode,
+		Countries: query.Countries,
+		Region:    query.Region,
+		Features:  query.Features,
+	}
+	if err := validateRegistration(reg); err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !hasFeatures(reg.Features) {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "At least one feature must be requested")
+		return
+	}
+
+	if !writeDashboard(w, r, &reg) {
+		return
+	}
+	if invoke {
+		// Not a stored registration, so the event is not scoped to one or its tags
+		TriggerWebhookEventVar(structs.WebhookEvent{Event: "INVOKE", Country: webhookCountry(reg)})
+	}
+}
+
+// parseDashboardQuery reads a dashboard query from URL parameters:
+//
+//	country, isoCode, region    as in a registration
+//	countries                   comma-separated list
+//	features                    comma-separated feature names, e.g. temperature,capital,localTime
+//	currencies                  comma-separated target currencies
+//	temperatureUnit, areaUnit,
+//	precipitationMode           presentation options as in a registration
+//	distanceFrom                reference point as "latitude,longitude"
+func parseDashboardQuery(q url.Values) (dashboardQuery, error) {
+	query := dashboardQuery{
+		Country:   q.Get("country"),
+		ISOCode:   q.Get("isoCode"),
+		Countries: splitList(q.Get("countries")),
+		Region:    q.Get("region"),
+	}
+	query.Features.TargetCurrencies = splitList(q.Get("currencies"))
+	query.Features.TemperatureUnit = q.Get("temperatureUnit")
+	query.Features.AreaUnit = q.Get("areaUnit")
+	query.Features.PrecipitationMode = q.Get("precipitationMode")
+	if v := q.Get("distanceFrom"); v != "" {
+		point := splitList(v)
+		if len(point) != 2 {
+			return query, fmt.Errorf("distanceFrom must be 'latitude,longitude'")
+		}
+		lat, errLat := strconv.ParseFloat(point[0], 64)
+		lon, errLon := strconv.ParseFloat(point[1], 64)
+		if errLat != nil || errLon != nil {
+			return query, fmt.Errorf("distanceFrom must be 'latitude,longitude'")
+		}
+		query.Features.DistanceFrom = &structs.ReferencePoint{Latitude: lat, Longitude: lon}
+	}
+
+	flags := map[string]*bool{
+		"temperature":       &query.Features.Temperature,
+		"precipitation":     &query.Features.Precipitation,
+		"capital":           &query.Features.Capital,
+		"coordinates":       &query.Features.Coordinates,
+		"population":        &query.Features.Population,
+		"area":              &query.Features.Area,
+		"populationDensity": &query.Features.PopulationDensity,
+		"localTime":         &query.Features.LocalTime,
+	}
+	for _, name := range splitList(q.Get("features")) {
+		flag, ok := flags[name]
+		if !ok {
+			return query, fmt.Errorf("unknown feature '%s'", name)
+		}
+		*flag = true
+	}
+	return query, nil
+}
+
+// splitList splits a comma-separated parameter, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// hasFeatures reports whether any feature is requested.
+func hasFeatures(f structs.Features) bool {
+	return needsCountry(f) || needsMeteo(f) || len(f.TargetCurrencies) > 0
+}
@@ -0,0 +1,109 @@
+// File: assignment-2/handlers/dashboard_query_test.go
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"assignment-2/constants"
+	"assignment-2/structs"
+)
+
+// TestDashboardQuery tests GET and POST /dashboard/v1/dashboards/query.
+func TestDashboardQuery(t *testing.T) {
+	overrideStubs()
+	defer revertStubs()
+
+	var events []string
+	TriggerWebhookEventVar = func(ev structs.WebhookEvent) { events = append(events, ev.Event+":"+ev.Country) }
+
+	query := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, constants.DASHBOARDS_QUERY_PATH+target, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		DashboardsRouter(rr, req)
+		return rr
+	}
+
+	t.Run("Get", func(t *testing.T) {
+		events = nil
+		rr := query(http.MethodGet, "?isoCode=NO&features=capital,temperature&currencies=EUR", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var dash structs.Dashboard
+		_ = json.Unmarshal(rr.Body.Bytes(), &dash)
+		if dash.Features.Capital != "Oslo" || dash.Features.Temperature != 5.5 || dash.Features.TargetCurrencies["EUR"] != 0.09 {
+			t.Errorf("Unexpected dashboard: %+v", dash)
+		}
+		if dash.Features.Population != 0 {
+			t.Error("Population was not requested")
+		}
+		if rr.Header().Get("ETag") == "" {
+			t.Error("Expected an ETag")
+		}
+		if len(events) != 0 {
+			t.Errorf("Expected no webhooks without ?invoke=true, got %v", events)
+		}
+	})
+
+	t.Run("PostWithInvoke", func(t *testing.T) {
+		events = nil
+		rr := query(http.MethodPost, "?invoke=true", `{"country":"Norway","features":{"population":true}}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if len(events) != 1 || events[0] != "INVOKE:Norway" {
+			t.Errorf("Expected one INVOKE event, got %v", events)
+		}
+	})
+
+	t.Run("UnitsAndDerivedMetrics", func(t *testing.T) {
+		rr := query(http.MethodGet, "?isoCode=NO&features=temperature,localTime&temperatureUnit=K&distanceFrom=59.92,10.75", "")
+		var dash structs.Dashboard
+		_ = json.Unmarshal(rr.Body.Bytes(), &dash)
+		if rr.Code != http.StatusOK || dash.Features.Temperature != 278.65 || dash.Features.Units["temperature"] != "K" {
+			t.Errorf("Expected 278.65 K, got %d %+v", rr.Code, dash.Features)
+		}
+		if dash.Features.LocalTime == "" || dash.Features.DistanceToCapital != 0 || dash.Features.Units["distanceToCapital"] != "km" {
+			t.Errorf("Expected the local time and a zero distance from Oslo, got %+v", dash.Features)
+		}
+	})
+
+	t.Run("Comparison", func(t *testing.T) {
+		rr := query(http.MethodGet, "?region=Scandinavia&features=population", "")
+		var dash structs.Dashboard
+		_ = json.Unmarshal(rr.Body.Bytes(), &dash)
+		if rr.Code != http.StatusOK || len(dash.Countries) != 3 {
+			t.Errorf("Expected a comparison of 3 countries, got %d %+v", rr.Code, dash)
+		}
+	})
+
+	t.Run("BadRequests", func(t *testing.T) {
+		cases := []struct {
+			name, method, target, body string
+		}{
+			{"NoCountry", http.MethodGet, "?features=capital", ""},
+			{"NoFeatures", http.MethodGet, "?isoCode=NO", ""},
+			{"UnknownFeature", http.MethodGet, "?isoCode=NO&features=weather", ""},
+			{"UnknownUnit", http.MethodGet, "?isoCode=NO&features=temperature&temperatureUnit=R", ""},
+			{"BadReferencePoint", http.MethodGet, "?isoCode=NO&features=capital&distanceFrom=north", ""},
+			{"BadInvoke", http.MethodGet, "?isoCode=NO&features=capital&invoke=maybe", ""},
+			{"UnknownField", http.MethodPost, "", `{"id":"x","isoCode":"NO","features":{"capital":true}}`},
+			{"InvalidJSON", http.MethodPost, "", `{"isoCode":`},
+		}
+		for _, tc := range cases {
+			if rr := query(tc.method, tc.target, tc.body); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400, got %d", tc.name, rr.Code)
+			}
+		}
+	})
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		if rr := query(http.MethodDelete, "", ""); rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected 405, got %d", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,183 @@
+// File: assignment-2/handlers/dashboard_snapshots.go
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"assignment-2/constants"
+	"assignment-2/firebase"
+	"assignment-2/structs"
+	"assignment-2/tools"
+)
+
+// snapshotFeatures are the numeric features that can be queried as a time series, in
+// addition to "targetCurrencies.<CODE>".
+var snapshotFeatures = []string{"temperature", "precipitation", "population", "area", "populationDensity", "distanceToCapital"}
+
+// TakeDueSnapshots stores a dashboard snapshot for every registration whose schedule is
+// due at 'now' and returns how many were stored. Snapshots are aligned to the schedule:
+// an hourly registration gets one per clock hour, a daily one per UTC day. Dashboards are
+// built together like an expanded listing and do not trigger INVOKE webhooks.
+func TakeDueSnapshots(ctx context.Context, now time.Time) (int, error) {
+	regs, err := firebase.ListScheduledRegistrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var due []structs.Registration
+	for _, reg := range regs {
+		period, ok := structs.SnapshotPeriod(reg.Snapshot)
+		if !ok {
+			continue
+		}
+		latest, err := firebase.LatestDashboardSnapshot(ctx, reg.ID)
+		if err != nil {
+			log.Printf("Warning: could not read the latest snapshot of %s: %v\n", reg.ID, err)
+			continue
+		}
+		if latest == nil || latest.Time.Truncate(period).Before(now.Truncate(period)) {
+			due = append(due, reg)
+		}
+	}
+	if len(due) == 0 {
+		return 0, nil
+	}
+
+	dashboards, values := measureDashboards(due)
+	snapshots := make([]structs.DashboardSnapshot, len(due))
+	for i, reg := range due {
+		snapshots[i] = structs.DashboardSnapshot{
+			RegistrationID: reg.ID,
+			Time:           now,
+			Dashboard:      dashboards[i],
+			Values:         values[i],
+		}
+	}
+	if err := firebase.SaveDashboardSnapshots(ctx, snapshots); err != nil {
+		return 0, err
+	}
+	return len(snapshots), nil
+}
+
+// handleDashboardHistory handles GET /dashboards/{id}/history. Without ?feature= it
+// returns the stored snapshots; with it, the values of that feature as a time series.
+// ?from= and ?to= (RFC 3339) limit the time range and ?limit= the number of snapshots
+// (the newest are kept). For comparison registrations ?country= selects the country.
+func handleDashboardHistory(w http.ResponseWriter, r *http.Request, id string) {
+	q := r.URL.Query()
+	from, errFrom := parseTimeParam(q, "from")
+	to, errTo := parseTimeParam(q, "to")
+	if errFrom != nil || errTo != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Parameters 'from' and 'to' must be RFC 3339 timestamps")
+		return
+	}
+	limit := constants.MAX_SNAPSHOT_POINTS
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+		if n < limit {
+			limit = n
+		}
+	}
+	feature := q.Get("feature")
+	if feature != "" && !validSnapshotFeature(feature) {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, fmt.Sprintf(
+			"Unknown feature '%s', use one of %s or targetCurrencies.<CODE>", feature, strings.Join(snapshotFeatures, ", ")))
+		return
+	}
+
+	reg, err := firebase.GetRegistrationByID(r.Context(), id)
+	if err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Registration not found")
+		return
+	}
+	country := q.Get("country")
+	if feature != "" && country == "" && reg.IsComparison() {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Parameter 'country' is required for comparison registrations")
+		return
+	}
+
+	snapshots, err := firebase.ListDashboardSnapshots(r.Context(), id, from, to, limit)
+	if err != nil {
+		log.Printf("Error listing snapshots of %s: %v\n", id, err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not retrieve snapshots")
+		return
+	}
+	if feature == "" {
+		tools.WriteJsonResponse(w, http.StatusOK, snapshots)
+		return
+	}
+
+	points := make([]structs.SeriesPoint, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		if value, ok := snapshotValue(snapshot, country, feature); ok {
+			points = append(points, structs.SeriesPoint{Time: snapshot.Time, Value: value, Unit: snapshotUnit(snapshot, country, feature)})
+		}
+	}
+	tools.WriteJsonResponse(w, http.StatusOK, map[string]interface{}{
+		"registrationId": id,
+		"feature":        feature,
+		"country":        country,
+		"points":         points,
+	})
+}
+
+// validSnapshotFeature reports whether 'feature' can be queried as a time series.
+func validSnapshotFeature(feature string) bool {
+	if cur, ok := strings.CutPrefix(feature, "targetCurrencies."); ok {
+		return isLetters(cur, 3, 3)
+	}
+	return containsString(snapshotFeatures, feature)
+}
+
+// snapshotValue returns the value of 'feature' for 'country' in a snapshot. An empty
+// country selects the only country of a single-country snapshot. Values that were not
+// retrieved when the snapshot was taken are reported as missing.
+func snapshotValue(snapshot structs.DashboardSnapshot, country, feature string) (float64, bool) {
+	var values map[string]float64
+	if country == "" {
+		if len(snapshot.Values) != 1 {
+			return 0, false
+		}
+		for _, v := range snapshot.Values {
+			values = v
+		}
+	} else {
+		for name, v := range snapshot.Values {
+			if strings.EqualFold(name, country) {
+				values = v
+				break
+			}
+		}
+	}
+	value, ok := values[feature]
+	return value, ok
+}
+
+// snapshotUnit returns the unit of 'feature' for 'country' in a snapshot, as shown in the
+// stored dashboard, or "" for features without a unit.
+func snapshotUnit(snapshot structs.DashboardSnapshot, country, feature string) string {
+	units := snapshot.Dashboard.Features.Units
+	if country != "" && len(snapshot.Dashboard.Countries) > 0 {
+		units = nil
+		for _, block := range snapshot.Dashboard.Countries {
+			if strings.EqualFold(block.Country, country) {
+				units = block.Features.Units
+				break
+			}
+		}
+	}
+	if strings.HasPrefix(feature, "targetCurrencies.") {
+		feature = "targetCurrencies"
+	}
+	return units[feature]
+}
@@ -0,0 +1,163 @@
+// File: assignment-2/handlers/dashboard_snapshots_test.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"assignment-2/constants"
+	"assignment-2/firebase"
+	"assignment-2/structs"
+)
+
+// In-memory "dashboard_snapshots" collection, oldest first
+var snapshotStore []structs.DashboardSnapshot
+
+var (
+	origListScheduledRegistrations = firebase.ListScheduledRegistrations
+	origLatestDashboardSnapshot    = firebase.LatestDashboardSnapshot
+	origSaveDashboardSnapshots     = firebase.SaveDashboardSnapshots
+	origListDashboardSnapshots     = firebase.ListDashboardSnapshots
+)
+
+// overrideSnapshotStubs replaces the snapshot functions with in-memory stubs that use the
+// registrations stored with storeRegistration.
+func overrideSnapshotStubs() {
+	snapshotStore = nil
+	firebase.ListScheduledRegistrations = func(ctx context.Context) ([]structs.Registration, error) {
+		regMutex.Lock()
+		defer regMutex.Unlock()
+		var regs []structs.Registration
+		for _, reg := range regStore {
+			if reg.Snapshot != "" {
+				regs = append(regs, reg)
+			}
+		}
+		return regs, nil
+	}
+	firebase.LatestDashboardSnapshot = func(ctx context.Context, registrationID string) (*structs.DashboardSnapshot, error) {
+		for i := len(snapshotStore) - 1; i >= 0; i-- {
+			if snapshotStore[i].RegistrationID == registrationID {
+				return &snapshotStore[i], nil
+			}
+		}
+		return nil, nil
+	}
+	firebase.SaveDashboardSnapshots = func(ctx context.Context, snapshots []structs.DashboardSnapshot) error {
+		snapshotStore = append(snapshotStore, snapshots...)
+		return nil
+	}
+	firebase.ListDashboardSnapshots = func(ctx context.Context, registrationID string, from, to time.Time, limit int) ([]structs.DashboardSnapshot, error) {
+		var found []structs.DashboardSnapshot
+		for _, s := range snapshotStore {
+			if s.RegistrationID == registrationID && !s.Time.Before(from) && (to.IsZero() || s.Time.Before(to)) {
+				found = append(found, s)
+			}
+		}
+		if len(found) > limit {
+			found = found[len(found)-limit:]
+		}
+		return found, nil
+	}
+}
+
+func revertSnapshotStubs() {
+	firebase.ListScheduledRegistrations = origListScheduledRegistrations
+	firebase.LatestDashboardSnapshot = origLatestDashboardSnapshot
+	firebase.SaveDashboardSnapshots = origSaveDashboardSnapshots
+	firebase.ListDashboardSnapshots = origListDashboardSnapshots
+}
+
+// TestDashboardSnapshots runs the scheduler a few times and queries the history.
+func TestDashboardSnapshots(t *testing.T) {
+	overrideStubs()
+	defer revertStubs()
+	overrideSnapshotStubs()
+	defer revertSnapshotStubs()
+
+	features := structs.Features{Temperature: true, TargetCurrencies: []string{"EUR"}}
+	storeRegistration("snap-hourly", structs.Registration{ID: "snap-hourly", ISOCode: "NO", Snapshot: structs.SnapshotHourly, Features: features})
+	storeRegistration("snap-daily", structs.Registration{ID: "snap-daily", ISOCode: "NO", Snapshot: structs.SnapshotDaily, Features: features})
+	storeRegistration("snap-none", structs.Registration{ID: "snap-none", ISOCode: "NO", Features: features})
+	storeRegistration("snap-compare", structs.Registration{ID: "snap-compare", Region: "Scandinavia", Snapshot: structs.SnapshotDaily, Features: features})
+
+	t.Run("Scheduler", func(t *testing.T) {
+		start := time.Date(2025, 4, 10, 10, 5, 0, 0, time.UTC)
+		steps := []struct {
+			at   time.Time
+			want int
+		}{
+			{start, 3},                                   // first run: every scheduled registration
+			{start.Add(20 * time.Minute), 0},             // same hour
+			{start.Add(time.Hour), 1},                    // next hour: only the hourly one
+			{start.Add(14 * time.Hour), 3},               // next day
+			{start.Add(14*time.Hour + 5*time.Minute), 0}, // nothing due
+		}
+		for i, step := range steps {
+			n, err := TakeDueSnapshots(context.Background(), step.at)
+			if err != nil || n != step.want {
+				t.Errorf("Run %d: expected %d snapshots, got %d (%v)", i, step.want, n, err)
+			}
+		}
+	})
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, constants.DASHBOARDS_PATH+target, nil)
+		rr := httptest.NewRecorder()
+		DashboardsRouter(rr, req)
+		return rr
+	}
+
+	t.Run("History", func(t *testing.T) {
+		rr := get("snap-hourly/history")
+		var snapshots []structs.DashboardSnapshot
+		_ = json.Unmarshal(rr.Body.Bytes(), &snapshots)
+		if rr.Code != http.StatusOK || len(snapshots) != 3 || snapshots[0].Dashboard.Features.Temperature != 5.5 {
+			t.Errorf("Expected 3 snapshots, got %d %+v", rr.Code, snapshots)
+		}
+	})
+
+	t.Run("FeatureSeries", func(t *testing.T) {
+		rr := get("snap-hourly/history?feature=targetCurrencies.EUR&from=2025-04-10T11:00:00Z&limit=5")
+		var series struct {
+			Points []structs.SeriesPoint `json:"points"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &series)
+		if rr.Code != http.StatusOK || len(series.Points) != 2 || series.Points[0].Value != 0.09 {
+			t.Errorf("Expected 2 EUR points, got %d %s", rr.Code, rr.Body.String())
+		}
+		if len(series.Points) > 0 && !strings.HasPrefix(series.Points[0].Unit, "per 1 ") {
+			t.Errorf("Expected the unit of the EUR rate, got %q", series.Points[0].Unit)
+		}
+
+		rr = get("snap-compare/history?feature=temperature&country=norway")
+		_ = json.Unmarshal(rr.Body.Bytes(), &series)
+		if rr.Code != http.StatusOK || len(series.Points) != 2 || series.Points[1].Value != 5.5 {
+			t.Errorf("Expected 2 temperature points for Norway, got %d %s", rr.Code, rr.Body.String())
+		}
+		if len(series.Points) == 2 && series.Points[1].Unit != "°C" {
+			t.Errorf("Expected the temperature in °C, got %q", series.Points[1].Unit)
+		}
+	})
+
+	t.Run("BadRequests", func(t *testing.T) {
+		cases := map[string]int{
+			"snap-hourly/history?feature=humidity":    http.StatusBadRequest,
+			"snap-hourly/history?from=yesterday":      http.StatusBadRequest,
+			"snap-hourly/history?limit=0":             http.StatusBadRequest,
+			"snap-compare/history?feature=population": http.StatusBadRequest,
+			"unknown/history":                         http.StatusNotFound,
+			"snap-hourly/trends":                      http.StatusNotFound,
+		}
+		for target, want := range cases {
+			if rr := get(target); rr.Code != want {
+				t.Errorf("%s: expected %d, got %d", target, want, rr.Code)
+			}
+		}
+	})
+}
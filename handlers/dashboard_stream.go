@@ -0,0 +1,276 @@
+// File: assignment-2/handlers/dashboard_stream.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"assignment-2/constants"
+	"assignment-2/firebase"
+	"assignment-2/structs"
+	"assignment-2/tools"
+)
+
+// Intervals of the dashboard streams; variables so tests can shorten them
+var (
+	streamRefreshInterval   = constants.STREAM_REFRESH_SECONDS * time.Second
+	streamHeartbeatInterval = constants.STREAM_HEARTBEAT_SECONDS * time.Second
+)
+
+// streamEvent is one server-sent event. Every "dashboard" event carries the complete
+// dashboard, so a client only ever needs the latest one.
+type streamEvent struct {
+	ID   int64
+	Name string
+	Data []byte
+}
+
+// dashboardTopic is the shared state of all streams of one registration. A single
+// goroutine per topic rebuilds the dashboard and fans it out to the subscribers.
+type dashboardTopic struct {
+	id          string
+	reg         *structs.Registration
+	subscribers map[chan streamEvent]struct{}
+	latest      *streamEvent
+	etag        string
+	changed     chan struct{}
+	stop        chan struct{}
+}
+
+// streamHub holds the topics that have at least one subscriber.
+type streamHub struct {
+	mu     sync.Mutex
+	topics map[string]*dashboardTopic
+	seq    int64
+}
+
+// dashboardStreams is the hub used by the stream endpoint and notified by registration writes.
+// Event IDs start at the startup time in milliseconds so they keep increasing across restarts.
+var dashboardStreams = &streamHub{
+	topics: make(map[string]*dashboardTopic),
+	seq:    time.Now().UnixMilli(),
+}
+
+// subscribe adds a subscriber to the topic of 'reg', starting its refresh loop if it is
+// the first one. It returns the event channel, the latest event (nil if the first
+// dashboard is still being built) and a function that unsubscribes.
+func (h *streamHub) subscribe(reg *structs.Registration) (<-chan streamEvent, *streamEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	topic, ok := h.topics[reg.ID]
+	if !ok {
+		topic = &dashboardTopic{
+			id:          reg.ID,
+			reg:         reg,
+			subscribers: make(map[chan streamEvent]struct{}),
+			changed:     make(chan struct{}, 1),
+			stop:        make(chan struct{}),
+		}
+		h.topics[reg.ID] = topic
+		go h.run(topic)
+	}
+	ch := make(chan streamEvent, 1)
+	topic.subscribers[ch] = struct{}{}
+
+	cancel := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := topic.subscribers[ch]; !ok {
+			return
+		}
+		delete(topic.subscribers, ch)
+		close(ch)
+		if len(topic.subscribers) == 0 && h.topics[topic.id] == topic {
+			delete(h.topics, topic.id)
+			close(topic.stop)
+		}
+	}
+	return ch, topic.latest, cancel
+}
+
+// run is the refresh loop of a topic: it builds the dashboard right away, then again on
+// every tick and whenever the registration changes, and publishes it if it differs.
+func (h *streamHub) run(topic *dashboardTopic) {
+	ticker := time.NewTicker(streamRefreshInterval)
+	defer ticker.Stop()
+	for {
+		h.refresh(topic)
+		select {
+		case <-topic.stop:
+			return
+		case <-ticker.C:
+		case <-topic.changed:
+			reg, err := firebase.GetRegistrationByID(context.Background(), topic.id)
+			if err != nil {
+				log.Printf("Warning: could not reload registration %s for its streams: %v\n", topic.id, err)
+				continue
+			}
+			h.mu.Lock()
+			topic.reg = reg
+			h.mu.Unlock()
+		}
+	}
+}
+
+// refresh builds the dashboard of a topic and publishes it when its content changed.
+// Dashboards are built from the cached upstream data, so subscribers of one
+// registration share one upstream fetch per cache period.
+func (h *streamHub) refresh(topic *dashboardTopic) {
+	h.mu.Lock()
+	reg := topic.reg
+	h.mu.Unlock()
+
+	dash := buildDashboard(reg)
+	etag, err := tools.ContentETag(dashboardContent(dash))
+	if err != nil {
+		log.Printf("Warning: could not compute dashboard ETag: %v\n", err)
+	}
+	data, err := json.Marshal(dash)
+	if err != nil {
+		log.Printf("Error encoding dashboard %s for its streams: %v\n", topic.id, err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if topic.latest != nil && etag != "" && etag == topic.etag {
+		return
+	}
+	topic.etag = etag
+	h.publish(topic, "dashboard", data)
+}
+
+// publish sends an event to every subscriber of 'topic'. A subscriber that has not read
+// the previous event yet gets it replaced, so slow clients never block the topic.
+// The caller holds h.mu.
+func (h *streamHub) publish(topic *dashboardTopic, name string, data []byte) {
+	h.seq++
+	event := streamEvent{ID: h.seq, Name: name, Data: data}
+	topic.latest = &event
+	for ch := range topic.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- event
+	}
+}
+
+// registrationChanged makes the streams of registration 'id', if any, reload it and
+// push the new dashboard.
+func (h *streamHub) registrationChanged(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if topic, ok := h.topics[id]; ok {
+		select {
+		case topic.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// registrationDeleted sends a "deleted" event to the streams of registration 'id' and
+// ends them.
+func (h *streamHub) registrationDeleted(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	topic, ok := h.topics[id]
+	if !ok {
+		return
+	}
+	h.publish(topic, "deleted", []byte(strconv.Quote(id)))
+	for ch := range topic.subscribers {
+		close(ch)
+	}
+	topic.subscribers = make(map[chan streamEvent]struct{})
+	delete(h.topics, id)
+	close(topic.stop)
+}
+
+// CloseDashboardStreams ends all open dashboard streams, so a server shutdown does not
+// wait for them. Clients reconnect to the next instance using their Last-Event-ID.
+func CloseDashboardStreams() {
+	dashboardStreams.closeAll()
+}
+
+// closeAll ends every stream and stops the refresh loops.
+func (h *streamHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, topic := range h.topics {
+		for ch := range topic.subscribers {
+			close(ch)
+		}
+		topic.subscribers = make(map[chan streamEvent]struct{})
+		delete(h.topics, id)
+		close(topic.stop)
+	}
+}
+
+// handleDashboardStream handles GET /dashboards/{id}/stream, a text/event-stream of the
+// dashboard. The current dashboard is sent first, unless the client resumes with a
+// Last-Event-ID that is still current; after that a new event follows whenever the
+// dashboard changes. Comment lines are sent as heartbeats.
+func handleDashboardStream(w http.ResponseWriter, r *http.Request, id string) {
+	if _, ok := w.(http.Flusher); !ok {
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+	reg, err := firebase.GetRegistrationByID(r.Context(), id)
+	if err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Registration not found")
+		return
+	}
+	reg.ID = id
+	lastEventID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	// Streams outlive any server write timeout
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", constants.STREAM_RETRY_MILLIS)
+
+	events, latest, cancel := dashboardStreams.subscribe(reg)
+	defer cancel()
+	if latest != nil && latest.ID > lastEventID {
+		writeStreamEvent(w, *latest)
+	}
+	_ = rc.Flush()
+
+	TriggerWebhookEventVar(registrationEvent("INVOKE", *reg))
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			writeStreamEvent(w, event)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeStreamEvent writes one event in the text/event-stream format. The JSON data
+// never contains newlines, so it fits on a single data line.
+func writeStreamEvent(w http.ResponseWriter, event streamEvent) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Name, event.Data)
+}
@@ -0,0 +1,174 @@
+// File: assignment-2/handlers/dashboard_stream_test.go
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"assignment-2/constants"
+	"assignment-2/services"
+	"assignment-2/structs"
+)
+
+// sseMessage is one block of a text/event-stream: an event or a comment.
+type sseMessage struct {
+	id, event, data, comment string
+}
+
+// openStream connects to the stream of 'id' and returns its messages (without the
+// initial retry block) on a channel that is closed when the stream ends.
+func openStream(t *testing.T, srv *httptest.Server, id, lastEventID string) (*http.Response, <-chan sseMessage) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+constants.DASHBOARDS_PATH+id+"/stream", nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Could not open stream: %v", err)
+	}
+	messages := make(chan sseMessage, 16)
+	go func() {
+		defer close(messages)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		var msg sseMessage
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if msg != (sseMessage{}) {
+					messages <- msg
+				}
+				msg = sseMessage{}
+			case strings.HasPrefix(line, ":"):
+				msg.comment = strings.TrimSpace(line[1:])
+			case strings.HasPrefix(line, "id: "):
+				msg.id = line[4:]
+			case strings.HasPrefix(line, "event: "):
+				msg.event = line[7:]
+			case strings.HasPrefix(line, "data: "):
+				msg.data = line[6:]
+			}
+		}
+	}()
+	return resp, messages
+}
+
+// nextMessage returns the next message of a stream, skipping the retry block and, unless
+// 'heartbeats' is set, heartbeat comments.
+func nextMessage(t *testing.T, messages <-chan sseMessage, heartbeats bool) (sseMessage, bool) {
+	t.Helper()
+	for {
+		select {
+		case msg, ok := <-messages:
+			if ok && msg.event == "" && (msg.comment == "" || !heartbeats) {
+				continue
+			}
+			return msg, ok
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for a stream message")
+			return sseMessage{}, false
+		}
+	}
+}
+
+// TestDashboardStream subscribes twice to one dashboard, changes and deletes the
+// registration, and resumes a stream with Last-Event-ID.
+func TestDashboardStream(t *testing.T) {
+	overrideStubs()
+	defer revertStubs()
+
+	origRefresh, origHeartbeat := streamRefreshInterval, streamHeartbeatInterval
+	streamRefreshInterval, streamHeartbeatInterval = time.Hour, 50*time.Millisecond
+	defer func() { streamRefreshInterval, streamHeartbeatInterval = origRefresh, origHeartbeat }()
+
+	var mu sync.Mutex
+	builds := 0
+	countryStub := services.FetchCountryInfo
+	services.FetchCountryInfo = func(c string) (*structs.CountryInfo, error) {
+		mu.Lock()
+		builds++
+		mu.Unlock()
+		return countryStub(c)
+	}
+
+	storeRegistration("live-1", structs.Registration{ID: "live-1", ISOCode: "NO", Features: structs.Features{Capital: true}})
+
+	srv := httptest.NewServer(http.HandlerFunc(DashboardsRouter))
+	defer srv.Close()
+
+	respA, streamA := openStream(t, srv, "live-1", "")
+	if ct := respA.Header.Get("Content-Type"); respA.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d %q", respA.StatusCode, ct)
+	}
+	first, _ := nextMessage(t, streamA, false)
+	var dash structs.Dashboard
+	if err := json.Unmarshal([]byte(first.data), &dash); err != nil || first.event != "dashboard" || dash.Features.Capital != "Oslo" {
+		t.Fatalf("Expected the dashboard first, got %+v", first)
+	}
+
+	t.Run("SharedRefresh", func(t *testing.T) {
+		_, streamB := openStream(t, srv, "live-1", "")
+		msg, _ := nextMessage(t, streamB, false)
+		if msg.id != first.id {
+			t.Errorf("Expected the latest event %s, got %+v", first.id, msg)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if builds != 1 {
+			t.Errorf("Expected one dashboard build for two subscribers, got %d", builds)
+		}
+	})
+
+	t.Run("RegistrationChange", func(t *testing.T) {
+		storeRegistration("live-1", structs.Registration{ID: "live-1", ISOCode: "NO", Features: structs.Features{Population: true}})
+		dashboardStreams.registrationChanged("live-1")
+		msg, _ := nextMessage(t, streamA, false)
+		dash = structs.Dashboard{}
+		_ = json.Unmarshal([]byte(msg.data), &dash)
+		if msg.event != "dashboard" || dash.Features.Population != 5372000 || msg.id <= first.id {
+			t.Errorf("Expected the changed dashboard, got %+v", msg)
+		}
+		first = msg
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		// Up to date: nothing is replayed
+		_, current := openStream(t, srv, "live-1", first.id)
+		if msg, _ := nextMessage(t, current, true); msg.comment != "heartbeat" {
+			t.Errorf("Expected a heartbeat, got %+v", msg)
+		}
+		// Behind: the latest dashboard is sent
+		older, _ := strconv.ParseInt(first.id, 10, 64)
+		_, behind := openStream(t, srv, "live-1", strconv.FormatInt(older-1, 10))
+		if msg, _ := nextMessage(t, behind, false); msg.id != first.id {
+			t.Errorf("Expected event %s, got %+v", first.id, msg)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		dashboardStreams.registrationDeleted("live-1")
+		msg, _ := nextMessage(t, streamA, false)
+		if msg.event != "deleted" {
+			t.Errorf("Expected a deleted event, got %+v", msg)
+		}
+		if _, ok := nextMessage(t, streamA, false); ok {
+			t.Error("Expected the stream to end")
+		}
+	})
+
+	t.Run("UnknownRegistration", func(t *testing.T) {
+		resp, _ := openStream(t, srv, "missing", "")
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", resp.StatusCode)
+		}
+	})
+}
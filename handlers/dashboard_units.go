@@ -0,0 +1,138 @@
+// File: assignment-2/handlers/dashboard_units.go
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+
+	"assignment-2/structs"
+)
+
+// Conversion factors and the mean Earth radius used for great-circle distances
+const (
+	squareKilometresPerSquareMile = 2.589988110336
+	earthRadiusKm                 = 6371.0088
+)
+
+// Unit labels, keyed by option value
+var (
+	temperatureUnitLabels = map[string]string{
+		structs.TemperatureCelsius:    "°C",
+		structs.TemperatureFahrenheit: "°F",
+		structs.TemperatureKelvin:     "K",
+	}
+	areaUnitLabels = map[string]string{
+		structs.AreaSquareKilometres: "km²",
+		structs.AreaSquareMiles:      "mi²",
+	}
+	precipitationUnitLabels = map[string]string{
+		structs.PrecipitationAverage: "mm/h",
+		structs.PrecipitationTotal:   "mm",
+	}
+)
+
+// validatePresentation checks the presentation options and derived metrics of 'f'.
+func validatePresentation(f structs.Features) error {
+	if _, ok := temperatureUnitLabels[f.TemperatureUnit]; f.TemperatureUnit != "" && !ok {
+		return fmt.Errorf("temperatureUnit must be 'C', 'F' or 'K'")
+	}
+	if _, ok := areaUnitLabels[f.AreaUnit]; f.AreaUnit != "" && !ok {
+		return fmt.Errorf("areaUnit must be 'km2' or 'mi2'")
+	}
+	if _, ok := precipitationUnitLabels[f.PrecipitationMode]; f.PrecipitationMode != "" && !ok {
+		return fmt.Errorf("precipitationMode must be 'average' or 'total'")
+	}
+	if p := f.DistanceFrom; p != nil {
+		if p.Latitude < -90 || p.Latitude > 90 || p.Longitude < -180 || p.Longitude > 180 {
+			return fmt.Errorf("distanceFrom must have a latitude between -90 and 90 and a longitude between -180 and 180")
+		}
+	}
+	return nil
+}
+
+// optionOrDefault returns 'value', or 'def' if it is empty.
+func optionOrDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// convertTemperature converts degrees Celsius to 'unit'.
+func convertTemperature(celsius float64, unit string) float64 {
+	switch unit {
+	case structs.TemperatureFahrenheit:
+		return round2(celsius*9/5 + 32)
+	case structs.TemperatureKelvin:
+		return round2(celsius + 273.15)
+	}
+	return celsius
+}
+
+// convertArea converts square kilometres to 'unit'.
+func convertArea(km2 float64, unit string) float64 {
+	if unit == structs.AreaSquareMiles {
+		return round2(km2 / squareKilometresPerSquareMile)
+	}
+	return km2
+}
+
+// greatCircleKm returns the haversine distance between two points in kilometres.
+func greatCircleKm(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := rad(lat2 - lat1)
+	dLon := rad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// utcOffsetPattern matches the REST Countries time zones "UTC", "UTC+01:00" and "UTC-03:30".
+var utcOffsetPattern = regexp.MustCompile(`^UTC(?:([+-])(\d{2}):(\d{2}))?$`)
+
+// parseUTCOffset returns the offset of a REST Countries time zone in seconds.
+func parseUTCOffset(zone string) (int, bool) {
+	m := utcOffsetPattern.FindStringSubmatch(zone)
+	if m == nil {
+		return 0, false
+	}
+	if m[1] == "" {
+		return 0, true
+	}
+	hours, _ := strconv.Atoi(m[2])
+	minutes, _ := strconv.Atoi(m[3])
+	offset := hours*3600 + minutes*60
+	if m[1] == "-" {
+		offset = -offset
+	}
+	return offset, true
+}
+
+// capitalTimeZone picks the time zone of the capital among the country's zones. REST
+// Countries does not say which zone the capital is in, so for countries with several
+// zones the one closest to the solar time at the capital's longitude is used.
+func capitalTimeZone(zones []string, capitalLon float64) (string, *time.Location, bool) {
+	best, bestOffset := "", 0
+	bestDiff := math.Inf(1)
+	for _, zone := range zones {
+		offset, ok := parseUTCOffset(zone)
+		if !ok {
+			continue
+		}
+		if diff := math.Abs(float64(offset) - capitalLon*240); diff < bestDiff {
+			best, bestOffset, bestDiff = zone, offset, diff
+		}
+	}
+	if best == "" {
+		return "", nil, false
+	}
+	return best, time.FixedZone(best, bestOffset), true
+}
+
+// round2 rounds to two decimals, so converted and derived values stay readable.
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,78 @@
+// File: assignment-2/handlers/dashboard_units_test.go
+package handlers
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"assignment-2/structs"
+)
+
+// TestUnitConversions checks the temperature and area conversions.
+func TestUnitConversions(t *testing.T) {
+	if got := convertTemperature(20, structs.TemperatureFahrenheit); got != 68 {
+		t.Errorf("Expected 68 °F, got %v", got)
+	}
+	if got := convertTemperature(-10.5, structs.TemperatureKelvin); got != 262.65 {
+		t.Errorf("Expected 262.65 K, got %v", got)
+	}
+	if got := convertTemperature(5.123, ""); got != 5.123 {
+		t.Errorf("Expected Celsius to be unchanged, got %v", got)
+	}
+	if got := convertArea(2.589988110336, structs.AreaSquareMiles); got != 1 {
+		t.Errorf("Expected 1 mi², got %v", got)
+	}
+}
+
+// TestGreatCircleKm checks the distance between Oslo and Stockholm (about 416 km).
+func TestGreatCircleKm(t *testing.T) {
+	d := greatCircleKm(59.91, 10.75, 59.33, 18.07)
+	if math.Abs(d-416) > 3 {
+		t.Errorf("Expected about 416 km, got %v", d)
+	}
+	if d := greatCircleKm(10, 20, 10, 20); d != 0 {
+		t.Errorf("Expected 0 km, got %v", d)
+	}
+}
+
+// TestCapitalTimeZone checks the offset parsing and the choice among several zones.
+func TestCapitalTimeZone(t *testing.T) {
+	zones := []string{"UTC-10:00", "UTC-08:00", "UTC-05:00", "UTC+10:00"}
+	zone, loc, ok := capitalTimeZone(zones, -77.0) // Washington, D.C.
+	if !ok || zone != "UTC-05:00" {
+		t.Fatalf("Expected UTC-05:00, got %q %v", zone, ok)
+	}
+	if _, offset := time.Now().In(loc).Zone(); offset != -5*3600 {
+		t.Errorf("Expected an offset of -5 h, got %d", offset)
+	}
+	if zone, _, _ := capitalTimeZone([]string{"UTC"}, 0); zone != "UTC" {
+		t.Errorf("Expected UTC, got %q", zone)
+	}
+	if offset, ok := parseUTCOffset("UTC+05:30"); !ok || offset != 5*3600+30*60 {
+		t.Errorf("Expected +5:30, got %d %v", offset, ok)
+	}
+	if _, _, ok := capitalTimeZone([]string{"CET"}, 10); ok {
+		t.Error("Expected unknown zone formats to be ignored")
+	}
+}
+
+// TestValidatePresentation checks the accepted option values.
+func TestValidatePresentation(t *testing.T) {
+	valid := structs.Features{TemperatureUnit: "K", AreaUnit: "mi2", PrecipitationMode: "total",
+		DistanceFrom: &structs.ReferencePoint{Latitude: -33.9, Longitude: 151.2}}
+	if err := validatePresentation(valid); err != nil {
+		t.Errorf("Expected valid options, got %v", err)
+	}
+	invalid := []structs.Features{
+		{TemperatureUnit: "celsius"},
+		{AreaUnit: "ha"},
+		{PrecipitationMode: "max"},
+		{DistanceFrom: &structs.ReferencePoint{Latitude: 91}},
+	}
+	for _, f := range invalid {
+		if err := validatePresentation(f); err == nil {
+			t.Errorf("Expected %+v to be rejected", f)
+		}
+	}
+}
@@ -2,6 +2,7 @@
 package handlers
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
@@ -9,13 +10,24 @@ import (
 
 	"assignment-2/constants"
 	"assignment-2/firebase"
-	"assignment-2/services"
 	"assignment-2/structs"
 	"assignment-2/tools"
 )
 
-// DashboardsRouter handles GET /dashboard/v1/dashboards/{id}
+// DashboardsRouter handles GET /dashboard/v1/dashboards/{id} with its /history and /stream
+// subresources, the ad-hoc GET/POST /dashboard/v1/dashboards/query and
+// POST /dashboard/v1/dashboards/batch
 func DashboardsRouter(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case constants.DASHBOARDS_QUERY_PATH:
+		handleDashboardQuery(w, r)
+		return
+	case constants.DASHBOARDS_BATCH_PATH:
+		if requireMethod(w, r, http.MethodPost) {
+			handleDashboardBatch(w, r)
+		}
+		return
+	}
 	if r.Method != http.MethodGet {
 		tools.WriteJsonErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed on dashboards")
 		return
@@ -27,10 +39,23 @@ func DashboardsRouter(w http.ResponseWriter, r *http.Request) {
 	}
 	// There's something after /dashboards/
 	id := strings.TrimPrefix(r.URL.Path, constants.DASHBOARDS_PATH)
+	if parts := strings.Split(id, "/"); len(parts) > 1 {
+		if len(parts) == 2 && parts[1] == "history" {
+			handleDashboardHistory(w, r, parts[0])
+			return
+		}
+		if len(parts) == 2 && parts[1] == "stream" {
+			handleDashboardStream(w, r, parts[0])
+			return
+		}
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Unknown dashboard resource")
+		return
+	}
 	handleGetDashboardByID(w, r, id)
 }
 
 // handleGetDashboardByID fetches the corresponding registration and then retrieves real data from external APIs.
+// Polling clients can revalidate cheaply with the ETag set by writeDashboard.
 func handleGetDashboardByID(w http.ResponseWriter, r *http.Request, id string) {
 	reg, err := firebase.GetRegistrationByID(r.Context(), id)
 	if err != nil {
@@ -39,87 +64,61 @@ func handleGetDashboardByID(w http.ResponseWriter, r *http.Request, id string) {
 		return
 	}
 
-	// Build the Dashboard response
-	var dash structs.Dashboard
-	dash.Country = reg.Country
-	dash.ISOCode = reg.ISOCode
+	if !writeDashboard(w, r, reg) {
+		return
+	}
 
-	var df structs.DashboardFeatures
+	// Trigger 'INVOKE' event
+	TriggerWebhookEventVar(registrationEvent("INVOKE", *reg))
+}
 
-	// Decide if we need to fetch country info
-	needCountry := reg.Features.Capital || reg.Features.Population || reg.Features.Area || reg.Features.Coordinates
+// writeDashboard builds the dashboard for 'reg' and writes it in the format negotiated
+// from Accept or ?format= (JSON, CSV, XML or HTML). The response has an ETag computed from
+// the dashboard content and a Cache-Control max-age of the time left until the first of the
+// cached upstream entries it was built from expires, answering 304 when the client's copy
+// is still current. It reports whether a dashboard was delivered.
+func writeDashboard(w http.ResponseWriter, r *http.Request, reg *structs.Registration) bool {
+	format := negotiateRenderFormat(w, r)
+	if format == "" {
+		return false
+	}
+	w.Header().Add("Vary", "Accept")
+	src, used := trackedUpstream(directUpstream())
+	dash := buildDashboardFrom(src, reg)
 
-	var cInfo *structs.CountryInfo
-	if needCountry {
-		key := reg.Country
-		if key == "" {
-			key = reg.ISOCode
-		}
-		cInfo, err = services.FetchCountryInfo(key)
-		if err != nil {
-			log.Printf("Warning: could not fetch country info for '%s': %v\n", key, err)
-		}
+	// LastRetrieval and local times change on every call, so they are left out of the content hash
+	hashed := dashboardContent(dash)
+	var etag string
+	var err error
+	if format == "json" {
+		etag, err = tools.ContentETag(hashed)
+	} else {
+		etag, err = tools.ContentETag([]interface{}{format, hashed})
 	}
-	if cInfo != nil {
-		if reg.Features.Capital {
-			df.Capital = cInfo.Capital
-		}
-		if reg.Features.Coordinates {
-			df.Coordinates = &structs.Coordinates{
-				Lat: cInfo.Coordinates.Lat,
-				Lon: cInfo.Coordinates.Lon,
-			}
-		}
-		if reg.Features.Population {
-			df.Population = cInfo.Population
-		}
-		if reg.Features.Area {
-			df.Area = cInfo.Area
-		}
+	if err != nil {
+		log.Printf("Warning: could not compute dashboard ETag: %v\n", err)
 	}
-
-	// If temperature/precipitation... call open-meteo
-	if (reg.Features.Temperature || reg.Features.Precipitation) && cInfo != nil {
-		mData, errM := services.FetchMeteoData(cInfo.Coordinates.Lat, cInfo.Coordinates.Lon)
-		if errM == nil && mData != nil {
-			if reg.Features.Temperature {
-				df.Temperature = mData.AverageTemp
-			}
-			if reg.Features.Precipitation {
-				df.Precipitation = mData.AveragePrecipitation
-			}
-		} else {
-			log.Printf("Warning: fetch meteo data lat=%.2f lon=%.2f: %v\n",
-				cInfo.Coordinates.Lat, cInfo.Coordinates.Lon, errM)
-		}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
 	}
-
-	// If targetCurrencies... call currency API if cInfo.BaseCurrency is not empty
-	if len(reg.Features.TargetCurrencies) > 0 && cInfo != nil && cInfo.BaseCurrency != "" {
-		rates, errC := services.FetchCurrencyRates(cInfo.BaseCurrency)
-		if errC == nil && rates != nil {
-			tcMap := make(map[string]float64)
-			for _, cur := range reg.Features.TargetCurrencies {
-				if val, ok := rates[cur]; ok {
-					tcMap[cur] = val
-				}
-			}
-			df.TargetCurrencies = tcMap
-		} else {
-			log.Printf("Warning: fetch currency rates for base=%s: %v\n", cInfo.BaseCurrency, errC)
-		}
+	maxAge := used.maxAge(time.Now())
+	if maxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d, must-revalidate", maxAge))
+	} else {
+		w.Header().Set("Cache-Control", "private, no-cache")
 	}
 
-	dash.Features = df
-	dash.LastRetrieval = time.Now()
-
-	// Return the assembled JSON
-	tools.WriteJsonResponse(w, http.StatusOK, dash)
-
-	// Trigger 'INVOKE' event
-	countryKey := reg.Country
-	if countryKey == "" {
-		countryKey = reg.ISOCode
+	if tools.NotModified(r, etag, time.Time{}) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	refresh := constants.HTML_REFRESH_SECONDS
+	if maxAge == 0 {
+		maxAge = dashboardRefreshSeconds(reg.Features)
+	}
+	if maxAge > 0 && maxAge < refresh {
+		refresh = maxAge
 	}
-	TriggerWebhookEventVar("INVOKE", countryKey)
+	renderDashboard(w, format, reg, dash, refresh)
+	return true
 }
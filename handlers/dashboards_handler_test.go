@@ -22,9 +22,11 @@ import (
 // Backup original references
 var (
 	origGetRegistrationByID = firebase.GetRegistrationByID
+	origGetRegistrations    = firebase.GetRegistrationsByIDs
 	origFetchCountryInfo    = services.FetchCountryInfo
 	origFetchMeteoData      = services.FetchMeteoData
 	origFetchCurrencyRates  = services.FetchCurrencyRates
+	origFetchRegion         = services.FetchRegionCountries
 	origTriggerWebhook      = TriggerWebhookEventVar
 )
 
@@ -49,9 +51,22 @@ func overrideStubs() {
 		return &r, nil
 	}
 
+	// Stub for Firestore: GetRegistrationsByIDs
+	firebase.GetRegistrationsByIDs = func(ctx context.Context, docIDs []string) (map[string]structs.Registration, error) {
+		regMutex.Lock()
+		defer regMutex.Unlock()
+		found := make(map[string]structs.Registration)
+		for _, id := range docIDs {
+			if r, ok := regStore[id]; ok {
+				found[id] = r
+			}
+		}
+		return found, nil
+	}
+
 	// Stub for country info
 	services.FetchCountryInfo = func(countryOrISO string) (*structs.CountryInfo, error) {
-		if strings.ToUpper(countryOrISO) == "NO" {
+		if key := strings.ToUpper(countryOrISO); key == "NO" || key == "NORWAY" {
 			return &structs.CountryInfo{
 				Name:         "Norway",
 				Capital:      "Oslo",
@@ -59,6 +74,9 @@ func overrideStubs() {
 				Area:         385207.0,
 				BaseCurrency: "NOK",
 				Coordinates:  structs.Coordinates{Lat: 60.0, Lon: 10.0},
+				// Oslo
+				CapitalCoordinates: structs.Coordinates{Lat: 59.92, Lon: 10.75},
+				Timezones:          []string{"UTC+01:00"},
 			}, nil
 		} else if strings.ToUpper(countryOrISO) == "ERR" {
 			// simulate an error
@@ -80,6 +98,7 @@ func overrideStubs() {
 		return &structs.MeteoData{
 			AverageTemp:          5.5,
 			AveragePrecipitation: 1.2,
+			TotalPrecipitation:   3.4,
 		}, nil
 	}
 
@@ -92,8 +111,16 @@ func overrideStubs() {
 		return structs.CurrencyRates{"ABC": 0.5}, nil
 	}
 
+	// Stub for region lookups
+	services.FetchRegionCountries = func(region string) ([]string, error) {
+		if region == "Scandinavia" {
+			return []string{"Denmark", "Norway", "Sweden"}, nil
+		}
+		return nil, errors.New("unknown region")
+	}
+
 	// Stub for TriggerWebhook
-	TriggerWebhookEventVar = func(event, country string) {
+	TriggerWebhookEventVar = func(ev structs.WebhookEvent) {
 		// do nothing
 	}
 }
@@ -101,9 +128,11 @@ func overrideStubs() {
 // revertStubs reverts all stubs to original references
 func revertStubs() {
 	firebase.GetRegistrationByID = origGetRegistrationByID
+	firebase.GetRegistrationsByIDs = origGetRegistrations
 	services.FetchCountryInfo = origFetchCountryInfo
 	services.FetchMeteoData = origFetchMeteoData
 	services.FetchCurrencyRates = origFetchCurrencyRates
+	services.FetchRegionCountries = origFetchRegion
 	TriggerWebhookEventVar = origTriggerWebhook
 }
 
@@ -163,6 +192,50 @@ func TestDashboardsHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("GetDashboard_ConditionalGet", func(t *testing.T) {
+		// The weather entry expires first, in 20 minutes
+		origExpiry := services.CacheExpiry
+		defer func() { services.CacheExpiry = origExpiry }()
+		services.CacheExpiry = func(key string) (time.Time, bool) {
+			if strings.HasPrefix(key, "meteo:") {
+				return time.Now().Add(20 * time.Minute), true
+			}
+			return time.Now().Add(5 * time.Hour), true
+		}
+
+		req := httptest.NewRequest(http.MethodGet, constants.DASHBOARDS_PATH+"doc-1", nil)
+		rr := httptest.NewRecorder()
+		DashboardsRouter(rr, req)
+
+		etag := rr.Header().Get("ETag")
+		if etag == "" {
+			t.Fatal("Expected an ETag header on the dashboard")
+		}
+		if cc := rr.Header().Get("Cache-Control"); cc != "private, max-age=1199, must-revalidate" && cc != "private, max-age=1200, must-revalidate" {
+			t.Errorf("Expected max-age from the weather entry's remaining TTL, got %q", cc)
+		}
+
+		// Without a known expiry the dashboard has to be revalidated
+		services.CacheExpiry = func(key string) (time.Time, bool) { return time.Time{}, false }
+		rr = httptest.NewRecorder()
+		DashboardsRouter(rr, httptest.NewRequest(http.MethodGet, constants.DASHBOARDS_PATH+"doc-1", nil))
+		if cc := rr.Header().Get("Cache-Control"); cc != "private, no-cache" {
+			t.Errorf("Expected the dashboard to be revalidated, got %q", cc)
+		}
+
+		// Same data again => 304 with no body, even though lastRetrieval differs
+		req = httptest.NewRequest(http.MethodGet, constants.DASHBOARDS_PATH+"doc-1", nil)
+		req.Header.Set("If-None-Match", etag)
+		rr = httptest.NewRecorder()
+		DashboardsRouter(rr, req)
+		if rr.Code != http.StatusNotModified {
+			t.Fatalf("Expected 304 Not Modified, got %d", rr.Code)
+		}
+		if rr.Body.Len() != 0 {
+			t.Errorf("Expected empty body on 304, got %q", rr.Body.String())
+		}
+	})
+
 	t.Run("GetDashboard_NotFound", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, constants.DASHBOARDS_PATH+"doc-999", nil)
 		rr := httptest.NewRecorder()
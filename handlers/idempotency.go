@@ -0,0 +1,177 @@
+// File: assignment-2/handlers/idempotency.go
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"assignment-2/constants"
+	"assignment-2/firebase"
+	"assignment-2/structs"
+	"assignment-2/tools"
+)
+
+// maxIdempotencyKeyLength bounds the accepted Idempotency-Key header.
+const maxIdempotencyKeyLength = 255
+
+// withIdempotency runs 'handle' at most once per Idempotency-Key within 'scope'. The first
+// request with a key is executed and its response stored; retries with the same key and
+// the same request get the stored response replayed. Requests without the header are
+// passed through unchanged. The top level JSON fields listed in 'redact' are left out of
+// the stored response, so they are only sent to the first request. The key is released
+// if 'handle' fails with a server error or panics, and a reservation that is never
+// completed can be taken over once its lease has run out.
+func withIdempotency(w http.ResponseWriter, r *http.Request, scope string, handle http.HandlerFunc, redact ...string) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		handle(w, r)
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Idempotency-Key is too long")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Could not read request body")
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	ctx := context.Background()
+	docID := hashHex(scope, key)
+	now := time.Now()
+	ttl := time.Duration(tools.GetEnvInt("IDEMPOTENCY_TTL_HOURS", constants.IDEMPOTENCY_TTL_HOURS)) * time.Hour
+	rec := structs.IdempotencyRecord{
+		Key:         key,
+		RequestHash: hashHex(r.Method, r.URL.Path, string(body)),
+		Created:     now,
+		ExpiresAt:   now.Add(ttl),
+		LockedUntil: now.Add(constants.IDEMPOTENCY_LOCK_SECONDS * time.Second),
+	}
+
+	existing, err := firebase.ReserveIdempotencyKey(ctx, docID, rec)
+	if err != nil {
+		log.Printf("Error reserving idempotency key: %v\n", err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not process Idempotency-Key")
+		return
+	}
+	if existing != nil {
+		replayIdempotent(w, rec, *existing)
+		return
+	}
+
+	release := func() {
+		if err := firebase.ReleaseIdempotencyKey(ctx, docID); err != nil {
+			log.Printf("Error releasing idempotency key: %v\n", err)
+		}
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			release()
+			panic(p)
+		}
+	}()
+
+	rw := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+	handle(rw, r)
+
+	if rw.status >= 500 {
+		// Server errors are not final, so let the client retry with the same key
+		release()
+		return
+	}
+	rec.Completed = true
+	rec.StatusCode = rw.status
+	rec.Body = redactJSON(rw.body.Bytes(), redact)
+	rec.Headers = make(map[string]string)
+	for name := range rw.Header() {
+		rec.Headers[name] = rw.Header().Get(name)
+	}
+	if err := firebase.CompleteIdempotencyKey(ctx, docID, rec); err != nil {
+		log.Printf("Error storing idempotent response: %v\n", err)
+	}
+}
+
+// replayIdempotent answers a request whose key was seen before.
+func replayIdempotent(w http.ResponseWriter, current, stored structs.IdempotencyRecord) {
+	if stored.RequestHash != current.RequestHash {
+		tools.WriteJsonErrorResponse(w, http.StatusUnprocessableEntity,
+			"Idempotency-Key was already used for a different request")
+		return
+	}
+	if !stored.Completed {
+		w.Header().Set("Retry-After", "1")
+		tools.WriteJsonErrorResponse(w, http.StatusConflict,
+			"A request with this Idempotency-Key is still being processed")
+		return
+	}
+	for name, value := range stored.Headers {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(stored.StatusCode)
+	w.Write(stored.Body)
+}
+
+// redactJSON removes the given top level fields from a JSON object. Bodies that are not
+// a JSON object are returned unchanged.
+func redactJSON(body []byte, fields []string) []byte {
+	if len(fields) == 0 {
+		return body
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return body
+	}
+	for _, f := range fields {
+		delete(obj, f)
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(obj); err != nil {
+		return body
+	}
+	return buf.Bytes()
+}
+
+// hashHex returns the hex encoded SHA-256 of the NUL separated parts.
+func hashHex(parts ...string) string {
+	h := sha256.New()
+	for i, p := range parts {
+		if i > 0 {
+			h.Write([]byte{0})
+		}
+		h.Write([]byte(p))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordingWriter passes a response through while keeping a copy of status and body.
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.status = status
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	rw.wroteHeader = true
+	rw.body.Write(p)
+	return rw.ResponseWriter.Write(p)
+}
@@ -0,0 +1,224 @@
+// File: assignment-2/handlers/idempotency_test.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"assignment-2/constants"
+	"assignment-2/firebase"
+	"assignment-2/structs"
+)
+
+// In-memory "idempotency" collection
+var (
+	idemMutex sync.Mutex
+	idemStore map[string]structs.IdempotencyRecord
+)
+
+var (
+	origReserveIdempotencyKey  = firebase.ReserveIdempotencyKey
+	origCompleteIdempotencyKey = firebase.CompleteIdempotencyKey
+	origReleaseIdempotencyKey  = firebase.ReleaseIdempotencyKey
+)
+
+// overrideIdempotencyStubs replaces the idempotency functions with in-memory stubs
+func overrideIdempotencyStubs() {
+	idemStore = make(map[string]structs.IdempotencyRecord)
+
+	firebase.ReserveIdempotencyKey = func(ctx context.Context, docID string, rec structs.IdempotencyRecord) (*structs.IdempotencyRecord, error) {
+		idemMutex.Lock()
+		defer idemMutex.Unlock()
+		if stored, ok := idemStore[docID]; ok && !stored.Reusable(rec.Created) {
+			return &stored, nil
+		}
+		idemStore[docID] = rec
+		return nil, nil
+	}
+	firebase.CompleteIdempotencyKey = func(ctx context.Context, docID string, rec structs.IdempotencyRecord) error {
+		idemMutex.Lock()
+		defer idemMutex.Unlock()
+		idemStore[docID] = rec
+		return nil
+	}
+	firebase.ReleaseIdempotencyKey = func(ctx context.Context, docID string) error {
+		idemMutex.Lock()
+		defer idemMutex.Unlock()
+		delete(idemStore, docID)
+		return nil
+	}
+}
+
+func revertIdempotencyStubs() {
+	firebase.ReserveIdempotencyKey = origReserveIdempotencyKey
+	firebase.CompleteIdempotencyKey = origCompleteIdempotencyKey
+	firebase.ReleaseIdempotencyKey = origReleaseIdempotencyKey
+}
+
+// TestIdempotency covers replays, key reuse with another body, in-flight requests and server errors.
+func TestIdempotency(t *testing.T) {
+	overrideIdempotencyStubs()
+	defer revertIdempotencyStubs()
+	overrideFirebaseStubs()
+	defer revertFirebaseStubs()
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, constants.REGISTRATIONS_PATH, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		return rr
+	}
+	countStored := func(country string) int {
+		stubRegMutex.Lock()
+		defer stubRegMutex.Unlock()
+		n := 0
+		for _, reg := range stubRegStore {
+			if reg.Country == country {
+				n++
+			}
+		}
+		return n
+	}
+
+	t.Run("RetryIsReplayed", func(t *testing.T) {
+		body := `{"country":"Idemland"}`
+		first := post("key-1", body)
+		second := post("key-1", body)
+		if first.Code != http.StatusCreated || second.Code != http.StatusCreated {
+			t.Fatalf("Expected 201 twice, got %d and %d", first.Code, second.Code)
+		}
+		if first.Body.String() != second.Body.String() {
+			t.Errorf("Expected the same body, got %q and %q", first.Body.String(), second.Body.String())
+		}
+		if second.Header().Get("Idempotent-Replayed") != "true" || second.Header().Get("ETag") != `"1"` {
+			t.Errorf("Expected replayed headers, got %v", second.Header())
+		}
+		if n := countStored("Idemland"); n != 1 {
+			t.Errorf("Expected exactly one stored registration, got %d", n)
+		}
+	})
+
+	t.Run("DifferentBodySameKey", func(t *testing.T) {
+		post("key-2", `{"country":"Firstland"}`)
+		rr := post("key-2", `{"country":"Secondland"}`)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected 422, got %d", rr.Code)
+		}
+		if countStored("Secondland") != 0 {
+			t.Error("The second request must not be executed")
+		}
+	})
+
+	t.Run("KeysAreScopedPerEndpoint", func(t *testing.T) {
+		post("key-3", `{"country":"Scopeland"}`)
+		overrideNotificationStubs()
+		defer revertNotificationStubs()
+
+		req := httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH,
+			strings.NewReader(`{"url":"https://example.org/hook","event":"REGISTER"}`))
+		req.Header.Set("Idempotency-Key", "key-3")
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, req)
+		if rr.Code != http.StatusCreated || rr.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("Expected a fresh 201 for the notifications endpoint, got %d", rr.Code)
+		}
+	})
+
+	t.Run("NotificationSecretIsNotReplayed", func(t *testing.T) {
+		overrideNotificationStubs()
+		defer revertNotificationStubs()
+
+		var bodies []map[string]string
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH,
+				strings.NewReader(`{"url":"https://example.org/hook","event":"DELETE"}`))
+			req.Header.Set("Idempotency-Key", "key-8")
+			rr := httptest.NewRecorder()
+			NotificationsRouter(rr, req)
+			var body map[string]string
+			_ = json.Unmarshal(rr.Body.Bytes(), &body)
+			bodies = append(bodies, body)
+		}
+		if bodies[0]["secret"] == "" {
+			t.Fatalf("Expected the first response to show the secret, got %v", bodies[0])
+		}
+		if _, ok := bodies[1]["secret"]; ok || bodies[1]["id"] != bodies[0]["id"] || bodies[1]["status"] != bodies[0]["status"] {
+			t.Errorf("Expected the replay without the secret, got %v", bodies[1])
+		}
+	})
+
+	t.Run("InFlight", func(t *testing.T) {
+		// Simulate a first request that has reserved the key but not finished yet
+		idemStore[hashHex("registrations", "key-4")] = structs.IdempotencyRecord{
+			Key:         "key-4",
+			RequestHash: hashHex(http.MethodPost, constants.REGISTRATIONS_PATH, `{"country":"Slowland"}`),
+			ExpiresAt:   time.Now().Add(time.Hour),
+			LockedUntil: time.Now().Add(time.Minute),
+		}
+		rr := post("key-4", `{"country":"Slowland"}`)
+		if rr.Code != http.StatusConflict || rr.Header().Get("Retry-After") == "" {
+			t.Errorf("Expected 409 with Retry-After, got %d", rr.Code)
+		}
+	})
+
+	t.Run("StaleReservationIsTakenOver", func(t *testing.T) {
+		// Simulate a first request that reserved the key and then died
+		idemStore[hashHex("registrations", "key-6")] = structs.IdempotencyRecord{
+			Key:         "key-6",
+			RequestHash: hashHex(http.MethodPost, constants.REGISTRATIONS_PATH, `{"country":"Staleland"}`),
+			ExpiresAt:   time.Now().Add(time.Hour),
+			LockedUntil: time.Now().Add(-time.Second),
+		}
+		rr := post("key-6", `{"country":"Staleland"}`)
+		if rr.Code != http.StatusCreated || countStored("Staleland") != 1 {
+			t.Errorf("Expected the request to be executed, got %d", rr.Code)
+		}
+	})
+
+	t.Run("PanicReleasesKey", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, constants.REGISTRATIONS_PATH, strings.NewReader(`{}`))
+		req.Header.Set("Idempotency-Key", "key-7")
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("Expected the panic to be passed on")
+				}
+			}()
+			withIdempotency(httptest.NewRecorder(), req, "panics", func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			})
+		}()
+		idemMutex.Lock()
+		defer idemMutex.Unlock()
+		if _, ok := idemStore[hashHex("panics", "key-7")]; ok {
+			t.Error("Expected the key to be released after a panic")
+		}
+	})
+
+	t.Run("ServerErrorReleasesKey", func(t *testing.T) {
+		origSave := firebase.SaveRegistration
+		firebase.SaveRegistration = func(ctx context.Context, reg structs.Registration) (string, error) {
+			return "", context.DeadlineExceeded
+		}
+		rr := post("key-5", `{"country":"Retryland"}`)
+		firebase.SaveRegistration = origSave
+		if rr.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected 500, got %d", rr.Code)
+		}
+
+		rr = post("key-5", `{"country":"Retryland"}`)
+		if rr.Code != http.StatusCreated || rr.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("Expected the retry to be executed, got %d", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+// File: assignment-2/handlers/list_params.go
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"assignment-2/structs"
+)
+
+// listSpec describes which query parameters a collection listing accepts.
+type listSpec struct {
+	filters    map[string]string // query parameter -> stored field, for equality filters
+	contains   map[string]string // query parameter -> stored array field that must contain the value
+	sortable   []string          // fields accepted by ?sort=
+	rangeField string            // timestamp field filterable via <field>From / <field>To
+}
+
+// parseListOptions translates the listing query parameters into ListOptions:
+//
+//	limit=N, cursor=ID, sort=field or sort=-field (descending),
+//	<filter>=value for every filter (or contains filter) in the spec,
+//	<rangeField>From / <rangeField>To (RFC 3339).
+//
+// Firestore only allows a range filter together with a sort on the same field, so a
+// range filter without explicit sort sorts on that field, and any other sort is rejected.
+func parseListOptions(q url.Values, spec listSpec) (structs.ListOptions, error) {
+	var opts structs.ListOptions
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return opts, fmt.Errorf("limit must be a positive integer")
+		}
+		opts.Limit = n
+	}
+	opts.Cursor = q.Get("cursor")
+
+	if v := q.Get("sort"); v != "" {
+		field := strings.TrimPrefix(v, "-")
+		if !containsString(spec.sortable, field) {
+			return opts, fmt.Errorf("cannot sort by '%s', expected one of: %s", field, strings.Join(spec.sortable, ", "))
+		}
+		opts.OrderBy = field
+		opts.Descending = strings.HasPrefix(v, "-")
+	}
+
+	for param, field := range spec.filters {
+		if v := q.Get(param); v != "" {
+			if opts.Equals == nil {
+				opts.Equals = make(map[string]string)
+			}
+			opts.Equals[field] = v
+		}
+	}
+	for param, field := range spec.contains {
+		if v := q.Get(param); v != "" {
+			if opts.Contains == nil {
+				opts.Contains = make(map[string]string)
+			}
+			opts.Contains[field] = v
+		}
+	}
+
+	if spec.rangeField != "" {
+		var err error
+		if opts.From, err = parseTimeParam(q, spec.rangeField+"From"); err != nil {
+			return opts, err
+		}
+		if opts.To, err = parseTimeParam(q, spec.rangeField+"To"); err != nil {
+			return opts, err
+		}
+		if !opts.From.IsZero() || !opts.To.IsZero() {
+			if !opts.From.IsZero() && !opts.To.IsZero() && !opts.To.After(opts.From) {
+				return opts, fmt.Errorf("%sTo must be after %sFrom", spec.rangeField, spec.rangeField)
+			}
+			if opts.OrderBy == "" {
+				opts.OrderBy = spec.rangeField
+			} else if opts.OrderBy != spec.rangeField {
+				return opts, fmt.Errorf("results filtered by %s can only be sorted by %s", spec.rangeField, spec.rangeField)
+			}
+			opts.RangeField = spec.rangeField
+		}
+	}
+	return opts, nil
+}
+
+// parseFields parses the sparse fieldset parameter (?fields=id,country). It returns nil
+// when the parameter is absent, meaning that every field is returned.
+func parseFields(q url.Values, allowed []string) ([]string, error) {
+	v := q.Get("fields")
+	if v == "" {
+		return nil, nil
+	}
+	var fields []string
+	for _, f := range strings.Split(v, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !containsString(allowed, f) {
+			return nil, fmt.Errorf("unknown field '%s', expected any of: %s", f, strings.Join(allowed, ", "))
+		}
+		fields = append(fields, f)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("fields must name at least one field")
+	}
+	return fields, nil
+}
+
+// parseTimeParam parses an optional RFC 3339 query parameter.
+func parseTimeParam(q url.Values, name string) (time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+	}
+	return t, nil
+}
+
+// setNextLink adds a Link header pointing to the next page, keeping all other query
+// parameters of the current request. Nothing is added on the last page.
+func setNextLink(w http.ResponseWriter, r *http.Request, cursor string) {
+	if cursor == "" {
+		return
+	}
+	q := r.URL.Query()
+	q.Set("cursor", cursor)
+	next := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.String()))
+}
+
+// containsString reports whether 's' is in 'list'.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+// File: assignment-2/handlers/list_params_test.go
+package handlers
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestParseListOptions checks the translation of query parameters into ListOptions.
+func TestParseListOptions(t *testing.T) {
+	t.Run("FiltersSortAndLimit", func(t *testing.T) {
+		q, _ := url.ParseQuery("isoCode=NO&sort=-country&limit=20&cursor=abc")
+		opts, err := parseListOptions(q, registrationListSpec)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if opts.Equals["isoCode"] != "NO" || opts.OrderBy != "country" || !opts.Descending {
+			t.Errorf("Unexpected options: %+v", opts)
+		}
+		if opts.Limit != 20 || opts.Cursor != "abc" {
+			t.Errorf("Expected limit=20 cursor=abc, got %d %q", opts.Limit, opts.Cursor)
+		}
+	})
+
+	t.Run("RangeImpliesSort", func(t *testing.T) {
+		q, _ := url.ParseQuery("lastChangeFrom=2025-04-01T00:00:00Z&lastChangeTo=2025-05-01T00:00:00Z")
+		opts, err := parseListOptions(q, registrationListSpec)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if opts.RangeField != "lastChange" || opts.OrderBy != "lastChange" {
+			t.Errorf("Expected range and sort on lastChange, got %+v", opts)
+		}
+		if opts.From.IsZero() || opts.To.IsZero() {
+			t.Error("Expected both range bounds to be set")
+		}
+	})
+
+	t.Run("ContainsFilter", func(t *testing.T) {
+		q, _ := url.ParseQuery("event=INVOKE&country=NO")
+		opts, err := parseListOptions(q, notificationListSpec)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if opts.Contains["events"] != "INVOKE" || opts.Equals["country"] != "NO" || len(opts.Equals) != 1 {
+			t.Errorf("Unexpected options: %+v", opts)
+		}
+	})
+
+	t.Run("EmptyRange", func(t *testing.T) {
+		q, _ := url.ParseQuery("lastChangeFrom=2025-05-01T00:00:00Z&lastChangeTo=2025-04-01T00:00:00Z")
+		if _, err := parseListOptions(q, registrationListSpec); err == nil {
+			t.Error("Expected an error when lastChangeTo is before lastChangeFrom")
+		}
+	})
+}
+
+// TestSetNextLink checks that the next link keeps the current query and replaces the cursor.
+func TestSetNextLink(t *testing.T) {
+	r := httptest.NewRequest("GET", "/dashboard/v1/registrations/?country=Norway&cursor=old", nil)
+	w := httptest.NewRecorder()
+	setNextLink(w, r, "new")
+	link := w.Header().Get("Link")
+	if !strings.Contains(link, "cursor=new") || strings.Contains(link, "cursor=old") || !strings.Contains(link, "country=Norway") {
+		t.Errorf("Unexpected Link header: %q", link)
+	}
+
+	w = httptest.NewRecorder()
+	setNextLink(w, r, "")
+	if w.Header().Get("Link") != "" {
+		t.Error("Expected no Link header without a cursor")
+	}
+}
@@ -2,8 +2,12 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strings"
@@ -21,8 +25,12 @@ func NotificationsRouter(w http.ResponseWriter, r *http.Request) {
 		// No ID => handle collection-level
 		handleNotificationsCollection(w, r)
 	} else {
-		// There's something after /notifications/
+		// There's something after /notifications/, possibly followed by a sub-resource
 		id := strings.TrimPrefix(r.URL.Path, constants.NOTIFICATIONS_PATH)
+		if parts := strings.Split(id, "/"); len(parts) > 1 {
+			handleNotificationSubresource(w, r, parts[0], parts[1:])
+			return
+		}
 		handleNotificationWithID(w, r, id)
 	}
 }
@@ -30,7 +38,7 @@ func NotificationsRouter(w http.ResponseWriter, r *http.Request) {
 func handleNotificationsCollection(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
-		handlePostNotification(w, r)
+		withIdempotency(w, r, "notifications", handlePostNotification, "secret")
 	case http.MethodGet:
 		handleGetAllNotifications(w, r)
 	default:
@@ -38,10 +46,58 @@ func handleNotificationsCollection(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleNotificationSubresource routes the paths below a single notification:
+//
+//	POST {id}/verify                         repeat the verification handshake
+//	POST {id}/test                           send a test event, return the response
+//	POST {id}/rotate-secret                  replace the signing secret
+//	GET  {id}/deliveries                     the delivery log, newest first
+//	GET  {id}/dead-letters                   list the deliveries that ran out of attempts
+//	POST {id}/dead-letters/replay            queue all of them again
+//	POST {id}/dead-letters/{letter}/replay   queue a single one again
+func handleNotificationSubresource(w http.ResponseWriter, r *http.Request, id string, rest []string) {
+	switch {
+	case len(rest) == 1 && rest[0] == "verify":
+		if requireMethod(w, r, http.MethodPost) {
+			handleVerifyWebhook(w, r, id)
+		}
+	case len(rest) == 1 && rest[0] == "test":
+		if requireMethod(w, r, http.MethodPost) {
+			handleTestWebhook(w, r, id)
+		}
+	case len(rest) == 1 && rest[0] == "rotate-secret":
+		if requireMethod(w, r, http.MethodPost) {
+			handleRotateSecret(w, r, id)
+		}
+	case len(rest) == 1 && rest[0] == "deliveries":
+		if requireMethod(w, r, http.MethodGet) {
+			handleListDeliveries(w, r, id)
+		}
+	case len(rest) == 1 && rest[0] == "dead-letters":
+		if requireMethod(w, r, http.MethodGet) {
+			handleListDeadLetters(w, r, id)
+		}
+	case len(rest) == 2 && rest[0] == "dead-letters" && rest[1] == "replay":
+		if requireMethod(w, r, http.MethodPost) {
+			handleReplayDeadLetters(w, id)
+		}
+	case len(rest) == 3 && rest[0] == "dead-letters" && rest[2] == "replay":
+		if requireMethod(w, r, http.MethodPost) {
+			handleReplayDeadLetter(w, id, rest[1])
+		}
+	default:
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Unknown notification resource")
+	}
+}
+
 func handleNotificationWithID(w http.ResponseWriter, r *http.Request, id string) {
 	switch r.Method {
 	case http.MethodGet:
 		handleGetNotificationByID(w, r, id)
+	case http.MethodPut:
+		handleUpdateNotification(w, r, id, false)
+	case http.MethodPatch:
+		handleUpdateNotification(w, r, id, true)
 	case http.MethodDelete:
 		handleDeleteNotification(w, r, id)
 	default:
@@ -56,7 +112,19 @@ func handlePostNotification(w http.ResponseWriter, r *http.Request) {
 		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Invalid JSON request body")
 		return
 	}
+	if err := validateNotification(r.Context(), &req); err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	req.Created = time.Now()
+	secret, err := newWebhookSecret()
+	if err != nil {
+		log.Printf("Error generating webhook secret: %v\n", err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not save webhook notification")
+		return
+	}
+	req.Secrets = []structs.WebhookSecret{{Value: secret, Created: req.Created}}
+	req.Status, req.Verified = structs.WebhookPending, time.Time{}
 
 	ctx := context.Background()
 	newID, err := firebase.SaveNotification(ctx, req)
@@ -65,18 +133,283 @@ func handlePostNotification(w http.ResponseWriter, r *http.Request) {
 		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not save webhook notification")
 		return
 	}
-	resp := map[string]string{"id": newID}
+	req.ID = newID
+
+	// The secret is only ever shown in this response; idempotent replays leave it out
+	resp := map[string]string{"id": newID, "secret": secret}
+	if err := activateWebhook(r.Context(), &req); err != nil {
+		log.Printf("[Webhook] Verification of %s failed, webhook stays pending: %v\n", req.URL, err)
+		resp["verificationError"] = err.Error()
+	}
+	resp["status"] = req.Status
 	tools.WriteJsonResponse(w, http.StatusCreated, resp)
 }
 
+// validateNotification checks the settings of a webhook supplied by a client, and
+// normalizes its events and country.
+func validateNotification(ctx context.Context, n *structs.Notification) error {
+	if err := webhookPolicy.CheckURL(ctx, n.URL); err != nil {
+		return err
+	}
+	if err := normalizeEvents(n); err != nil {
+		return err
+	}
+	code, err := countryCode(n.Country)
+	if err != nil {
+		return err
+	}
+	n.Country = code
+	if err := validateScope(ctx, n); err != nil {
+		return err
+	}
+	if err := validatePayloadOptions(n); err != nil {
+		return err
+	}
+	if err := validateRetryPolicy(n.Retry); err != nil {
+		return err
+	}
+	return validateCondition(n)
+}
+
+// validateScope checks the registrations and tags a webhook is scoped to. Duplicates are
+// dropped, and every registration has to exist. CONDITION events are about a country, so
+// they cannot be scoped.
+func validateScope(ctx context.Context, n *structs.Notification) error {
+	n.Registrations = uniqueStrings(n.Registrations)
+	n.Tags = uniqueStrings(n.Tags)
+	if len(n.Registrations) == 0 && len(n.Tags) == 0 {
+		return nil
+	}
+	if n.Subscribes(conditionEvent) {
+		return fmt.Errorf("event %s cannot be scoped to registrations or tags", conditionEvent)
+	}
+	if err := validateTags(n.Tags); err != nil {
+		return err
+	}
+	if len(n.Registrations) > constants.MAX_TAGS {
+		return fmt.Errorf("a webhook can be scoped to at most %d registrations", constants.MAX_TAGS)
+	}
+	if len(n.Registrations) == 0 {
+		return nil
+	}
+	for _, id := range n.Registrations {
+		if id == "" {
+			return errors.New("registrations must not contain empty IDs")
+		}
+	}
+	stored, err := firebase.GetRegistrationsByIDs(ctx, n.Registrations)
+	if err != nil {
+		return fmt.Errorf("could not check the registrations: %v", err)
+	}
+	for _, id := range n.Registrations {
+		if _, ok := stored[id]; !ok {
+			return fmt.Errorf("registration '%s' not found", id)
+		}
+	}
+	return nil
+}
+
+// uniqueStrings returns the trimmed, non-duplicate elements of 'values' in order.
+func uniqueStrings(values []string) []string {
+	var out []string
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if !containsString(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// notificationUpdate holds the settings of a webhook that PUT and PATCH can change.
+type notificationUpdate struct {
+	URL            string               `json:"url"`
+	Country        string               `json:"country"`
+	Events         []string             `json:"events"`
+	Event          string               `json:"event,omitempty"`
+	Registrations  []string             `json:"registrations"`
+	Tags           []string             `json:"tags"`
+	PayloadVersion int                  `json:"payloadVersion"`
+	Format         string               `json:"format"`
+	IncludeData    bool                 `json:"includeData"`
+	Retry          *structs.RetryPolicy `json:"retry"`
+	Condition      string               `json:"condition"`
+	Hysteresis     float64              `json:"hysteresis"`
+}
+
+// handleUpdateNotification handles PUT and PATCH {id}. PUT replaces all settings, PATCH
+// applies a JSON Merge Patch (RFC 7396) to them; both reject unknown fields. The ID, secrets and delivery history are
+// kept. A new URL has to pass the verification handshake again, and a new condition or
+// country starts its evaluation over.
+func handleUpdateNotification(w http.ResponseWriter, r *http.Request, id string, patch bool) {
+	ctx := r.Context()
+	existing, err := firebase.GetNotificationByID(ctx, id)
+	if err != nil {
+		log.Printf("Error fetching notification %s: %v\n", id, err)
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Could not read request body")
+		return
+	}
+
+	var update notificationUpdate
+	if patch {
+		update, err = applyNotificationPatch(*existing, body)
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&update)
+	}
+	if err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Invalid JSON request body: "+err.Error())
+		return
+	}
+
+	updated := *existing
+	updated.URL, updated.Country, updated.Retry = update.URL, update.Country, update.Retry
+	updated.Event, updated.Events = update.Event, update.Events
+	updated.Registrations, updated.Tags = update.Registrations, update.Tags
+	updated.PayloadVersion, updated.Format, updated.IncludeData = update.PayloadVersion, update.Format, update.IncludeData
+	updated.Condition, updated.Hysteresis = update.Condition, update.Hysteresis
+	if err := validateNotification(ctx, &updated); err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if updated.Condition == existing.Condition && updated.Country == existing.Country {
+		updated.ConditionState = existing.ConditionState
+	}
+	urlChanged := updated.URL != existing.URL
+	if urlChanged {
+		updated.Status, updated.Verified = structs.WebhookPending, time.Time{}
+	}
+
+	err = firebase.UpdateNotification(ctx, updated)
+	if errors.Is(err, firebase.ErrNotificationNotFound) {
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating notification %s: %v\n", id, err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not update webhook notification")
+		return
+	}
+	if urlChanged {
+		if err := activateWebhook(ctx, &updated); err != nil {
+			log.Printf("[Webhook] Verification of %s failed, webhook stays pending: %v\n", updated.URL, err)
+		}
+	}
+	updated.Secrets = nil
+	tools.WriteJsonResponse(w, http.StatusOK, updated)
+}
+
+// applyNotificationPatch applies a JSON Merge Patch to the settings of 'existing'. Fields
+// that cannot be changed are rejected. Patching the single "event" replaces all events.
+func applyNotificationPatch(existing structs.Notification, patch []byte) (notificationUpdate, error) {
+	original, err := json.Marshal(notificationUpdate{
+		URL: existing.URL, Country: existing.Country, Events: existing.EventList(), Retry: existing.Retry,
+		Registrations: existing.Registrations, Tags: existing.Tags, Condition: existing.Condition, Hysteresis: existing.Hysteresis,
+		PayloadVersion: existing.Schema(), Format: existing.Format, IncludeData: existing.IncludeData,
+	})
+	if err != nil {
+		return notificationUpdate{}, err
+	}
+	patched, err := tools.MergePatch(original, patch)
+	if err != nil {
+		return notificationUpdate{}, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	var update notificationUpdate
+	if err := dec.Decode(&update); err != nil {
+		return notificationUpdate{}, err
+	}
+	if update.Event != "" {
+		update.Events = nil
+	}
+	return update, nil
+}
+
+// validateRetryPolicy checks the retry policy of a webhook, if it has one. Backoff values
+// that are left out take the defaults.
+func validateRetryPolicy(p *structs.RetryPolicy) error {
+	if p == nil {
+		return nil
+	}
+	if p.MaxAttempts < 1 || p.MaxAttempts > constants.WEBHOOK_MAX_ATTEMPTS_LIMIT {
+		return fmt.Errorf("retry.maxAttempts must be between 1 and %d", constants.WEBHOOK_MAX_ATTEMPTS_LIMIT)
+	}
+	if p.InitialBackoffSeconds < 0 || p.InitialBackoffSeconds > constants.WEBHOOK_BACKOFF_LIMIT_SECONDS ||
+		p.MaxBackoffSeconds < 0 || p.MaxBackoffSeconds > constants.WEBHOOK_BACKOFF_LIMIT_SECONDS {
+		return fmt.Errorf("retry backoff must be between 0 (default) and %d seconds", constants.WEBHOOK_BACKOFF_LIMIT_SECONDS)
+	}
+	if effective := retryPolicyOf(structs.Notification{Retry: p}); effective.MaxBackoffSeconds < effective.InitialBackoffSeconds {
+		return fmt.Errorf("retry.maxBackoffSeconds must not be less than retry.initialBackoffSeconds")
+	}
+	return nil
+}
+
+// retryPolicyOf returns the retry policy deliveries to 'n' use, filling in the defaults.
+func retryPolicyOf(n structs.Notification) structs.RetryPolicy {
+	policy := structs.RetryPolicy{
+		MaxAttempts:           constants.WEBHOOK_MAX_ATTEMPTS,
+		InitialBackoffSeconds: constants.WEBHOOK_INITIAL_BACKOFF_SECONDS,
+		MaxBackoffSeconds:     constants.WEBHOOK_MAX_BACKOFF_SECONDS,
+	}
+	if n.Retry == nil {
+		return policy
+	}
+	policy.MaxAttempts = n.Retry.MaxAttempts
+	if n.Retry.InitialBackoffSeconds > 0 {
+		policy.InitialBackoffSeconds = n.Retry.InitialBackoffSeconds
+	}
+	if n.Retry.MaxBackoffSeconds > 0 {
+		policy.MaxBackoffSeconds = n.Retry.MaxBackoffSeconds
+	} else if policy.MaxBackoffSeconds < policy.InitialBackoffSeconds {
+		policy.MaxBackoffSeconds = policy.InitialBackoffSeconds
+	}
+	return policy
+}
+
+// notificationListSpec lists the query parameters accepted by GET /notifications/
+var notificationListSpec = listSpec{
+	filters:    map[string]string{"country": "country"},
+	contains:   map[string]string{"event": "events"},
+	sortable:   []string{"country", "created"},
+	rangeField: "created",
+}
+
 func handleGetAllNotifications(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r.URL.Query(), notificationListSpec)
+	if err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Filters take the same spellings as registrations
+	if c, ok := opts.Equals["country"]; ok {
+		if opts.Equals["country"], err = countryCode(c); err != nil {
+			tools.WriteJsonErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if e, ok := opts.Contains["events"]; ok {
+		opts.Contains["events"] = strings.ToUpper(e)
+	}
+
 	ctx := context.Background()
-	notifs, err := firebase.GetAllNotifications(ctx)
+	notifs, next, err := firebase.ListNotifications(ctx, opts)
+	if errors.Is(err, firebase.ErrInvalidCursor) {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
 	if err != nil {
 		log.Printf("Error fetching notifications: %v\n", err)
 		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not retrieve notifications")
 		return
 	}
+	setNextLink(w, r, next)
 	tools.WriteJsonResponse(w, http.StatusOK, notifs)
 }
 
@@ -88,6 +421,11 @@ func handleGetNotificationByID(w http.ResponseWriter, r *http.Request, id string
 		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Notification not found")
 		return
 	}
+	if summary, err := firebase.GetDeliverySummary(ctx, id); err != nil {
+		log.Printf("Warning: could not summarize the deliveries of %s: %v\n", id, err)
+	} else {
+		notif.Deliveries = summary
+	}
 	tools.WriteJsonResponse(w, http.StatusOK, notif)
 }
 
@@ -4,9 +4,12 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -14,6 +17,7 @@ import (
 
 	"assignment-2/constants"
 	"assignment-2/firebase"
+	"assignment-2/services"
 	"assignment-2/structs"
 )
 
@@ -28,20 +32,26 @@ var (
 var (
 	origSaveNotification    = firebase.SaveNotification
 	origGetAllNotifications = firebase.GetAllNotifications
+	origListNotifications   = firebase.ListNotifications
 	origGetNotificationByID = firebase.GetNotificationByID
 	origDeleteNotification  = firebase.DeleteNotification
+	origRotateSecret        = firebase.RotateNotificationSecret
+	origActivate            = firebase.ActivateNotification
+	origSaveConditionState  = firebase.SaveConditionState
+	origUpdateNotification  = firebase.UpdateNotification
 )
 
 // overrideNotificationStubs replaces Firebase functions with in-memory stubs
 func overrideNotificationStubs() {
 	notifStore = make(map[string]structs.Notification)
 	notifIDSeq = 0
+	overrideDeliveryLogStubs()
 
 	firebase.SaveNotification = func(ctx context.Context, notif structs.Notification) (string, error) {
 		notifMutex.Lock()
 		defer notifMutex.Unlock()
 		notifIDSeq++
-		newID := "notif-" + string(rune(notifIDSeq))
+		newID := "notif-" + strconv.Itoa(notifIDSeq)
 		notif.ID = newID
 		notifStore[newID] = notif
 		return newID, nil
@@ -57,12 +67,28 @@ func overrideNotificationStubs() {
 		return all, nil
 	}
 
+	firebase.ListNotifications = func(ctx context.Context, opts structs.ListOptions) ([]structs.Notification, string, error) {
+		notifMutex.Lock()
+		defer notifMutex.Unlock()
+		var page []structs.Notification
+		for _, n := range notifStore {
+			if c, ok := opts.Equals["country"]; ok && n.Country != c {
+				continue
+			}
+			if e, ok := opts.Contains["events"]; ok && !n.Subscribes(e) {
+				continue
+			}
+			page = append(page, n)
+		}
+		return page, "", nil
+	}
+
 	firebase.GetNotificationByID = func(ctx context.Context, docID string) (*structs.Notification, error) {
 		notifMutex.Lock()
 		defer notifMutex.Unlock()
 		n, ok := notifStore[docID]
 		if !ok {
-			return nil, os.ErrNotExist
+			return nil, firebase.ErrNotificationNotFound
 		}
 		return &n, nil
 	}
@@ -72,18 +98,83 @@ func overrideNotificationStubs() {
 		defer notifMutex.Unlock()
 		_, ok := notifStore[docID]
 		if !ok {
-			return os.ErrNotExist
+			return firebase.ErrNotificationNotFound
 		}
 		delete(notifStore, docID)
 		return nil
 	}
+
+	firebase.RotateNotificationSecret = func(ctx context.Context, docID, secret string, grace time.Duration) (*structs.Notification, error) {
+		notifMutex.Lock()
+		defer notifMutex.Unlock()
+		n, ok := notifStore[docID]
+		if !ok {
+			return nil, firebase.ErrNotificationNotFound
+		}
+		now := time.Now()
+		secrets := []structs.WebhookSecret{{Value: secret, Created: now}}
+		if len(n.Secrets) > 0 && grace > 0 {
+			previous := n.Secrets[0]
+			previous.Expires = now.Add(grace)
+			secrets = append(secrets, previous)
+		}
+		n.Secrets = secrets
+		notifStore[docID] = n
+		return &n, nil
+	}
+
+	firebase.ActivateNotification = func(ctx context.Context, docID string, verified time.Time) error {
+		notifMutex.Lock()
+		defer notifMutex.Unlock()
+		n, ok := notifStore[docID]
+		if !ok {
+			return firebase.ErrNotificationNotFound
+		}
+		n.Status, n.Verified = structs.WebhookActive, verified
+		notifStore[docID] = n
+		return nil
+	}
+
+	firebase.UpdateNotification = func(ctx context.Context, notif structs.Notification) error {
+		notifMutex.Lock()
+		defer notifMutex.Unlock()
+		n, ok := notifStore[notif.ID]
+		if !ok {
+			return firebase.ErrNotificationNotFound
+		}
+		n.URL, n.Country, n.Event, n.Events, n.Retry = notif.URL, notif.Country, "", notif.EventList(), notif.Retry
+		n.Registrations, n.Tags = notif.Registrations, notif.Tags
+		n.PayloadVersion, n.Format, n.IncludeData = notif.PayloadVersion, notif.Format, notif.IncludeData
+		n.Status, n.Verified = notif.Status, notif.Verified
+		n.Condition, n.Hysteresis, n.ConditionState = notif.Condition, notif.Hysteresis, notif.ConditionState
+		notifStore[notif.ID] = n
+		return nil
+	}
+
+	firebase.SaveConditionState = func(ctx context.Context, docID string, state structs.ConditionState) error {
+		notifMutex.Lock()
+		defer notifMutex.Unlock()
+		n, ok := notifStore[docID]
+		if !ok {
+			return firebase.ErrNotificationNotFound
+		}
+		n.ConditionState = &state
+		notifStore[docID] = n
+		return nil
+	}
 }
 
 func revertNotificationStubs() {
 	firebase.SaveNotification = origSaveNotification
 	firebase.GetAllNotifications = origGetAllNotifications
+	firebase.ListNotifications = origListNotifications
 	firebase.GetNotificationByID = origGetNotificationByID
 	firebase.DeleteNotification = origDeleteNotification
+	firebase.RotateNotificationSecret = origRotateSecret
+	firebase.ActivateNotification = origActivate
+	firebase.SaveConditionState = origSaveConditionState
+	firebase.UpdateNotification = origUpdateNotification
+	revertDeliveryLogStubs()
 }
 
 func TestNotificationsHandler(t *testing.T) {
@@ -152,6 +243,34 @@ func TestNotificationsHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("GET /notifications?event= - filtered", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, constants.NOTIFICATIONS_PATH+"?event=INVOKE", nil)
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200 OK, got %d", rr.Code)
+		}
+		var notifs []structs.Notification
+		_ = json.Unmarshal(rr.Body.Bytes(), &notifs)
+		if len(notifs) == 0 {
+			t.Fatal("Expected at least one INVOKE notification")
+		}
+		for _, n := range notifs {
+			if !n.Subscribes("INVOKE") {
+				t.Errorf("Expected only INVOKE notifications, got %v", n.EventList())
+			}
+		}
+	})
+
+	t.Run("GET /notifications?sort= - invalid field", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, constants.NOTIFICATIONS_PATH+"?sort=url", nil)
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 Bad Request, got %d", rr.Code)
+		}
+	})
+
 	t.Run("GET /notifications/{id} - success", func(t *testing.T) {
 		// Insert a known notification
 		id, _ := firebase.SaveNotification(context.Background(), structs.Notification{
@@ -224,7 +343,7 @@ func TestNotificationsHandler(t *testing.T) {
 	})
 
 	t.Run("Method not allowed - single resource", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodPut, constants.NOTIFICATIONS_PATH+"someid", nil)
+		req := httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH+"someid", nil)
 		rr := httptest.NewRecorder()
 		NotificationsRouter(rr, req)
 
@@ -243,3 +362,295 @@ func TestNotificationsHandler(t *testing.T) {
 		}
 	})
 }
+
+// TestNotificationEvents checks the validation of events and countries, and that webhooks
+// with several events and countries given by name match their events.
+func TestNotificationEvents(t *testing.T) {
+	overrideNotificationStubs()
+	defer revertNotificationStubs()
+	origFetch := services.FetchCountryInfo
+	services.FetchCountryInfo = func(country string) (*structs.CountryInfo, error) {
+		if strings.EqualFold(country, "Norway") {
+			return &structs.CountryInfo{Name: "Norway", ISOCode: "NO"}, nil
+		}
+		return nil, errors.New("stub: only Norway is known")
+	}
+	defer func() { services.FetchCountryInfo = origFetch }()
+
+	received := make(chan map[string]interface{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if echoChallenge(w, body) {
+			return
+		}
+		var payload map[string]interface{}
+		_ = json.Unmarshal(body, &payload)
+		received <- payload
+	}))
+	defer srv.Close()
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH, strings.NewReader(body)))
+		return rr
+	}
+	rr := post(`{"url":"` + srv.URL + `","events":["register"," change ","REGISTER"],"country":"Norway"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d %s", rr.Code, rr.Body.String())
+	}
+	var created map[string]string
+	_ = json.Unmarshal(rr.Body.Bytes(), &created)
+	stored, _ := firebase.GetNotificationByID(context.Background(), created["id"])
+	if stored.Country != "NO" || strings.Join(stored.Events, ",") != "REGISTER,CHANGE" || stored.Event != "" {
+		t.Errorf("Expected normalized events and country, got %+v", stored)
+	}
+
+	t.Run("Validation", func(t *testing.T) {
+		bodies := []string{
+			`{"url":"` + srv.URL + `","event":"REGISTERED"}`,
+			`{"url":"` + srv.URL + `","events":["REGISTER","INVOKED"]}`,
+			`{"url":"` + srv.URL + `","events":[]}`,
+			`{"url":"` + srv.URL + `"}`,
+			`{"url":"` + srv.URL + `","event":"REGISTER","events":["CHANGE"]}`,
+			`{"url":"` + srv.URL + `","event":"REGISTER","country":"Atlantis"}`,
+		}
+		for _, body := range bodies {
+			if rr := post(body); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400, got %d", body, rr.Code)
+			}
+		}
+	})
+
+	t.Run("Matching", func(t *testing.T) {
+		// Stored with a country name before countries were normalized
+		legacy, _ := firebase.SaveNotification(context.Background(), structs.Notification{
+			URL: srv.URL, Country: "Norway", Event: "INVOKE",
+		})
+		startTestQueue(t, 1)
+		TriggerWebhookEventVar(structs.WebhookEvent{Event: "CHANGE", Country: "Norway"})
+		TriggerWebhookEventVar(structs.WebhookEvent{Event: "REGISTER", Country: "NO"})
+		TriggerWebhookEventVar(structs.WebhookEvent{Event: "DELETE", Country: "NO"})
+		TriggerWebhookEventVar(structs.WebhookEvent{Event: "INVOKE", Country: "no"})
+		waitForEmptyQueue(t)
+		if len(received) != 3 {
+			t.Fatalf("Expected 3 deliveries, got %d", len(received))
+		}
+		for _, want := range []string{"CHANGE", "REGISTER", "INVOKE"} {
+			payload := <-received
+			country := payload["country"] // version 1, as the legacy webhook has
+			if subject, ok := payload["subject"].(map[string]interface{}); ok {
+				country = subject["country"]
+			}
+			if payload["event"] != want || country != "NO" {
+				t.Errorf("Expected a %s event for NO, got %v", want, payload)
+			}
+			if want == "INVOKE" && payload["id"] != legacy {
+				t.Errorf("Expected the legacy webhook to match, got %v", payload)
+			}
+		}
+	})
+
+	t.Run("ListFilters", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, httptest.NewRequest(http.MethodGet, constants.NOTIFICATIONS_PATH+"?event=change&country=Norway", nil))
+		var notifs []structs.Notification
+		_ = json.Unmarshal(rr.Body.Bytes(), &notifs)
+		if rr.Code != http.StatusOK || len(notifs) != 1 || notifs[0].ID != created["id"] {
+			t.Errorf("Expected the CHANGE webhook for NO, got %d %s", rr.Code, rr.Body.String())
+		}
+	})
+}
+
+// TestScopedWebhooks scopes webhooks to registrations and tags.
+func TestScopedWebhooks(t *testing.T) {
+	overrideNotificationStubs()
+	defer revertNotificationStubs()
+	origGet := firebase.GetRegistrationsByIDs
+	firebase.GetRegistrationsByIDs = func(ctx context.Context, docIDs []string) (map[string]structs.Registration, error) {
+		found := make(map[string]structs.Registration)
+		for _, id := range docIDs {
+			if id == "reg-1" || id == "reg-2" {
+				found[id] = structs.Registration{ID: id}
+			}
+		}
+		return found, nil
+	}
+	defer func() { firebase.GetRegistrationsByIDs = origGet }()
+
+	received := make(chan map[string]interface{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if echoChallenge(w, body) {
+			return
+		}
+		var payload map[string]interface{}
+		_ = json.Unmarshal(body, &payload)
+		received <- payload
+	}))
+	defer srv.Close()
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH, strings.NewReader(body)))
+		return rr
+	}
+	byID := post(`{"url":"` + srv.URL + `","events":["INVOKE","CHANGE"],"registrations":["reg-1","reg-1"]}`)
+	byTag := post(`{"url":"` + srv.URL + `","event":"INVOKE","tags":["team-a"]}`)
+	if byID.Code != http.StatusCreated || byTag.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d %s and %d %s", byID.Code, byID.Body.String(), byTag.Code, byTag.Body.String())
+	}
+	var created map[string]string
+	_ = json.Unmarshal(byID.Body.Bytes(), &created)
+	if stored, _ := firebase.GetNotificationByID(context.Background(), created["id"]); len(stored.Registrations) != 1 {
+		t.Errorf("Expected duplicate registrations to be dropped, got %v", stored.Registrations)
+	}
+
+	t.Run("Validation", func(t *testing.T) {
+		bodies := []string{
+			`{"url":"` + srv.URL + `","event":"INVOKE","registrations":["missing"]}`,
+			`{"url":"` + srv.URL + `","event":"INVOKE","registrations":[""]}`,
+			`{"url":"` + srv.URL + `","event":"INVOKE","tags":["two words"]}`,
+			`{"url":"` + srv.URL + `","event":"CONDITION","country":"NO","condition":"temperature < 0","tags":["team-a"]}`,
+		}
+		for _, body := range bodies {
+			if rr := post(body); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400, got %d", body, rr.Code)
+			}
+		}
+	})
+
+	t.Run("Matching", func(t *testing.T) {
+		startTestQueue(t, 1)
+		TriggerWebhookEventVar(registrationEvent("INVOKE", structs.Registration{ID: "reg-2", Country: "NO"}))
+		TriggerWebhookEventVar(structs.WebhookEvent{Event: "INVOKE", Country: "NO"})
+		TriggerWebhookEventVar(registrationEvent("INVOKE", structs.Registration{ID: "reg-1", Country: "NO"}))
+		TriggerWebhookEventVar(registrationEvent("INVOKE", structs.Registration{ID: "reg-3", Country: "NO", Tags: []string{"team-a"}}))
+		TriggerWebhookEventVar(changeEvent(
+			structs.Registration{ID: "reg-1", Country: "NO", Features: structs.Features{Temperature: true}},
+			structs.Registration{ID: "reg-1", Country: "NO"}))
+		waitForEmptyQueue(t)
+		if len(received) != 3 {
+			t.Fatalf("Expected 3 deliveries, got %d", len(received))
+		}
+		for _, want := range []string{"INVOKE:reg-1", "INVOKE:reg-3", "CHANGE:reg-1"} {
+			payload := <-received
+			subject, _ := payload["subject"].(map[string]interface{})
+			if got := fmt.Sprintf("%v:%v", payload["event"], subject["id"]); got != want {
+				t.Errorf("Expected %s, got %v", want, payload)
+			}
+			if payload["event"] == "CHANGE" {
+				changes, _ := json.Marshal(payload["changes"])
+				if string(changes) != `[{"op":"replace","path":"/features/temperature","value":false}]` {
+					t.Errorf("Expected the diff of the change, got %s", changes)
+				}
+			}
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, httptest.NewRequest(http.MethodPatch, constants.NOTIFICATIONS_PATH+created["id"],
+			strings.NewReader(`{"registrations":null,"tags":["team-b"]}`)))
+		var n structs.Notification
+		_ = json.Unmarshal(rr.Body.Bytes(), &n)
+		if rr.Code != http.StatusOK || len(n.Registrations) != 0 || len(n.Tags) != 1 || n.Tags[0] != "team-b" {
+			t.Errorf("Expected the scope to be replaced, got %d %s", rr.Code, rr.Body.String())
+		}
+	})
+}
+
+// TestUpdateNotification replaces and patches the settings of a webhook.
+func TestUpdateNotification(t *testing.T) {
+	overrideNotificationStubs()
+	defer revertNotificationStubs()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		echoChallenge(w, body)
+	}))
+	defer srv.Close()
+	silent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer silent.Close()
+
+	rr := httptest.NewRecorder()
+	NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH,
+		strings.NewReader(`{"url":"`+srv.URL+`","event":"REGISTER","country":"NO"}`)))
+	var created map[string]string
+	_ = json.Unmarshal(rr.Body.Bytes(), &created)
+	id := created["id"]
+
+	update := func(method, id, body string) (int, structs.Notification) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, httptest.NewRequest(method, constants.NOTIFICATIONS_PATH+id, strings.NewReader(body)))
+		var n structs.Notification
+		_ = json.Unmarshal(rr.Body.Bytes(), &n)
+		return rr.Code, n
+	}
+
+	code, n := update(http.MethodPut, id, `{"url":"`+srv.URL+`","events":["delete","INVOKE"],"country":"se"}`)
+	if code != http.StatusOK || n.ID != id || n.Country != "SE" || strings.Join(n.Events, ",") != "DELETE,INVOKE" {
+		t.Fatalf("Expected the replaced settings, got %d %+v", code, n)
+	}
+	if n.Status != structs.WebhookActive || n.Secret != "" {
+		t.Errorf("Expected the webhook to stay active without showing its secret, got %+v", n)
+	}
+	if stored, _ := firebase.GetNotificationByID(context.Background(), id); len(stored.Secrets) != 1 || stored.Created.IsZero() {
+		t.Errorf("Expected the secret and creation time to be kept, got %+v", stored)
+	}
+
+	code, n = update(http.MethodPatch, id, `{"country":null,"retry":{"maxAttempts":2}}`)
+	if code != http.StatusOK || n.Country != "" || len(n.Events) != 2 || n.Retry == nil || n.Retry.MaxAttempts != 2 {
+		t.Errorf("Expected only country and retry to change, got %d %+v", code, n)
+	}
+	code, n = update(http.MethodPatch, id, `{"event":"change"}`)
+	if code != http.StatusOK || strings.Join(n.Events, ",") != "CHANGE" {
+		t.Errorf("Expected the single event to replace the events, got %d %+v", code, n)
+	}
+
+	// A new URL is verified again
+	code, n = update(http.MethodPatch, id, `{"url":"`+silent.URL+`"}`)
+	if code != http.StatusOK || n.Status != structs.WebhookPending {
+		t.Errorf("Expected the webhook to be pending after changing its URL, got %d %+v", code, n)
+	}
+
+	for _, body := range []string{`{"events":["CHANGED"]}`, `{"id":"other"}`, `{"url":"http://localhost/hook"}`, `not json`} {
+		if code, _ := update(http.MethodPatch, id, body); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, code)
+		}
+	}
+	if code, _ := update(http.MethodPut, id, `{"url":"`+srv.URL+`","event":"REGISTER","status":"active"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown field in PUT, got %d", code)
+	}
+	if code, _ := update(http.MethodPut, "missing-id", `{"url":"`+srv.URL+`","event":"REGISTER"}`); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown webhook, got %d", code)
+	}
+
+	t.Run("PayloadOptions", func(t *testing.T) {
+		// Stored before payload versions were introduced
+		id, _ := firebase.SaveNotification(context.Background(), structs.Notification{URL: srv.URL, Event: "REGISTER"})
+		if code, n := update(http.MethodPatch, id, `{"country":"NO"}`); code != http.StatusOK || n.PayloadVersion != 1 || n.Format != structs.FormatJSON {
+			t.Errorf("Expected the webhook to keep payload version 1, got %d %+v", code, n)
+		}
+		if code, _ := update(http.MethodPatch, id, `{"format":"cloudevents"}`); code != http.StatusBadRequest {
+			t.Errorf("Expected CloudEvents to require version 2, got %d", code)
+		}
+		code, n := update(http.MethodPatch, id, `{"payloadVersion":2,"format":"cloudevents","includeData":true}`)
+		if code != http.StatusOK || n.PayloadVersion != 2 || n.Format != structs.FormatCloudEvents || !n.IncludeData {
+			t.Errorf("Expected the webhook to switch to CloudEvents, got %d %+v", code, n)
+		}
+	})
+
+	t.Run("ConditionState", func(t *testing.T) {
+		id, _ := firebase.SaveNotification(context.Background(), structs.Notification{
+			URL: srv.URL, Events: []string{"CONDITION"}, Country: "NO", Condition: "temperature < 0",
+			ConditionState: &structs.ConditionState{Value: -2, Triggered: true, Evaluated: time.Now()},
+		})
+		if code, n := update(http.MethodPatch, id, `{"hysteresis":1.5}`); code != http.StatusOK || n.ConditionState == nil || !n.ConditionState.Triggered {
+			t.Errorf("Expected the condition state to be kept, got %d %+v", code, n)
+		}
+		if code, n := update(http.MethodPatch, id, `{"condition":"temperature < -5"}`); code != http.StatusOK || n.ConditionState != nil {
+			t.Errorf("Expected a new condition to start over, got %d %+v", code, n)
+		}
+	})
+}
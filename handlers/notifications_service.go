@@ -2,24 +2,41 @@
 package handlers
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"net/http"
+	"strings"
 	"time"
 
+	"assignment-2/constants"
 	"assignment-2/firebase"
+	"assignment-2/services"
 	"assignment-2/structs"
 )
 
+// webhookEvents is the registry of the events webhooks can subscribe to.
+var webhookEvents = []string{"REGISTER", "CHANGE", "DELETE", "INVOKE", conditionEvent}
+
 // TriggerWebhookEventVar is a function variable we can override in test code.
 // It points by default to realTriggerWebhookEvent.
-var TriggerWebhookEventVar func(event, country string) = realTriggerWebhookEvent
+var TriggerWebhookEventVar func(ev structs.WebhookEvent) = realTriggerWebhookEvent
 
-// realTriggerWebhookEvent is the actual logic for sending webhooks.
-func realTriggerWebhookEvent(event, country string) {
-	ctx := context.Background()
+// realTriggerWebhookEvent hands the event to the webhook queue, which matches it against
+// the webhooks and delivers it, so handlers wait neither for Firestore nor for a receiver.
+// Events are dropped if the queue is full or stopped.
+func realTriggerWebhookEvent(ev structs.WebhookEvent) {
+	if !webhookDeliveries.trigger(ev, time.Now()) {
+		log.Printf("[Webhook] Dropped event=%s, country=%s: the event queue is full or stopped\n", ev.Event, ev.Country)
+	}
+}
+
+// dispatchWebhookEvent queues a delivery of an event that happened at 'now' for every
+// webhook matching it. Reading the webhooks and resolving countries together may take at
+// most WEBHOOK_FANOUT_TIMEOUT_SECONDS.
+func dispatchWebhookEvent(ev structs.WebhookEvent, now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), constants.WEBHOOK_FANOUT_TIMEOUT_SECONDS*time.Second)
+	defer cancel()
 
 	notifs, err := firebase.GetAllNotifications(ctx)
 	if err != nil {
@@ -27,39 +44,141 @@ func realTriggerWebhookEvent(event, country string) {
 		return
 	}
 
-	// Filter relevant notifications
+	// Filter relevant notifications; webhooks pending verification get no events. Each
+	// webhook gets the event cut down to its scope. Countries are compared by ISO code, also
+	// for webhooks stored with a country name, and are only looked up when a webhook needs them.
+	codes := make(map[string]string)
+	codeOf := func(c string) string {
+		if _, ok := codes[c]; !ok {
+			codes[c] = countryKey(ctx, c)
+		}
+		return codes[c]
+	}
 	var relevant []structs.Notification
+	var scoped []structs.WebhookEvent
 	for _, n := range notifs {
-		if n.Event == event && (n.Country == "" || n.Country == country) {
+		if !n.Active() || !n.Subscribes(ev.Event) {
+			continue
+		}
+		if sub, ok := n.Scope(ev); ok && (n.Country == "" || codeOf(n.Country) == codeOf(ev.Country)) {
 			relevant = append(relevant, n)
+			scoped = append(scoped, sub)
 		}
 	}
 
 	if len(relevant) == 0 {
-		log.Printf("[Webhook] No matching webhooks for event=%s, country=%s\n", event, country)
+		log.Printf("[Webhook] No matching webhooks for event=%s, country=%s\n", ev.Event, ev.Country)
 		return
 	}
 
-	now := time.Now().Format("20060102 15:04")
-	for _, wh := range relevant {
-		body := map[string]string{
-			"id":      wh.ID,
-			"country": country,
-			"event":   event,
-			"time":    now,
-		}
-		bodyBytes, _ := json.Marshal(body)
-		resp, postErr := http.Post(wh.URL, "application/json", bytes.NewBuffer(bodyBytes))
-		if postErr != nil {
-			log.Printf("[Webhook] Failed POST to %s: %v\n", wh.URL, postErr)
+	country := codeOf(ev.Country)
+	for i, wh := range relevant {
+		ev := scoped[i]
+		ev.Country = country
+		delivery, err := newDelivery(wh, ev, now)
+		if err != nil {
+			log.Printf("[Webhook] Could not build the %s event for %s: %v\n", ev.Event, wh.ID, err)
 			continue
 		}
-		if resp.StatusCode < 200 || resp.StatusCode > 299 {
-			log.Printf("[Webhook] Webhook %s responded %d\n", wh.URL, resp.StatusCode)
-		} else {
-			log.Printf("[Webhook] Successfully triggered %s, event=%s, country=%s\n",
-				wh.URL, event, country)
+		webhookDeliveries.enqueue(context.Background(), delivery)
+	}
+}
+
+// registrationEvent returns the event 'event' of a registration, with its state as data.
+func registrationEvent(event string, reg structs.Registration) structs.WebhookEvent {
+	ev := structs.WebhookEvent{Event: event, Country: webhookCountry(reg), Tags: reg.Tags, Data: &reg}
+	if reg.ID != "" {
+		ev.Registrations = []string{reg.ID}
+	}
+	return ev
+}
+
+// changeEvent returns the CHANGE event of a registration that was changed from 'old' to
+// 'updated', with the changed fields. It carries the tags of both, so webhooks scoped to a
+// tag that was removed learn about it.
+func changeEvent(old, updated structs.Registration) structs.WebhookEvent {
+	ev := registrationEvent("CHANGE", updated)
+	for _, tag := range old.Tags {
+		if !containsString(ev.Tags, tag) {
+			ev.Tags = append(ev.Tags, tag)
+		}
+	}
+	changes, err := diffRegistrations(old, updated)
+	if err != nil {
+		log.Printf("[Webhook] Could not diff the changes of %s: %v\n", updated.ID, err)
+	}
+	ev.Changes = changes
+	return ev
+}
+
+// normalizeEvents validates the events of a webhook against webhookEvents and stores them,
+// upper-cased and without duplicates, in Events. A single "event" is accepted instead.
+func normalizeEvents(n *structs.Notification) error {
+	if n.Event != "" && len(n.Events) > 0 {
+		return errors.New("use either event or events, not both")
+	}
+	var events []string
+	for _, e := range n.EventList() {
+		e = strings.ToUpper(strings.TrimSpace(e))
+		if !containsString(webhookEvents, e) {
+			return fmt.Errorf("unknown event '%s', use one of %s", e, strings.Join(webhookEvents, ", "))
+		}
+		if !containsString(events, e) {
+			events = append(events, e)
+		}
+	}
+	if len(events) == 0 {
+		return errors.New("at least one event is required")
+	}
+	n.Event, n.Events = "", events
+	return nil
+}
+
+// countryCode returns the upper-case ISO 3166-1 alpha-2 code of a country given by name or
+// code, or "" for no country. Two letters are taken as a code without looking them up.
+func countryCode(country string) (string, error) {
+	country = strings.TrimSpace(country)
+	if country == "" || isLetters(country, 2, 2) {
+		return strings.ToUpper(country), nil
+	}
+	info, err := services.FetchCountryInfo(country)
+	if err != nil {
+		return "", fmt.Errorf("unknown country '%s'", country)
+	}
+	if info.ISOCode == "" {
+		return "", fmt.Errorf("no ISO code known for country '%s'", country)
+	}
+	return strings.ToUpper(info.ISOCode), nil
+}
+
+// MigrateLegacyNotifications updates the webhooks stored by older versions, replacing
+// country names with ISO codes (see firebase.MigrateLegacyNotifications).
+func MigrateLegacyNotifications(ctx context.Context) (int, error) {
+	return firebase.MigrateLegacyNotifications(ctx, countryCode)
+}
+
+// countryKey returns the ISO code of 'country' for matching webhooks, or the upper-case
+// name if it cannot be resolved before 'ctx' ends.
+func countryKey(ctx context.Context, country string) string {
+	type result struct {
+		code string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		code, err := countryCode(country)
+		done <- result{code, err}
+	}()
+	var err error
+	select {
+	case r := <-done:
+		if r.err == nil {
+			return r.code
 		}
-		resp.Body.Close()
+		err = r.err
+	case <-ctx.Done():
+		err = ctx.Err()
 	}
+	log.Printf("[Webhook] Matching country '%s' by name: %v\n", country, err)
+	return strings.ToUpper(strings.TrimSpace(country))
 }
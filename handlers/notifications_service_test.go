@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
 
 	"assignment-2/firebase"
@@ -16,13 +17,29 @@ import (
 
 // We actually use these references for overriding firebase.GetAllNotifications:
 var originalGetAllNotifications = firebase.GetAllNotifications
+var originalGetNotificationByID = firebase.GetNotificationByID
 
+// overrideGetAllNotifications makes 'fn' the source of all notifications, also for the
+// lookups by ID made when deliveries are sent.
 func overrideGetAllNotifications(fn func(ctx context.Context) ([]structs.Notification, error)) {
 	firebase.GetAllNotifications = fn
+	firebase.GetNotificationByID = func(ctx context.Context, docID string) (*structs.Notification, error) {
+		notifs, err := fn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range notifs {
+			if n.ID == docID {
+				return &n, nil
+			}
+		}
+		return nil, firebase.ErrNotificationNotFound
+	}
 }
 
 func revertGetAllNotifications() {
 	firebase.GetAllNotifications = originalGetAllNotifications
+	firebase.GetNotificationByID = originalGetNotificationByID
 }
 
 func TestTriggerWebhookEventVar(t *testing.T) {
@@ -37,10 +54,13 @@ func TestTriggerWebhookEventVar(t *testing.T) {
 	defer revertGetAllNotifications()
 
 	// 2) We'll create a test server to capture incoming requests
+	var mu sync.Mutex
 	var requestBodies []string
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		bodyBytes, _ := io.ReadAll(r.Body)
+		mu.Lock()
 		requestBodies = append(requestBodies, string(bodyBytes))
+		mu.Unlock()
 		w.WriteHeader(http.StatusOK)
 	}))
 	defer server.Close()
@@ -56,7 +76,14 @@ func TestTriggerWebhookEventVar(t *testing.T) {
 
 	// 4) Actually call TriggerWebhookEventVar ... event="REGISTER", country="NO"
 	//    w1 is matched, w2 also matched but fails (not a real server).
-	TriggerWebhookEventVar("REGISTER", "NO")
+	//    Stopping the queue waits until both deliveries have been attempted.
+	q := startTestQueue(t, 2)
+	TriggerWebhookEventVar(structs.WebhookEvent{Event: "REGISTER", Country: "NO"})
+	if err := q.shutdown(context.Background()); err != nil {
+		t.Fatalf("Expected the webhook queue to drain, got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
 
 	if len(requestBodies) != 1 {
 		t.Errorf("Expected exactly 1 POST to test server, got %d", len(requestBodies))
@@ -99,7 +126,9 @@ func TestTriggerWebhookEventVar_NoMatching(t *testing.T) {
 	defer srv.Close()
 
 	// No matching webhooks
-	TriggerWebhookEventVar("DELETE", "NO")
+	q := startTestQueue(t, 1)
+	TriggerWebhookEventVar(structs.WebhookEvent{Event: "DELETE", Country: "NO"})
+	_ = q.shutdown(context.Background())
 
 	if callCount != 0 {
 		t.Errorf("Expected 0 calls to test server, got %d", callCount)
@@ -113,5 +142,45 @@ func TestTriggerWebhookEventVar_GetAllFails(t *testing.T) {
 	defer revertGetAllNotifications()
 
 	// Should just log the error and return, no crash expected
-	TriggerWebhookEventVar("REGISTER", "NO")
+	TriggerWebhookEventVar(structs.WebhookEvent{Event: "REGISTER", Country: "NO"})
+}
+
+// TestTriggerWebhookEventVar_Scoped checks that a webhook scoped by tag only learns about
+// the registrations of a bulk import that carry its tags.
+func TestTriggerWebhookEventVar_Scoped(t *testing.T) {
+	var mu sync.Mutex
+	var posted []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		posted = append(posted, body)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	overrideGetAllNotifications(func(ctx context.Context) ([]structs.Notification, error) {
+		return []structs.Notification{
+			{ID: "w1", URL: srv.URL, Event: "REGISTER", Tags: []string{"ops"}},
+		}, nil
+	})
+	defer revertGetAllNotifications()
+
+	q := startTestQueue(t, 1)
+	TriggerWebhookEventVar(structs.WebhookEvent{
+		Event: "REGISTER", Country: "NO", Registrations: []string{"a", "b", "c"}, Tags: []string{"ops", "sales"},
+		RegistrationTags: map[string][]string{"a": {"ops"}, "b": {"sales"}, "c": {"ops", "sales"}},
+	})
+	if err := q.shutdown(context.Background()); err != nil {
+		t.Fatalf("Expected the webhook queue to drain, got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(posted) != 1 {
+		t.Fatalf("Expected 1 POST, got %d", len(posted))
+	}
+	ids, _ := json.Marshal(posted[0]["registrationIds"])
+	if string(ids) != `["a","c"]` {
+		t.Errorf("Expected only the registrations tagged ops, got %s", ids)
+	}
 }
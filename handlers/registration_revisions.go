@@ -0,0 +1,237 @@
+// File: assignment-2/handlers/registration_revisions.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"assignment-2/firebase"
+	"assignment-2/structs"
+	"assignment-2/tools"
+)
+
+// maxActorLength bounds the actor recorded from the X-Actor header, in characters.
+const maxActorLength = 100
+
+// requestContext returns the context for registration writes. Changes are attributed to
+// the caller named in the X-Actor header (see requestActor).
+func requestContext(r *http.Request) context.Context {
+	return firebase.WithActor(context.Background(), requestActor(r))
+}
+
+// requestActor returns the X-Actor header without control characters or invalid UTF-8,
+// trimmed and cut to maxActorLength characters. The service has no authentication, so
+// the actor is only what the caller claims to be.
+func requestActor(r *http.Request) string {
+	actor := strings.Map(func(c rune) rune {
+		if unicode.IsControl(c) {
+			return -1
+		}
+		return c
+	}, strings.ToValidUTF8(r.Header.Get("X-Actor"), ""))
+	actor = strings.TrimSpace(actor)
+	if runes := []rune(actor); len(runes) > maxActorLength {
+		actor = strings.TrimSpace(string(runes[:maxActorLength]))
+	}
+	return actor
+}
+
+// handleRegistrationSubresource routes the paths below a single registration:
+//
+//	GET  {id}/revisions            list the history, newest first
+//	GET  {id}/revisions/{n}        a single revision
+//	GET  {id}/revisions/diff       JSON Patch between ?from= and ?to=
+//	POST {id}/restore              undelete, or roll back to ?revision=
+func handleRegistrationSubresource(w http.ResponseWriter, r *http.Request, id string, rest []string) {
+	switch {
+	case len(rest) == 1 && rest[0] == "revisions":
+		if requireMethod(w, r, http.MethodGet) {
+			handleListRevisions(w, id)
+		}
+	case len(rest) == 2 && rest[0] == "revisions" && rest[1] == "diff":
+		if requireMethod(w, r, http.MethodGet) {
+			handleDiffRevisions(w, r, id)
+		}
+	case len(rest) == 2 && rest[0] == "revisions":
+		if requireMethod(w, r, http.MethodGet) {
+			handleGetRevision(w, id, rest[1])
+		}
+	case len(rest) == 1 && rest[0] == "restore":
+		if requireMethod(w, r, http.MethodPost) {
+			handleRestoreRegistration(w, r, id)
+		}
+	default:
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Unknown registration resource")
+	}
+}
+
+// requireMethod writes a 405 response and returns false unless r uses 'method'.
+func requireMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	tools.WriteJsonErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed, use "+method)
+	return false
+}
+
+func handleListRevisions(w http.ResponseWriter, id string) {
+	revisions, err := firebase.ListRegistrationRevisions(context.Background(), id)
+	if errors.Is(err, firebase.ErrRegistrationNotFound) {
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Registration not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error listing revisions of %s: %v\n", id, err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not retrieve revisions")
+		return
+	}
+	tools.WriteJsonResponse(w, http.StatusOK, revisions)
+}
+
+func handleGetRevision(w http.ResponseWriter, id, param string) {
+	revision, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || revision < 1 {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Revision must be a positive integer")
+		return
+	}
+	rev, ok := loadRevision(w, id, revision)
+	if !ok {
+		return
+	}
+	tools.WriteJsonResponse(w, http.StatusOK, rev)
+}
+
+// handleDiffRevisions answers with the RFC 6902 JSON Patch that turns revision ?from= into
+// revision ?to=. The id and revision fields, which always differ, are left out.
+func handleDiffRevisions(w http.ResponseWriter, r *http.Request, id string) {
+	from, errFrom := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+	to, errTo := strconv.ParseInt(r.URL.Query().Get("to"), 10, 64)
+	if errFrom != nil || errTo != nil || from < 1 || to < 1 {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Both 'from' and 'to' must be positive revision numbers")
+		return
+	}
+	revFrom, ok := loadRevision(w, id, from)
+	if !ok {
+		return
+	}
+	revTo, ok := loadRevision(w, id, to)
+	if !ok {
+		return
+	}
+
+	patch, err := diffRegistrations(revFrom.Registration, revTo.Registration, "lastChange")
+	if err != nil {
+		log.Printf("Error diffing revisions %d and %d of %s: %v\n", from, to, id, err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not compare revisions")
+		return
+	}
+	tools.WriteJsonResponse(w, http.StatusOK, map[string]interface{}{
+		"from":  from,
+		"to":    to,
+		"patch": patch,
+	})
+}
+
+// registrationDiffFields are the fields diffRegistrations compares.
+var registrationDiffFields = []string{"country", "isoCode", "countries", "region", "snapshot", "features", "tags"}
+
+// diffRegistrations returns the RFC 6902 JSON Patch that turns 'from' into 'to', limited
+// to registrationDiffFields and 'extra'.
+func diffRegistrations(from, to structs.Registration, extra ...string) (json.RawMessage, error) {
+	fields := append(append([]string{}, registrationDiffFields...), extra...)
+	docFrom, err := tools.SelectFields(from, fields)
+	if err != nil {
+		return nil, err
+	}
+	docTo, err := tools.SelectFields(to, fields)
+	if err != nil {
+		return nil, err
+	}
+	rawFrom, _ := json.Marshal(docFrom)
+	rawTo, _ := json.Marshal(docTo)
+	return tools.DiffJSON(rawFrom, rawTo)
+}
+
+// loadRevision fetches a revision, writing the error response and returning false on failure.
+func loadRevision(w http.ResponseWriter, id string, revision int64) (*structs.RegistrationRevision, bool) {
+	rev, err := firebase.GetRegistrationRevision(context.Background(), id, revision)
+	if errors.Is(err, firebase.ErrRevisionNotFound) {
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Revision not found")
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error fetching revision %d of %s: %v\n", revision, id, err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not retrieve revision")
+		return nil, false
+	}
+	return rev, true
+}
+
+// handleRestoreRegistration undeletes a deleted registration or, with ?revision=N, rolls
+// an existing one back to revision N. Either way the result is stored as a new revision.
+// If-Match is honoured for registrations that exist.
+func handleRestoreRegistration(w http.ResponseWriter, r *http.Request, id string) {
+	revision := firebase.LatestRevision
+	if v := r.URL.Query().Get("revision"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Revision must be a positive integer")
+			return
+		}
+		revision = n
+	}
+
+	ctx := requestContext(r)
+	existing, err := firebase.GetRegistrationByID(ctx, id)
+	if err != nil && !errors.Is(err, firebase.ErrRegistrationNotFound) {
+		log.Printf("Error fetching registration %s: %v\n", id, err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not restore registration")
+		return
+	}
+	// Only a registration that does not exist any more is undeleted
+	undelete := err != nil
+	expected := firebase.AnyRevision
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !undelete {
+		if !checkIfMatch(w, ifMatch, existing) {
+			return
+		}
+		expected = existing.Revision
+	}
+
+	restored, err := firebase.RestoreRegistration(ctx, id, revision, expected)
+	switch {
+	case errors.Is(err, firebase.ErrRegistrationNotFound):
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Registration not found")
+		return
+	case errors.Is(err, firebase.ErrRevisionNotFound):
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Revision not found")
+		return
+	case errors.Is(err, firebase.ErrRegistrationNotDeleted):
+		tools.WriteJsonErrorResponse(w, http.StatusConflict, "Registration is not deleted; use ?revision= to roll it back")
+		return
+	case errors.Is(err, firebase.ErrRevisionMismatch):
+		writePreconditionFailed(w)
+		return
+	case err != nil:
+		log.Printf("Error restoring registration %s: %v\n", id, err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not restore registration")
+		return
+	}
+
+	w.Header().Set("ETag", tools.RevisionETag(restored.Revision))
+	tools.WriteJsonResponse(w, http.StatusOK, restored)
+	dashboardStreams.registrationChanged(id)
+
+	if undelete {
+		TriggerWebhookEventVar(registrationEvent("REGISTER", *restored))
+	} else {
+		TriggerWebhookEventVar(changeEvent(*existing, *restored))
+	}
+}
@@ -0,0 +1,187 @@
+// File: assignment-2/handlers/registration_revisions_test.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"assignment-2/constants"
+	"assignment-2/firebase"
+	"assignment-2/structs"
+)
+
+// TestRegistrationRevisions walks a registration through changes, a rollback, a delete
+// and an undelete, checking the history endpoints along the way.
+func TestRegistrationRevisions(t *testing.T) {
+	overrideFirebaseStubs()
+	defer revertFirebaseStubs()
+
+	var events []string
+	origTrigger := TriggerWebhookEventVar
+	TriggerWebhookEventVar = func(ev structs.WebhookEvent) { events = append(events, ev.Event) }
+	defer func() { TriggerWebhookEventVar = origTrigger }()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, constants.REGISTRATIONS_PATH+path, strings.NewReader(body))
+		if method == http.MethodPatch {
+			req.Header.Set("Content-Type", constants.CONTENT_TYPE_MERGE_PATCH)
+		}
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		return rr
+	}
+
+	docID := createFakeRegistration(t, "Historia")
+	if rr := do(http.MethodPatch, docID, `{"features":{"capital":true}}`); rr.Code != http.StatusNoContent && rr.Code != http.StatusOK {
+		t.Fatalf("PATCH failed with %d: %s", rr.Code, rr.Body.String())
+	}
+
+	t.Run("ListRevisions", func(t *testing.T) {
+		rr := do(http.MethodGet, docID+"/revisions", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rr.Code)
+		}
+		var revs []structs.RegistrationRevision
+		_ = json.Unmarshal(rr.Body.Bytes(), &revs)
+		if len(revs) != 2 || revs[0].Operation != structs.OperationPatch || revs[1].Operation != structs.OperationCreate {
+			t.Errorf("Expected [patch, create], got %+v", revs)
+		}
+	})
+
+	t.Run("GetRevision", func(t *testing.T) {
+		rr := do(http.MethodGet, docID+"/revisions/1", "")
+		var rev structs.RegistrationRevision
+		_ = json.Unmarshal(rr.Body.Bytes(), &rev)
+		if rr.Code != http.StatusOK || rev.Registration.Features.Capital {
+			t.Errorf("Expected revision 1 without capital, got %d %+v", rr.Code, rev)
+		}
+		if rr := do(http.MethodGet, docID+"/revisions/99", ""); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for an unknown revision, got %d", rr.Code)
+		}
+		if rr := do(http.MethodGet, docID+"/revisions/abc", ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for a malformed revision, got %d", rr.Code)
+		}
+	})
+
+	t.Run("DiffRevisions", func(t *testing.T) {
+		rr := do(http.MethodGet, docID+"/revisions/diff?from=1&to=2", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var diff struct {
+			Patch []map[string]interface{} `json:"patch"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &diff)
+		found := false
+		for _, op := range diff.Patch {
+			if op["path"] == "/features/capital" && op["value"] == true {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected a replace of /features/capital, got %s", rr.Body.String())
+		}
+		if rr := do(http.MethodGet, docID+"/revisions/diff?from=1", ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 without 'to', got %d", rr.Code)
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		if rr := do(http.MethodPost, docID+"/restore", ""); rr.Code != http.StatusConflict {
+			t.Errorf("Expected 409 when undeleting an existing registration, got %d", rr.Code)
+		}
+
+		events = nil
+		rr := do(http.MethodPost, docID+"/restore?revision=1", "")
+		if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"3"` {
+			t.Fatalf("Expected 200 with ETag \"3\", got %d %q", rr.Code, rr.Header().Get("ETag"))
+		}
+		reg := stubRegStore[docID]
+		if reg.Features.Capital || reg.Revision != 3 {
+			t.Errorf("Expected revision 3 with the state of revision 1, got %+v", reg)
+		}
+		if len(events) != 1 || events[0] != "CHANGE" {
+			t.Errorf("Expected a CHANGE event, got %v", events)
+		}
+	})
+
+	t.Run("DeleteAndUndelete", func(t *testing.T) {
+		if rr := do(http.MethodDelete, docID, ""); rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected 204, got %d", rr.Code)
+		}
+		if rr := do(http.MethodGet, docID, ""); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for a deleted registration, got %d", rr.Code)
+		}
+		if rr := do(http.MethodGet, docID+"/revisions", ""); rr.Code != http.StatusOK {
+			t.Errorf("Expected the history of a deleted registration to remain, got %d", rr.Code)
+		}
+
+		events = nil
+		rr := do(http.MethodPost, docID+"/restore", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var restored structs.Registration
+		_ = json.Unmarshal(rr.Body.Bytes(), &restored)
+		if restored.Country != "Historia" || restored.Revision != 5 {
+			t.Errorf("Expected Historia at revision 5, got %+v", restored)
+		}
+		if len(events) != 1 || events[0] != "REGISTER" {
+			t.Errorf("Expected a REGISTER event, got %v", events)
+		}
+	})
+
+	t.Run("Routing", func(t *testing.T) {
+		if rr := do(http.MethodPost, docID+"/revisions", ""); rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected 405, got %d", rr.Code)
+		}
+		if rr := do(http.MethodGet, docID+"/unknown", ""); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", rr.Code)
+		}
+		if rr := do(http.MethodPost, "doc-missing/restore", ""); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for an unknown registration, got %d", rr.Code)
+		}
+	})
+
+	t.Run("LookupFailure", func(t *testing.T) {
+		// A failing lookup must not be mistaken for a deleted registration
+		origGet := firebase.GetRegistrationByID
+		firebase.GetRegistrationByID = func(ctx context.Context, docID string) (*structs.Registration, error) {
+			return nil, context.DeadlineExceeded
+		}
+		defer func() { firebase.GetRegistrationByID = origGet }()
+
+		events = nil
+		if rr := do(http.MethodPost, docID+"/restore", ""); rr.Code != http.StatusInternalServerError {
+			t.Errorf("Expected 500, got %d", rr.Code)
+		}
+		if len(events) != 0 {
+			t.Errorf("Expected no events, got %v", events)
+		}
+	})
+}
+
+// TestRequestActor checks that the X-Actor header is cleaned up before it is recorded.
+func TestRequestActor(t *testing.T) {
+	cases := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"  alice ", "alice"},
+		{"al\x1b[31mice\r\nX-Evil: 1", "al[31miceX-Evil: 1"},
+		{"bad\xffutf8", "badutf8"},
+		{strings.Repeat("å", maxActorLength+20), strings.Repeat("å", maxActorLength)},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, constants.REGISTRATIONS_PATH, nil)
+		req.Header["X-Actor"] = []string{c.header}
+		if got := requestActor(req); got != c.want {
+			t.Errorf("%q: expected %q, got %q", c.header, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,590 @@
+// File: assignment-2/handlers/registrations_bulk.go
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"assignment-2/constants"
+	"assignment-2/firebase"
+	"assignment-2/structs"
+	"assignment-2/tools"
+)
+
+// registrationCSVHeader is the column layout used by the CSV export. The import accepts
+// the same columns in any order; id, lastChange and revision are ignored on import, so an
+// export can be imported again as-is.
+var registrationCSVHeader = []string{
+	"id", "country", "isoCode", "countries", "region", "snapshot", "tags",
+	"temperature", "precipitation", "capital", "coordinates", "population", "area",
+	"populationDensity", "localTime", "distanceFrom", "targetCurrencies",
+	"temperatureUnit", "areaUnit", "precipitationMode", "lastChange", "revision",
+}
+
+// errTooManyRows is returned by the import parsers when the upload exceeds MAX_IMPORT_ROWS.
+var errTooManyRows = fmt.Errorf("an import may contain at most %d registrations", constants.MAX_IMPORT_ROWS)
+
+// importRow is one parsed row of a bulk import. Rows that could not be parsed carry 'err'.
+type importRow struct {
+	line int
+	reg  structs.Registration
+	err  error
+}
+
+// importResult reports the outcome of a single row.
+type importResult struct {
+	Row    int    `json:"row"`
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status"` // "created", "invalid" or "failed"
+	Error  string `json:"error,omitempty"`
+}
+
+// importReport is the response body of a bulk import.
+type importReport struct {
+	Total   int            `json:"total"`
+	Created int            `json:"created"`
+	Failed  int            `json:"failed"`
+	Results []importResult `json:"results"`
+}
+
+// handleImportRegistrations handles POST /registrations/import. The body is NDJSON (one
+// registration per line) or CSV with a header row. Every row is validated on its own and
+// the valid ones are written in bulk; the response lists the outcome of each row.
+func handleImportRegistrations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tools.WriteJsonErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed on registrations import")
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType = ""
+	}
+	var rows []importRow
+	switch mediaType {
+	case constants.CONTENT_TYPE_NDJSON:
+		rows, err = parseNDJSONRegistrations(r.Body)
+	case constants.CONTENT_TYPE_CSV:
+		rows, err = parseCSVRegistrations(r.Body)
+	default:
+		tools.WriteJsonErrorResponse(w, http.StatusUnsupportedMediaType,
+			fmt.Sprintf("Unsupported Content-Type, use %s or %s", constants.CONTENT_TYPE_NDJSON, constants.CONTENT_TYPE_CSV))
+		return
+	}
+	if errors.Is(err, errTooManyRows) {
+		tools.WriteJsonErrorResponse(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(rows) == 0 {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "The import contains no registrations")
+		return
+	}
+
+	report := importReport{Total: len(rows), Results: make([]importResult, len(rows))}
+	var valid []structs.Registration
+	var validIdx []int
+	now := time.Now()
+	for i, row := range rows {
+		report.Results[i].Row = row.line
+		if row.err == nil {
+			row.err = validateRegistration(row.reg)
+		}
+		if row.err != nil {
+			report.Results[i].Status = "invalid"
+			report.Results[i].Error = row.err.Error()
+			continue
+		}
+		row.reg.LastChange = now
+		valid = append(valid, row.reg)
+		validIdx = append(validIdx, i)
+	}
+
+	countries := make(map[string]*structs.WebhookEvent)
+	if len(valid) > 0 {
+		ids, errs := firebase.SaveRegistrations(requestContext(r), valid)
+		for j, i := range validIdx {
+			if errs[j] != nil {
+				log.Printf("Error importing registration (row %d): %v\n", rows[i].line, errs[j])
+				report.Results[i].Status = "failed"
+				report.Results[i].Error = "Could not save registration in the database"
+				continue
+			}
+			report.Results[i].ID = ids[j]
+			report.Results[i].Status = "created"
+			report.Created++
+			key := webhookCountry(valid[j])
+			ev, ok := countries[key]
+			if !ok {
+				ev = &structs.WebhookEvent{Event: "REGISTER", Country: key, RegistrationTags: map[string][]string{}}
+				countries[key] = ev
+			}
+			ev.Registrations = append(ev.Registrations, ids[j])
+			ev.RegistrationTags[ids[j]] = valid[j].Tags
+			for _, tag := range valid[j].Tags {
+				if !containsString(ev.Tags, tag) {
+					ev.Tags = append(ev.Tags, tag)
+				}
+			}
+		}
+	}
+	report.Failed = report.Total - report.Created
+	tools.WriteJsonResponse(w, http.StatusOK, report)
+
+	// One REGISTER event per country instead of one per row, listing the registrations.
+	// Each webhook only gets those in its scope.
+	keys := make([]string, 0, len(countries))
+	for c := range countries {
+		keys = append(keys, c)
+	}
+	sort.Strings(keys)
+	for _, c := range keys {
+		TriggerWebhookEventVar(*countries[c])
+	}
+}
+
+// webhookCountry returns the country key used to match webhooks for a registration.
+func webhookCountry(reg structs.Registration) string {
+	if reg.Country != "" {
+		return reg.Country
+	}
+	return reg.ISOCode
+}
+
+// validateRegistration checks the fields of a registration supplied by a client.
+func validateRegistration(reg structs.Registration) error {
+	if err := validateWrite(reg); err != nil {
+		return err
+	}
+	if reg.Country == "" && reg.ISOCode == "" && !reg.IsComparison() {
+		return fmt.Errorf("either country, isoCode, countries or region is required")
+	}
+	if reg.ISOCode != "" && !isLetters(reg.ISOCode, 2, 3) {
+		return fmt.Errorf("isoCode '%s' must be a 2 or 3 letter country code", reg.ISOCode)
+	}
+	for _, cur := range reg.Features.TargetCurrencies {
+		if !isLetters(cur, 3, 3) {
+			return fmt.Errorf("target currency '%s' must be a 3 letter currency code", cur)
+		}
+	}
+	return nil
+}
+
+// validateWrite checks the fields that single writes validate as well; those otherwise
+// accept any body.
+func validateWrite(reg structs.Registration) error {
+	if _, ok := structs.SnapshotPeriod(reg.Snapshot); reg.Snapshot != "" && !ok {
+		return fmt.Errorf("snapshot must be '%s' or '%s'", structs.SnapshotHourly, structs.SnapshotDaily)
+	}
+	if err := validatePresentation(reg.Features); err != nil {
+		return err
+	}
+	if err := validateTags(reg.Tags); err != nil {
+		return err
+	}
+	return validateComparison(reg)
+}
+
+// validateTags checks a list of tags. Tags are matched exactly, so they may not contain
+// spaces, and ';' separates them in CSV.
+func validateTags(tags []string) error {
+	if len(tags) > constants.MAX_TAGS {
+		return fmt.Errorf("at most %d tags are allowed", constants.MAX_TAGS)
+	}
+	for _, tag := range tags {
+		if tag == "" || len(tag) > constants.MAX_TAG_LENGTH || strings.ContainsAny(tag, " \t\r\n;") {
+			return fmt.Errorf("tag %q must be 1 to %d characters without spaces or ';'", tag, constants.MAX_TAG_LENGTH)
+		}
+	}
+	return nil
+}
+
+// validateComparison checks the fields that make a registration compare several countries.
+func validateComparison(reg structs.Registration) error {
+	if !reg.IsComparison() {
+		return nil
+	}
+	if reg.Country != "" || reg.ISOCode != "" {
+		return fmt.Errorf("country and isoCode cannot be combined with countries or region")
+	}
+	if len(reg.Countries) > constants.MAX_COMPARISON_COUNTRIES {
+		return fmt.Errorf("at most %d countries can be compared", constants.MAX_COMPARISON_COUNTRIES)
+	}
+	for _, c := range reg.Countries {
+		if strings.TrimSpace(c) == "" {
+			return fmt.Errorf("countries must not contain empty names")
+		}
+	}
+	return nil
+}
+
+// isLetters reports whether 's' consists of between min and max ASCII letters.
+func isLetters(s string, min, max int) bool {
+	if len(s) < min || len(s) > max {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseNDJSONRegistrations reads one registration per non-empty line. Lines that are not
+// valid registrations are reported per row; only read errors fail the whole import.
+func parseNDJSONRegistrations(body io.Reader) ([]importRow, error) {
+	var rows []importRow
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		if len(rows) == constants.MAX_IMPORT_ROWS {
+			return nil, errTooManyRows
+		}
+		var reg structs.Registration
+		dec := json.NewDecoder(bytes.NewReader(text))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&reg)
+		if err != nil {
+			err = fmt.Errorf("invalid JSON: %v", err)
+		}
+		rows = append(rows, importRow{line: line, reg: newImportedRegistration(reg), err: err})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read the import: %v", err)
+	}
+	return rows, nil
+}
+
+// parseCSVRegistrations reads registrations from CSV with a header row naming the columns
+// (see registrationCSVHeader). Unknown columns reject the whole file; bad values are
+// reported per row. Row numbers count lines including the header.
+func parseCSVRegistrations(body io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read the CSV header: %v", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if !containsString(registrationCSVHeader, name) {
+			return nil, fmt.Errorf("unknown CSV column '%s'", name)
+		}
+		columns[name] = i
+	}
+
+	var rows []importRow
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				rows = append(rows, importRow{line: line, err: err})
+				continue
+			}
+			return nil, fmt.Errorf("could not read the CSV: %v", err)
+		}
+		if len(rows) == constants.MAX_IMPORT_ROWS {
+			return nil, errTooManyRows
+		}
+		reg, err := registrationFromCSV(record, columns)
+		rows = append(rows, importRow{line: line, reg: reg, err: err})
+	}
+	return rows, nil
+}
+
+// registrationFromCSV builds a registration from one CSV record.
+func registrationFromCSV(record []string, columns map[string]int) (structs.Registration, error) {
+	value := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	flag := func(name string) (bool, error) {
+		v := value(name)
+		if v == "" {
+			return false, nil
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("column '%s': '%s' is not a boolean", name, v)
+		}
+		return b, nil
+	}
+
+	reg := structs.Registration{
+		Country:  value("country"),
+		ISOCode:  value("isoCode"),
+		Region:   value("region"),
+		Snapshot: value("snapshot"),
+	}
+	for _, c := range strings.Split(value("countries"), ";") {
+		if c = strings.TrimSpace(c); c != "" {
+			reg.Countries = append(reg.Countries, c)
+		}
+	}
+	for _, tag := range strings.Split(value("tags"), ";") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			reg.Tags = append(reg.Tags, tag)
+		}
+	}
+	reg.Features.TargetCurrencies = []string{}
+	flags := []struct {
+		name string
+		dst  *bool
+	}{
+		{"temperature", &reg.Features.Temperature},
+		{"precipitation", &reg.Features.Precipitation},
+		{"capital", &reg.Features.Capital},
+		{"coordinates", &reg.Features.Coordinates},
+		{"population", &reg.Features.Population},
+		{"area", &reg.Features.Area},
+		{"populationDensity", &reg.Features.PopulationDensity},
+		{"localTime", &reg.Features.LocalTime},
+	}
+	for _, f := range flags {
+		b, err := flag(f.name)
+		if err != nil {
+			return reg, err
+		}
+		*f.dst = b
+	}
+	for _, cur := range strings.Split(value("targetCurrencies"), ";") {
+		if cur = strings.TrimSpace(cur); cur != "" {
+			reg.Features.TargetCurrencies = append(reg.Features.TargetCurrencies, cur)
+		}
+	}
+	reg.Features.TemperatureUnit = value("temperatureUnit")
+	reg.Features.AreaUnit = value("areaUnit")
+	reg.Features.PrecipitationMode = value("precipitationMode")
+	if v := value("distanceFrom"); v != "" {
+		lat, lon, ok := strings.Cut(v, ";")
+		latitude, errLat := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+		longitude, errLon := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+		if !ok || errLat != nil || errLon != nil {
+			return reg, fmt.Errorf("column 'distanceFrom': '%s' is not 'latitude;longitude'", v)
+		}
+		reg.Features.DistanceFrom = &structs.ReferencePoint{Latitude: latitude, Longitude: longitude}
+	}
+	return reg, nil
+}
+
+// newImportedRegistration drops the server-managed fields of an imported registration.
+func newImportedRegistration(reg structs.Registration) structs.Registration {
+	reg.ID = ""
+	reg.Revision = 0
+	reg.LastChange = time.Time{}
+	return reg
+}
+
+// handleExportRegistrations handles GET /registrations/export. All registrations matching
+// the listing filters are streamed page by page as JSON (default), NDJSON or CSV, chosen
+// with ?format= or the Accept header.
+func handleExportRegistrations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		tools.WriteJsonErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed on registrations export")
+		return
+	}
+	format := exportFormat(r)
+	if format == "" {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Unknown export format, use json, ndjson or csv")
+		return
+	}
+	opts, err := parseListOptions(r.URL.Query(), registrationListSpec)
+	if err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts.Limit = structs.MaxPageSize
+
+	// Fetch the first page before committing to a status code
+	ctx := context.Background()
+	regs, next, err := firebase.ListRegistrations(ctx, opts)
+	if errors.Is(err, firebase.ErrInvalidCursor) {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+	if err != nil {
+		log.Printf("Error exporting registrations: %v\n", err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not export registrations")
+		return
+	}
+
+	enc := newExportEncoder(w, format)
+	w.Header().Set("Content-Type", enc.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"registrations.%s\"", format))
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	for {
+		for _, reg := range regs {
+			if err := enc.write(reg); err != nil {
+				log.Printf("Error writing registrations export: %v\n", err)
+				return
+			}
+		}
+		enc.flush()
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+		if regs, next, err = firebase.ListRegistrations(ctx, opts); err != nil {
+			// The status line is already sent, so the truncated body is all we can do
+			log.Printf("Error exporting registrations after cursor %s: %v\n", opts.Cursor, err)
+			return
+		}
+	}
+	enc.close()
+}
+
+// exportFormat returns "json", "ndjson" or "csv", or "" for an unknown ?format= value.
+func exportFormat(r *http.Request) string {
+	if f := r.URL.Query().Get("format"); f != "" {
+		switch f {
+		case "json", "ndjson", "csv":
+			return f
+		}
+		return ""
+	}
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, constants.CONTENT_TYPE_NDJSON):
+		return "ndjson"
+	case strings.Contains(accept, constants.CONTENT_TYPE_CSV):
+		return "csv"
+	}
+	return "json"
+}
+
+// exportEncoder writes registrations one at a time in the chosen format.
+type exportEncoder struct {
+	w           io.Writer
+	format      string
+	contentType string
+	csv         *csv.Writer
+	count       int
+}
+
+func newExportEncoder(w io.Writer, format string) *exportEncoder {
+	enc := &exportEncoder{w: w, format: format}
+	switch format {
+	case "ndjson":
+		enc.contentType = constants.CONTENT_TYPE_NDJSON
+	case "csv":
+		enc.contentType = constants.CONTENT_TYPE_CSV
+		enc.csv = csv.NewWriter(w)
+	default:
+		enc.contentType = constants.CONTENT_TYPE_JSON
+	}
+	return enc
+}
+
+func (e *exportEncoder) write(reg structs.Registration) error {
+	first := e.count == 0
+	e.count++
+	switch e.format {
+	case "csv":
+		if first {
+			if err := e.csv.Write(registrationCSVHeader); err != nil {
+				return err
+			}
+		}
+		return e.csv.Write(registrationToCSV(reg))
+	case "ndjson":
+		return json.NewEncoder(e.w).Encode(reg)
+	default:
+		prefix := ",\n"
+		if first {
+			prefix = "[\n"
+		}
+		raw, err := json.Marshal(reg)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(e.w, "%s%s", prefix, raw)
+		return err
+	}
+}
+
+func (e *exportEncoder) flush() {
+	if e.csv != nil {
+		e.csv.Flush()
+	}
+}
+
+// close terminates the document; empty exports still produce a valid (empty) document.
+func (e *exportEncoder) close() {
+	switch e.format {
+	case "csv":
+		if e.count == 0 {
+			_ = e.csv.Write(registrationCSVHeader)
+		}
+		e.csv.Flush()
+	case "json":
+		if e.count == 0 {
+			fmt.Fprint(e.w, "[]\n")
+		} else {
+			fmt.Fprint(e.w, "\n]\n")
+		}
+	}
+}
+
+// registrationToCSV lays out a registration according to registrationCSVHeader.
+func registrationToCSV(reg structs.Registration) []string {
+	f := reg.Features
+	lastChange := ""
+	if !reg.LastChange.IsZero() {
+		lastChange = reg.LastChange.UTC().Format(time.RFC3339)
+	}
+	distanceFrom := ""
+	if p := f.DistanceFrom; p != nil {
+		distanceFrom = strconv.FormatFloat(p.Latitude, 'f', -1, 64) + ";" + strconv.FormatFloat(p.Longitude, 'f', -1, 64)
+	}
+	return []string{
+		reg.ID, reg.Country, reg.ISOCode, strings.Join(reg.Countries, ";"), reg.Region, reg.Snapshot,
+		strings.Join(reg.Tags, ";"),
+		strconv.FormatBool(f.Temperature), strconv.FormatBool(f.Precipitation),
+		strconv.FormatBool(f.Capital), strconv.FormatBool(f.Coordinates),
+		strconv.FormatBool(f.Population), strconv.FormatBool(f.Area),
+		strconv.FormatBool(f.PopulationDensity), strconv.FormatBool(f.LocalTime), distanceFrom,
+		strings.Join(f.TargetCurrencies, ";"),
+		f.TemperatureUnit, f.AreaUnit, f.PrecipitationMode,
+		lastChange, strconv.FormatInt(reg.Revision, 10),
+	}
+}
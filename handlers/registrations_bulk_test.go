@@ -0,0 +1,197 @@
+// File: assignment-2/handlers/registrations_bulk_test.go
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"assignment-2/constants"
+	"assignment-2/structs"
+)
+
+// TestRegistrationsImport tests POST /registrations/import with NDJSON and CSV uploads.
+func TestRegistrationsImport(t *testing.T) {
+	overrideFirebaseStubs()
+	defer revertFirebaseStubs()
+
+	var events []string
+	var eventRegs [][]string
+	origTrigger := TriggerWebhookEventVar
+	TriggerWebhookEventVar = func(ev structs.WebhookEvent) {
+		events = append(events, ev.Event+":"+ev.Country)
+		eventRegs = append(eventRegs, ev.Registrations)
+	}
+	defer func() { TriggerWebhookEventVar = origTrigger }()
+
+	importBody := func(t *testing.T, contentType, body string) (int, importReport) {
+		req := httptest.NewRequest(http.MethodPost, constants.REGISTRATIONS_IMPORT_PATH, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		var report importReport
+		_ = json.Unmarshal(rr.Body.Bytes(), &report)
+		return rr.Code, report
+	}
+
+	t.Run("NDJSON", func(t *testing.T) {
+		events, eventRegs = nil, nil
+		body := `{"country":"Importland","features":{"capital":true}}
+{"country":"Importland","isoCode":"IM"}
+
+{"isoCode":"NO","features":{"targetCurrencies":["EURO"]}}
+not json
+{"country":"Otherland","bogus":true}
+{"isoCode":"SE"}
+`
+		code, report := importBody(t, constants.CONTENT_TYPE_NDJSON, body)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200 OK, got %d", code)
+		}
+		if report.Total != 6 || report.Created != 3 || report.Failed != 3 {
+			t.Fatalf("Unexpected totals: %+v", report)
+		}
+		wantStatus := []string{"created", "created", "invalid", "invalid", "invalid", "created"}
+		wantRows := []int{1, 2, 4, 5, 6, 7}
+		for i, res := range report.Results {
+			if res.Status != wantStatus[i] || res.Row != wantRows[i] {
+				t.Errorf("Result %d: expected %s on row %d, got %+v", i, wantStatus[i], wantRows[i], res)
+			}
+		}
+		if report.Results[0].ID == "" {
+			t.Error("Expected the created row to carry the new ID")
+		}
+		// Two Importland rows => a single event for that country
+		if !reflect.DeepEqual(events, []string{"REGISTER:Importland", "REGISTER:SE"}) {
+			t.Errorf("Expected one REGISTER event per country, got %v", events)
+		}
+		if want := []string{report.Results[0].ID, report.Results[1].ID}; len(eventRegs) != 2 || !reflect.DeepEqual(eventRegs[0], want) {
+			t.Errorf("Expected the event to carry the IDs %v, got %v", want, eventRegs)
+		}
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		body := "country,isoCode,capital,targetCurrencies,tags\n" +
+			"Csvland,CV,true,EUR;USD,team-a;ops\n" +
+			"Csvland,CV,maybe,,\n"
+		code, report := importBody(t, constants.CONTENT_TYPE_CSV+"; charset=utf-8", body)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200 OK, got %d", code)
+		}
+		if report.Created != 1 || report.Results[1].Status != "invalid" || report.Results[1].Row != 3 {
+			t.Fatalf("Unexpected report: %+v", report)
+		}
+		reg := stubRegStore[report.Results[0].ID]
+		if !reg.Features.Capital || !reflect.DeepEqual(reg.Features.TargetCurrencies, []string{"EUR", "USD"}) ||
+			!reflect.DeepEqual(reg.Tags, []string{"team-a", "ops"}) {
+			t.Errorf("Unexpected stored registration: %+v", reg)
+		}
+	})
+
+	t.Run("CSV_UnknownColumn", func(t *testing.T) {
+		code, _ := importBody(t, constants.CONTENT_TYPE_CSV, "country,weather\nNorway,true\n")
+		if code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an unknown column, got %d", code)
+		}
+	})
+
+	t.Run("TooManyRows", func(t *testing.T) {
+		body := strings.Repeat(`{"isoCode":"NO"}`+"\n", constants.MAX_IMPORT_ROWS+1)
+		code, _ := importBody(t, constants.CONTENT_TYPE_NDJSON, body)
+		if code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected 413, got %d", code)
+		}
+	})
+
+	t.Run("UnsupportedContentType", func(t *testing.T) {
+		code, _ := importBody(t, constants.CONTENT_TYPE_JSON, `[{"isoCode":"NO"}]`)
+		if code != http.StatusUnsupportedMediaType {
+			t.Errorf("Expected 415, got %d", code)
+		}
+	})
+}
+
+// TestRegistrationsExport tests GET /registrations/export in every format.
+func TestRegistrationsExport(t *testing.T) {
+	overrideFirebaseStubs()
+	defer revertFirebaseStubs()
+
+	for i := 0; i < 3; i++ {
+		createFakeRegistration(t, "Exportland")
+	}
+
+	export := func(t *testing.T, query, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, constants.REGISTRATIONS_EXPORT_PATH+query, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200 OK, got %d: %s", rr.Code, rr.Body.String())
+		}
+		return rr
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		rr := export(t, "?country=Exportland", "")
+		var regs []structs.Registration
+		if err := json.Unmarshal(rr.Body.Bytes(), &regs); err != nil {
+			t.Fatalf("Export is not a JSON array: %v", err)
+		}
+		if len(regs) != 3 {
+			t.Errorf("Expected 3 registrations, got %d", len(regs))
+		}
+	})
+
+	t.Run("JSON_Empty", func(t *testing.T) {
+		rr := export(t, "?country=Nowhere", "")
+		if strings.TrimSpace(rr.Body.String()) != "[]" {
+			t.Errorf("Expected an empty array, got %q", rr.Body.String())
+		}
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		rr := export(t, "?country=Exportland", constants.CONTENT_TYPE_NDJSON)
+		if rr.Header().Get("Content-Type") != constants.CONTENT_TYPE_NDJSON {
+			t.Errorf("Unexpected Content-Type %q", rr.Header().Get("Content-Type"))
+		}
+		lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+		if len(lines) != 3 {
+			t.Errorf("Expected 3 lines, got %d", len(lines))
+		}
+	})
+
+	t.Run("CSV_RoundTrip", func(t *testing.T) {
+		rr := export(t, "?country=Exportland&format=csv", "")
+		records, err := csv.NewReader(strings.NewReader(rr.Body.String())).ReadAll()
+		if err != nil {
+			t.Fatalf("Export is not valid CSV: %v", err)
+		}
+		if len(records) != 4 || !reflect.DeepEqual(records[0], registrationCSVHeader) {
+			t.Fatalf("Expected a header and 3 rows, got %v", records)
+		}
+
+		// The export can be imported again unchanged
+		rows, err := parseCSVRegistrations(strings.NewReader(rr.Body.String()))
+		if err != nil || len(rows) != 3 || rows[0].err != nil {
+			t.Fatalf("Could not re-import the export: %v %+v", err, rows)
+		}
+		if rows[0].reg.Country != "Exportland" || !rows[0].reg.Features.Temperature || rows[0].reg.ID != "" {
+			t.Errorf("Unexpected re-imported registration: %+v", rows[0].reg)
+		}
+	})
+
+	t.Run("UnknownFormat", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, constants.REGISTRATIONS_EXPORT_PATH+"?format=xml", nil)
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", rr.Code)
+		}
+	})
+}
@@ -2,10 +2,18 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 
 	"assignment-2/constants"
@@ -21,15 +29,28 @@ func RegistrationRouter(w http.ResponseWriter, r *http.Request) {
 		handleRegistrationsCollection(w, r)
 		return
 	}
-	// Otherwise, we assume there's an ID
+	// Bulk endpoints share the prefix with single registrations
+	switch r.URL.Path {
+	case constants.REGISTRATIONS_IMPORT_PATH:
+		handleImportRegistrations(w, r)
+		return
+	case constants.REGISTRATIONS_EXPORT_PATH:
+		handleExportRegistrations(w, r)
+		return
+	}
+	// Otherwise, we assume there's an ID, possibly followed by a sub-resource
 	id := r.URL.Path[len(constants.REGISTRATIONS_PATH):]
+	if parts := strings.Split(id, "/"); len(parts) > 1 {
+		handleRegistrationSubresource(w, r, parts[0], parts[1:])
+		return
+	}
 	handleRegistrationWithID(w, r, id)
 }
 
 func handleRegistrationsCollection(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
-		handlePostRegistration(w, r)
+		withIdempotency(w, r, "registrations", handlePostRegistration)
 	case http.MethodGet:
 		handleGetAllRegistrations(w, r)
 	case http.MethodHead:
@@ -64,9 +85,13 @@ func handlePostRegistration(w http.ResponseWriter, r *http.Request) {
 		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Invalid JSON request body")
 		return
 	}
+	if err := validateWrite(req); err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	req.LastChange = time.Now()
 
-	ctx := context.Background()
+	ctx := requestContext(r)
 	newID, err := firebase.SaveRegistration(ctx, req)
 	if err != nil {
 		log.Printf("Error saving registration: %v\n", err)
@@ -78,29 +103,115 @@ func handlePostRegistration(w http.ResponseWriter, r *http.Request) {
 		"id":         newID,
 		"lastChange": req.LastChange.Format("20060102 15:04"),
 	}
+	w.Header().Set("ETag", tools.RevisionETag(1))
 	tools.WriteJsonResponse(w, http.StatusCreated, resp)
 
-	countryFilter := req.Country
-	if countryFilter == "" {
-		countryFilter = req.ISOCode
-	}
-	TriggerWebhookEventVar("REGISTER", countryFilter)
+	req.ID = newID
+	TriggerWebhookEventVar(registrationEvent("REGISTER", req))
+}
+
+// registrationListSpec lists the query parameters accepted by GET /registrations/
+var registrationListSpec = listSpec{
+	filters:    map[string]string{"country": "country", "isoCode": "isoCode"},
+	contains:   map[string]string{"tag": "tags"},
+	sortable:   []string{"country", "isoCode", "lastChange"},
+	rangeField: "lastChange",
 }
 
-// handleGetAllRegistrations
+// registrationJSONFields are the top-level fields that can be selected with ?fields=
+var registrationJSONFields = []string{"id", "country", "isoCode", "countries", "region", "snapshot", "features", "tags", "lastChange", "revision"}
+
+// handleGetAllRegistrations returns one page of registrations. The next page, if any,
+// is announced in a Link header. ?fields= selects a sparse fieldset, and ?expand=dashboard
+// inlines the computed dashboard of every returned registration. The page can also be
+// returned as CSV, XML or HTML (Accept or ?format=), without fields or expansions.
 func handleGetAllRegistrations(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r.URL.Query(), registrationListSpec)
+	if err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	fields, err := parseFields(r.URL.Query(), registrationJSONFields)
+	if err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	expand, err := parseExpand(r.URL.Query())
+	if err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	format := negotiateRenderFormat(w, r)
+	if format == "" {
+		return
+	}
+	if format != "json" && (fields != nil || expand) {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "fields and expand are only supported with JSON")
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+
 	ctx := context.Background()
-	regs, err := firebase.GetAllRegistrations(ctx)
+	regs, next, err := firebase.ListRegistrations(ctx, opts)
+	if errors.Is(err, firebase.ErrInvalidCursor) {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
 	if err != nil {
 		log.Printf("Error fetching registrations: %v\n", err)
 		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not retrieve registrations")
 		return
 	}
-	tools.WriteJsonResponse(w, http.StatusOK, regs)
+	setNextLink(w, r, next)
+
+	if fields == nil && !expand {
+		renderRegistrations(w, format, regs)
+		return
+	}
+	var dashboards []structs.Dashboard
+	if expand {
+		dashboards = buildDashboards(regs)
+	}
+	items := make([]map[string]interface{}, len(regs))
+	for i, reg := range regs {
+		item, err := tools.SelectFields(reg, fields)
+		if err != nil {
+			log.Printf("Error projecting registration %s: %v\n", reg.ID, err)
+			tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not retrieve registrations")
+			return
+		}
+		if expand {
+			item["dashboard"] = dashboards[i]
+		}
+		items[i] = item
+	}
+	tools.WriteJsonResponse(w, http.StatusOK, items)
+}
+
+// parseExpand reports whether ?expand=dashboard was requested; other values are rejected.
+func parseExpand(q url.Values) (bool, error) {
+	switch q.Get("expand") {
+	case "":
+		return false, nil
+	case "dashboard":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown expansion '%s', only 'dashboard' is supported", q.Get("expand"))
+	}
 }
 
 // handleGetRegistrationByID ... GET /.../registrations/{id}
 func handleGetRegistrationByID(w http.ResponseWriter, r *http.Request, id string) {
+	fields, err := parseFields(r.URL.Query(), registrationJSONFields)
+	if err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if r.URL.Query().Get("expand") != "" {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "expand is only supported on the registrations listing, use the dashboards endpoint instead")
+		return
+	}
+
 	ctx := context.Background()
 	reg, err := firebase.GetRegistrationByID(ctx, id)
 	if err != nil {
@@ -108,7 +219,38 @@ func handleGetRegistrationByID(w http.ResponseWriter, r *http.Request, id string
 		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Registration not found")
 		return
 	}
-	tools.WriteJsonResponse(w, http.StatusOK, reg)
+	etag := tools.RevisionETag(reg.Revision)
+	if fields != nil {
+		// A projection is a different representation, so it gets its own tag
+		selected := append([]string(nil), fields...)
+		sort.Strings(selected)
+		if etag, err = tools.ContentETag([]interface{}{reg.Revision, selected}); err != nil {
+			log.Printf("Warning: could not compute registration ETag: %v\n", err)
+		}
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !reg.LastChange.IsZero() {
+		w.Header().Set("Last-Modified", reg.LastChange.UTC().Format(http.TimeFormat))
+	}
+	// Registrations change only through this API, so clients may cache them but must revalidate
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if tools.NotModified(r, etag, reg.LastChange) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if fields == nil {
+		tools.WriteJsonResponse(w, http.StatusOK, reg)
+		return
+	}
+	item, err := tools.SelectFields(reg, fields)
+	if err != nil {
+		log.Printf("Error projecting registration %s: %v\n", id, err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not retrieve registration")
+		return
+	}
+	tools.WriteJsonResponse(w, http.StatusOK, item)
 }
 
 // handlePutRegistration
@@ -119,58 +261,167 @@ func handlePutRegistration(w http.ResponseWriter, r *http.Request, id string) {
 		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Invalid JSON request body")
 		return
 	}
+	if err := validateWrite(req); err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	req.LastChange = time.Now()
 
-	ctx := context.Background()
-	err := firebase.UpdateRegistration(ctx, id, req)
+	// The stored registration is also what the CHANGE event reports the changes against
+	ctx := requestContext(r)
+	existing, err := firebase.GetRegistrationByID(ctx, id)
+	if err != nil {
+		log.Printf("Error fetching registration %s for update: %v\n", id, err)
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Could not update registration")
+		return
+	}
+	expected := firebase.AnyRevision
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !checkIfMatch(w, ifMatch, existing) {
+			return
+		}
+		expected = existing.Revision
+	}
+
+	newRevision, err := firebase.UpdateRegistration(ctx, id, req, expected)
+	if errors.Is(err, firebase.ErrRevisionMismatch) {
+		writePreconditionFailed(w)
+		return
+	}
 	if err != nil {
 		log.Printf("Error updating registration %s: %v\n", id, err)
 		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Could not update registration")
 		return
 	}
+	w.Header().Set("ETag", tools.RevisionETag(newRevision))
 	w.WriteHeader(http.StatusNoContent)
+	dashboardStreams.registrationChanged(id)
 
-	countryFilter := req.Country
-	if countryFilter == "" {
-		countryFilter = req.ISOCode
-	}
-	TriggerWebhookEventVar("CHANGE", countryFilter)
+	req.ID = id
+	TriggerWebhookEventVar(changeEvent(*existing, req))
 }
 
 // handlePatchRegistration ... partial update
+// The body is interpreted according to its Content-Type: application/json-patch+json
+// is an RFC 6902 JSON Patch, while application/merge-patch+json (and plain
+// application/json) is an RFC 7396 JSON Merge Patch. Members absent from the patch
+// keep their stored values.
 func handlePatchRegistration(w http.ResponseWriter, r *http.Request, id string) {
-	var partial structs.Registration
-	if err := json.NewDecoder(r.Body).Decode(&partial); err != nil {
-		log.Printf("Error decoding PATCH body: %v\n", err)
-		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Invalid JSON request body")
-		return
-	}
-	ctx := context.Background()
-	err := firebase.PatchRegistration(ctx, id, partial)
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Error patching registration %s: %v\n", id, err)
-		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Could not patch registration")
+		log.Printf("Error reading PATCH body: %v\n", err)
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Could not read request body")
 		return
 	}
+
+	// The patch is applied to the revision we read and written back only if that revision
+	// is still current. Without If-Match a lost race is retried against the fresh document;
+	// with If-Match the client asked for a specific revision, so a race means 412.
+	ctx := requestContext(r)
+	ifMatch := r.Header.Get("If-Match")
+	var previous, patched structs.Registration
+	var newRevision int64
+	for attempt := 1; ; attempt++ {
+		existing, err := firebase.GetRegistrationByID(ctx, id)
+		if err != nil {
+			log.Printf("Error fetching registration %s for patch: %v\n", id, err)
+			tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Could not patch registration")
+			return
+		}
+		if ifMatch != "" && !checkIfMatch(w, ifMatch, existing) {
+			return
+		}
+
+		var status int
+		previous = *existing
+		patched, status, err = applyRegistrationPatch(*existing, r.Header.Get("Content-Type"), body)
+		if err != nil {
+			log.Printf("Error applying PATCH to registration %s: %v\n", id, err)
+			tools.WriteJsonErrorResponse(w, status, err.Error())
+			return
+		}
+
+		newRevision, err = firebase.PatchRegistration(ctx, id, patched, existing.Revision)
+		if errors.Is(err, firebase.ErrRevisionMismatch) {
+			if ifMatch == "" && attempt < constants.MAX_PATCH_ATTEMPTS {
+				continue
+			}
+			writePreconditionFailed(w)
+			return
+		}
+		if err != nil {
+			log.Printf("Error patching registration %s: %v\n", id, err)
+			tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Could not patch registration")
+			return
+		}
+		break
+	}
+	w.Header().Set("ETag", tools.RevisionETag(newRevision))
 	w.WriteHeader(http.StatusNoContent)
+	dashboardStreams.registrationChanged(id)
+
+	TriggerWebhookEventVar(changeEvent(previous, patched))
+}
+
+// applyRegistrationPatch applies a patch document to 'existing' and returns the resulting
+// registration. On failure it also returns the HTTP status code that best describes the error.
+func applyRegistrationPatch(existing structs.Registration, contentType string, patch []byte) (structs.Registration, int, error) {
+	mediaType := constants.CONTENT_TYPE_JSON
+	if contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return structs.Registration{}, http.StatusUnsupportedMediaType, fmt.Errorf("invalid Content-Type: %v", err)
+		}
+		mediaType = parsed
+	}
 
-	// Trigger "CHANGE"
-	countryFilter := partial.Country
-	if countryFilter == "" {
-		// If partial.Country was not set, read from updated doc
-		updatedReg, _ := firebase.GetRegistrationByID(ctx, id)
-		if updatedReg != nil && updatedReg.Country != "" {
-			countryFilter = updatedReg.Country
-		} else if updatedReg != nil {
-			countryFilter = updatedReg.ISOCode
+	original, err := json.Marshal(existing)
+	if err != nil {
+		return structs.Registration{}, http.StatusInternalServerError, fmt.Errorf("could not encode stored registration: %v", err)
+	}
+
+	var patchedDoc []byte
+	switch mediaType {
+	case constants.CONTENT_TYPE_JSON, constants.CONTENT_TYPE_MERGE_PATCH:
+		patchedDoc, err = tools.MergePatch(original, patch)
+	case constants.CONTENT_TYPE_JSON_PATCH:
+		patchedDoc, err = tools.ApplyJSONPatch(original, patch)
+	default:
+		return structs.Registration{}, http.StatusUnsupportedMediaType,
+			fmt.Errorf("unsupported Content-Type '%s' (use %s or %s)", mediaType,
+				constants.CONTENT_TYPE_MERGE_PATCH, constants.CONTENT_TYPE_JSON_PATCH)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, tools.ErrMalformedPatch):
+			return structs.Registration{}, http.StatusBadRequest, err
+		case errors.Is(err, tools.ErrPatchTestFailed):
+			return structs.Registration{}, http.StatusConflict, err
+		default:
+			return structs.Registration{}, http.StatusUnprocessableEntity, err
 		}
 	}
-	TriggerWebhookEventVar("CHANGE", countryFilter)
+
+	// Decode strictly so that misspelled or unknown fields are rejected instead of ignored
+	dec := json.NewDecoder(bytes.NewReader(patchedDoc))
+	dec.DisallowUnknownFields()
+	var patched structs.Registration
+	if err := dec.Decode(&patched); err != nil {
+		return structs.Registration{}, http.StatusUnprocessableEntity, fmt.Errorf("patched registration is invalid: %v", err)
+	}
+	if patched.ID != existing.ID {
+		return structs.Registration{}, http.StatusUnprocessableEntity, fmt.Errorf("field 'id' is read-only")
+	}
+	if err := validateWrite(patched); err != nil {
+		return structs.Registration{}, http.StatusUnprocessableEntity, err
+	}
+	patched.LastChange = time.Now()
+	return patched, http.StatusOK, nil
 }
 
 // handleDeleteRegistration
 func handleDeleteRegistration(w http.ResponseWriter, r *http.Request, id string) {
-	ctx := context.Background()
+	ctx := requestContext(r)
 	existing, err := firebase.GetRegistrationByID(ctx, id)
 	if err != nil {
 		log.Printf("Error fetching registration for delete: %v\n", err)
@@ -178,17 +429,42 @@ func handleDeleteRegistration(w http.ResponseWriter, r *http.Request, id string)
 		return
 	}
 
-	err = firebase.DeleteRegistration(ctx, id)
+	expected := firebase.AnyRevision
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !checkIfMatch(w, ifMatch, existing) {
+			return
+		}
+		expected = existing.Revision
+	}
+
+	err = firebase.DeleteRegistration(ctx, id, expected)
+	if errors.Is(err, firebase.ErrRevisionMismatch) {
+		writePreconditionFailed(w)
+		return
+	}
 	if err != nil {
 		log.Printf("Error deleting registration %s: %v\n", id, err)
 		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Could not delete registration")
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
+	dashboardStreams.registrationDeleted(id)
+
+	TriggerWebhookEventVar(registrationEvent("DELETE", *existing))
+}
 
-	countryFilter := existing.Country
-	if countryFilter == "" {
-		countryFilter = existing.ISOCode
+// checkIfMatch compares the If-Match header against the registration's current ETag.
+// It writes a 412 response and returns false when the precondition fails.
+func checkIfMatch(w http.ResponseWriter, ifMatch string, reg *structs.Registration) bool {
+	if tools.IfMatchSatisfied(ifMatch, tools.RevisionETag(reg.Revision)) {
+		return true
 	}
-	TriggerWebhookEventVar("DELETE", countryFilter)
+	writePreconditionFailed(w)
+	return false
+}
+
+// writePreconditionFailed reports that the registration changed since the client last read it.
+func writePreconditionFailed(w http.ResponseWriter) {
+	tools.WriteJsonErrorResponse(w, http.StatusPreconditionFailed,
+		"Registration was modified by another request; fetch it again and retry with the new ETag")
 }
@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -24,16 +26,36 @@ var (
 	stubRegMutex sync.Mutex
 	stubRegStore = make(map[string]structs.Registration)
 	idCounter    int
+
+	// History and soft-deleted registrations, guarded by stubRegMutex as well
+	stubRevisions = make(map[string][]structs.RegistrationRevision)
+	stubTrash     = make(map[string]structs.Registration)
 )
 
+// recordStubRevision appends a history entry; the caller holds stubRegMutex.
+func recordStubRevision(docID, operation string, reg structs.Registration) {
+	reg.ID = docID
+	stubRevisions[docID] = append(stubRevisions[docID], structs.RegistrationRevision{
+		Revision:     reg.Revision,
+		Operation:    operation,
+		Time:         time.Now(),
+		Registration: reg,
+	})
+}
+
 // Keep a backup of the original firebase.*Registration function variables.
 var (
 	originalSaveRegistration    = firebase.SaveRegistration
+	originalSaveRegistrations   = firebase.SaveRegistrations
 	originalGetAllRegistrations = firebase.GetAllRegistrations
+	originalListRegistrations   = firebase.ListRegistrations
 	originalGetRegistrationByID = firebase.GetRegistrationByID
 	originalUpdateRegistration  = firebase.UpdateRegistration
 	originalDeleteRegistration  = firebase.DeleteRegistration
 	originalPatchRegistration   = firebase.PatchRegistration
+	originalListRevisions       = firebase.ListRegistrationRevisions
+	originalGetRevision         = firebase.GetRegistrationRevision
+	originalRestoreRegistration = firebase.RestoreRegistration
 )
 
 // overrideFirebaseStubs redirects the firebase.*Registration variables to in-memory stub implementations.
@@ -42,12 +64,23 @@ func overrideFirebaseStubs() {
 		stubRegMutex.Lock()
 		defer stubRegMutex.Unlock()
 		idCounter++
-		docID := "doc-" + string(rune(idCounter))
+		docID := "doc-" + strconv.Itoa(idCounter)
 		reg.ID = docID
+		reg.Revision = 1
 		stubRegStore[docID] = reg
+		recordStubRevision(docID, structs.OperationCreate, reg)
 		return docID, nil
 	}
 
+	firebase.SaveRegistrations = func(ctx context.Context, regs []structs.Registration) ([]string, []error) {
+		ids := make([]string, len(regs))
+		errs := make([]error, len(regs))
+		for i, reg := range regs {
+			ids[i], errs[i] = firebase.SaveRegistration(ctx, reg)
+		}
+		return ids, errs
+	}
+
 	firebase.GetAllRegistrations = func(ctx context.Context) ([]structs.Registration, error) {
 		stubRegMutex.Lock()
 		defer stubRegMutex.Unlock()
@@ -58,84 +91,181 @@ func overrideFirebaseStubs() {
 		return all, nil
 	}
 
+	// ListRegistrations applies the equality filters and pages through the IDs in order
+	firebase.ListRegistrations = func(ctx context.Context, opts structs.ListOptions) ([]structs.Registration, string, error) {
+		stubRegMutex.Lock()
+		defer stubRegMutex.Unlock()
+		if opts.Cursor != "" {
+			if _, ok := stubRegStore[opts.Cursor]; !ok {
+				return nil, "", firebase.ErrInvalidCursor
+			}
+		}
+		var ids []string
+		for id, r := range stubRegStore {
+			if c, ok := opts.Equals["country"]; ok && r.Country != c {
+				continue
+			}
+			if iso, ok := opts.Equals["isoCode"]; ok && r.ISOCode != iso {
+				continue
+			}
+			if tag, ok := opts.Contains["tags"]; ok && !containsString(r.Tags, tag) {
+				continue
+			}
+			if opts.Cursor != "" && id <= opts.Cursor {
+				continue
+			}
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		next := ""
+		if len(ids) > opts.PageSize() {
+			ids = ids[:opts.PageSize()]
+			next = ids[len(ids)-1]
+		}
+		page := make([]structs.Registration, 0, len(ids))
+		for _, id := range ids {
+			page = append(page, stubRegStore[id])
+		}
+		return page, next, nil
+	}
+
 	firebase.GetRegistrationByID = func(ctx context.Context, docID string) (*structs.Registration, error) {
 		stubRegMutex.Lock()
 		defer stubRegMutex.Unlock()
 		reg, ok := stubRegStore[docID]
 		if !ok {
-			return nil, os.ErrNotExist
+			return nil, firebase.ErrRegistrationNotFound
 		}
 		return &reg, nil
 	}
 
-	firebase.UpdateRegistration = func(ctx context.Context, docID string, reg structs.Registration) error {
+	firebase.UpdateRegistration = func(ctx context.Context, docID string, reg structs.Registration, expectedRevision int64) (int64, error) {
 		stubRegMutex.Lock()
 		defer stubRegMutex.Unlock()
-		_, exists := stubRegStore[docID]
+		existing, exists := stubRegStore[docID]
 		if !exists {
-			return os.ErrNotExist
+			return 0, os.ErrNotExist
+		}
+		if expectedRevision != firebase.AnyRevision && existing.Revision != expectedRevision {
+			return 0, firebase.ErrRevisionMismatch
 		}
 		reg.ID = docID
+		reg.Revision = existing.Revision + 1
 		stubRegStore[docID] = reg
-		return nil
+		recordStubRevision(docID, structs.OperationUpdate, reg)
+		return reg.Revision, nil
 	}
 
-	firebase.DeleteRegistration = func(ctx context.Context, docID string) error {
+	firebase.DeleteRegistration = func(ctx context.Context, docID string, expectedRevision int64) error {
 		stubRegMutex.Lock()
 		defer stubRegMutex.Unlock()
-		_, exists := stubRegStore[docID]
+		existing, exists := stubRegStore[docID]
 		if !exists {
 			return os.ErrNotExist
 		}
+		if expectedRevision != firebase.AnyRevision && existing.Revision != expectedRevision {
+			return firebase.ErrRevisionMismatch
+		}
 		delete(stubRegStore, docID)
+		existing.Revision++
+		stubTrash[docID] = existing
+		recordStubRevision(docID, structs.OperationDelete, existing)
 		return nil
 	}
 
-	firebase.PatchRegistration = func(ctx context.Context, docID string, partial structs.Registration) error {
+	firebase.PatchRegistration = func(ctx context.Context, docID string, patched structs.Registration, expectedRevision int64) (int64, error) {
 		stubRegMutex.Lock()
 		defer stubRegMutex.Unlock()
 		existing, ok := stubRegStore[docID]
 		if !ok {
-			return os.ErrNotExist
+			return 0, os.ErrNotExist
+		}
+		if expectedRevision != firebase.AnyRevision && existing.Revision != expectedRevision {
+			return 0, firebase.ErrRevisionMismatch
 		}
-		// Minimal patch-like logic:
-		if partial.Country != "" {
-			existing.Country = partial.Country
+		// The handler has already merged the patch, so store the result as-is
+		patched.ID = docID
+		patched.Revision = existing.Revision + 1
+		stubRegStore[docID] = patched
+		recordStubRevision(docID, structs.OperationPatch, patched)
+		return patched.Revision, nil
+	}
+
+	firebase.ListRegistrationRevisions = func(ctx context.Context, docID string) ([]structs.RegistrationRevision, error) {
+		stubRegMutex.Lock()
+		defer stubRegMutex.Unlock()
+		history, ok := stubRevisions[docID]
+		if !ok {
+			return nil, firebase.ErrRegistrationNotFound
 		}
-		if partial.ISOCode != "" {
-			existing.ISOCode = partial.ISOCode
+		newestFirst := make([]structs.RegistrationRevision, len(history))
+		for i, rev := range history {
+			newestFirst[len(history)-1-i] = rev
 		}
+		return newestFirst, nil
+	}
 
-		if !isZeroFeatures(partial.Features) {
-			existing.Features = partial.Features
+	firebase.GetRegistrationRevision = func(ctx context.Context, docID string, revision int64) (*structs.RegistrationRevision, error) {
+		stubRegMutex.Lock()
+		defer stubRegMutex.Unlock()
+		for _, rev := range stubRevisions[docID] {
+			if rev.Revision == revision {
+				return &rev, nil
+			}
 		}
-		existing.LastChange = time.Now()
-		existing.ID = docID
-		stubRegStore[docID] = existing
-		return nil
+		return nil, firebase.ErrRevisionNotFound
+	}
+
+	firebase.RestoreRegistration = func(ctx context.Context, docID string, revision int64, expectedRevision int64) (*structs.Registration, error) {
+		stubRegMutex.Lock()
+		defer stubRegMutex.Unlock()
+		current, active := stubRegStore[docID]
+		if !active {
+			var deleted bool
+			if current, deleted = stubTrash[docID]; !deleted {
+				return nil, firebase.ErrRegistrationNotFound
+			}
+		} else if revision == firebase.LatestRevision {
+			return nil, firebase.ErrRegistrationNotDeleted
+		}
+		if expectedRevision != firebase.AnyRevision && current.Revision != expectedRevision {
+			return nil, firebase.ErrRevisionMismatch
+		}
+		state := current
+		if revision != firebase.LatestRevision {
+			found := false
+			for _, rev := range stubRevisions[docID] {
+				if rev.Revision == revision {
+					state, found = rev.Registration, true
+				}
+			}
+			if !found {
+				return nil, firebase.ErrRevisionNotFound
+			}
+		}
+		state.ID = docID
+		state.Revision = current.Revision + 1
+		state.LastChange = time.Now()
+		stubRegStore[docID] = state
+		delete(stubTrash, docID)
+		recordStubRevision(docID, structs.OperationRestore, state)
+		return &state, nil
 	}
 }
 
 // revertFirebaseStubs restores the original references
 func revertFirebaseStubs() {
 	firebase.SaveRegistration = originalSaveRegistration
+	firebase.SaveRegistrations = originalSaveRegistrations
 	firebase.GetAllRegistrations = originalGetAllRegistrations
+	firebase.ListRegistrations = originalListRegistrations
 	firebase.GetRegistrationByID = originalGetRegistrationByID
 	firebase.UpdateRegistration = originalUpdateRegistration
 	firebase.DeleteRegistration = originalDeleteRegistration
 	firebase.PatchRegistration = originalPatchRegistration
-}
-
-// isZeroFeatures checks if a Features struct is entirely "zero".
-
-func isZeroFeatures(f structs.Features) bool {
-	return !f.Temperature &&
-		!f.Precipitation &&
-		!f.Capital &&
-		!f.Coordinates &&
-		!f.Population &&
-		!f.Area &&
-		len(f.TargetCurrencies) == 0
+	firebase.ListRegistrationRevisions = originalListRevisions
+	firebase.GetRegistrationRevision = originalGetRevision
+	firebase.RestoreRegistration = originalRestoreRegistration
 }
 
 // TestRegistrationsHandler runs subtests for POST, GET, PUT, PATCH, DELETE
@@ -189,6 +319,38 @@ func TestRegistrationsHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("PostRegistration_Comparison", func(t *testing.T) {
+		post := func(body string) int {
+			req := httptest.NewRequest(http.MethodPost, constants.REGISTRATIONS_PATH, strings.NewReader(body))
+			rr := httptest.NewRecorder()
+			RegistrationRouter(rr, req)
+			return rr.Code
+		}
+		if code := post(`{"countries":["Norway","Sweden"],"region":"Northern Europe","features":{"population":true}}`); code != http.StatusCreated {
+			t.Errorf("Expected 201 for a comparison registration, got %d", code)
+		}
+		if code := post(`{"country":"Norway","countries":["Sweden"]}`); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 when mixing country and countries, got %d", code)
+		}
+		if code := post(`{"countries":["Norway",""]}`); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an empty country name, got %d", code)
+		}
+	})
+
+	t.Run("PostRegistration_SnapshotSchedule", func(t *testing.T) {
+		for body, want := range map[string]int{
+			`{"country":"Norway","snapshot":"daily"}`:  http.StatusCreated,
+			`{"country":"Norway","snapshot":"weekly"}`: http.StatusBadRequest,
+		} {
+			req := httptest.NewRequest(http.MethodPost, constants.REGISTRATIONS_PATH, strings.NewReader(body))
+			rr := httptest.NewRecorder()
+			RegistrationRouter(rr, req)
+			if rr.Code != want {
+				t.Errorf("%s: expected %d, got %d", body, want, rr.Code)
+			}
+		}
+	})
+
 	t.Run("GetAllRegistrations", func(t *testing.T) {
 		// Create a doc
 		docID := createFakeRegistration(t, "AllRegTest")
@@ -207,6 +369,139 @@ func TestRegistrationsHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("GetAllRegistrations_Paginated", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			createFakeRegistration(t, "Pagedland")
+		}
+
+		req := httptest.NewRequest(http.MethodGet, constants.REGISTRATIONS_PATH+"?country=Pagedland&limit=2", nil)
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200 OK, got %d", rr.Code)
+		}
+		var page []structs.Registration
+		_ = json.Unmarshal(rr.Body.Bytes(), &page)
+		if len(page) != 2 {
+			t.Fatalf("Expected 2 registrations on the first page, got %d", len(page))
+		}
+		link := rr.Header().Get("Link")
+		if !strings.Contains(link, `rel="next"`) || !strings.Contains(link, "country=Pagedland") {
+			t.Fatalf("Expected a next Link keeping the filter, got %q", link)
+		}
+
+		// Follow the link to the last page
+		next := strings.TrimSuffix(strings.TrimPrefix(strings.SplitN(link, ";", 2)[0], "<"), ">")
+		req = httptest.NewRequest(http.MethodGet, next, nil)
+		rr = httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		page = nil
+		_ = json.Unmarshal(rr.Body.Bytes(), &page)
+		if len(page) != 1 || page[0].Country != "Pagedland" {
+			t.Errorf("Expected the remaining Pagedland registration, got %+v", page)
+		}
+		if rr.Header().Get("Link") != "" {
+			t.Errorf("Expected no Link header on the last page, got %q", rr.Header().Get("Link"))
+		}
+	})
+
+	t.Run("GetAllRegistrations_BadQuery", func(t *testing.T) {
+		for _, query := range []string{"?limit=abc", "?sort=features", "?lastChangeFrom=yesterday", "?lastChangeFrom=2025-01-01T00:00:00Z&sort=country", "?cursor=doc-unknown"} {
+			req := httptest.NewRequest(http.MethodGet, constants.REGISTRATIONS_PATH+query, nil)
+			rr := httptest.NewRecorder()
+			RegistrationRouter(rr, req)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400 Bad Request, got %d", query, rr.Code)
+			}
+		}
+	})
+
+	t.Run("GetAllRegistrations_FieldsAndExpand", func(t *testing.T) {
+		createFakeRegistration(t, "Expandia")
+
+		// Stub the external services for the dashboards, keeping the registration stubs
+		overrideStubs()
+		overrideFirebaseStubs()
+		defer func() {
+			revertStubs()
+			overrideFirebaseStubs()
+		}()
+
+		req := httptest.NewRequest(http.MethodGet, constants.REGISTRATIONS_PATH+"?country=Expandia&fields=id,country&expand=dashboard", nil)
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200 OK, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var items []map[string]json.RawMessage
+		if err := json.Unmarshal(rr.Body.Bytes(), &items); err != nil || len(items) != 1 {
+			t.Fatalf("Expected one item, got %s", rr.Body.String())
+		}
+		if len(items[0]) != 3 {
+			t.Errorf("Expected only id, country and dashboard, got %s", rr.Body.String())
+		}
+		var dash structs.Dashboard
+		if err := json.Unmarshal(items[0]["dashboard"], &dash); err != nil {
+			t.Fatalf("Failed to parse inlined dashboard: %v", err)
+		}
+		if dash.Features.Temperature != 5.5 {
+			t.Errorf("Expected the inlined dashboard to have Temperature=5.5, got %f", dash.Features.Temperature)
+		}
+	})
+
+	t.Run("GetAllRegistrations_BadProjection", func(t *testing.T) {
+		for _, query := range []string{"?fields=id,secret", "?fields=,", "?expand=notifications"} {
+			req := httptest.NewRequest(http.MethodGet, constants.REGISTRATIONS_PATH+query, nil)
+			rr := httptest.NewRecorder()
+			RegistrationRouter(rr, req)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400 Bad Request, got %d", query, rr.Code)
+			}
+		}
+	})
+
+	t.Run("GetOne_Fields", func(t *testing.T) {
+		docID := createFakeRegistration(t, "Sparse")
+
+		req := httptest.NewRequest(http.MethodGet, constants.REGISTRATIONS_PATH+docID+"?fields=country,revision", nil)
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200 OK, got %d", rr.Code)
+		}
+		var item map[string]interface{}
+		_ = json.Unmarshal(rr.Body.Bytes(), &item)
+		if len(item) != 2 || item["country"] != "Sparse" || item["revision"] != float64(1) {
+			t.Errorf("Expected only country and revision, got %v", item)
+		}
+		// The projection has its own tag, independent of the order of the fields
+		etag := rr.Header().Get("ETag")
+		if etag == "" || etag == `"1"` {
+			t.Errorf("Expected a tag for the projection, got %q", etag)
+		}
+		req = httptest.NewRequest(http.MethodGet, constants.REGISTRATIONS_PATH+docID+"?fields=revision,country", nil)
+		req.Header.Set("If-None-Match", etag)
+		rr = httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusNotModified {
+			t.Errorf("Expected 304 for the same projection, got %d", rr.Code)
+		}
+		req = httptest.NewRequest(http.MethodGet, constants.REGISTRATIONS_PATH+docID, nil)
+		req.Header.Set("If-None-Match", etag)
+		rr = httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected the full registration for the projection's tag, got %d", rr.Code)
+		}
+
+		req = httptest.NewRequest(http.MethodGet, constants.REGISTRATIONS_PATH+docID+"?expand=dashboard", nil)
+		rr = httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for expand on a single registration, got %d", rr.Code)
+		}
+	})
+
 	t.Run("GetOne_NotFound", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, constants.REGISTRATIONS_PATH+"doc-9999", nil)
 		rr := httptest.NewRecorder()
@@ -297,6 +592,230 @@ func TestRegistrationsHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("PatchRegistration_MergeKeepsOmittedFeatures", func(t *testing.T) {
+		docID := createFakeRegistration(t, "MergeMe")
+		patchBody := `{"features":{"capital":true,"targetCurrencies":["EUR"]}}`
+		req := httptest.NewRequest(http.MethodPatch, constants.REGISTRATIONS_PATH+docID, strings.NewReader(patchBody))
+		req.Header.Set("Content-Type", constants.CONTENT_TYPE_MERGE_PATCH)
+
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected 204, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		got, _ := firebase.GetRegistrationByID(context.Background(), docID)
+		if !got.Features.Temperature {
+			t.Error("Expected temperature=true to survive a patch that omitted it")
+		}
+		if !got.Features.Capital {
+			t.Error("Expected capital=true after patch")
+		}
+		if got.Country != "MergeMe" {
+			t.Errorf("Expected country to be unchanged, got %s", got.Country)
+		}
+	})
+
+	t.Run("PatchRegistration_MergeNullClearsCurrencies", func(t *testing.T) {
+		docID := createFakeRegistration(t, "ClearMe")
+		seed := httptest.NewRequest(http.MethodPatch, constants.REGISTRATIONS_PATH+docID,
+			strings.NewReader(`{"features":{"targetCurrencies":["EUR","USD"]}}`))
+		RegistrationRouter(httptest.NewRecorder(), seed)
+
+		req := httptest.NewRequest(http.MethodPatch, constants.REGISTRATIONS_PATH+docID,
+			strings.NewReader(`{"features":{"targetCurrencies":null}}`))
+		req.Header.Set("Content-Type", constants.CONTENT_TYPE_MERGE_PATCH)
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected 204, got %d", rr.Code)
+		}
+
+		got, _ := firebase.GetRegistrationByID(context.Background(), docID)
+		if len(got.Features.TargetCurrencies) != 0 {
+			t.Errorf("Expected targetCurrencies to be cleared, got %v", got.Features.TargetCurrencies)
+		}
+	})
+
+	t.Run("PatchRegistration_JSONPatch", func(t *testing.T) {
+		docID := createFakeRegistration(t, "JsonPatchMe")
+		patchBody := `[
+          {"op":"replace","path":"/features/temperature","value":false},
+          {"op":"add","path":"/features/targetCurrencies","value":["SEK"]}
+        ]`
+		req := httptest.NewRequest(http.MethodPatch, constants.REGISTRATIONS_PATH+docID, strings.NewReader(patchBody))
+		req.Header.Set("Content-Type", constants.CONTENT_TYPE_JSON_PATCH)
+
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected 204, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		got, _ := firebase.GetRegistrationByID(context.Background(), docID)
+		if got.Features.Temperature {
+			t.Error("Expected temperature=false after JSON Patch replace")
+		}
+		if len(got.Features.TargetCurrencies) != 1 || got.Features.TargetCurrencies[0] != "SEK" {
+			t.Errorf("Expected targetCurrencies=[SEK], got %v", got.Features.TargetCurrencies)
+		}
+	})
+
+	t.Run("PatchRegistration_JSONPatchTestFails", func(t *testing.T) {
+		docID := createFakeRegistration(t, "TestOp")
+		patchBody := `[{"op":"test","path":"/country","value":"Elsewhere"}]`
+		req := httptest.NewRequest(http.MethodPatch, constants.REGISTRATIONS_PATH+docID, strings.NewReader(patchBody))
+		req.Header.Set("Content-Type", constants.CONTENT_TYPE_JSON_PATCH)
+
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusConflict {
+			t.Errorf("Expected 409 for failed test op, got %d", rr.Code)
+		}
+	})
+
+	t.Run("PatchRegistration_UnknownField", func(t *testing.T) {
+		docID := createFakeRegistration(t, "UnknownField")
+		req := httptest.NewRequest(http.MethodPatch, constants.REGISTRATIONS_PATH+docID,
+			strings.NewReader(`{"features":{"temprature":true}}`))
+		req.Header.Set("Content-Type", constants.CONTENT_TYPE_MERGE_PATCH)
+
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected 422 for unknown field, got %d", rr.Code)
+		}
+	})
+
+	t.Run("PatchRegistration_UnsupportedMediaType", func(t *testing.T) {
+		docID := createFakeRegistration(t, "MediaType")
+		req := httptest.NewRequest(http.MethodPatch, constants.REGISTRATIONS_PATH+docID,
+			strings.NewReader(`country=Norway`))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("Expected 415, got %d", rr.Code)
+		}
+	})
+
+	t.Run("GetOne_ReturnsETag", func(t *testing.T) {
+		docID := createFakeRegistration(t, "ETagMe")
+		req := httptest.NewRequest(http.MethodGet, constants.REGISTRATIONS_PATH+docID, nil)
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rr.Code)
+		}
+		if etag := rr.Header().Get("ETag"); etag != `"1"` {
+			t.Errorf("Expected ETag \"1\" (quoted), got %s", etag)
+		}
+	})
+
+	t.Run("GetOne_ConditionalGet", func(t *testing.T) {
+		docID := createFakeRegistration(t, "Conditional")
+
+		req := httptest.NewRequest(http.MethodGet, constants.REGISTRATIONS_PATH+docID, nil)
+		req.Header.Set("If-None-Match", `"1"`)
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusNotModified {
+			t.Errorf("Expected 304 for matching If-None-Match, got %d", rr.Code)
+		}
+
+		req = httptest.NewRequest(http.MethodGet, constants.REGISTRATIONS_PATH+docID, nil)
+		req.Header.Set("If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		rr = httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusNotModified {
+			t.Errorf("Expected 304 for If-Modified-Since after lastChange, got %d", rr.Code)
+		}
+
+		req = httptest.NewRequest(http.MethodGet, constants.REGISTRATIONS_PATH+docID, nil)
+		req.Header.Set("If-Modified-Since", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+		rr = httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected 200 for If-Modified-Since before lastChange, got %d", rr.Code)
+		}
+		if rr.Header().Get("Last-Modified") == "" {
+			t.Error("Expected a Last-Modified header")
+		}
+	})
+
+	t.Run("PutRegistration_IfMatch", func(t *testing.T) {
+		docID := createFakeRegistration(t, "IfMatchPut")
+		putBody := `{"country":"IfMatchPut","features":{"capital":true}}`
+
+		stale := httptest.NewRequest(http.MethodPut, constants.REGISTRATIONS_PATH+docID, strings.NewReader(putBody))
+		stale.Header.Set("If-Match", `"7"`)
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, stale)
+		if rr.Code != http.StatusPreconditionFailed {
+			t.Fatalf("Expected 412 for stale If-Match, got %d", rr.Code)
+		}
+
+		fresh := httptest.NewRequest(http.MethodPut, constants.REGISTRATIONS_PATH+docID, strings.NewReader(putBody))
+		fresh.Header.Set("If-Match", `"1"`)
+		rr = httptest.NewRecorder()
+		RegistrationRouter(rr, fresh)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected 204 for matching If-Match, got %d", rr.Code)
+		}
+		if etag := rr.Header().Get("ETag"); etag != `"2"` {
+			t.Errorf("Expected new ETag \"2\" (quoted), got %s", etag)
+		}
+	})
+
+	t.Run("PatchRegistration_IfMatchMismatch", func(t *testing.T) {
+		docID := createFakeRegistration(t, "IfMatchPatch")
+		req := httptest.NewRequest(http.MethodPatch, constants.REGISTRATIONS_PATH+docID, strings.NewReader(`{"country":"X"}`))
+		req.Header.Set("If-Match", `"99"`)
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("Expected 412, got %d", rr.Code)
+		}
+	})
+
+	t.Run("PatchRegistration_RetriesLostRace", func(t *testing.T) {
+		docID := createFakeRegistration(t, "RaceMe")
+		stubPatch := firebase.PatchRegistration
+		calls := 0
+		firebase.PatchRegistration = func(ctx context.Context, id string, patched structs.Registration, expected int64) (int64, error) {
+			calls++
+			if calls == 1 {
+				// Simulate a concurrent writer that got in between the read and the write
+				return 0, firebase.ErrRevisionMismatch
+			}
+			return stubPatch(ctx, id, patched, expected)
+		}
+		defer func() { firebase.PatchRegistration = stubPatch }()
+
+		req := httptest.NewRequest(http.MethodPatch, constants.REGISTRATIONS_PATH+docID, strings.NewReader(`{"country":"Raced"}`))
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("Expected 204 after retry, got %d", rr.Code)
+		}
+		if calls != 2 {
+			t.Errorf("Expected 2 patch attempts, got %d", calls)
+		}
+	})
+
+	t.Run("DeleteRegistration_IfMatchMismatch", func(t *testing.T) {
+		docID := createFakeRegistration(t, "IfMatchDelete")
+		req := httptest.NewRequest(http.MethodDelete, constants.REGISTRATIONS_PATH+docID, nil)
+		req.Header.Set("If-Match", `"5"`)
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		if rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("Expected 412, got %d", rr.Code)
+		}
+	})
+
 	t.Run("DeleteRegistration_Success", func(t *testing.T) {
 		docID := createFakeRegistration(t, "DelMe")
 		req := httptest.NewRequest(http.MethodDelete, constants.REGISTRATIONS_PATH+docID, nil)
@@ -317,6 +836,69 @@ func TestRegistrationsHandler(t *testing.T) {
 			t.Errorf("Expected 404 Not Found for unknown doc, got %d", rr.Code)
 		}
 	})
+
+	t.Run("Tags", func(t *testing.T) {
+		post := func(body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, constants.REGISTRATIONS_PATH, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			RegistrationRouter(rr, req)
+			return rr
+		}
+		if rr := post(`{"country":"Tagland","features":{"temperature":true},"tags":["team-a","tagland"]}`); rr.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d %s", rr.Code, rr.Body.String())
+		}
+		for _, tags := range []string{`[""]`, `["two words"]`, `["a;b"]`, `["` + strings.Repeat("x", constants.MAX_TAG_LENGTH+1) + `"]`} {
+			if rr := post(`{"country":"Tagland","features":{"temperature":true},"tags":` + tags + `}`); rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400, got %d", tags, rr.Code)
+			}
+		}
+
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, httptest.NewRequest(http.MethodGet, constants.REGISTRATIONS_PATH+"?tag=tagland", nil))
+		var regs []structs.Registration
+		_ = json.Unmarshal(rr.Body.Bytes(), &regs)
+		if rr.Code != http.StatusOK || len(regs) != 1 || regs[0].Country != "Tagland" {
+			t.Errorf("Expected the registration tagged tagland, got %d %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("WebhookEvents", func(t *testing.T) {
+		var events []structs.WebhookEvent
+		origTrigger := TriggerWebhookEventVar
+		TriggerWebhookEventVar = func(ev structs.WebhookEvent) { events = append(events, ev) }
+		defer func() { TriggerWebhookEventVar = origTrigger }()
+
+		docID := createFakeRegistration(t, "Eventland")
+		req := httptest.NewRequest(http.MethodPatch, constants.REGISTRATIONS_PATH+docID,
+			strings.NewReader(`{"features":{"precipitation":true},"tags":["team-b"]}`))
+		req.Header.Set("Content-Type", "application/json")
+		RegistrationRouter(httptest.NewRecorder(), req)
+		RegistrationRouter(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, constants.REGISTRATIONS_PATH+docID, nil))
+
+		if len(events) != 3 {
+			t.Fatalf("Expected REGISTER, CHANGE and DELETE, got %+v", events)
+		}
+		for i, want := range []string{"REGISTER", "CHANGE", "DELETE"} {
+			if events[i].Event != want || len(events[i].Registrations) != 1 || events[i].Registrations[0] != docID {
+				t.Errorf("Expected %s of %s, got %+v", want, docID, events[i])
+			}
+		}
+		change := events[1]
+		if len(change.Tags) != 1 || change.Tags[0] != "team-b" {
+			t.Errorf("Expected the new tag on the CHANGE event, got %v", change.Tags)
+		}
+		var ops []map[string]interface{}
+		_ = json.Unmarshal(change.Changes, &ops)
+		paths := make([]string, len(ops))
+		for i, op := range ops {
+			paths[i], _ = op["path"].(string)
+		}
+		sort.Strings(paths)
+		if strings.Join(paths, ",") != "/features/precipitation,/tags" {
+			t.Errorf("Expected the changed fields in the diff, got %s", change.Changes)
+		}
+	})
 }
 
 // createFakeRegistration is a helper that does a POST /registrations/ to create a doc
@@ -0,0 +1,526 @@
+// File: assignment-2/handlers/renderers.go
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"assignment-2/structs"
+	"assignment-2/tools"
+)
+
+// Formats offered by the dashboard and registration listing endpoints; JSON is the default.
+var renderFormats = []string{"json", "csv", "xml", "html"}
+
+// negotiateRenderFormat picks one of renderFormats for the request, writing a 400 (unknown
+// ?format=) or 406 (unsatisfiable Accept) response and returning "" if there is none.
+func negotiateRenderFormat(w http.ResponseWriter, r *http.Request) string {
+	format, err := tools.NegotiateFormat(r, renderFormats...)
+	switch {
+	case errors.Is(err, tools.ErrUnknownFormat):
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Unknown format, use "+strings.Join(renderFormats, ", "))
+		return ""
+	case err != nil:
+		tools.WriteJsonErrorResponse(w, http.StatusNotAcceptable, "Available media types: application/json, text/csv, application/xml, text/html")
+		return ""
+	}
+	return format
+}
+
+// renderDashboard writes 'dash' (built for 'reg') in 'format'. 'refresh' is the number of
+// seconds after which the HTML page reloads itself.
+func renderDashboard(w http.ResponseWriter, format string, reg *structs.Registration, dash structs.Dashboard, refresh int) {
+	if format == "json" {
+		tools.WriteJsonResponse(w, http.StatusOK, dash)
+		return
+	}
+	w.Header().Set("Content-Type", tools.ContentTypeOf(format))
+	w.WriteHeader(http.StatusOK)
+	var err error
+	switch format {
+	case "csv":
+		err = writeDashboardCSV(w, reg.Features, dash)
+	case "xml":
+		err = writeXML(w, newXMLDashboard(dash))
+	case "html":
+		err = dashboardTemplate.Execute(w, newHTMLDashboard(reg.Features, dash, refresh))
+	}
+	if err != nil {
+		log.Printf("Error rendering dashboard as %s: %v\n", format, err)
+	}
+}
+
+// renderRegistrations writes a page of registrations in 'format'.
+func renderRegistrations(w http.ResponseWriter, format string, regs []structs.Registration) {
+	if format == "json" {
+		tools.WriteJsonResponse(w, http.StatusOK, regs)
+		return
+	}
+	w.Header().Set("Content-Type", tools.ContentTypeOf(format))
+	w.WriteHeader(http.StatusOK)
+	var err error
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write(registrationCSVHeader)
+		for _, reg := range regs {
+			_ = cw.Write(registrationToCSV(reg))
+		}
+		cw.Flush()
+		err = cw.Error()
+	case "xml":
+		list := xmlRegistrations{Registrations: make([]xmlRegistration, len(regs))}
+		for i, reg := range regs {
+			list.Registrations[i] = newXMLRegistration(reg)
+		}
+		err = writeXML(w, list)
+	case "html":
+		err = registrationsTemplate.Execute(w, regs)
+	}
+	if err != nil {
+		log.Printf("Error rendering registrations as %s: %v\n", format, err)
+	}
+}
+
+// CSV
+
+// dashboardCSVColumns returns the feature columns of a dashboard CSV for 'f', in the
+// order of the Features struct. Coordinates take two columns, and every target currency
+// its own column named by the currency code.
+func dashboardCSVColumns(f structs.Features) []string {
+	var columns []string
+	if f.Temperature {
+		columns = append(columns, "temperature")
+	}
+	if f.Precipitation {
+		columns = append(columns, "precipitation")
+	}
+	if f.Capital {
+		columns = append(columns, "capital")
+	}
+	if f.Coordinates {
+		columns = append(columns, "latitude", "longitude")
+	}
+	if f.Population {
+		columns = append(columns, "population")
+	}
+	if f.Area {
+		columns = append(columns, "area")
+	}
+	if f.PopulationDensity {
+		columns = append(columns, "populationDensity")
+	}
+	if f.LocalTime {
+		columns = append(columns, "localTime")
+	}
+	if f.DistanceFrom != nil {
+		columns = append(columns, "distanceToCapital")
+	}
+	return append(columns, f.TargetCurrencies...)
+}
+
+// writeDashboardCSV writes one row per country: the only one of a single-country
+// dashboard, or every block of a comparison. Aggregates are left out.
+func writeDashboardCSV(w io.Writer, f structs.Features, dash structs.Dashboard) error {
+	columns := dashboardCSVColumns(f)
+	cw := csv.NewWriter(w)
+	_ = cw.Write(append([]string{"country", "isoCode"}, columns...))
+
+	row := func(country, isoCode string, df structs.DashboardFeatures) []string {
+		record := []string{country, isoCode}
+		for _, c := range columns {
+			record = append(record, dashboardCSVValue(df, c))
+		}
+		return record
+	}
+	if len(dash.Countries) > 0 {
+		for _, block := range dash.Countries {
+			_ = cw.Write(row(block.Country, "", block.Features))
+		}
+	} else {
+		_ = cw.Write(row(dash.Country, dash.ISOCode, dash.Features))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// dashboardCSVValue formats one feature column; values that are missing stay empty.
+func dashboardCSVValue(df structs.DashboardFeatures, column string) string {
+	number := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	switch column {
+	case "temperature":
+		return number(df.Temperature)
+	case "precipitation":
+		return number(df.Precipitation)
+	case "capital":
+		return df.Capital
+	case "latitude", "longitude":
+		if df.Coordinates == nil {
+			return ""
+		}
+		if column == "latitude" {
+			return number(df.Coordinates.Lat)
+		}
+		return number(df.Coordinates.Lon)
+	case "population":
+		return strconv.FormatInt(df.Population, 10)
+	case "area":
+		return number(df.Area)
+	case "populationDensity":
+		return number(df.PopulationDensity)
+	case "localTime":
+		return df.LocalTime
+	case "distanceToCapital":
+		return number(df.DistanceToCapital)
+	}
+	if rate, ok := df.TargetCurrencies[column]; ok {
+		return number(rate)
+	}
+	return ""
+}
+
+// XML
+// encoding/xml cannot marshal maps, so the XML documents use their own view types.
+
+func writeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(v)
+}
+
+type xmlRate struct {
+	Currency string  `xml:"currency,attr"`
+	Rate     float64 `xml:",chardata"`
+}
+
+type xmlCoordinates struct {
+	Lat float64 `xml:"lat,attr"`
+	Lon float64 `xml:"lon,attr"`
+}
+
+type xmlFeatures struct {
+	Temperature      float64         `xml:"temperature,omitempty"`
+	Precipitation    float64         `xml:"precipitation,omitempty"`
+	Capital          string          `xml:"capital,omitempty"`
+	Coordinates      *xmlCoordinates `xml:"coordinates,omitempty"`
+	Population       int64           `xml:"population,omitempty"`
+	Area             float64         `xml:"area,omitempty"`
+	TargetCurrencies []xmlRate       `xml:"targetCurrencies>rate,omitempty"`
+
+	PopulationDensity float64   `xml:"populationDensity,omitempty"`
+	LocalTime         string    `xml:"localTime,omitempty"`
+	TimeZone          string    `xml:"timeZone,omitempty"`
+	DistanceToCapital float64   `xml:"distanceToCapital,omitempty"`
+	Units             []xmlUnit `xml:"units>unit,omitempty"`
+}
+
+type xmlUnit struct {
+	Feature string `xml:"feature,attr"`
+	Unit    string `xml:",chardata"`
+}
+
+type xmlCountryDashboard struct {
+	Name     string      `xml:"name,attr"`
+	Features xmlFeatures `xml:"features"`
+}
+
+type xmlAggregate struct {
+	Feature string   `xml:"feature,attr"`
+	Min     float64  `xml:"min,attr"`
+	Max     float64  `xml:"max,attr"`
+	Mean    float64  `xml:"mean,attr"`
+	Rank    []string `xml:"rank>country"`
+}
+
+type xmlDashboard struct {
+	XMLName       xml.Name              `xml:"dashboard"`
+	Country       string                `xml:"country,omitempty"`
+	ISOCode       string                `xml:"isoCode,omitempty"`
+	Region        string                `xml:"region,omitempty"`
+	Features      *xmlFeatures          `xml:"features,omitempty"`
+	Countries     []xmlCountryDashboard `xml:"countries>country,omitempty"`
+	Aggregates    []xmlAggregate        `xml:"aggregates>aggregate,omitempty"`
+	LastRetrieval time.Time             `xml:"lastRetrieval"`
+}
+
+func newXMLFeatures(df structs.DashboardFeatures) xmlFeatures {
+	x := xmlFeatures{
+		Temperature:   df.Temperature,
+		Precipitation: df.Precipitation,
+		Capital:       df.Capital,
+		Population:    df.Population,
+		Area:          df.Area,
+
+		PopulationDensity: df.PopulationDensity,
+		LocalTime:         df.LocalTime,
+		TimeZone:          df.TimeZone,
+		DistanceToCapital: df.DistanceToCapital,
+	}
+	for _, feature := range sortedKeys(df.Units) {
+		x.Units = append(x.Units, xmlUnit{Feature: feature, Unit: df.Units[feature]})
+	}
+	if df.Coordinates != nil {
+		x.Coordinates = &xmlCoordinates{Lat: df.Coordinates.Lat, Lon: df.Coordinates.Lon}
+	}
+	for _, cur := range sortedKeys(df.TargetCurrencies) {
+		x.TargetCurrencies = append(x.TargetCurrencies, xmlRate{Currency: cur, Rate: df.TargetCurrencies[cur]})
+	}
+	return x
+}
+
+func newXMLDashboard(dash structs.Dashboard) xmlDashboard {
+	x := xmlDashboard{
+		Country:       dash.Country,
+		ISOCode:       dash.ISOCode,
+		Region:        dash.Region,
+		LastRetrieval: dash.LastRetrieval,
+	}
+	if len(dash.Countries) == 0 {
+		features := newXMLFeatures(dash.Features)
+		x.Features = &features
+	}
+	for _, block := range dash.Countries {
+		x.Countries = append(x.Countries, xmlCountryDashboard{Name: block.Country, Features: newXMLFeatures(block.Features)})
+	}
+	for _, name := range sortedKeys(dash.Aggregates) {
+		agg := dash.Aggregates[name]
+		x.Aggregates = append(x.Aggregates, xmlAggregate{Feature: name, Min: agg.Min, Max: agg.Max, Mean: agg.Mean, Rank: agg.Rank})
+	}
+	return x
+}
+
+type xmlRegistrationFeatures struct {
+	Temperature      bool     `xml:"temperature"`
+	Precipitation    bool     `xml:"precipitation"`
+	Capital          bool     `xml:"capital"`
+	Coordinates      bool     `xml:"coordinates"`
+	Population       bool     `xml:"population"`
+	Area             bool     `xml:"area"`
+	TargetCurrencies []string `xml:"targetCurrencies>currency"`
+}
+
+type xmlRegistration struct {
+	ID         string                  `xml:"id,attr"`
+	Revision   int64                   `xml:"revision,attr"`
+	Country    string                  `xml:"country,omitempty"`
+	ISOCode    string                  `xml:"isoCode,omitempty"`
+	Countries  []string                `xml:"countries>country,omitempty"`
+	Region     string                  `xml:"region,omitempty"`
+	Snapshot   string                  `xml:"snapshot,omitempty"`
+	Tags       []string                `xml:"tags>tag,omitempty"`
+	Features   xmlRegistrationFeatures `xml:"features"`
+	LastChange time.Time               `xml:"lastChange"`
+}
+
+type xmlRegistrations struct {
+	XMLName       xml.Name          `xml:"registrations"`
+	Registrations []xmlRegistration `xml:"registration"`
+}
+
+func newXMLRegistration(reg structs.Registration) xmlRegistration {
+	f := reg.Features
+	return xmlRegistration{
+		ID:        reg.ID,
+		Revision:  reg.Revision,
+		Country:   reg.Country,
+		ISOCode:   reg.ISOCode,
+		Countries: reg.Countries,
+		Region:    reg.Region,
+		Snapshot:  reg.Snapshot,
+		Tags:      reg.Tags,
+		Features: xmlRegistrationFeatures{
+			Temperature:      f.Temperature,
+			Precipitation:    f.Precipitation,
+			Capital:          f.Capital,
+			Coordinates:      f.Coordinates,
+			Population:       f.Population,
+			Area:             f.Area,
+			TargetCurrencies: f.TargetCurrencies,
+		},
+		LastChange: reg.LastChange,
+	}
+}
+
+// sortedKeys returns the keys of a string-keyed map in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// HTML
+
+// htmlTile is one value on the dashboard page.
+type htmlTile struct {
+	Label string
+	Value string
+}
+
+// htmlCountry is the tiles of one country.
+type htmlCountry struct {
+	Name  string
+	Tiles []htmlTile
+}
+
+// htmlDashboard is the data of the dashboard page.
+type htmlDashboard struct {
+	Title         string
+	Refresh       int
+	Countries     []htmlCountry
+	Columns       []string
+	Aggregates    []htmlAggregate
+	LastRetrieval string
+}
+
+type htmlAggregate struct {
+	Feature string
+	Min     string
+	Max     string
+	Mean    string
+	Leader  string
+}
+
+// htmlLabels are the tile captions of the dashboard CSV columns; the unit of the block
+// is appended to them.
+var htmlLabels = map[string]string{
+	"temperature":       "Temperature",
+	"precipitation":     "Precipitation",
+	"capital":           "Capital",
+	"latitude":          "Latitude",
+	"longitude":         "Longitude",
+	"population":        "Population",
+	"area":              "Area",
+	"populationDensity": "Population density",
+	"localTime":         "Local time",
+	"distanceToCapital": "Distance to capital",
+}
+
+func newHTMLDashboard(f structs.Features, dash structs.Dashboard, refresh int) htmlDashboard {
+	columns := dashboardCSVColumns(f)
+	page := htmlDashboard{Refresh: refresh, LastRetrieval: dash.LastRetrieval.UTC().Format("2006-01-02 15:04 MST")}
+	tiles := func(df structs.DashboardFeatures) []htmlTile {
+		var out []htmlTile
+		for _, c := range columns {
+			label, ok := htmlLabels[c]
+			unit := df.Units[c]
+			if !ok {
+				label, unit = c, df.Units["targetCurrencies"]
+			}
+			if unit != "" {
+				label += " (" + unit + ")"
+			}
+			out = append(out, htmlTile{Label: label, Value: dashboardCSVValue(df, c)})
+		}
+		return out
+	}
+
+	if len(dash.Countries) == 0 {
+		page.Title = dash.Country
+		if page.Title == "" {
+			page.Title = dash.ISOCode
+		}
+		page.Countries = []htmlCountry{{Name: page.Title, Tiles: tiles(dash.Features)}}
+		return page
+	}
+
+	page.Title = dash.Region
+	if page.Title == "" {
+		page.Title = "Comparison"
+	}
+	for _, block := range dash.Countries {
+		page.Countries = append(page.Countries, htmlCountry{Name: block.Country, Tiles: tiles(block.Features)})
+	}
+	number := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+	for _, name := range sortedKeys(dash.Aggregates) {
+		agg := dash.Aggregates[name]
+		leader := ""
+		if len(agg.Rank) > 0 {
+			leader = agg.Rank[0]
+		}
+		page.Aggregates = append(page.Aggregates, htmlAggregate{
+			Feature: name, Min: number(agg.Min), Max: number(agg.Max), Mean: number(agg.Mean), Leader: leader,
+		})
+	}
+	return page
+}
+
+// htmlStyle is shared by the pages; large type on a dark background for wall displays.
+const htmlStyle = `
+body { margin: 0; padding: 2vw; background: #111; color: #eee; font-family: Helvetica, Arial, sans-serif; }
+h1 { font-size: 4vw; margin: 0 0 2vw; }
+h2 { font-size: 2.5vw; margin: 0 0 1vw; color: #8cf; }
+.country { margin-bottom: 3vw; }
+.tiles { display: flex; flex-wrap: wrap; gap: 1.5vw; }
+.tile { background: #222; border-radius: 1vw; padding: 1.5vw 2vw; min-width: 14vw; }
+.label { font-size: 1.2vw; color: #aaa; text-transform: uppercase; }
+.value { font-size: 3.5vw; font-weight: bold; }
+table { border-collapse: collapse; font-size: 1.6vw; width: 100%; }
+th, td { padding: 0.6vw 1vw; text-align: left; border-bottom: 1px solid #333; }
+th { color: #aaa; }
+footer { margin-top: 2vw; font-size: 1vw; color: #777; }
+`
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+{{if .Refresh}}<meta http-equiv="refresh" content="{{.Refresh}}">{{end}}
+<title>{{.Title}} dashboard</title>
+<style>` + htmlStyle + `</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Countries}}<section class="country">
+{{if gt (len $.Countries) 1}}<h2>{{.Name}}</h2>{{end}}
+<div class="tiles">
+{{range .Tiles}}<div class="tile"><div class="label">{{.Label}}</div><div class="value">{{if .Value}}{{.Value}}{{else}}–{{end}}</div></div>
+{{end}}</div>
+</section>
+{{end}}{{if .Aggregates}}<table>
+<tr><th>Feature</th><th>Min</th><th>Mean</th><th>Max</th><th>Highest</th></tr>
+{{range .Aggregates}}<tr><td>{{.Feature}}</td><td>{{.Min}}</td><td>{{.Mean}}</td><td>{{.Max}}</td><td>{{.Leader}}</td></tr>
+{{end}}</table>
+{{end}}<footer>Last retrieval {{.LastRetrieval}}</footer>
+</body>
+</html>
+`))
+
+var registrationsTemplate = template.Must(template.New("registrations").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Registrations</title>
+<style>` + htmlStyle + `</style>
+</head>
+<body>
+<h1>Registrations</h1>
+<table>
+<tr><th>ID</th><th>Country</th><th>Features</th><th>Currencies</th><th>Last change</th></tr>
+{{range .}}<tr>
+<td>{{.ID}}</td>
+<td>{{if .Country}}{{.Country}}{{else if .ISOCode}}{{.ISOCode}}{{else}}{{join .Countries ", "}}{{if .Region}} {{.Region}}{{end}}{{end}}</td>
+<td>{{with .Features}}{{if .Temperature}}temperature {{end}}{{if .Precipitation}}precipitation {{end}}{{if .Capital}}capital {{end}}{{if .Coordinates}}coordinates {{end}}{{if .Population}}population {{end}}{{if .Area}}area{{end}}{{end}}</td>
+<td>{{join .Features.TargetCurrencies ", "}}</td>
+<td>{{.LastChange.Format "2006-01-02 15:04"}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
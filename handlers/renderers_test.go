@@ -0,0 +1,162 @@
+// File: assignment-2/handlers/renderers_test.go
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"assignment-2/constants"
+	"assignment-2/firebase"
+	"assignment-2/structs"
+)
+
+// TestDashboardFormats requests one dashboard in every format.
+func TestDashboardFormats(t *testing.T) {
+	overrideStubs()
+	defer revertStubs()
+
+	invoked := 0
+	TriggerWebhookEventVar = func(structs.WebhookEvent) { invoked++ }
+
+	storeRegistration("fmt-1", structs.Registration{ID: "fmt-1", ISOCode: "NO", Features: structs.Features{
+		Temperature: true, Capital: true, Coordinates: true, TargetCurrencies: []string{"EUR", "USD"},
+	}})
+	storeRegistration("fmt-compare", structs.Registration{ID: "fmt-compare", Region: "Scandinavia", Features: structs.Features{
+		Temperature: true, Population: true,
+	}})
+
+	get := func(target, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, constants.DASHBOARDS_PATH+target, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		DashboardsRouter(rr, req)
+		return rr
+	}
+
+	t.Run("CSV", func(t *testing.T) {
+		rr := get("fmt-1", "text/csv")
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		if rr.Code != http.StatusOK || err != nil || len(records) != 2 {
+			t.Fatalf("Expected a header and one row, got %d %v %v", rr.Code, records, err)
+		}
+		want := "country,isoCode,temperature,capital,latitude,longitude,EUR,USD"
+		if got := strings.Join(records[0], ","); got != want {
+			t.Errorf("Expected header %q, got %q", want, got)
+		}
+		if records[1][1] != "NO" || records[1][2] != "5.5" || records[1][3] != "Oslo" || records[1][6] != "0.09" {
+			t.Errorf("Unexpected row %v", records[1])
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+			t.Errorf("Unexpected Content-Type %q", ct)
+		}
+
+		rr = get("fmt-compare?format=csv", "")
+		records, _ = csv.NewReader(rr.Body).ReadAll()
+		if len(records) != 4 || records[2][0] != "Norway" {
+			t.Errorf("Expected one row per Scandinavian country, got %v", records)
+		}
+	})
+
+	t.Run("XML", func(t *testing.T) {
+		rr := get("fmt-1", "application/xml")
+		var doc xmlDashboard
+		if err := xml.Unmarshal(rr.Body.Bytes(), &doc); err != nil || rr.Code != http.StatusOK {
+			t.Fatalf("Expected an XML dashboard, got %d %v", rr.Code, err)
+		}
+		if doc.ISOCode != "NO" || doc.Features == nil || len(doc.Features.TargetCurrencies) != 2 || doc.Features.TargetCurrencies[0].Currency != "EUR" {
+			t.Errorf("Unexpected XML dashboard %+v", doc)
+		}
+
+		rr = get("fmt-compare", "text/xml")
+		doc = xmlDashboard{}
+		_ = xml.Unmarshal(rr.Body.Bytes(), &doc)
+		if len(doc.Countries) != 3 || len(doc.Aggregates) != 2 {
+			t.Errorf("Expected 3 countries and 2 aggregates, got %+v", doc)
+		}
+	})
+
+	t.Run("HTML", func(t *testing.T) {
+		rr := get("fmt-compare", "text/html,application/xhtml+xml,*/*;q=0.8")
+		body := rr.Body.String()
+		if rr.Code != http.StatusOK || !strings.Contains(body, "<h1>Scandinavia</h1>") || !strings.Contains(body, `http-equiv="refresh"`) {
+			t.Errorf("Expected the HTML page, got %d %s", rr.Code, body)
+		}
+		if !strings.Contains(body, "<h2>Sweden</h2>") {
+			t.Errorf("Expected a block per country in %s", body)
+		}
+	})
+
+	t.Run("ETagPerFormat", func(t *testing.T) {
+		jsonTag := get("fmt-1", "").Header().Get("ETag")
+		csvTag := get("fmt-1", "text/csv").Header().Get("ETag")
+		if jsonTag == "" || jsonTag == csvTag {
+			t.Errorf("Expected distinct ETags, got %q and %q", jsonTag, csvTag)
+		}
+		if vary := get("fmt-1", "").Header().Get("Vary"); vary != "Accept" {
+			t.Errorf("Expected Vary: Accept, got %q", vary)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		invoked = 0
+		if rr := get("fmt-1?format=pdf", ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an unknown format, got %d", rr.Code)
+		}
+		if rr := get("fmt-1", "application/pdf"); rr.Code != http.StatusNotAcceptable {
+			t.Errorf("Expected 406, got %d", rr.Code)
+		}
+		if invoked != 0 {
+			t.Errorf("Expected no INVOKE for undelivered dashboards, got %d", invoked)
+		}
+	})
+}
+
+// TestRegistrationListFormats requests the registration listing as CSV, XML and HTML.
+func TestRegistrationListFormats(t *testing.T) {
+	overrideFirebaseStubs()
+	defer revertFirebaseStubs()
+
+	if _, err := firebase.SaveRegistration(context.Background(), structs.Registration{
+		Country: "Norway", ISOCode: "NO", Features: structs.Features{Temperature: true},
+	}); err != nil {
+		t.Fatalf("Could not store registration: %v", err)
+	}
+
+	get := func(target, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, constants.REGISTRATIONS_PATH+target, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		RegistrationRouter(rr, req)
+		return rr
+	}
+
+	rr := get("", "text/csv")
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if rr.Code != http.StatusOK || err != nil || len(records) < 2 || records[0][0] != registrationCSVHeader[0] {
+		t.Errorf("Expected a CSV listing, got %d %v %v", rr.Code, records, err)
+	}
+
+	rr = get("?format=xml", "")
+	var list xmlRegistrations
+	if err := xml.Unmarshal(rr.Body.Bytes(), &list); err != nil || len(list.Registrations) == 0 {
+		t.Errorf("Expected an XML listing, got %v %s", err, rr.Body.String())
+	}
+
+	rr = get("", "text/html")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "<table>") {
+		t.Errorf("Expected an HTML table, got %d", rr.Code)
+	}
+
+	if rr = get("?format=csv&expand=dashboard", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for expand with CSV, got %d", rr.Code)
+	}
+}
@@ -22,6 +22,11 @@ func TestMain(m *testing.M) {
 		os.Exit(0)
 	}
 
+	// The test receivers are httptest servers on the loopback interface
+	if err := ConfigureWebhookPolicy([]string{"http", "https"}, []string{"127.0.0.1"}, nil); err != nil {
+		log.Fatalf("Could not configure the webhook URL policy: %v", err)
+	}
+
 	code := m.Run()
 
 	// Close Firestore
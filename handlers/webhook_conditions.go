@@ -0,0 +1,398 @@
+// File: assignment-2/handlers/webhook_conditions.go
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"assignment-2/constants"
+	"assignment-2/firebase"
+	"assignment-2/services"
+	"assignment-2/structs"
+)
+
+// conditionEvent is the event of webhooks that fire on a condition over the data of
+// their country instead of on a registration lifecycle event.
+const conditionEvent = "CONDITION"
+
+// conditionMetrics are the numeric features a condition can test, in addition to
+// "targetCurrencies.<CODE>". They are measured in the default units (°C, km², mm/h).
+var conditionMetrics = []string{"temperature", "precipitation", "population", "area", "populationDensity"}
+
+// Kinds of conditions
+const (
+	conditionThreshold = "threshold" // <metric> <op> <number>
+	conditionChange    = "change"    // <metric> change <op> <number>[%]
+	conditionChanged   = "changed"   // <metric> changed
+)
+
+// condition is a parsed condition expression.
+type condition struct {
+	metric  string
+	kind    string
+	op      string
+	amount  float64
+	percent bool // percent is set if a change is relative to the baseline
+}
+
+// parseCondition parses a condition expression. Tokens are separated by spaces:
+//
+//	temperature < -10                  the value crosses a threshold (<, <=, >, >=)
+//	targetCurrencies.EUR change > 2%   the value moved since the last notification
+//	population changed                 the value differs from the last notification
+func parseCondition(expr string) (condition, error) {
+	fields := strings.Fields(expr)
+	if len(fields) < 2 || len(fields) > 4 {
+		return condition{}, fmt.Errorf("condition %q must look like '<metric> < -10', '<metric> change > 2%%' or '<metric> changed'", expr)
+	}
+
+	c := condition{metric: fields[0]}
+	if cur, ok := strings.CutPrefix(c.metric, "targetCurrencies."); ok && isLetters(cur, 3, 3) {
+		c.metric = "targetCurrencies." + strings.ToUpper(cur)
+	} else if !containsString(conditionMetrics, c.metric) {
+		return condition{}, fmt.Errorf("unknown condition metric '%s', use one of %s or targetCurrencies.<CODE>",
+			c.metric, strings.Join(conditionMetrics, ", "))
+	}
+
+	rest := fields[1:]
+	switch {
+	case len(rest) == 1 && rest[0] == "changed":
+		c.kind = conditionChanged
+		return c, nil
+	case len(rest) == 3 && rest[0] == "change":
+		c.kind = conditionChange
+		rest = rest[1:]
+	case len(rest) == 2:
+		c.kind = conditionThreshold
+	default:
+		return condition{}, fmt.Errorf("condition %q must look like '<metric> < -10', '<metric> change > 2%%' or '<metric> changed'", expr)
+	}
+
+	c.op = rest[0]
+	switch {
+	case c.kind == conditionThreshold && !containsString([]string{"<", "<=", ">", ">="}, c.op):
+		return condition{}, fmt.Errorf("unknown comparison '%s', use <, <=, > or >=", c.op)
+	case c.kind == conditionChange && c.op != ">" && c.op != ">=":
+		return condition{}, fmt.Errorf("a change can only be compared with > or >=")
+	}
+	number := rest[1]
+	if c.kind == conditionChange {
+		number, c.percent = strings.CutSuffix(number, "%")
+	}
+	amount, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return condition{}, fmt.Errorf("'%s' is not a number", rest[1])
+	}
+	if c.kind == conditionChange && amount < 0 {
+		return condition{}, fmt.Errorf("a change must not be negative")
+	}
+	c.amount = amount
+	return c, nil
+}
+
+// addFeatures adds the dashboard feature the condition's metric comes from to 'f'.
+func (c condition) addFeatures(f *structs.Features) {
+	switch c.metric {
+	case "temperature":
+		f.Temperature = true
+	case "precipitation":
+		f.Precipitation = true
+	case "population":
+		f.Population = true
+	case "area":
+		f.Area = true
+	case "populationDensity":
+		f.PopulationDensity = true
+	default:
+		cur := strings.TrimPrefix(c.metric, "targetCurrencies.")
+		if !containsString(f.TargetCurrencies, cur) {
+			f.TargetCurrencies = append(f.TargetCurrencies, cur)
+		}
+	}
+}
+
+// compare applies a comparison operator.
+func compare(a float64, op string, b float64) bool {
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+// evaluate evaluates the condition for 'value' and reports whether it fires. A threshold
+// condition fires when it becomes true and cannot fire again until the value has moved
+// back past the threshold by at least 'hysteresis'. Change conditions compare against the
+// value at the last notification; the first evaluation only records it.
+func (c condition) evaluate(state structs.ConditionState, value, hysteresis float64, now time.Time) (structs.ConditionState, bool) {
+	first := state.Evaluated.IsZero()
+	next := state
+	next.Value, next.Evaluated = value, now
+	fire := false
+
+	switch c.kind {
+	case conditionThreshold:
+		met := compare(value, c.op, c.amount)
+		if !state.Triggered && met {
+			fire, next.Triggered = true, true
+		} else if state.Triggered && !met && math.Abs(value-c.amount) >= hysteresis {
+			next.Triggered = false
+		}
+	case conditionChange:
+		if first {
+			next.Baseline = value
+			break
+		}
+		delta := math.Abs(value - state.Baseline)
+		if c.percent {
+			if state.Baseline == 0 {
+				break
+			}
+			delta = delta / math.Abs(state.Baseline) * 100
+		}
+		fire = compare(delta, c.op, c.amount)
+	case conditionChanged:
+		if first {
+			next.Baseline = value
+			break
+		}
+		fire = value != state.Baseline
+	}
+
+	if fire {
+		next.Baseline, next.Fired = value, now
+	}
+	return next, fire
+}
+
+// validateCondition checks the condition of a webhook to be registered: CONDITION
+// webhooks need a valid condition and a country, other webhooks take neither a condition
+// nor a hysteresis. The evaluation state cannot be set by clients.
+func validateCondition(n *structs.Notification) error {
+	n.ConditionState = nil
+	if n.Hysteresis < 0 {
+		return errors.New("hysteresis must not be negative")
+	}
+	if !n.Subscribes(conditionEvent) {
+		if n.Condition != "" || n.Hysteresis != 0 {
+			return fmt.Errorf("condition and hysteresis can only be used with event %s", conditionEvent)
+		}
+		return nil
+	}
+	if n.Condition == "" {
+		return fmt.Errorf("event %s requires a condition", conditionEvent)
+	}
+	if strings.TrimSpace(n.Country) == "" {
+		return fmt.Errorf("event %s requires a country", conditionEvent)
+	}
+	_, err := parseCondition(n.Condition)
+	return err
+}
+
+// conditionChecker runs the evaluations of condition webhooks: all of them on a schedule,
+// and those of a country soon after its data has been fetched again from an external API.
+// Requests are ignored until it has been started.
+type conditionChecker struct {
+	mu       sync.Mutex
+	requests chan string
+	// sources maps the cache keys of weather data and exchange rates to the countries
+	// they were fetched for
+	sources map[string][]string
+	wg      sync.WaitGroup // done when the checker has stopped
+}
+
+// conditionChecks is the checker the services cache reports its refreshes to.
+var conditionChecks = &conditionChecker{}
+
+// StartConditionChecks evaluates all condition webhooks every 'interval', and those of a
+// country at most every CONDITION_REFRESH_SECONDS when its cached data is refreshed,
+// until 'ctx' ends.
+func StartConditionChecks(ctx context.Context, interval time.Duration) {
+	conditionChecks.start(ctx, interval)
+}
+
+func (c *conditionChecker) start(ctx context.Context, interval time.Duration) {
+	requests := make(chan string, constants.WEBHOOK_QUEUE_SIZE)
+	c.mu.Lock()
+	c.requests = requests
+	c.mu.Unlock()
+	services.CacheRefreshed = c.refreshed
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		checked := make(map[string]time.Time)
+		for {
+			select {
+			case <-ctx.Done():
+				c.mu.Lock()
+				c.requests = nil
+				c.mu.Unlock()
+				return
+			case now := <-ticker.C:
+				// Only recent checks matter for the rate limit, so the map stays small
+				for country, at := range checked {
+					if now.Sub(at) >= constants.CONDITION_REFRESH_SECONDS*time.Second {
+						delete(checked, country)
+					}
+				}
+				if _, err := evaluateConditions(ctx, nil); err != nil {
+					log.Printf("[Webhook] Could not evaluate conditions: %v\n", err)
+				}
+			case country := <-requests:
+				if time.Since(checked[country]) < constants.CONDITION_REFRESH_SECONDS*time.Second {
+					continue
+				}
+				checked[country] = time.Now()
+				if _, err := evaluateConditions(ctx, []string{country}); err != nil {
+					log.Printf("[Webhook] Could not evaluate the conditions of %s: %v\n", country, err)
+				}
+			}
+		}
+	}()
+}
+
+// watch remembers that the weather data and exchange rates of 'info' belong to the
+// country looked up as 'country', so that their refreshes can be traced back to it.
+func (c *conditionChecker) watch(country string, info *structs.CountryInfo) {
+	names := []string{strings.ToLower(country)}
+	if code := strings.ToLower(info.ISOCode); code != "" && code != names[0] {
+		names = append(names, code)
+	}
+	keys := []string{services.MeteoCacheKey(info.Coordinates.Lat, info.Coordinates.Lon)}
+	if info.BaseCurrency != "" {
+		keys = append(keys, services.CurrencyCacheKey(info.BaseCurrency))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sources == nil {
+		c.sources = make(map[string][]string)
+	}
+	for _, key := range keys {
+		for _, name := range names {
+			if !containsString(c.sources[key], name) {
+				c.sources[key] = append(c.sources[key], name)
+			}
+		}
+	}
+}
+
+// refreshed is called by the services cache when data was fetched from an external API.
+// It requests the conditions of the countries the data belongs to.
+func (c *conditionChecker) refreshed(key string, value interface{}) {
+	if info, ok := value.(*structs.CountryInfo); ok {
+		c.request(info.ISOCode)
+		return
+	}
+	c.mu.Lock()
+	countries := append([]string(nil), c.sources[key]...)
+	c.mu.Unlock()
+	c.request(countries...)
+}
+
+// request asks for the conditions of 'countries' to be evaluated, without waiting.
+func (c *conditionChecker) request(countries ...string) {
+	c.mu.Lock()
+	requests := c.requests
+	c.mu.Unlock()
+	if requests == nil {
+		return
+	}
+	for _, country := range countries {
+		if country == "" {
+			continue
+		}
+		select {
+		case requests <- strings.ToLower(country):
+		default:
+		}
+	}
+}
+
+// evaluateConditions evaluates the active condition webhooks of 'countries' (all if nil)
+// with fresh data, stores their state and queues a CONDITION event for those that fire.
+// It returns how many fired.
+func evaluateConditions(ctx context.Context, countries []string) (int, error) {
+	notifs, err := firebase.GetAllNotifications(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	byCountry := make(map[string][]structs.Notification)
+	for _, n := range notifs {
+		if !n.Subscribes(conditionEvent) || !n.Active() {
+			continue
+		}
+		key := strings.ToLower(n.Country)
+		if countries != nil && !containsString(countries, key) {
+			continue
+		}
+		byCountry[key] = append(byCountry[key], n)
+	}
+
+	src := memoizedUpstream()
+	fired := 0
+	for _, group := range byCountry {
+		conds := make([]condition, len(group))
+		var features structs.Features
+		for i, n := range group {
+			if conds[i], err = parseCondition(n.Condition); err != nil {
+				log.Printf("[Webhook] Skipping the condition of %s: %v\n", n.ID, err)
+				continue
+			}
+			conds[i].addFeatures(&features)
+		}
+		_, metrics := fetchCountryFeatures(src, group[0].Country, features)
+
+		now := time.Now()
+		for i, n := range group {
+			value, ok := metrics[conds[i].metric]
+			if conds[i].metric == "" || !ok {
+				continue
+			}
+			var state structs.ConditionState
+			if n.ConditionState != nil {
+				state = *n.ConditionState
+			}
+			next, fire := conds[i].evaluate(state, value, n.Hysteresis, now)
+			// The state is stored first, so a failure cannot make the condition fire twice
+			if err := firebase.SaveConditionState(ctx, n.ID, next); err != nil {
+				log.Printf("[Webhook] Could not store the condition state of %s: %v\n", n.ID, err)
+				continue
+			}
+			if fire {
+				queueConditionEvent(ctx, n, value, now)
+				fired++
+			}
+		}
+	}
+	return fired, nil
+}
+
+// queueConditionEvent queues the CONDITION event of a webhook whose condition fired.
+func queueConditionEvent(ctx context.Context, n structs.Notification, value float64, now time.Time) {
+	log.Printf("[Webhook] Condition '%s' of %s fired with value %v\n", n.Condition, n.ID, value)
+	delivery, err := newDelivery(n, structs.WebhookEvent{
+		Event: conditionEvent, Country: n.Country, Condition: n.Condition, Value: &value,
+	}, now)
+	if err != nil {
+		log.Printf("[Webhook] Could not build the %s event for %s: %v\n", conditionEvent, n.ID, err)
+		return
+	}
+	webhookDeliveries.enqueue(ctx, delivery)
+}
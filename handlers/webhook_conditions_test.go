@@ -0,0 +1,201 @@
+// File: assignment-2/handlers/webhook_conditions_test.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"assignment-2/constants"
+	"assignment-2/services"
+	"assignment-2/structs"
+)
+
+// TestParseCondition checks the accepted condition expressions.
+func TestParseCondition(t *testing.T) {
+	valid := map[string]condition{
+		"temperature < -10":                {metric: "temperature", kind: conditionThreshold, op: "<", amount: -10},
+		"population >= 5e6":                {metric: "population", kind: conditionThreshold, op: ">=", amount: 5e6},
+		"targetCurrencies.eur change > 2%": {metric: "targetCurrencies.EUR", kind: conditionChange, op: ">", amount: 2, percent: true},
+		"precipitation change >= 0.5":      {metric: "precipitation", kind: conditionChange, op: ">=", amount: 0.5},
+		"  population   changed ":          {metric: "population", kind: conditionChanged},
+	}
+	for expr, want := range valid {
+		got, err := parseCondition(expr)
+		if err != nil || got != want {
+			t.Errorf("%q: expected %+v, got %+v (%v)", expr, want, got, err)
+		}
+	}
+	invalid := []string{
+		"", "temperature", "temperature <", "humidity < 10", "distanceToCapital > 100",
+		"temperature == 3", "temperature < cold", "temperature < 3%", "temperature change < 2",
+		"temperature change > -2", "targetCurrencies.EURO changed", "population changed a lot",
+	}
+	for _, expr := range invalid {
+		if _, err := parseCondition(expr); err == nil {
+			t.Errorf("Expected %q to be rejected", expr)
+		}
+	}
+}
+
+// TestConditionEvaluate runs the conditions over a series of values.
+func TestConditionEvaluate(t *testing.T) {
+	run := func(expr string, hysteresis float64, values []float64) []bool {
+		c, err := parseCondition(expr)
+		if err != nil {
+			t.Fatalf("parseCondition(%q) failed: %v", expr, err)
+		}
+		var state structs.ConditionState
+		fired := make([]bool, len(values))
+		for i, v := range values {
+			state, fired[i] = c.evaluate(state, v, hysteresis, time.Unix(int64(i+1), 0))
+		}
+		return fired
+	}
+	check := func(name string, got, want []bool) {
+		t.Helper()
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: expected %v, got %v", name, want, got)
+				return
+			}
+		}
+	}
+
+	// Oscillating around -10 only fires again once the value was back above -8
+	check("threshold", run("temperature < -10", 2, []float64{-5, -11, -9, -11, -9.5, -12, -7, -10.5}),
+		[]bool{false, true, false, false, false, false, false, true})
+	check("threshold without hysteresis", run("temperature < -10", 0, []float64{-11, -10, -11}),
+		[]bool{true, false, true})
+	// Changes are measured from the value at the last notification
+	check("change", run("targetCurrencies.EUR change > 2%", 0, []float64{100, 101, 101.5, 102.5, 101, 100}),
+		[]bool{false, false, false, true, false, true})
+	check("changed", run("population changed", 0, []float64{10, 10, 11, 11, 10}),
+		[]bool{false, false, true, false, true})
+}
+
+// TestConditionWebhooks registers a condition webhook and evaluates it while the
+// temperature of its country moves around the threshold.
+func TestConditionWebhooks(t *testing.T) {
+	overrideStubs()
+	defer revertStubs()
+	overrideNotificationStubs()
+	defer revertNotificationStubs()
+
+	var mu sync.Mutex
+	temperature := 5.0
+	services.FetchMeteoData = func(lat, lon float64) (*structs.MeteoData, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return &structs.MeteoData{AverageTemp: temperature}, nil
+	}
+	setTemperature := func(v float64) {
+		mu.Lock()
+		temperature = v
+		mu.Unlock()
+	}
+
+	events := make(chan map[string]interface{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if echoChallenge(w, body) {
+			return
+		}
+		var payload map[string]interface{}
+		_ = json.Unmarshal(body, &payload)
+		events <- payload
+	}))
+	defer srv.Close()
+	startTestQueue(t, 1)
+
+	rr := httptest.NewRecorder()
+	NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH, strings.NewReader(
+		`{"url":"`+srv.URL+`","event":"CONDITION","country":"NO","condition":"temperature < 0","hysteresis":2}`)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d %s", rr.Code, rr.Body.String())
+	}
+	var created map[string]string
+	_ = json.Unmarshal(rr.Body.Bytes(), &created)
+
+	evaluate := func(v float64) int {
+		t.Helper()
+		setTemperature(v)
+		n, err := evaluateConditions(context.Background(), nil)
+		if err != nil {
+			t.Fatalf("evaluateConditions failed: %v", err)
+		}
+		waitForEmptyQueue(t)
+		return n
+	}
+	fired := 0
+	for _, v := range []float64{5, -1, 1, -3, 2.5, -0.5} {
+		fired += evaluate(v)
+	}
+	if fired != 2 || len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d fired and %d received", fired, len(events))
+	}
+	first, second := <-events, <-events
+	if first["event"] != "CONDITION" || first["condition"] != "temperature < 0" || first["value"] != -1.0 || first["webhookId"] != created["id"] {
+		t.Errorf("Unexpected payload: %v", first)
+	}
+	if second["value"] != -0.5 {
+		t.Errorf("Expected the second event after the value was back above 2, got %v", second)
+	}
+
+	rr = httptest.NewRecorder()
+	NotificationsRouter(rr, httptest.NewRequest(http.MethodGet, constants.NOTIFICATIONS_PATH+created["id"], nil))
+	var notif structs.Notification
+	_ = json.Unmarshal(rr.Body.Bytes(), &notif)
+	if s := notif.ConditionState; s == nil || !s.Triggered || s.Value != -0.5 || s.Fired.IsZero() {
+		t.Errorf("Expected the stored condition state, got %s", rr.Body.String())
+	}
+
+	t.Run("CacheRefresh", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		conditionChecks.start(ctx, time.Hour)
+		defer func() { services.CacheRefreshed = nil }()
+		defer conditionChecks.wg.Wait()
+		defer cancel()
+
+		evaluate(3) // re-arm; this also maps Norway's weather data to it
+		setTemperature(-4)
+		info, err := services.FetchCountryInfo("NO")
+		if err != nil {
+			t.Fatalf("Could not fetch the stub country: %v", err)
+		}
+		// The services cache reports that Norway's weather data was fetched again
+		services.CacheRefreshed(services.MeteoCacheKey(info.Coordinates.Lat, info.Coordinates.Lon), &structs.MeteoData{AverageTemp: -4})
+		select {
+		case payload := <-events:
+			waitForEmptyQueue(t)
+			if payload["value"] != -4.0 {
+				t.Errorf("Expected the refreshed value, got %v", payload)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected the cache refresh to evaluate the condition")
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		bodies := []string{
+			`{"url":"` + srv.URL + `","event":"CONDITION","country":"NO"}`,
+			`{"url":"` + srv.URL + `","event":"CONDITION","condition":"temperature < 0"}`,
+			`{"url":"` + srv.URL + `","event":"CONDITION","country":"NO","condition":"humidity > 90"}`,
+			`{"url":"` + srv.URL + `","event":"CONDITION","country":"NO","condition":"temperature < 0","hysteresis":-1}`,
+			`{"url":"` + srv.URL + `","event":"REGISTER","condition":"temperature < 0"}`,
+		}
+		for _, body := range bodies {
+			rr := httptest.NewRecorder()
+			NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH, strings.NewReader(body)))
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected 400, got %d", body, rr.Code)
+			}
+		}
+	})
+}
@@ -0,0 +1,129 @@
+// File: assignment-2/handlers/webhook_dead_letters.go
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"assignment-2/firebase"
+	"assignment-2/structs"
+	"assignment-2/tools"
+)
+
+// deadLetterListSpec lists the query parameters accepted by GET {id}/dead-letters
+var deadLetterListSpec = listSpec{
+	filters:    map[string]string{"country": "country", "event": "event"},
+	sortable:   []string{"failed"},
+	rangeField: "failed",
+}
+
+// handleListDeadLetters returns one page of the dead letters of a webhook.
+func handleListDeadLetters(w http.ResponseWriter, r *http.Request, id string) {
+	opts, err := parseListOptions(r.URL.Query(), deadLetterListSpec)
+	if err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx := context.Background()
+	if _, err := firebase.GetNotificationByID(ctx, id); err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+	if opts.Equals == nil {
+		opts.Equals = make(map[string]string)
+	}
+	opts.Equals["notificationId"] = id
+
+	letters, next, err := firebase.ListDeadLetters(ctx, opts)
+	if errors.Is(err, firebase.ErrInvalidCursor) {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+	if err != nil {
+		log.Printf("Error listing dead letters of %s: %v\n", id, err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not retrieve dead letters")
+		return
+	}
+	setNextLink(w, r, next)
+	tools.WriteJsonResponse(w, http.StatusOK, letters)
+}
+
+// handleReplayDeadLetters queues every dead letter of a webhook again.
+func handleReplayDeadLetters(w http.ResponseWriter, id string) {
+	ctx := context.Background()
+	notif, err := firebase.GetNotificationByID(ctx, id)
+	if err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+
+	replayed := 0
+	opts := structs.ListOptions{Limit: structs.MaxPageSize, Equals: map[string]string{"notificationId": id}}
+	for {
+		letters, next, err := firebase.ListDeadLetters(ctx, opts)
+		if err != nil {
+			log.Printf("Error listing dead letters of %s: %v\n", id, err)
+			tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not replay dead letters")
+			return
+		}
+		for _, letter := range letters {
+			if err := replayDeadLetter(ctx, *notif, letter); err != nil {
+				log.Printf("Error replaying dead letter %s: %v\n", letter.ID, err)
+				continue
+			}
+			replayed++
+		}
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+	}
+	tools.WriteJsonResponse(w, http.StatusAccepted, map[string]int{"replayed": replayed})
+}
+
+// handleReplayDeadLetter queues a single dead letter of a webhook again.
+func handleReplayDeadLetter(w http.ResponseWriter, id, letterID string) {
+	ctx := context.Background()
+	notif, err := firebase.GetNotificationByID(ctx, id)
+	if err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+	letter, err := firebase.GetDeadLetter(ctx, letterID)
+	if err == nil && letter.NotificationID != id {
+		err = firebase.ErrDeadLetterNotFound
+	}
+	if err == nil {
+		err = replayDeadLetter(ctx, *notif, *letter)
+	}
+	switch {
+	case errors.Is(err, firebase.ErrDeadLetterNotFound):
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Dead letter not found")
+		return
+	case err != nil:
+		log.Printf("Error replaying dead letter %s: %v\n", letterID, err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not replay dead letter")
+		return
+	}
+	tools.WriteJsonResponse(w, http.StatusAccepted, map[string]string{"id": letterID})
+}
+
+// replayDeadLetter moves a dead letter back to the queue with fresh attempts. It is sent
+// to the current URL of the webhook, with its current retry policy, and keeps the
+// original payload.
+func replayDeadLetter(ctx context.Context, notif structs.Notification, letter structs.WebhookDelivery) error {
+	letter.URL = notif.URL
+	letter.Retry = retryPolicyOf(notif)
+	letter.Attempts = 0
+	letter.LastError = ""
+	letter.Failed = time.Time{}
+	letter.NextAttempt = time.Now()
+	if err := firebase.RequeueDeadLetter(ctx, letter); err != nil {
+		return err
+	}
+	webhookDeliveries.submit(letter)
+	return nil
+}
@@ -0,0 +1,133 @@
+// File: assignment-2/handlers/webhook_dead_letters_test.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"assignment-2/constants"
+	"assignment-2/firebase"
+	"assignment-2/structs"
+)
+
+// TestDeadLetters lets the deliveries of a webhook run out of attempts, lists them as dead
+// letters and replays them once the receiver is back.
+func TestDeadLetters(t *testing.T) {
+	overrideNotificationStubs()
+	defer revertNotificationStubs()
+	shortRetryDelays(t)
+
+	var mu sync.Mutex
+	up, received := false, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !up {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		received++
+	}))
+	defer srv.Close()
+
+	id, _ := firebase.SaveNotification(context.Background(), structs.Notification{
+		URL: srv.URL, Event: "REGISTER", Retry: &structs.RetryPolicy{MaxAttempts: 2},
+	})
+	startTestQueue(t, 2)
+	TriggerWebhookEventVar(structs.WebhookEvent{Event: "REGISTER", Country: "NO"})
+	TriggerWebhookEventVar(structs.WebhookEvent{Event: "REGISTER", Country: "SE"})
+	waitForEmptyQueue(t)
+
+	lettersPath := constants.NOTIFICATIONS_PATH + id + "/dead-letters"
+	rr := httptest.NewRecorder()
+	NotificationsRouter(rr, httptest.NewRequest(http.MethodGet, lettersPath, nil))
+	var letters []structs.WebhookDelivery
+	_ = json.Unmarshal(rr.Body.Bytes(), &letters)
+	if rr.Code != http.StatusOK || len(letters) != 2 {
+		t.Fatalf("Expected 2 dead letters, got %d %s", rr.Code, rr.Body.String())
+	}
+	if l := letters[0]; l.Attempts != 2 || l.LastError != "webhook responded 502" || l.Failed.IsZero() || l.NotificationID != id {
+		t.Errorf("Expected the failure to be recorded, got %+v", l)
+	}
+
+	mu.Lock()
+	up = true
+	mu.Unlock()
+
+	t.Run("ReplayOne", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, lettersPath+"/"+letters[0].ID+"/replay", nil))
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("Expected 202, got %d %s", rr.Code, rr.Body.String())
+		}
+		waitForEmptyQueue(t)
+		mu.Lock()
+		defer mu.Unlock()
+		if received != 1 {
+			t.Errorf("Expected 1 delivery, got %d", received)
+		}
+	})
+
+	t.Run("ReplayAll", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, lettersPath+"/replay", nil))
+		var resp map[string]int
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		if rr.Code != http.StatusAccepted || resp["replayed"] != 1 {
+			t.Fatalf("Expected 1 replayed dead letter, got %d %s", rr.Code, rr.Body.String())
+		}
+		waitForEmptyQueue(t)
+		mu.Lock()
+		defer mu.Unlock()
+		if received != 2 {
+			t.Errorf("Expected 2 deliveries, got %d", received)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		paths := []string{
+			lettersPath + "/" + letters[0].ID + "/replay",         // already replayed
+			constants.NOTIFICATIONS_PATH + "missing/dead-letters", // unknown webhook
+			constants.NOTIFICATIONS_PATH + id + "/unknown",
+		}
+		for _, path := range paths {
+			method := http.MethodPost
+			if path == paths[1] {
+				method = http.MethodGet
+			}
+			rr := httptest.NewRecorder()
+			NotificationsRouter(rr, httptest.NewRequest(method, path, nil))
+			if rr.Code != http.StatusNotFound {
+				t.Errorf("%s %s: expected 404, got %d", method, path, rr.Code)
+			}
+		}
+	})
+}
+
+// TestValidateRetryPolicy checks the accepted retry policies and the defaults.
+func TestValidateRetryPolicy(t *testing.T) {
+	valid := []*structs.RetryPolicy{nil, {MaxAttempts: 1}, {MaxAttempts: 10, InitialBackoffSeconds: 5, MaxBackoffSeconds: 60}}
+	for _, p := range valid {
+		if err := validateRetryPolicy(p); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", p, err)
+		}
+	}
+	invalid := []*structs.RetryPolicy{{}, {MaxAttempts: 21}, {MaxAttempts: 3, InitialBackoffSeconds: -1}, {MaxAttempts: 3, InitialBackoffSeconds: 60, MaxBackoffSeconds: 10}}
+	for _, p := range invalid {
+		if err := validateRetryPolicy(p); err == nil {
+			t.Errorf("Expected %+v to be rejected", p)
+		}
+	}
+
+	p := retryPolicyOf(structs.Notification{Retry: &structs.RetryPolicy{MaxAttempts: 2, InitialBackoffSeconds: 7200}})
+	if p.MaxAttempts != 2 || p.InitialBackoffSeconds != 7200 || p.MaxBackoffSeconds != 7200 {
+		t.Errorf("Expected the maximum backoff to follow the initial one, got %+v", p)
+	}
+	if p := retryPolicyOf(structs.Notification{}); p.MaxAttempts != constants.WEBHOOK_MAX_ATTEMPTS {
+		t.Errorf("Expected the default policy, got %+v", p)
+	}
+}
@@ -0,0 +1,54 @@
+// File: assignment-2/handlers/webhook_deliveries.go
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"assignment-2/firebase"
+	"assignment-2/tools"
+)
+
+// deliveryListSpec lists the query parameters accepted by GET {id}/deliveries
+var deliveryListSpec = listSpec{
+	filters:    map[string]string{"country": "country", "event": "event", "eventId": "eventId", "deliveryId": "deliveryId"},
+	sortable:   []string{"time"},
+	rangeField: "time",
+}
+
+// handleListDeliveries returns one page of the delivery log of a webhook. Without
+// ?sort= the newest attempts come first.
+func handleListDeliveries(w http.ResponseWriter, r *http.Request, id string) {
+	opts, err := parseListOptions(r.URL.Query(), deliveryListSpec)
+	if err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if opts.OrderBy == "" {
+		opts.OrderBy, opts.Descending = "time", true
+	}
+	ctx := context.Background()
+	if _, err := firebase.GetNotificationByID(ctx, id); err != nil {
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+	if opts.Equals == nil {
+		opts.Equals = make(map[string]string)
+	}
+	opts.Equals["notificationId"] = id
+
+	attempts, next, err := firebase.ListDeliveryAttempts(ctx, opts)
+	if errors.Is(err, firebase.ErrInvalidCursor) {
+		tools.WriteJsonErrorResponse(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+	if err != nil {
+		log.Printf("Error listing deliveries of %s: %v\n", id, err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not retrieve deliveries")
+		return
+	}
+	setNextLink(w, r, next)
+	tools.WriteJsonResponse(w, http.StatusOK, attempts)
+}
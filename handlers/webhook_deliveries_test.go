@@ -0,0 +1,155 @@
+// File: assignment-2/handlers/webhook_deliveries_test.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"assignment-2/constants"
+	"assignment-2/firebase"
+	"assignment-2/structs"
+)
+
+// In-memory stand-in for the delivery log
+var (
+	attemptMutex sync.Mutex
+	attemptStore []structs.DeliveryAttempt
+)
+
+var (
+	origSaveDeliveryAttempt  = firebase.SaveDeliveryAttempt
+	origListDeliveryAttempts = firebase.ListDeliveryAttempts
+	origGetDeliverySummary   = firebase.GetDeliverySummary
+)
+
+// overrideDeliveryLogStubs replaces the delivery log with an in-memory slice.
+func overrideDeliveryLogStubs() {
+	attemptStore = nil
+
+	firebase.SaveDeliveryAttempt = func(ctx context.Context, a structs.DeliveryAttempt) error {
+		attemptMutex.Lock()
+		defer attemptMutex.Unlock()
+		attemptStore = append(attemptStore, a)
+		return nil
+	}
+	firebase.ListDeliveryAttempts = func(ctx context.Context, opts structs.ListOptions) ([]structs.DeliveryAttempt, string, error) {
+		attemptMutex.Lock()
+		defer attemptMutex.Unlock()
+		var page []structs.DeliveryAttempt
+		for _, a := range attemptStore {
+			if a.NotificationID == opts.Equals["notificationId"] {
+				page = append(page, a)
+			}
+		}
+		sort.SliceStable(page, func(i, j int) bool {
+			if opts.Descending {
+				return page[i].Time.After(page[j].Time)
+			}
+			return page[i].Time.Before(page[j].Time)
+		})
+		return page, "", nil
+	}
+	firebase.GetDeliverySummary = func(ctx context.Context, notificationID string) (*structs.DeliverySummary, error) {
+		attemptMutex.Lock()
+		defer attemptMutex.Unlock()
+		summary := &structs.DeliverySummary{}
+		for _, a := range attemptStore {
+			if a.NotificationID != notificationID {
+				continue
+			}
+			summary.Attempts++
+			if a.Success {
+				summary.Successes++
+			}
+			if summary.LastAttempt == nil || a.Time.After(summary.LastAttempt.Time) {
+				last := a
+				last.Payload = nil
+				summary.LastAttempt = &last
+			}
+		}
+		if summary.Attempts > 0 {
+			summary.SuccessRate = float64(summary.Successes) / float64(summary.Attempts)
+		}
+		return summary, nil
+	}
+}
+
+func revertDeliveryLogStubs() {
+	firebase.SaveDeliveryAttempt = origSaveDeliveryAttempt
+	firebase.ListDeliveryAttempts = origListDeliveryAttempts
+	firebase.GetDeliverySummary = origGetDeliverySummary
+}
+
+// TestDeliveryLog delivers an event that succeeds on the second attempt and reads the
+// log and the summary of the webhook.
+func TestDeliveryLog(t *testing.T) {
+	overrideNotificationStubs()
+	defer revertNotificationStubs()
+	shortRetryDelays(t)
+
+	var mu sync.Mutex
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	id, _ := firebase.SaveNotification(context.Background(), structs.Notification{URL: srv.URL, Event: "CHANGE"})
+	startTestQueue(t, 1)
+	TriggerWebhookEventVar(structs.WebhookEvent{Event: "CHANGE", Country: "NO"})
+	waitForEmptyQueue(t)
+
+	t.Run("Deliveries", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, httptest.NewRequest(http.MethodGet, constants.NOTIFICATIONS_PATH+id+"/deliveries", nil))
+		var attempts []structs.DeliveryAttempt
+		_ = json.Unmarshal(rr.Body.Bytes(), &attempts)
+		if rr.Code != http.StatusOK || len(attempts) != 2 {
+			t.Fatalf("Expected 2 attempts, got %d %s", rr.Code, rr.Body.String())
+		}
+		last, first := attempts[0], attempts[1]
+		if !last.Success || last.Attempt != 2 || last.StatusCode != http.StatusOK || last.Event != "CHANGE" || last.Country != "NO" {
+			t.Errorf("Expected the successful second attempt first, got %+v", last)
+		}
+		if first.Success || first.StatusCode != http.StatusInternalServerError || first.Error != "webhook responded 500" || len(first.Payload) == 0 {
+			t.Errorf("Expected the failed first attempt with its payload, got %+v", first)
+		}
+	})
+
+	t.Run("Summary", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, httptest.NewRequest(http.MethodGet, constants.NOTIFICATIONS_PATH+id, nil))
+		var notif structs.Notification
+		_ = json.Unmarshal(rr.Body.Bytes(), &notif)
+		s := notif.Deliveries
+		if s == nil || s.Attempts != 2 || s.Successes != 1 || s.SuccessRate != 0.5 {
+			t.Fatalf("Expected 1 of 2 successful attempts, got %s", rr.Body.String())
+		}
+		if s.LastAttempt == nil || !s.LastAttempt.Success || s.LastAttempt.Payload != nil {
+			t.Errorf("Expected the last attempt without payload, got %+v", s.LastAttempt)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, httptest.NewRequest(http.MethodGet, constants.NOTIFICATIONS_PATH+"missing/deliveries", nil))
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for an unknown webhook, got %d", rr.Code)
+		}
+		rr = httptest.NewRecorder()
+		NotificationsRouter(rr, httptest.NewRequest(http.MethodGet, constants.NOTIFICATIONS_PATH+id+"/deliveries?sort=url", nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an unknown sort field, got %d", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,202 @@
+// File: assignment-2/handlers/webhook_payload.go
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"assignment-2/constants"
+	"assignment-2/structs"
+)
+
+// payloadFormats are the formats a webhook can receive its payloads in.
+var payloadFormats = []string{structs.FormatJSON, structs.FormatCloudEvents, structs.FormatCloudEventsBinary}
+
+// cloudEvent is a CloudEvents 1.0 event in structured mode. Its data is the version 2
+// payload.
+type cloudEvent struct {
+	SpecVersion     string                 `json:"specversion"`
+	ID              string                 `json:"id"`
+	Source          string                 `json:"source"`
+	Type            string                 `json:"type"`
+	Subject         string                 `json:"subject,omitempty"`
+	Time            string                 `json:"time"`
+	DataContentType string                 `json:"datacontenttype"`
+	Data            structs.WebhookPayload `json:"data"`
+}
+
+// validatePayloadOptions checks the payload version and format of a webhook. Webhooks
+// without a version get the current one; CloudEvents and data snapshots need version 2.
+func validatePayloadOptions(n *structs.Notification) error {
+	if n.PayloadVersion == 0 {
+		n.PayloadVersion = constants.WEBHOOK_PAYLOAD_VERSION
+	}
+	if n.PayloadVersion != 1 && n.PayloadVersion != 2 {
+		return errors.New("payloadVersion must be 1 or 2")
+	}
+	n.Format = strings.ToLower(strings.TrimSpace(n.Format))
+	if n.Format == "" {
+		n.Format = structs.FormatJSON
+	}
+	if !containsString(payloadFormats, n.Format) {
+		return fmt.Errorf("unknown format '%s', use one of %s", n.Format, strings.Join(payloadFormats, ", "))
+	}
+	if n.PayloadVersion == 1 && (n.Format != structs.FormatJSON || n.IncludeData) {
+		return errors.New("CloudEvents formats and includeData require payloadVersion 2")
+	}
+	return nil
+}
+
+// newEventID returns a random event ID.
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "evt_" + hex.EncodeToString(b), nil
+}
+
+// eventSubject returns the resource an event is about.
+func eventSubject(ev structs.WebhookEvent) structs.WebhookSubject {
+	switch {
+	case len(ev.Registrations) == 1:
+		return structs.WebhookSubject{Type: "registration", ID: ev.Registrations[0], Country: ev.Country}
+	case len(ev.Registrations) > 1:
+		return structs.WebhookSubject{Type: "registrations", IDs: ev.Registrations, Country: ev.Country}
+	}
+	return structs.WebhookSubject{Type: "country", ID: ev.Country, Country: ev.Country}
+}
+
+// legacyPayload returns the version 1 payload of an event for webhook 'id': a flat object
+// with the time in "20060102 15:04" format.
+func legacyPayload(id string, ev structs.WebhookEvent, now time.Time) map[string]interface{} {
+	payload := map[string]interface{}{
+		"id":      id,
+		"country": ev.Country,
+		"event":   ev.Event,
+		"time":    now.Format("20060102 15:04"),
+	}
+	switch {
+	case len(ev.Registrations) == 1:
+		payload["registrationId"] = ev.Registrations[0]
+	case len(ev.Registrations) > 1:
+		payload["registrationIds"] = ev.Registrations
+	}
+	if len(ev.Changes) > 0 {
+		payload["changes"] = ev.Changes
+	}
+	if ev.Condition != "" {
+		payload["condition"] = ev.Condition
+	}
+	if ev.Value != nil {
+		payload["value"] = *ev.Value
+	}
+	if ev.Test {
+		payload["test"] = true
+	}
+	return payload
+}
+
+// eventPayload returns the version 2 payload of an event for webhook 'n'.
+func eventPayload(n structs.Notification, ev structs.WebhookEvent, eventID string, now time.Time) structs.WebhookPayload {
+	payload := structs.WebhookPayload{
+		SchemaVersion: 2,
+		EventID:       eventID,
+		Event:         ev.Event,
+		Time:          now.UTC(),
+		WebhookID:     n.ID,
+		Subject:       eventSubject(ev),
+		Changes:       ev.Changes,
+		Condition:     ev.Condition,
+		Value:         ev.Value,
+		Test:          ev.Test,
+	}
+	if n.IncludeData {
+		payload.Data = ev.Data
+	}
+	return payload
+}
+
+// encodeEvent returns the body and headers POSTed to webhook 'n' for an event at 'now', in
+// the payload version and format of the webhook, and the event ID (empty for version 1).
+func encodeEvent(n structs.Notification, ev structs.WebhookEvent, now time.Time) ([]byte, map[string]string, string, error) {
+	if n.Schema() == 1 {
+		body, err := json.Marshal(legacyPayload(n.ID, ev, now))
+		return body, nil, "", err
+	}
+	eventID, err := newEventID()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	payload := eventPayload(n, ev, eventID, now)
+	ce := cloudEvent{
+		SpecVersion:     constants.CLOUDEVENTS_SPEC_VERSION,
+		ID:              eventID,
+		Source:          constants.NOTIFICATIONS_PATH + n.ID,
+		Type:            constants.CLOUDEVENTS_TYPE_PREFIX + strings.ToLower(ev.Event),
+		Subject:         cloudEventSubject(payload.Subject),
+		Time:            payload.Time.Format(time.RFC3339Nano),
+		DataContentType: constants.CONTENT_TYPE_JSON,
+		Data:            payload,
+	}
+
+	switch n.Format {
+	case structs.FormatCloudEvents:
+		body, err := json.Marshal(ce)
+		return body, map[string]string{"Content-Type": constants.CONTENT_TYPE_CLOUDEVENTS}, eventID, err
+	case structs.FormatCloudEventsBinary:
+		// The attributes travel as ce-* headers and the body is the plain payload
+		headers := map[string]string{
+			"Content-Type":   ce.DataContentType,
+			"ce-specversion": ce.SpecVersion,
+			"ce-id":          ce.ID,
+			"ce-source":      ce.Source,
+			"ce-type":        ce.Type,
+			"ce-time":        ce.Time,
+		}
+		if ce.Subject != "" {
+			headers["ce-subject"] = ce.Subject
+		}
+		body, err := json.Marshal(payload)
+		return body, headers, eventID, err
+	}
+	body, err := json.Marshal(payload)
+	return body, nil, eventID, err
+}
+
+// cloudEventSubject returns the CloudEvents subject of an event, such as "registrations/abc"
+// or "countries/NO". Events about several registrations have none.
+func cloudEventSubject(s structs.WebhookSubject) string {
+	switch {
+	case s.Type == "registration":
+		return "registrations/" + s.ID
+	case s.Type == "country" && s.ID != "":
+		return "countries/" + s.ID
+	}
+	return ""
+}
+
+// newDelivery returns the queued delivery of an event at 'now' to webhook 'n'.
+func newDelivery(n structs.Notification, ev structs.WebhookEvent, now time.Time) (structs.WebhookDelivery, error) {
+	body, headers, eventID, err := encodeEvent(n, ev, now)
+	if err != nil {
+		return structs.WebhookDelivery{}, err
+	}
+	return structs.WebhookDelivery{
+		NotificationID: n.ID,
+		URL:            n.URL,
+		Event:          ev.Event,
+		EventID:        eventID,
+		Country:        ev.Country,
+		Payload:        body,
+		Headers:        headers,
+		Enqueued:       now,
+		NextAttempt:    now,
+		Retry:          retryPolicyOf(n),
+	}, nil
+}
@@ -0,0 +1,191 @@
+// File: assignment-2/handlers/webhook_payload_test.go
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"assignment-2/constants"
+	"assignment-2/structs"
+)
+
+// TestValidatePayloadOptions checks the defaults and combinations of payload options.
+func TestValidatePayloadOptions(t *testing.T) {
+	n := structs.Notification{Format: " CloudEvents "}
+	if err := validatePayloadOptions(&n); err != nil || n.PayloadVersion != 2 || n.Format != structs.FormatCloudEvents {
+		t.Errorf("Expected version 2 in structured mode, got %+v (%v)", n, err)
+	}
+	n = structs.Notification{PayloadVersion: 1}
+	if err := validatePayloadOptions(&n); err != nil || n.Format != structs.FormatJSON {
+		t.Errorf("Expected version 1 as JSON, got %+v (%v)", n, err)
+	}
+	invalid := []structs.Notification{
+		{PayloadVersion: 3},
+		{Format: "xml"},
+		{PayloadVersion: 1, Format: structs.FormatCloudEventsBinary},
+		{PayloadVersion: 1, IncludeData: true},
+	}
+	for _, n := range invalid {
+		if err := validatePayloadOptions(&n); err == nil {
+			t.Errorf("Expected %+v to be rejected", n)
+		}
+	}
+}
+
+// TestEncodeEvent encodes a CHANGE event in every payload version and format.
+func TestEncodeEvent(t *testing.T) {
+	reg := structs.Registration{ID: "reg-1", Country: "Norway", ISOCode: "NO"}
+	ev := registrationEvent("CHANGE", reg)
+	ev.Country = "NO"
+	ev.Changes = json.RawMessage(`[{"op":"add","path":"/tags","value":["ops"]}]`)
+	now := time.Date(2025, 4, 10, 12, 25, 30, 0, time.FixedZone("CEST", 7200))
+
+	t.Run("Version1", func(t *testing.T) {
+		body, headers, eventID, err := encodeEvent(structs.Notification{ID: "notif-1"}, ev, now)
+		var payload map[string]interface{}
+		_ = json.Unmarshal(body, &payload)
+		if err != nil || headers != nil || eventID != "" || payload["id"] != "notif-1" ||
+			payload["time"] != "20250410 12:25" || payload["registrationId"] != "reg-1" {
+			t.Errorf("Expected the legacy payload, got %s %v (%v)", body, headers, err)
+		}
+	})
+
+	t.Run("Version2", func(t *testing.T) {
+		n := structs.Notification{ID: "notif-1", PayloadVersion: 2, IncludeData: true}
+		body, headers, eventID, err := encodeEvent(n, ev, now)
+		var payload structs.WebhookPayload
+		if err != nil || json.Unmarshal(body, &payload) != nil || headers != nil {
+			t.Fatalf("Expected a JSON payload, got %s %v (%v)", body, headers, err)
+		}
+		if payload.SchemaVersion != 2 || payload.EventID != eventID || !strings.HasPrefix(eventID, "evt_") ||
+			payload.WebhookID != "notif-1" || payload.Event != "CHANGE" || len(payload.Changes) == 0 {
+			t.Errorf("Unexpected payload %s", body)
+		}
+		if !strings.Contains(string(body), `"time":"2025-04-10T10:25:30Z"`) {
+			t.Errorf("Expected an RFC 3339 time in UTC, got %s", body)
+		}
+		if want := (structs.WebhookSubject{Type: "registration", ID: "reg-1", Country: "NO"}); payload.Subject.Type != want.Type ||
+			payload.Subject.ID != want.ID || payload.Subject.Country != want.Country {
+			t.Errorf("Expected subject %+v, got %+v", want, payload.Subject)
+		}
+		if payload.Data == nil || payload.Data.ID != "reg-1" {
+			t.Errorf("Expected the registration as data, got %+v", payload.Data)
+		}
+
+		n.IncludeData = false
+		body, _, second, _ := encodeEvent(n, ev, now)
+		if strings.Contains(string(body), `"data"`) || second == eventID {
+			t.Errorf("Expected a new event ID and no data, got %s", body)
+		}
+	})
+
+	t.Run("CloudEventsStructured", func(t *testing.T) {
+		n := structs.Notification{ID: "notif-1", PayloadVersion: 2, Format: structs.FormatCloudEvents}
+		body, headers, eventID, err := encodeEvent(n, ev, now)
+		var ce cloudEvent
+		if err != nil || json.Unmarshal(body, &ce) != nil || headers["Content-Type"] != constants.CONTENT_TYPE_CLOUDEVENTS {
+			t.Fatalf("Expected a structured CloudEvent, got %s %v (%v)", body, headers, err)
+		}
+		if ce.SpecVersion != "1.0" || ce.ID != eventID || ce.Type != "dashboard.v1.change" || ce.Subject != "registrations/reg-1" ||
+			ce.Source != constants.NOTIFICATIONS_PATH+"notif-1" || ce.Time != "2025-04-10T10:25:30Z" ||
+			ce.DataContentType != constants.CONTENT_TYPE_JSON || ce.Data.EventID != eventID {
+			t.Errorf("Unexpected CloudEvent %s", body)
+		}
+	})
+
+	t.Run("CloudEventsBinary", func(t *testing.T) {
+		n := structs.Notification{ID: "notif-1", PayloadVersion: 2, Format: structs.FormatCloudEventsBinary}
+		bulk := structs.WebhookEvent{Event: "REGISTER", Country: "SE", Registrations: []string{"a", "b"}}
+		body, headers, eventID, err := encodeEvent(n, bulk, now)
+		var payload structs.WebhookPayload
+		if err != nil || json.Unmarshal(body, &payload) != nil || payload.Subject.Type != "registrations" || len(payload.Subject.IDs) != 2 {
+			t.Fatalf("Expected the payload as body, got %s (%v)", body, err)
+		}
+		want := map[string]string{
+			"Content-Type": constants.CONTENT_TYPE_JSON, "ce-specversion": "1.0", "ce-id": eventID,
+			"ce-source": constants.NOTIFICATIONS_PATH + "notif-1", "ce-type": "dashboard.v1.register", "ce-time": "2025-04-10T10:25:30Z",
+		}
+		for name, value := range want {
+			if headers[name] != value {
+				t.Errorf("Expected %s: %s, got %q", name, value, headers[name])
+			}
+		}
+		if _, ok := headers["ce-subject"]; ok {
+			t.Errorf("Expected no subject for several registrations, got %v", headers)
+		}
+	})
+}
+
+// TestCloudEventsDelivery registers a webhook in binary mode and checks that a retried
+// delivery keeps its event ID and is signed over the body.
+func TestCloudEventsDelivery(t *testing.T) {
+	overrideNotificationStubs()
+	defer revertNotificationStubs()
+	shortRetryDelays(t)
+
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+	var mu sync.Mutex
+	var requests []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if echoChallenge(w, body) {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, request{r.Header.Clone(), body})
+		if len(requests) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	rr := httptest.NewRecorder()
+	NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH, strings.NewReader(
+		`{"url":"`+srv.URL+`","event":"DELETE","format":"cloudevents-binary","includeData":true}`)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d %s", rr.Code, rr.Body.String())
+	}
+	var created map[string]string
+	_ = json.Unmarshal(rr.Body.Bytes(), &created)
+
+	startTestQueue(t, 1)
+	TriggerWebhookEventVar(registrationEvent("DELETE", structs.Registration{ID: "reg-9", ISOCode: "NO"}))
+	waitForEmptyQueue(t)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 2 {
+		t.Fatalf("Expected a failed and a retried request, got %d", len(requests))
+	}
+	first, second := requests[0], requests[1]
+	if id := first.header.Get("ce-id"); id == "" || id != second.header.Get("ce-id") || string(first.body) != string(second.body) {
+		t.Errorf("Expected the retry to repeat the event, got %q and %q", id, second.header.Get("ce-id"))
+	}
+	if second.header.Get("ce-subject") != "registrations/reg-9" || second.header.Get("Content-Type") != constants.CONTENT_TYPE_JSON {
+		t.Errorf("Unexpected headers %v", second.header)
+	}
+	var payload structs.WebhookPayload
+	_ = json.Unmarshal(second.body, &payload)
+	if payload.EventID != second.header.Get("ce-id") || payload.WebhookID != created["id"] || payload.Data == nil || payload.Data.ID != "reg-9" {
+		t.Errorf("Unexpected payload %s", second.body)
+	}
+	if ts, err := time.Parse(time.RFC3339, second.header.Get("ce-time")); err != nil || ts.IsZero() {
+		t.Errorf("Expected an RFC 3339 ce-time, got %q", second.header.Get("ce-time"))
+	}
+	unix, _ := strconv.ParseInt(second.header.Get(constants.WEBHOOK_TIMESTAMP_HEADER), 10, 64)
+	secrets := []structs.WebhookSecret{{Value: created["secret"]}}
+	if sig := signatureHeader(secrets, time.Unix(unix, 0), second.body); sig != second.header.Get(constants.WEBHOOK_SIGNATURE_HEADER) {
+		t.Errorf("Expected the body to be signed, got %q", second.header.Get(constants.WEBHOOK_SIGNATURE_HEADER))
+	}
+}
@@ -0,0 +1,41 @@
+// File: assignment-2/handlers/webhook_policy.go
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"assignment-2/constants"
+	"assignment-2/tools"
+)
+
+// webhookPolicy decides which URLs webhooks may target. URLs are checked when a webhook
+// is registered and again before every request, and webhookClient checks the address it
+// connects to.
+var webhookPolicy = defaultWebhookPolicy()
+
+// defaultWebhookPolicy returns the policy used until ConfigureWebhookPolicy is called. It
+// panics if the built-in settings are invalid, so a broken default cannot leave webhooks
+// without a policy.
+func defaultWebhookPolicy() *tools.URLPolicy {
+	policy, err := tools.NewURLPolicy(strings.Split(constants.WEBHOOK_ALLOWED_SCHEMES, ","), nil, nil)
+	if err != nil {
+		panic(fmt.Sprintf("invalid default webhook URL policy: %v", err))
+	}
+	return policy
+}
+
+// ConfigureWebhookPolicy replaces the URL policy of webhooks: the allowed URL schemes and
+// the host names, addresses and CIDR ranges that are always allowed or always denied.
+// Private and reserved addresses are denied unless they are allowed explicitly. It must
+// be called before the webhook queue is started.
+func ConfigureWebhookPolicy(schemes, allow, deny []string) error {
+	policy, err := tools.NewURLPolicy(schemes, allow, deny)
+	if err != nil {
+		return err
+	}
+	webhookPolicy = policy
+	webhookClient = policy.Client(constants.WEBHOOK_TIMEOUT_SECONDS*time.Second, constants.WEBHOOK_MAX_REDIRECTS)
+	return nil
+}
@@ -0,0 +1,78 @@
+// File: assignment-2/handlers/webhook_policy_test.go
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"assignment-2/constants"
+	"assignment-2/firebase"
+	"assignment-2/structs"
+)
+
+// TestWebhookURLPolicy checks that webhooks for internal URLs are refused when they are
+// registered, and that deliveries to them become dead letters without being sent.
+func TestWebhookURLPolicy(t *testing.T) {
+	overrideNotificationStubs()
+	defer revertNotificationStubs()
+
+	t.Run("Registration", func(t *testing.T) {
+		urls := []string{
+			"http://localhost:8080/hook",
+			"http://169.254.169.254/latest/meta-data/",
+			"https://10.0.0.7/hook",
+			"http://[::1]/hook",
+			"ftp://example.org/hook",
+			"not a url",
+		}
+		for _, u := range urls {
+			rr := httptest.NewRecorder()
+			NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH,
+				strings.NewReader(`{"url":"`+u+`","event":"REGISTER"}`)))
+			if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "URL not allowed") {
+				t.Errorf("%s: expected 400, got %d %s", u, rr.Code, rr.Body.String())
+			}
+		}
+		notifMutex.Lock()
+		defer notifMutex.Unlock()
+		if len(notifStore) != 0 {
+			t.Errorf("Expected no webhook to be stored, got %d", len(notifStore))
+		}
+	})
+
+	t.Run("Delivery", func(t *testing.T) {
+		// Stored before the policy was introduced, so it was never checked
+		id, _ := firebase.SaveNotification(context.Background(), structs.Notification{
+			URL: "http://192.168.0.1/hook", Event: "INVOKE", Retry: &structs.RetryPolicy{MaxAttempts: 5},
+		})
+		startTestQueue(t, 1)
+		TriggerWebhookEventVar(structs.WebhookEvent{Event: "INVOKE", Country: "NO"})
+		waitForEmptyQueue(t)
+
+		queueMutex.Lock()
+		defer queueMutex.Unlock()
+		if len(deadStore) != 1 {
+			t.Fatalf("Expected a dead letter, got %d", len(deadStore))
+		}
+		for _, d := range deadStore {
+			if d.NotificationID != id || d.Attempts != 1 || !strings.Contains(d.LastError, "URL not allowed") {
+				t.Errorf("Expected a single refused attempt, got %+v", d)
+			}
+		}
+	})
+}
+
+// TestDefaultWebhookPolicy checks that the built-in policy is valid and refuses internal
+// addresses.
+func TestDefaultWebhookPolicy(t *testing.T) {
+	policy := defaultWebhookPolicy()
+	if policy == nil {
+		t.Fatal("Expected a default policy")
+	}
+	if err := policy.CheckURL(context.Background(), "http://127.0.0.1/hook"); err == nil {
+		t.Error("Expected the default policy to refuse a loopback address")
+	}
+}
@@ -0,0 +1,429 @@
+// File: assignment-2/handlers/webhook_queue.go
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"assignment-2/constants"
+	"assignment-2/firebase"
+	"assignment-2/structs"
+	"assignment-2/tools"
+)
+
+// webhookSweepInterval is how often deliveries left in Firestore are queued again; a
+// variable so tests can shorten it
+var webhookSweepInterval = constants.WEBHOOK_SWEEP_SECONDS * time.Second
+
+// webhookRetryDelay returns the delay before the next attempt of a delivery; a variable
+// so tests can shorten it
+var webhookRetryDelay = func(policy structs.RetryPolicy, failed int) time.Duration {
+	return policy.Backoff(failed)
+}
+
+// webhookClient sends the webhook POSTs. It only connects to addresses webhookPolicy
+// allows, and its timeout bounds every delivery, so a slow receiver only holds up one worker.
+var webhookClient = webhookPolicy.Client(constants.WEBHOOK_TIMEOUT_SECONDS*time.Second, constants.WEBHOOK_MAX_REDIRECTS)
+
+// webhookEventJob is an event waiting to be matched against the webhooks.
+type webhookEventJob struct {
+	event structs.WebhookEvent
+	at    time.Time // when the event happened
+}
+
+// webhookQueue delivers webhooks with a bounded pool of workers. Events are matched
+// against the webhooks by a dispatcher, so handlers only hand them over. Every delivery
+// is stored in Firestore before it is queued in memory and removed once it has been sent,
+// so deliveries that are still waiting when the service stops are sent after the restart.
+// Failed deliveries are retried with the backoff of their retry policy; the retry state
+// is stored with them, and once the attempts are used up they become dead letters.
+type webhookQueue struct {
+	mu      sync.Mutex
+	jobs    chan structs.WebhookDelivery
+	events  chan webhookEventJob // nil once shutdown has begun
+	pending int                  // events in 'events' or being dispatched
+	drained chan struct{}        // closed when the dispatcher has stopped
+	queued  map[string]struct{}  // IDs in 'jobs', being delivered or waiting for a retry
+	swept   map[string]struct{}  // IDs finished while a sweep is running
+	running bool
+	stop    chan struct{}
+	abort   context.Context // cancelled when shutdown gives up waiting
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// webhookDeliveries is the queue used by TriggerWebhookEventVar.
+var webhookDeliveries = &webhookQueue{}
+
+// StartWebhookQueue starts the webhook workers. Deliveries left in Firestore by an
+// earlier run are queued right away.
+func StartWebhookQueue(workers int) {
+	webhookDeliveries.start(workers)
+}
+
+// StopWebhookQueue stops accepting new deliveries and waits until the queued ones are
+// sent or 'ctx' ends. Deliveries that could not be sent by then stay in Firestore.
+func StopWebhookQueue(ctx context.Context) error {
+	return webhookDeliveries.shutdown(ctx)
+}
+
+// start launches 'workers' delivery workers, the event dispatcher and the sweeper.
+func (q *webhookQueue) start(workers int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.running {
+		return
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	q.jobs = make(chan structs.WebhookDelivery, constants.WEBHOOK_QUEUE_SIZE)
+	q.events = make(chan webhookEventJob, constants.WEBHOOK_QUEUE_SIZE)
+	q.drained = make(chan struct{})
+	q.queued = make(map[string]struct{})
+	q.stop = make(chan struct{})
+	q.abort, q.cancel = context.WithCancel(context.Background())
+	q.running = true
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	go q.dispatch(q.events, q.drained)
+	q.wg.Add(1)
+	go q.sweepLoop()
+}
+
+// shutdown closes the queue and waits for the workers to drain it. Events still waiting
+// are dispatched first, so their deliveries are sent as well. If 'ctx' ends first,
+// deliveries in flight are cancelled and the remaining ones are left in Firestore.
+func (q *webhookQueue) shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.running || q.events == nil {
+		q.mu.Unlock()
+		return nil
+	}
+	close(q.events)
+	q.events = nil
+	drained := q.drained
+	q.mu.Unlock()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	q.running = false
+	close(q.stop)
+	close(q.jobs)
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return fmt.Errorf("webhook queue not drained: %v", ctx.Err())
+	}
+}
+
+// trigger hands an event that happened at 'at' to the dispatcher without blocking. It
+// reports false if the queue is full or not running.
+func (q *webhookQueue) trigger(ev structs.WebhookEvent, at time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.running || q.events == nil {
+		return false
+	}
+	select {
+	case q.events <- webhookEventJob{event: ev, at: at}:
+		q.pending++
+		return true
+	default:
+		return false
+	}
+}
+
+// dispatch queues the deliveries of every event until 'events' is closed, then closes
+// 'drained'.
+func (q *webhookQueue) dispatch(events <-chan webhookEventJob, drained chan<- struct{}) {
+	defer close(drained)
+	for job := range events {
+		dispatchWebhookEvent(job.event, job.at)
+		q.mu.Lock()
+		q.pending--
+		q.mu.Unlock()
+	}
+}
+
+// enqueue stores a delivery and hands it to the workers. If it cannot be stored it is
+// still queued in memory; if the memory queue is full (or stopped) it waits in Firestore
+// for the next sweep.
+func (q *webhookQueue) enqueue(ctx context.Context, delivery structs.WebhookDelivery) {
+	id, err := firebase.SaveWebhookDelivery(ctx, delivery)
+	if err != nil {
+		log.Printf("[Webhook] Could not store delivery to %s, sending it without persistence: %v\n", delivery.URL, err)
+	}
+	delivery.ID = id
+	if !q.submit(delivery) {
+		if id == "" {
+			log.Printf("[Webhook] Dropped delivery to %s: queue is full or stopped\n", delivery.URL)
+		} else {
+			log.Printf("[Webhook] Queue is full or stopped, delivery %s waits in Firestore\n", id)
+		}
+	}
+}
+
+// submit adds a delivery to the memory queue without blocking. It reports false if the
+// queue is full, not running, or already holds the delivery.
+func (q *webhookQueue) submit(delivery structs.WebhookDelivery) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.running {
+		return false
+	}
+	if delivery.ID != "" {
+		if _, ok := q.queued[delivery.ID]; ok {
+			return false
+		}
+	}
+	select {
+	case q.jobs <- delivery:
+		if delivery.ID != "" {
+			q.queued[delivery.ID] = struct{}{}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// work sends deliveries until the queue is closed and empty.
+func (q *webhookQueue) work() {
+	defer q.wg.Done()
+	for delivery := range q.jobs {
+		if q.abort.Err() != nil {
+			q.keep(delivery)
+			continue
+		}
+		webhook, err := currentWebhook(delivery)
+		if errors.Is(err, firebase.ErrNotificationNotFound) {
+			log.Printf("[Webhook] Dropped delivery to %s: the webhook was deleted\n", delivery.URL)
+			q.finish(delivery)
+			continue
+		}
+		start := time.Now()
+		status := 0
+		if err == nil {
+			status, err = q.deliver(delivery, webhook.Secrets)
+		}
+		if err != nil && q.abort.Err() != nil {
+			q.keep(delivery)
+			continue
+		}
+		logAttempt(delivery, start, status, err)
+		if err != nil {
+			q.failed(delivery, err)
+			continue
+		}
+		log.Printf("[Webhook] Successfully triggered %s, event=%s, country=%s\n",
+			delivery.URL, delivery.Event, delivery.Country)
+		q.finish(delivery)
+	}
+}
+
+// failed records a failed attempt. The delivery is scheduled again after its backoff,
+// or moved to the dead letters if it has no attempts left or its URL is not allowed.
+func (q *webhookQueue) failed(delivery structs.WebhookDelivery, cause error) {
+	ctx := context.Background()
+	delivery.Attempts++
+	delivery.LastError = cause.Error()
+	policy := delivery.Retry
+	if delivery.Attempts >= policy.MaxAttempts || errors.Is(cause, tools.ErrURLNotAllowed) {
+		delivery.Failed = time.Now()
+		log.Printf("[Webhook] Delivery to %s failed after %d attempts, moved to the dead letters: %v\n",
+			delivery.URL, delivery.Attempts, cause)
+		if err := firebase.DeadLetterWebhookDelivery(ctx, delivery); err != nil {
+			log.Printf("[Webhook] Could not store dead letter for %s: %v\n", delivery.URL, err)
+		}
+		q.release(delivery.ID)
+		return
+	}
+
+	delay := webhookRetryDelay(policy, delivery.Attempts)
+	delivery.NextAttempt = time.Now().Add(delay)
+	log.Printf("[Webhook] Delivery to %s failed (attempt %d of %d), retrying in %v: %v\n",
+		delivery.URL, delivery.Attempts, policy.MaxAttempts, delay, cause)
+	if delivery.ID == "" {
+		if id, err := firebase.SaveWebhookDelivery(ctx, delivery); err == nil {
+			delivery.ID = id
+			q.mu.Lock()
+			q.queued[id] = struct{}{}
+			q.mu.Unlock()
+		}
+	} else if err := firebase.UpdateWebhookDelivery(ctx, delivery); err != nil {
+		log.Printf("[Webhook] Could not store retry state of delivery %s: %v\n", delivery.ID, err)
+	}
+	q.retryLater(delivery, delay)
+}
+
+// retryLater queues a delivery again after 'delay', unless the queue has been stopped
+// (and possibly restarted) in the meantime; the restarted queue picks it up from Firestore.
+func (q *webhookQueue) retryLater(delivery structs.WebhookDelivery, delay time.Duration) {
+	q.mu.Lock()
+	stop := q.stop
+	q.mu.Unlock()
+	time.AfterFunc(delay, func() {
+		q.mu.Lock()
+		current := q.stop == stop
+		if current {
+			delete(q.queued, delivery.ID)
+		}
+		q.mu.Unlock()
+		if current {
+			q.submit(delivery)
+		}
+	})
+}
+
+// currentWebhook looks up the webhook of a delivery, so it is signed with the secrets the
+// webhook has at the time it is sent. Deliveries without a webhook are sent unsigned.
+func currentWebhook(delivery structs.WebhookDelivery) (structs.Notification, error) {
+	if delivery.NotificationID == "" {
+		return structs.Notification{}, nil
+	}
+	notif, err := firebase.GetNotificationByID(context.Background(), delivery.NotificationID)
+	if err != nil {
+		return structs.Notification{}, err
+	}
+	return *notif, nil
+}
+
+// deliver POSTs the payload of a delivery, signed with 'secrets', and returns the response
+// status (0 if there was no response).
+func (q *webhookQueue) deliver(delivery structs.WebhookDelivery, secrets []structs.WebhookSecret) (int, error) {
+	resp, err := postSigned(q.abort, delivery.URL, secrets, delivery.Payload, delivery.Headers)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// logAttempt adds an attempt to the delivery log. Attempts cut off by shutdown are not
+// logged, as they are repeated after the restart.
+func logAttempt(delivery structs.WebhookDelivery, start time.Time, status int, err error) {
+	attempt := structs.DeliveryAttempt{
+		NotificationID: delivery.NotificationID,
+		DeliveryID:     delivery.ID,
+		URL:            delivery.URL,
+		Event:          delivery.Event,
+		EventID:        delivery.EventID,
+		Country:        delivery.Country,
+		Payload:        delivery.Payload,
+		Attempt:        delivery.Attempts + 1,
+		Time:           start,
+		StatusCode:     status,
+		LatencyMs:      time.Since(start).Milliseconds(),
+		Success:        err == nil,
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	if logErr := firebase.SaveDeliveryAttempt(context.Background(), attempt); logErr != nil {
+		log.Printf("[Webhook] Could not log delivery attempt to %s: %v\n", delivery.URL, logErr)
+	}
+}
+
+// finish removes a delivery that has been sent from Firestore.
+func (q *webhookQueue) finish(delivery structs.WebhookDelivery) {
+	if delivery.ID == "" {
+		return
+	}
+	if err := firebase.DeleteWebhookDelivery(context.Background(), delivery.ID); err != nil {
+		log.Printf("[Webhook] Could not remove delivery %s from the queue: %v\n", delivery.ID, err)
+	}
+	q.release(delivery.ID)
+}
+
+// release forgets a delivery that has left the queue.
+func (q *webhookQueue) release(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.queued, id)
+	if q.swept != nil {
+		q.swept[id] = struct{}{}
+	}
+}
+
+// keep leaves a delivery that was not sent because of shutdown in Firestore, storing it
+// first if that failed when it was queued.
+func (q *webhookQueue) keep(delivery structs.WebhookDelivery) {
+	if delivery.ID != "" {
+		return
+	}
+	if _, err := firebase.SaveWebhookDelivery(context.Background(), delivery); err != nil {
+		log.Printf("[Webhook] Lost delivery to %s at shutdown: %v\n", delivery.URL, err)
+	}
+}
+
+// sweepLoop queues the deliveries found in Firestore at startup and then periodically.
+func (q *webhookQueue) sweepLoop() {
+	defer q.wg.Done()
+	ticker := time.NewTicker(webhookSweepInterval)
+	defer ticker.Stop()
+	for {
+		q.sweep(context.Background())
+		select {
+		case <-q.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep queues stored deliveries that are due and not queued yet, e.g. those left by a
+// restart or by a full memory queue. Deliveries finished while the listing runs are skipped, so
+// none is sent twice.
+func (q *webhookQueue) sweep(ctx context.Context) {
+	q.mu.Lock()
+	q.swept = make(map[string]struct{})
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		q.swept = nil
+		q.mu.Unlock()
+	}()
+
+	deliveries, err := firebase.ListWebhookDeliveries(ctx, time.Now(), constants.WEBHOOK_QUEUE_SIZE)
+	if err != nil {
+		log.Printf("[Webhook] Could not read the delivery queue: %v\n", err)
+		return
+	}
+	for _, delivery := range deliveries {
+		q.mu.Lock()
+		_, done := q.swept[delivery.ID]
+		q.mu.Unlock()
+		if !done && !q.submit(delivery) {
+			q.mu.Lock()
+			_, queued := q.queued[delivery.ID]
+			q.mu.Unlock()
+			if !queued {
+				return // full or stopped
+			}
+		}
+	}
+}
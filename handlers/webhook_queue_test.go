@@ -0,0 +1,342 @@
+// File: assignment-2/handlers/webhook_queue_test.go
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"assignment-2/firebase"
+	"assignment-2/structs"
+)
+
+// In-memory stand-ins for the webhook queue and dead letter collections
+var (
+	queueMutex sync.Mutex
+	queueStore map[string]structs.WebhookDelivery
+	deadStore  map[string]structs.WebhookDelivery
+	queueSeq   int
+)
+
+var (
+	origSaveWebhookDelivery       = firebase.SaveWebhookDelivery
+	origUpdateWebhookDelivery     = firebase.UpdateWebhookDelivery
+	origListWebhookDeliveries     = firebase.ListWebhookDeliveries
+	origDeleteWebhookDelivery     = firebase.DeleteWebhookDelivery
+	origDeadLetterWebhookDelivery = firebase.DeadLetterWebhookDelivery
+	origListDeadLetters           = firebase.ListDeadLetters
+	origGetDeadLetter             = firebase.GetDeadLetter
+	origRequeueDeadLetter         = firebase.RequeueDeadLetter
+)
+
+// overrideQueueStubs replaces the queue and dead letter collections with in-memory maps.
+func overrideQueueStubs() {
+	queueStore = make(map[string]structs.WebhookDelivery)
+	deadStore = make(map[string]structs.WebhookDelivery)
+	queueSeq = 0
+	overrideDeliveryLogStubs()
+
+	firebase.SaveWebhookDelivery = func(ctx context.Context, d structs.WebhookDelivery) (string, error) {
+		queueMutex.Lock()
+		defer queueMutex.Unlock()
+		queueSeq++
+		d.ID = "delivery-" + strconv.Itoa(queueSeq)
+		queueStore[d.ID] = d
+		return d.ID, nil
+	}
+	firebase.UpdateWebhookDelivery = func(ctx context.Context, d structs.WebhookDelivery) error {
+		queueMutex.Lock()
+		defer queueMutex.Unlock()
+		queueStore[d.ID] = d
+		return nil
+	}
+	firebase.ListWebhookDeliveries = func(ctx context.Context, due time.Time, limit int) ([]structs.WebhookDelivery, error) {
+		queueMutex.Lock()
+		defer queueMutex.Unlock()
+		var all []structs.WebhookDelivery
+		for _, d := range queueStore {
+			if !d.NextAttempt.After(due) {
+				all = append(all, d)
+			}
+		}
+		sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+		if len(all) > limit {
+			all = all[:limit]
+		}
+		return all, nil
+	}
+	firebase.DeleteWebhookDelivery = func(ctx context.Context, id string) error {
+		queueMutex.Lock()
+		defer queueMutex.Unlock()
+		delete(queueStore, id)
+		return nil
+	}
+	firebase.DeadLetterWebhookDelivery = func(ctx context.Context, d structs.WebhookDelivery) error {
+		queueMutex.Lock()
+		defer queueMutex.Unlock()
+		delete(queueStore, d.ID)
+		deadStore[d.ID] = d
+		return nil
+	}
+	firebase.ListDeadLetters = func(ctx context.Context, opts structs.ListOptions) ([]structs.WebhookDelivery, string, error) {
+		queueMutex.Lock()
+		defer queueMutex.Unlock()
+		var page []structs.WebhookDelivery
+		for _, d := range deadStore {
+			if d.NotificationID == opts.Equals["notificationId"] {
+				page = append(page, d)
+			}
+		}
+		sort.Slice(page, func(i, j int) bool { return page[i].ID < page[j].ID })
+		return page, "", nil
+	}
+	firebase.GetDeadLetter = func(ctx context.Context, id string) (*structs.WebhookDelivery, error) {
+		queueMutex.Lock()
+		defer queueMutex.Unlock()
+		d, ok := deadStore[id]
+		if !ok {
+			return nil, firebase.ErrDeadLetterNotFound
+		}
+		return &d, nil
+	}
+	firebase.RequeueDeadLetter = func(ctx context.Context, d structs.WebhookDelivery) error {
+		queueMutex.Lock()
+		defer queueMutex.Unlock()
+		if _, ok := deadStore[d.ID]; !ok {
+			return firebase.ErrDeadLetterNotFound
+		}
+		delete(deadStore, d.ID)
+		queueStore[d.ID] = d
+		return nil
+	}
+}
+
+func revertQueueStubs() {
+	firebase.SaveWebhookDelivery = origSaveWebhookDelivery
+	firebase.UpdateWebhookDelivery = origUpdateWebhookDelivery
+	firebase.ListWebhookDeliveries = origListWebhookDeliveries
+	firebase.DeleteWebhookDelivery = origDeleteWebhookDelivery
+	firebase.DeadLetterWebhookDelivery = origDeadLetterWebhookDelivery
+	firebase.ListDeadLetters = origListDeadLetters
+	firebase.GetDeadLetter = origGetDeadLetter
+	firebase.RequeueDeadLetter = origRequeueDeadLetter
+	revertDeliveryLogStubs()
+}
+
+// storedDeliveries returns how many deliveries are left in the stubbed collection.
+func storedDeliveries() int {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	return len(queueStore)
+}
+
+// startTestQueue replaces the package queue with a fresh one using the stubbed
+// collection, and stops it at the end of the test.
+func startTestQueue(t *testing.T, workers int) *webhookQueue {
+	t.Helper()
+	overrideQueueStubs()
+	orig := webhookDeliveries
+	webhookDeliveries = &webhookQueue{}
+	webhookDeliveries.start(workers)
+	q := webhookDeliveries
+	t.Cleanup(func() {
+		_ = q.shutdown(context.Background())
+		webhookDeliveries = orig
+		revertQueueStubs()
+	})
+	return q
+}
+
+// queueDelivery queues a delivery of a small payload to 'url', without retries.
+func queueDelivery(q *webhookQueue, url string) {
+	q.enqueue(context.Background(), structs.WebhookDelivery{
+		URL: url, Event: "REGISTER", Payload: []byte(`{}`), Enqueued: time.Now(),
+		Retry: structs.RetryPolicy{MaxAttempts: 1},
+	})
+}
+
+// TestWebhookQueueSlowReceiver checks that a receiver that never answers holds up
+// neither the caller nor the other deliveries, and is cut off by the client timeout.
+func TestWebhookQueueSlowReceiver(t *testing.T) {
+	origClient := webhookClient
+	webhookClient = &http.Client{Timeout: 100 * time.Millisecond}
+	defer func() { webhookClient = origClient }()
+
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body) // so the server notices when the client gives up
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := make(chan struct{}, 1)
+	fastSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fast <- struct{}{}
+	}))
+	defer fastSrv.Close()
+
+	q := startTestQueue(t, 2)
+	start := time.Now()
+	queueDelivery(q, slow.URL)
+	queueDelivery(q, fastSrv.URL)
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Expected enqueueing not to wait for receivers, took %v", elapsed)
+	}
+	select {
+	case <-fast:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the fast receiver to be called while the slow one hangs")
+	}
+
+	// Without retries the timed out delivery becomes a dead letter right away
+	waitForEmptyQueue(t)
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	if len(deadStore) != 1 {
+		t.Errorf("Expected one dead letter, got %d", len(deadStore))
+	}
+}
+
+// waitForEmptyQueue waits until every event has been dispatched and the stubbed queue
+// collection is empty.
+func waitForEmptyQueue(t *testing.T) {
+	t.Helper()
+	pending := func() int {
+		q := webhookDeliveries
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return q.pending
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for (pending() > 0 || storedDeliveries() > 0) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := pending(); n != 0 {
+		t.Fatalf("Expected every event to be dispatched, %d events left", n)
+	}
+	if n := storedDeliveries(); n != 0 {
+		t.Fatalf("Expected the queue to be empty, %d deliveries left", n)
+	}
+}
+
+// shortRetryDelays makes every retry wait 10ms until the end of the test.
+func shortRetryDelays(t *testing.T) {
+	orig := webhookRetryDelay
+	webhookRetryDelay = func(structs.RetryPolicy, int) time.Duration { return 10 * time.Millisecond }
+	t.Cleanup(func() { webhookRetryDelay = orig })
+}
+
+// TestWebhookQueueRetries checks that a failing receiver is tried again until it answers,
+// and that the retry state is stored in between.
+func TestWebhookQueueRetries(t *testing.T) {
+	shortRetryDelays(t)
+	var mu sync.Mutex
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	q := startTestQueue(t, 1)
+	var updates []structs.WebhookDelivery
+	update := firebase.UpdateWebhookDelivery
+	firebase.UpdateWebhookDelivery = func(ctx context.Context, d structs.WebhookDelivery) error {
+		queueMutex.Lock()
+		updates = append(updates, d)
+		queueMutex.Unlock()
+		return update(ctx, d)
+	}
+	q.enqueue(context.Background(), structs.WebhookDelivery{
+		URL: srv.URL, Payload: []byte(`{}`), Retry: structs.RetryPolicy{MaxAttempts: 3},
+	})
+	waitForEmptyQueue(t)
+
+	mu.Lock()
+	defer mu.Unlock()
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	if calls != 3 || len(deadStore) != 0 {
+		t.Errorf("Expected 3 calls and no dead letter, got %d calls and %d dead letters", calls, len(deadStore))
+	}
+	if len(updates) != 2 || updates[1].Attempts != 2 || updates[1].LastError != "webhook responded 503" || updates[1].NextAttempt.IsZero() {
+		t.Errorf("Expected the retry state to be stored twice, got %+v", updates)
+	}
+}
+
+// TestWebhookQueueShutdown checks that shutdown drains the queue when it can, keeps the
+// deliveries in Firestore when it cannot, and that a restart sends them.
+func TestWebhookQueueShutdown(t *testing.T) {
+	var mu sync.Mutex
+	received := 0
+	blocked := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		wait := blocked
+		mu.Unlock()
+		if wait {
+			_, _ = io.Copy(io.Discard, r.Body)
+			<-r.Context().Done()
+			return
+		}
+		mu.Lock()
+		received++
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	t.Run("Persist", func(t *testing.T) {
+		q := startTestQueue(t, 1)
+		for i := 0; i < 3; i++ {
+			queueDelivery(q, srv.URL)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if err := q.shutdown(ctx); err == nil {
+			t.Error("Expected shutdown to report an undrained queue")
+		}
+		if n := storedDeliveries(); n != 3 {
+			t.Fatalf("Expected 3 deliveries kept in Firestore, got %d", n)
+		}
+
+		// Restarting picks them up again
+		mu.Lock()
+		blocked = false
+		mu.Unlock()
+		q.start(2)
+		deadline := time.Now().Add(2 * time.Second)
+		for storedDeliveries() > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if received != 3 || storedDeliveries() != 0 {
+			t.Errorf("Expected 3 deliveries after the restart, got %d (%d left)", received, storedDeliveries())
+		}
+	})
+
+	t.Run("Stopped", func(t *testing.T) {
+		q := startTestQueue(t, 1)
+		_ = q.shutdown(context.Background())
+		queueDelivery(q, srv.URL)
+		if n := storedDeliveries(); n != 1 {
+			t.Errorf("Expected a delivery queued after shutdown to wait in Firestore, got %d", n)
+		}
+		if q.trigger(structs.WebhookEvent{Event: "REGISTER", Country: "Norway"}, time.Now()) {
+			t.Error("Expected a stopped queue to refuse events")
+		}
+	})
+}
@@ -0,0 +1,115 @@
+// File: assignment-2/handlers/webhook_signing.go
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"assignment-2/constants"
+	"assignment-2/firebase"
+	"assignment-2/structs"
+	"assignment-2/tools"
+)
+
+// newWebhookSecret returns a random signing secret.
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// signatureHeader returns the value of the signature header of a delivery sent at 'ts':
+// one "v1=" HMAC-SHA256 of "<timestamp>.<body>" per secret active at 'ts', comma
+// separated. It is empty if there is no active secret.
+func signatureHeader(secrets []structs.WebhookSecret, ts time.Time, body []byte) string {
+	var signatures []string
+	for _, secret := range secrets {
+		if !secret.ActiveAt(ts) {
+			continue
+		}
+		mac := hmac.New(sha256.New, []byte(secret.Value))
+		mac.Write([]byte(strconv.FormatInt(ts.Unix(), 10)))
+		mac.Write([]byte("."))
+		mac.Write(body)
+		signatures = append(signatures, "v1="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return strings.Join(signatures, ",")
+}
+
+// signRequest adds the timestamp and signature headers to a delivery request.
+func signRequest(req *http.Request, secrets []structs.WebhookSecret, body []byte) {
+	now := time.Now()
+	if sig := signatureHeader(secrets, now, body); sig != "" {
+		req.Header.Set(constants.WEBHOOK_TIMESTAMP_HEADER, strconv.FormatInt(now.Unix(), 10))
+		req.Header.Set(constants.WEBHOOK_SIGNATURE_HEADER, sig)
+	}
+}
+
+// postSigned POSTs 'body' to a webhook URL with 'headers', signed with 'secrets', if the
+// URL policy still allows it. The body is JSON unless the headers set another
+// Content-Type. The caller closes the response body.
+func postSigned(ctx context.Context, url string, secrets []structs.WebhookSecret, body []byte, headers map[string]string) (*http.Response, error) {
+	if err := webhookPolicy.CheckURL(ctx, url); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", constants.CONTENT_TYPE_JSON)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	signRequest(req, secrets, body)
+	return webhookClient.Do(req)
+}
+
+// handleRotateSecret handles POST {id}/rotate-secret. It returns the new secret, which is
+// not shown again; the previous one stays valid for ?graceSeconds= (default one day).
+func handleRotateSecret(w http.ResponseWriter, r *http.Request, id string) {
+	grace := constants.WEBHOOK_SECRET_GRACE_SECONDS
+	if v := r.URL.Query().Get("graceSeconds"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > constants.WEBHOOK_SECRET_MAX_GRACE_SECONDS {
+			tools.WriteJsonErrorResponse(w, http.StatusBadRequest,
+				"graceSeconds must be between 0 and "+strconv.Itoa(constants.WEBHOOK_SECRET_MAX_GRACE_SECONDS))
+			return
+		}
+		grace = n
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		log.Printf("Error generating webhook secret: %v\n", err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not generate secret")
+		return
+	}
+
+	notif, err := firebase.RotateNotificationSecret(r.Context(), id, secret, time.Duration(grace)*time.Second)
+	if errors.Is(err, firebase.ErrNotificationNotFound) {
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error rotating secret of %s: %v\n", id, err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not rotate secret")
+		return
+	}
+
+	resp := map[string]interface{}{"id": id, "secret": secret}
+	if len(notif.Secrets) > 1 {
+		resp["previousSecretExpires"] = notif.Secrets[1].Expires
+	}
+	tools.WriteJsonResponse(w, http.StatusOK, resp)
+}
@@ -0,0 +1,162 @@
+// File: assignment-2/handlers/webhook_signing_test.go
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"assignment-2/constants"
+	"assignment-2/structs"
+)
+
+// verifySignature checks a signature header the way a receiver would: it reports whether
+// one of the signatures matches 'secret'.
+func verifySignature(secret, timestamp, header string, body []byte) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	expected := "v1=" + hex.EncodeToString(mac.Sum(nil))
+	for _, sig := range strings.Split(header, ",") {
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestSignatureHeader checks that only active secrets sign.
+func TestSignatureHeader(t *testing.T) {
+	now := time.Unix(1744280700, 0)
+	body := []byte(`{"event":"REGISTER"}`)
+	secrets := []structs.WebhookSecret{
+		{Value: "new"},
+		{Value: "old", Expires: now.Add(time.Minute)},
+		{Value: "expired", Expires: now.Add(-time.Minute)},
+	}
+	header := signatureHeader(secrets, now, body)
+	if n := strings.Count(header, "v1="); n != 2 {
+		t.Fatalf("Expected 2 signatures, got %q", header)
+	}
+	for _, secret := range []string{"new", "old"} {
+		if !verifySignature(secret, "1744280700", header, body) {
+			t.Errorf("Expected a valid signature for %q in %q", secret, header)
+		}
+	}
+	if verifySignature("expired", "1744280700", header, body) {
+		t.Error("Expected no signature with the expired secret")
+	}
+	if signatureHeader(nil, now, body) != "" {
+		t.Error("Expected no signature without secrets")
+	}
+}
+
+// TestSignedDeliveries creates a webhook, checks the signature of its deliveries and
+// rotates its secret with and without a grace period.
+func TestSignedDeliveries(t *testing.T) {
+	overrideNotificationStubs()
+	defer revertNotificationStubs()
+
+	type received struct {
+		timestamp, signature string
+		body                 []byte
+	}
+	requests := make(chan received, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if echoChallenge(w, body) {
+			return
+		}
+		requests <- received{r.Header.Get(constants.WEBHOOK_TIMESTAMP_HEADER), r.Header.Get(constants.WEBHOOK_SIGNATURE_HEADER), body}
+	}))
+	defer srv.Close()
+	startTestQueue(t, 1)
+
+	rr := httptest.NewRecorder()
+	NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH,
+		strings.NewReader(`{"url":"`+srv.URL+`","event":"REGISTER"}`)))
+	var created map[string]string
+	_ = json.Unmarshal(rr.Body.Bytes(), &created)
+	id, secret := created["id"], created["secret"]
+	if rr.Code != http.StatusCreated || !strings.HasPrefix(secret, "whsec_") {
+		t.Fatalf("Expected the secret in the creation response, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	var mu sync.Mutex
+	deliver := func() received {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		TriggerWebhookEventVar(structs.WebhookEvent{Event: "REGISTER", Country: "NO"})
+		select {
+		case req := <-requests:
+			waitForEmptyQueue(t)
+			return req
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for the delivery")
+			return received{}
+		}
+	}
+
+	req := deliver()
+	if req.timestamp == "" || !verifySignature(secret, req.timestamp, req.signature, req.body) {
+		t.Fatalf("Expected a valid signature, got %+v", req)
+	}
+
+	t.Run("SecretNotShown", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, httptest.NewRequest(http.MethodGet, constants.NOTIFICATIONS_PATH+id, nil))
+		if strings.Contains(rr.Body.String(), "whsec_") {
+			t.Errorf("Expected no secret in %s", rr.Body.String())
+		}
+	})
+
+	t.Run("RotateWithGrace", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH+id+"/rotate-secret", nil))
+		var rotated map[string]interface{}
+		_ = json.Unmarshal(rr.Body.Bytes(), &rotated)
+		newSecret, _ := rotated["secret"].(string)
+		if rr.Code != http.StatusOK || newSecret == "" || newSecret == secret || rotated["previousSecretExpires"] == nil {
+			t.Fatalf("Expected a new secret, got %d %s", rr.Code, rr.Body.String())
+		}
+		req := deliver()
+		if !verifySignature(newSecret, req.timestamp, req.signature, req.body) || !verifySignature(secret, req.timestamp, req.signature, req.body) {
+			t.Errorf("Expected signatures with both secrets, got %q", req.signature)
+		}
+		secret = newSecret
+	})
+
+	t.Run("RotateWithoutGrace", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH+id+"/rotate-secret?graceSeconds=0", nil))
+		var rotated map[string]interface{}
+		_ = json.Unmarshal(rr.Body.Bytes(), &rotated)
+		newSecret, _ := rotated["secret"].(string)
+		req := deliver()
+		if !verifySignature(newSecret, req.timestamp, req.signature, req.body) || verifySignature(secret, req.timestamp, req.signature, req.body) {
+			t.Errorf("Expected a signature with the new secret only, got %q", req.signature)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		cases := map[string]int{
+			constants.NOTIFICATIONS_PATH + id + "/rotate-secret?graceSeconds=-1": http.StatusBadRequest,
+			constants.NOTIFICATIONS_PATH + "missing/rotate-secret":               http.StatusNotFound,
+		}
+		for path, want := range cases {
+			rr := httptest.NewRecorder()
+			NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, path, nil))
+			if rr.Code != want {
+				t.Errorf("%s: expected %d, got %d", path, want, rr.Code)
+			}
+		}
+	})
+}
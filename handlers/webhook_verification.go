@@ -0,0 +1,141 @@
+// File: assignment-2/handlers/webhook_verification.go
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"assignment-2/constants"
+	"assignment-2/firebase"
+	"assignment-2/structs"
+	"assignment-2/tools"
+)
+
+// verifyWebhook sends a verification challenge to the URL of a webhook. The receiver
+// proves that it accepts the webhook by answering 2xx with {"challenge": "<challenge>"}.
+func verifyWebhook(ctx context.Context, n structs.Notification) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	challenge := hex.EncodeToString(b)
+	payload, _ := json.Marshal(map[string]string{"type": "verification", "id": n.ID, "challenge": challenge})
+
+	resp, err := postSigned(ctx, n.URL, n.Secrets, payload, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %d", resp.StatusCode)
+	}
+	var echo struct {
+		Challenge string `json:"challenge"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, constants.WEBHOOK_RESPONSE_LIMIT_BYTES)).Decode(&echo)
+	if err != nil || echo.Challenge != challenge {
+		return errors.New("webhook did not echo the challenge")
+	}
+	return nil
+}
+
+// activateWebhook runs the verification handshake of a pending webhook and activates it
+// if the receiver echoes the challenge.
+func activateWebhook(ctx context.Context, n *structs.Notification) error {
+	if err := verifyWebhook(ctx, *n); err != nil {
+		return err
+	}
+	verified := time.Now()
+	if err := firebase.ActivateNotification(ctx, n.ID, verified); err != nil {
+		return err
+	}
+	n.Status, n.Verified = structs.WebhookActive, verified
+	return nil
+}
+
+// handleVerifyWebhook handles POST {id}/verify: it repeats the verification handshake of
+// a webhook that is still pending.
+func handleVerifyWebhook(w http.ResponseWriter, r *http.Request, id string) {
+	notif, err := firebase.GetNotificationByID(r.Context(), id)
+	if err != nil {
+		log.Printf("Error fetching notification %s: %v\n", id, err)
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+	if notif.Active() {
+		tools.WriteJsonResponse(w, http.StatusOK, map[string]interface{}{"id": id, "status": structs.WebhookActive})
+		return
+	}
+	if err := verifyWebhook(r.Context(), *notif); err != nil {
+		log.Printf("[Webhook] Verification of %s failed: %v\n", notif.URL, err)
+		tools.WriteJsonErrorResponse(w, http.StatusUnprocessableEntity, "Verification failed: "+err.Error())
+		return
+	}
+	verified := time.Now()
+	if err := firebase.ActivateNotification(r.Context(), id, verified); err != nil {
+		log.Printf("Error activating notification %s: %v\n", id, err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not activate webhook")
+		return
+	}
+	tools.WriteJsonResponse(w, http.StatusOK, map[string]interface{}{
+		"id": id, "status": structs.WebhookActive, "verified": verified,
+	})
+}
+
+// handleTestWebhook handles POST {id}/test: it sends a synthetic event of the first
+// type the webhook subscribes to, marked with "test": true, and returns the receiver's
+// response. Test events are sent right away and are neither retried nor logged. Pending
+// webhooks must be verified first.
+func handleTestWebhook(w http.ResponseWriter, r *http.Request, id string) {
+	notif, err := firebase.GetNotificationByID(r.Context(), id)
+	if err != nil {
+		log.Printf("Error fetching notification %s: %v\n", id, err)
+		tools.WriteJsonErrorResponse(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+	if !notif.Active() {
+		tools.WriteJsonErrorResponse(w, http.StatusConflict, "Webhook is not verified yet; use /verify first")
+		return
+	}
+	event := ""
+	if events := notif.EventList(); len(events) > 0 {
+		event = events[0]
+	}
+	body, headers, _, err := encodeEvent(*notif, structs.WebhookEvent{Event: event, Country: notif.Country, Test: true}, time.Now())
+	if err != nil {
+		log.Printf("Error building the test event of %s: %v\n", id, err)
+		tools.WriteJsonErrorResponse(w, http.StatusInternalServerError, "Could not build test event")
+		return
+	}
+
+	var result structs.WebhookTestResult
+	start := time.Now()
+	resp, err := postSigned(r.Context(), notif.URL, notif.Secrets, body, headers)
+	if err == nil {
+		defer resp.Body.Close()
+		received, _ := io.ReadAll(io.LimitReader(resp.Body, constants.WEBHOOK_RESPONSE_LIMIT_BYTES))
+		result.StatusCode = resp.StatusCode
+		result.Body = string(received)
+		result.Headers = make(map[string]string, len(resp.Header))
+		for name := range resp.Header {
+			result.Headers[name] = resp.Header.Get(name)
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err = fmt.Errorf("webhook responded %d", resp.StatusCode)
+		}
+	}
+	result.LatencyMs = time.Since(start).Milliseconds()
+	result.Success = err == nil
+	if err != nil {
+		result.Error = err.Error()
+	}
+	tools.WriteJsonResponse(w, http.StatusOK, result)
+}
@@ -0,0 +1,178 @@
+// File: assignment-2/handlers/webhook_verification_test.go
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"assignment-2/constants"
+	"assignment-2/structs"
+)
+
+// echoChallenge answers a verification request the way a receiver should. It reports
+// whether 'body' was one.
+func echoChallenge(w http.ResponseWriter, body []byte) bool {
+	var req map[string]string
+	if json.Unmarshal(body, &req) != nil || req["type"] != "verification" {
+		return false
+	}
+	w.Header().Set("Content-Type", constants.CONTENT_TYPE_JSON)
+	_ = json.NewEncoder(w).Encode(map[string]string{"challenge": req["challenge"]})
+	return true
+}
+
+// TestWebhookVerification registers a receiver that does not answer the challenge at
+// first, checks that it gets no events while pending, and verifies it once it does.
+func TestWebhookVerification(t *testing.T) {
+	overrideNotificationStubs()
+	defer revertNotificationStubs()
+
+	var mu sync.Mutex
+	echo, events := false, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.Contains(string(body), `"verification"`) {
+			if echo {
+				echoChallenge(w, body)
+			}
+			return
+		}
+		events++
+	}))
+	defer srv.Close()
+	startTestQueue(t, 1)
+
+	rr := httptest.NewRecorder()
+	NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH,
+		strings.NewReader(`{"url":"`+srv.URL+`","event":"REGISTER","status":"active"}`)))
+	var created map[string]string
+	_ = json.Unmarshal(rr.Body.Bytes(), &created)
+	id := created["id"]
+	if rr.Code != http.StatusCreated || created["status"] != structs.WebhookPending || created["verificationError"] == "" {
+		t.Fatalf("Expected a pending webhook, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	TriggerWebhookEventVar(structs.WebhookEvent{Event: "REGISTER", Country: "NO"})
+	if n := storedDeliveries(); n != 0 {
+		t.Errorf("Expected no delivery to a pending webhook, got %d", n)
+	}
+
+	verifyPath := constants.NOTIFICATIONS_PATH + id + "/verify"
+	rr = httptest.NewRecorder()
+	NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, verifyPath, nil))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 while the receiver does not echo, got %d", rr.Code)
+	}
+
+	mu.Lock()
+	echo = true
+	mu.Unlock()
+	rr = httptest.NewRecorder()
+	NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, verifyPath, nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"status":"active"`) {
+		t.Fatalf("Expected the webhook to be activated, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	TriggerWebhookEventVar(structs.WebhookEvent{Event: "REGISTER", Country: "NO"})
+	waitForEmptyQueue(t)
+	mu.Lock()
+	if events != 1 {
+		t.Errorf("Expected 1 event after verification, got %d", events)
+	}
+	mu.Unlock()
+
+	t.Run("VerifiedOnCreation", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH,
+			strings.NewReader(`{"url":"`+srv.URL+`","event":"CHANGE"}`)))
+		var created map[string]string
+		_ = json.Unmarshal(rr.Body.Bytes(), &created)
+		if created["status"] != structs.WebhookActive || created["verificationError"] != "" {
+			t.Errorf("Expected an active webhook, got %s", rr.Body.String())
+		}
+	})
+}
+
+// TestWebhookTestEvent sends test events and checks that the receiver's response is
+// returned inline.
+func TestWebhookTestEvent(t *testing.T) {
+	overrideNotificationStubs()
+	defer revertNotificationStubs()
+
+	var payload map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if echoChallenge(w, body) {
+			return
+		}
+		_ = json.Unmarshal(body, &payload)
+		w.Header().Set("X-Receiver", "test")
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = w.Write([]byte("short and stout"))
+	}))
+	defer srv.Close()
+
+	rr := httptest.NewRecorder()
+	NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH,
+		strings.NewReader(`{"url":"`+srv.URL+`","event":"DELETE","country":"SE"}`)))
+	var created map[string]string
+	_ = json.Unmarshal(rr.Body.Bytes(), &created)
+
+	rr = httptest.NewRecorder()
+	NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH+created["id"]+"/test", nil))
+	var result structs.WebhookTestResult
+	_ = json.Unmarshal(rr.Body.Bytes(), &result)
+	if rr.Code != http.StatusOK || result.Success || result.StatusCode != http.StatusTeapot ||
+		result.Body != "short and stout" || result.Headers["X-Receiver"] != "test" || result.Error != "webhook responded 418" {
+		t.Errorf("Expected the receiver's response, got %d %s", rr.Code, rr.Body.String())
+	}
+	subject, _ := payload["subject"].(map[string]interface{})
+	if payload["test"] != true || payload["event"] != "DELETE" || subject["country"] != "SE" || payload["webhookId"] != created["id"] {
+		t.Errorf("Expected a test event for the webhook, got %v", payload)
+	}
+
+	t.Run("Unreachable", func(t *testing.T) {
+		closed := httptest.NewServer(http.NotFoundHandler())
+		closed.Close()
+		notifMutex.Lock()
+		notifStore["notif-closed"] = structs.Notification{ID: "notif-closed", URL: closed.URL, Event: "REGISTER"}
+		notifMutex.Unlock()
+
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH+"notif-closed/test", nil))
+		var result structs.WebhookTestResult
+		_ = json.Unmarshal(rr.Body.Bytes(), &result)
+		if rr.Code != http.StatusOK || result.Success || result.StatusCode != 0 || result.Error == "" {
+			t.Errorf("Expected a failed test without response, got %d %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("Pending", func(t *testing.T) {
+		notifMutex.Lock()
+		notifStore["notif-pending"] = structs.Notification{ID: "notif-pending", URL: srv.URL, Event: "REGISTER", Status: structs.WebhookPending}
+		notifMutex.Unlock()
+
+		rr := httptest.NewRecorder()
+		NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH+"notif-pending/test", nil))
+		if rr.Code != http.StatusConflict {
+			t.Errorf("Expected 409 for a pending webhook, got %d %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		for _, sub := range []string{"/test", "/verify"} {
+			rr := httptest.NewRecorder()
+			NotificationsRouter(rr, httptest.NewRequest(http.MethodPost, constants.NOTIFICATIONS_PATH+"missing"+sub, nil))
+			if rr.Code != http.StatusNotFound {
+				t.Errorf("%s: expected 404, got %d", sub, rr.Code)
+			}
+		}
+	})
+}
@@ -6,8 +6,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"assignment-2/constants"
@@ -17,22 +21,62 @@ import (
 
 // Function variables for test stubbing
 var (
-	FetchCountryInfo   func(countryOrISO string) (*structs.CountryInfo, error) = realFetchCountryInfo
-	FetchMeteoData     func(lat, lon float64) (*structs.MeteoData, error)      = realFetchMeteoData
-	FetchCurrencyRates func(base string) (structs.CurrencyRates, error)        = realFetchCurrencyRates
+	FetchCountryInfo     func(countryOrISO string) (*structs.CountryInfo, error) = realFetchCountryInfo
+	FetchMeteoData       func(lat, lon float64) (*structs.MeteoData, error)      = realFetchMeteoData
+	FetchCurrencyRates   func(base string) (structs.CurrencyRates, error)        = realFetchCurrencyRates
+	FetchRegionCountries func(region string) ([]string, error)                   = realFetchRegionCountries
 )
 
+// CacheRefreshed, if set, is called after data had to be fetched from an external API
+// because it was not cached or had expired, with the cache key (see MeteoCacheKey and
+// CurrencyCacheKey) and the fetched value.
+var CacheRefreshed func(key string, value interface{})
+
+// CacheExpiry returns when the cached data under 'key' expires, as last seen by this
+// instance when reading or writing the cache. It reports false for keys it has not seen.
+var CacheExpiry func(key string) (time.Time, bool) = realCacheExpiry
+
+// expiries maps cache keys to when their data expires.
+var expiries sync.Map
+
+func realCacheExpiry(key string) (time.Time, bool) {
+	if v, ok := expiries.Load(key); ok {
+		return v.(time.Time), true
+	}
+	return time.Time{}, false
+}
+
+// CountryCacheKey returns the cache key of the data of a country, by name or ISO code.
+func CountryCacheKey(countryOrISO string) string {
+	return "country:" + strings.ToUpper(countryOrISO)
+}
+
+// RegionCacheKey returns the cache key of the countries in a region.
+func RegionCacheKey(region string) string {
+	return "region:" + strings.ToUpper(region)
+}
+
+// MeteoCacheKey returns the cache key of the weather data at a location.
+func MeteoCacheKey(lat, lon float64) string {
+	return fmt.Sprintf("meteo:%.2f,%.2f", lat, lon)
+}
+
+// CurrencyCacheKey returns the cache key of the exchange rates for 'base'.
+func CurrencyCacheKey(base string) string {
+	return "currency:" + strings.ToUpper(base)
+}
+
 // realFetchCountryInfo checks Firestore cache first, then calls callRestCountries if not found or parse fails
 
 func realFetchCountryInfo(countryOrISO string) (*structs.CountryInfo, error) {
 	ctx := context.Background()
-	cacheKey := "country:" + strings.ToUpper(countryOrISO)
+	cacheKey := CountryCacheKey(countryOrISO)
 
 	// 1) Attempt to read from Firestore-based cache
-	cached, err := firebase.GetCacheEntry(ctx, cacheKey)
-	if err == nil && cached != nil {
+	if cached, ok := readCache(ctx, cacheKey); ok {
 		var cInfo structs.CountryInfo
-		if unmarshalErr := json.Unmarshal(cached.Data, &cInfo); unmarshalErr == nil {
+		// Entries cached before the ISO code was fetched are refreshed
+		if unmarshalErr := json.Unmarshal(cached, &cInfo); unmarshalErr == nil && cInfo.ISOCode != "" {
 			// Cache HIT: if not unmarshal properly, return cached data
 			return &cInfo, nil
 		}
@@ -46,26 +90,54 @@ func realFetchCountryInfo(countryOrISO string) (*structs.CountryInfo, error) {
 	}
 
 	// 3) Save to cache so future lookups can avoid a real call
-	rawBytes, marshalErr := json.Marshal(cInfo)
-	if marshalErr == nil {
-		saveErr := firebase.SaveCacheEntry(ctx, structs.CacheEntry{
-			Key:         cacheKey,
-			Data:        rawBytes,
-			LastFetched: time.Now(),
-			TTLHours:    24,
-		})
-		if saveErr != nil {
-
-		}
-	}
+	writeCache(ctx, cacheKey, cInfo, constants.COUNTRY_CACHE_TTL_HOURS)
 
 	// Return the newly fetched data
 	return cInfo, nil
 }
 
+// readCache returns the cached payload for 'key' if it exists and is still within its TTL.
+func readCache(ctx context.Context, key string) ([]byte, bool) {
+	cached, err := firebase.GetCacheEntry(ctx, key)
+	if err != nil || cached == nil {
+		return nil, false
+	}
+	expires := cached.LastFetched.Add(time.Duration(cached.TTLHours) * time.Hour)
+	if time.Now().After(expires) {
+		return nil, false
+	}
+	expiries.Store(key, expires)
+	return cached.Data, true
+}
+
+// writeCache stores 'value' as JSON under 'key' and reports the refresh to CacheRefreshed.
+// The data is taken to expire after 'ttlHours' (see CacheExpiry) even if it cannot be stored.
+// Failures are logged but otherwise ignored, because the caller already has fresh data to
+// return.
+func writeCache(ctx context.Context, key string, value interface{}, ttlHours int) {
+	if CacheRefreshed != nil {
+		CacheRefreshed(key, value)
+	}
+	now := time.Now()
+	expiries.Store(key, now.Add(time.Duration(ttlHours)*time.Hour))
+	rawBytes, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	saveErr := firebase.SaveCacheEntry(ctx, structs.CacheEntry{
+		Key:         key,
+		Data:        rawBytes,
+		LastFetched: now,
+		TTLHours:    ttlHours,
+	})
+	if saveErr != nil {
+		log.Printf("Warning: could not cache %s: %v\n", key, saveErr)
+	}
+}
+
 // callRestCountries does a real HTTP request to REST Countries
 func callRestCountries(countryOrISO string) (*structs.CountryInfo, error) {
-	url := fmt.Sprintf("%s%s?fields=name,capital,population,area,latlng,currencies",
+	url := fmt.Sprintf("%s%s?fields=name,cca2,capital,capitalInfo,population,area,latlng,currencies,timezones",
 		constants.REST_COUNTRIES_NAME,
 		countryOrISO,
 	)
@@ -85,11 +157,16 @@ func callRestCountries(countryOrISO string) (*structs.CountryInfo, error) {
 		Name struct {
 			Common string `json:"common"`
 		} `json:"name"`
-		Capital    []string               `json:"capital"`
-		Population int64                  `json:"population"`
-		Area       float64                `json:"area"`
-		Latlng     []float64              `json:"latlng"`
-		Currencies map[string]interface{} `json:"currencies"`
+		CCA2        string                 `json:"cca2"`
+		Capital     []string               `json:"capital"`
+		Population  int64                  `json:"population"`
+		Area        float64                `json:"area"`
+		Latlng      []float64              `json:"latlng"`
+		Currencies  map[string]interface{} `json:"currencies"`
+		Timezones   []string               `json:"timezones"`
+		CapitalInfo struct {
+			Latlng []float64 `json:"latlng"`
+		} `json:"capitalInfo"`
 	}
 
 	var parsed []restCountry
@@ -103,11 +180,13 @@ func callRestCountries(countryOrISO string) (*structs.CountryInfo, error) {
 	first := parsed[0]
 	cInfo := &structs.CountryInfo{
 		Name:         first.Name.Common,
+		ISOCode:      first.CCA2,
 		Capital:      "",
 		Population:   first.Population,
 		Area:         first.Area,
 		BaseCurrency: "",
 		Coordinates:  structs.Coordinates{},
+		Timezones:    first.Timezones,
 	}
 
 	// Capital
@@ -119,6 +198,10 @@ func callRestCountries(countryOrISO string) (*structs.CountryInfo, error) {
 		cInfo.Coordinates.Lat = first.Latlng[0]
 		cInfo.Coordinates.Lon = first.Latlng[1]
 	}
+	if len(first.CapitalInfo.Latlng) == 2 {
+		cInfo.CapitalCoordinates.Lat = first.CapitalInfo.Latlng[0]
+		cInfo.CapitalCoordinates.Lon = first.CapitalInfo.Latlng[1]
+	}
 	// currency
 	if len(first.Currencies) > 0 {
 		for key := range first.Currencies {
@@ -130,8 +213,88 @@ func callRestCountries(countryOrISO string) (*structs.CountryInfo, error) {
 	return cInfo, nil
 }
 
-// realFetchMeteoData fetches average temperature and precipitation from open-meteo
+// realFetchRegionCountries returns the common names of the countries in a REST Countries
+// region (e.g. "Europe") or subregion (e.g. "Northern Europe"), sorted by name. The list
+// is cached for COUNTRY_CACHE_TTL_HOURS like the country data itself.
+func realFetchRegionCountries(region string) ([]string, error) {
+	ctx := context.Background()
+	cacheKey := RegionCacheKey(region)
+	if cached, ok := readCache(ctx, cacheKey); ok {
+		var names []string
+		if json.Unmarshal(cached, &names) == nil {
+			return names, nil
+		}
+	}
+
+	// Regions are tried first; a 404 means the name may be a subregion instead
+	names, status, err := callRestCountriesRegion(constants.REST_COUNTRIES_REGION, region)
+	if status == http.StatusNotFound {
+		names, status, err = callRestCountriesRegion(constants.REST_COUNTRIES_SUBREGION, region)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no countries found in region %s", region)
+	}
+	writeCache(ctx, cacheKey, names, constants.COUNTRY_CACHE_TTL_HOURS)
+	return names, nil
+}
+
+// callRestCountriesRegion lists the countries below 'baseURL' + region. The HTTP status is
+// returned alongside the error so the caller can tell an unknown region from an outage.
+func callRestCountriesRegion(baseURL, region string) ([]string, int, error) {
+	resp, err := http.Get(baseURL + url.PathEscape(region) + "?fields=name")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to call REST Countries: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, resp.StatusCode, fmt.Errorf("REST Countries returned %d => %s", resp.StatusCode, string(body))
+	}
+
+	var parsed []struct {
+		Name struct {
+			Common string `json:"common"`
+		} `json:"name"`
+	}
+	if decodeErr := json.NewDecoder(resp.Body).Decode(&parsed); decodeErr != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to decode restcountries JSON: %v", decodeErr)
+	}
+	names := make([]string, 0, len(parsed))
+	for _, c := range parsed {
+		if c.Name.Common != "" {
+			names = append(names, c.Name.Common)
+		}
+	}
+	sort.Strings(names)
+	return names, resp.StatusCode, nil
+}
+
+// realFetchMeteoData fetches average temperature and precipitation, using the Firestore
+// cache for up to METEO_CACHE_TTL_HOURS before calling open-meteo again.
 func realFetchMeteoData(lat, lon float64) (*structs.MeteoData, error) {
+	ctx := context.Background()
+	cacheKey := MeteoCacheKey(lat, lon)
+	if cached, ok := readCache(ctx, cacheKey); ok {
+		var mData structs.MeteoData
+		if json.Unmarshal(cached, &mData) == nil {
+			return &mData, nil
+		}
+	}
+
+	mData, err := callOpenMeteo(lat, lon)
+	if err != nil {
+		return nil, err
+	}
+	writeCache(ctx, cacheKey, mData, constants.METEO_CACHE_TTL_HOURS)
+	return mData, nil
+}
+
+// callOpenMeteo does a real HTTP request to open-meteo
+func callOpenMeteo(lat, lon float64) (*structs.MeteoData, error) {
 	url := fmt.Sprintf("%s?latitude=%.4f&longitude=%.4f&hourly=temperature_2m,precipitation",
 		constants.OPEN_METEO_API,
 		lat,
@@ -181,11 +344,32 @@ func realFetchMeteoData(lat, lon float64) (*structs.MeteoData, error) {
 	return &structs.MeteoData{
 		AverageTemp:          avgT,
 		AveragePrecipitation: avgP,
+		TotalPrecipitation:   sumP,
 	}, nil
 }
 
-// realFetchCurrencyRates calls the currency API to retrieve exchange rates
+// realFetchCurrencyRates returns exchange rates for 'base', using the Firestore cache for
+// up to CURRENCY_CACHE_TTL_HOURS before calling the currency API again.
 func realFetchCurrencyRates(base string) (structs.CurrencyRates, error) {
+	ctx := context.Background()
+	cacheKey := CurrencyCacheKey(base)
+	if cached, ok := readCache(ctx, cacheKey); ok {
+		var rates structs.CurrencyRates
+		if json.Unmarshal(cached, &rates) == nil {
+			return rates, nil
+		}
+	}
+
+	rates, err := callCurrencyAPI(base)
+	if err != nil {
+		return nil, err
+	}
+	writeCache(ctx, cacheKey, rates, constants.CURRENCY_CACHE_TTL_HOURS)
+	return rates, nil
+}
+
+// callCurrencyAPI calls the currency API to retrieve exchange rates
+func callCurrencyAPI(base string) (structs.CurrencyRates, error) {
 	url := fmt.Sprintf("%s%s", constants.CURRENCY_API, strings.ToUpper(base))
 	resp, err := http.Get(url)
 	if err != nil {
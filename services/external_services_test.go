@@ -76,6 +76,29 @@ func TestFetchCurrencyRatesStub(t *testing.T) {
 	}
 }
 
+func TestCallRestCountriesRegion(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/Northern%20Europe" && r.URL.Path != "/Northern Europe" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`[{"name":{"common":"Sweden"}},{"name":{"common":"Norway"}}]`))
+	}))
+	defer ts.Close()
+
+	names, status, err := callRestCountriesRegion(ts.URL+"/", "Northern Europe")
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("Expected success, got %d %v", status, err)
+	}
+	if len(names) != 2 || names[0] != "Norway" || names[1] != "Sweden" {
+		t.Errorf("Expected [Norway Sweden], got %v", names)
+	}
+
+	if _, status, err := callRestCountriesRegion(ts.URL+"/", "Atlantis"); err == nil || status != http.StatusNotFound {
+		t.Errorf("Expected a 404 error, got %d %v", status, err)
+	}
+}
+
 func TestMockDataIntegration(t *testing.T) {
 	mockFile := "mock_files/restcountries_norway.json"
 	if _, err := os.Stat(mockFile); os.IsNotExist(err) {
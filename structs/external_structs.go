@@ -13,11 +13,16 @@ type CacheEntryEx struct {
 // CountryInfo holds data about a country for external usage.
 type CountryInfo struct {
 	Name         string
+	ISOCode      string // ISOCode is the ISO 3166-1 alpha-2 code, e.g. "NO".
 	Capital      string
 	Population   int64
 	Area         float64
 	BaseCurrency string
 	Coordinates  Coordinates
+	// CapitalCoordinates is the location of the capital; zero if REST Countries has none.
+	CapitalCoordinates Coordinates
+	// Timezones are UTC offsets such as "UTC+01:00".
+	Timezones []string
 }
 
 // Coordinates represents latitude/longitude
@@ -26,21 +31,43 @@ type Coordinates struct {
 	Lon float64
 }
 
-// MeteoData holds average temperature and precipitation.
+// MeteoData holds average temperature and precipitation over the forecast period, and
+// the precipitation total of that period.
 type MeteoData struct {
 	AverageTemp          float64
 	AveragePrecipitation float64
+	TotalPrecipitation   float64
 }
 
 // CurrencyRates is a map from currency code to exchange rate.
 type CurrencyRates map[string]float64
 
 // Dashboard represents the data returned by GET /dashboard/v1/dashboards/{id}.
+// Comparison dashboards leave Features empty and fill Countries and Aggregates instead.
 type Dashboard struct {
-	Country       string            `json:"country,omitempty"`
-	ISOCode       string            `json:"isoCode,omitempty"`
-	Features      DashboardFeatures `json:"features,omitempty"`
-	LastRetrieval time.Time         `json:"lastRetrieval,omitempty"`
+	Country       string                      `json:"country,omitempty"`
+	ISOCode       string                      `json:"isoCode,omitempty"`
+	Region        string                      `json:"region,omitempty"`
+	Features      DashboardFeatures           `json:"features,omitempty"`
+	Countries     []CountryDashboard          `json:"countries,omitempty"`
+	Aggregates    map[string]FeatureAggregate `json:"aggregates,omitempty"`
+	LastRetrieval time.Time                   `json:"lastRetrieval,omitempty"`
+}
+
+// CountryDashboard is the feature block of one country in a comparison dashboard.
+type CountryDashboard struct {
+	Country  string            `json:"country"`
+	Features DashboardFeatures `json:"features"`
+}
+
+// FeatureAggregate summarises one numeric feature across the countries of a comparison
+// dashboard. Rank lists the countries from the highest to the lowest value; countries
+// for which the value could not be retrieved are left out.
+type FeatureAggregate struct {
+	Min  float64  `json:"min"`
+	Max  float64  `json:"max"`
+	Mean float64  `json:"mean"`
+	Rank []string `json:"rank"`
 }
 
 // DashboardFeatures contains the data that the user requested for inclusion in the dashboard.
@@ -53,4 +80,13 @@ type DashboardFeatures struct {
 	Population       int64              `json:"population,omitempty"`
 	Area             float64            `json:"area,omitempty"`
 	TargetCurrencies map[string]float64 `json:"targetCurrencies,omitempty"`
+
+	// Derived metrics
+	PopulationDensity float64 `json:"populationDensity,omitempty"`
+	LocalTime         string  `json:"localTime,omitempty"` // RFC 3339 in the capital's UTC offset
+	TimeZone          string  `json:"timeZone,omitempty"`
+	DistanceToCapital float64 `json:"distanceToCapital,omitempty"`
+
+	// Units labels the unit of every numeric feature in the block, e.g. "temperature": "°F".
+	Units map[string]string `json:"units,omitempty"`
 }
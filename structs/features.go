@@ -12,6 +12,35 @@ type Features struct {
 	TargetCurrencies []string `json:"targetCurrencies"` // Is a list of currency codes for which the user
 	// wants to see exchange rates relative to the country's base currency.
 
+	// Presentation options; empty values select the defaults (°C, km², hourly average).
+	TemperatureUnit   string `json:"temperatureUnit,omitempty"`   // "C", "F" or "K".
+	AreaUnit          string `json:"areaUnit,omitempty"`          // "km2" or "mi2", also used for the population density.
+	PrecipitationMode string `json:"precipitationMode,omitempty"` // "average" (mm/h) or "total" (mm over the forecast).
+
+	// Derived metrics
+	PopulationDensity bool            `json:"populationDensity,omitempty"` // Population per area unit.
+	LocalTime         bool            `json:"localTime,omitempty"`         // Current time at the capital.
+	DistanceFrom      *ReferencePoint `json:"distanceFrom,omitempty"`      // Great-circle distance from this point to the capital.
+}
+
+// Values of the presentation options
+const (
+	TemperatureCelsius    = "C"
+	TemperatureFahrenheit = "F"
+	TemperatureKelvin     = "K"
+
+	AreaSquareKilometres = "km2"
+	AreaSquareMiles      = "mi2"
+
+	PrecipitationAverage = "average"
+	PrecipitationTotal   = "total"
+)
+
+// ReferencePoint is a location that distances are measured from.
+type ReferencePoint struct {
+	Name      string  `json:"name,omitempty"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
 }
 
 // NewFeatures creates a Features struct with a guaranteed empty slice for TargetCurrencies
@@ -0,0 +1,30 @@
+// File: assignment-2/structs/idempotency.go
+package structs
+
+import "time"
+
+// IdempotencyRecord remembers a request sent with an Idempotency-Key header together with
+// the response it produced, so that retries of the same request can be answered without
+// executing it again.
+type IdempotencyRecord struct {
+	Key         string            `firestore:"key"`         // Key is the client supplied Idempotency-Key.
+	RequestHash string            `firestore:"requestHash"` // RequestHash identifies the method, path and body of the original request.
+	Completed   bool              `firestore:"completed"`   // Completed is false while the original request is still being processed.
+	StatusCode  int               `firestore:"statusCode"`  // StatusCode is the status of the original response.
+	Headers     map[string]string `firestore:"headers"`     // Headers holds the headers of the original response.
+	Body        []byte            `firestore:"body"`        // Body is the body of the original response.
+	Created     time.Time         `firestore:"created"`     // Created is when the key was first seen.
+	ExpiresAt   time.Time         `firestore:"expiresAt"`   // ExpiresAt is when the key may be reused.
+	LockedUntil time.Time         `firestore:"lockedUntil"` // LockedUntil is when an unfinished reservation may be taken over.
+}
+
+// Expired reports whether the record is no longer valid at 'now'.
+func (r IdempotencyRecord) Expired(now time.Time) bool {
+	return !now.Before(r.ExpiresAt)
+}
+
+// Reusable reports whether a new request may take the key over at 'now': the record has
+// expired, or it was never completed and its lease has run out.
+func (r IdempotencyRecord) Reusable(now time.Time) bool {
+	return r.Expired(now) || (!r.Completed && !now.Before(r.LockedUntil))
+}
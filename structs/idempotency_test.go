@@ -0,0 +1,41 @@
+// File: assignment-2/structs/idempotency_test.go
+package structs
+
+import (
+	"testing"
+	"time"
+)
+
+// TestIdempotencyRecordExpired checks the expiry boundary of an idempotency record.
+func TestIdempotencyRecordExpired(t *testing.T) {
+	expires := time.Date(2025, 4, 10, 12, 0, 0, 0, time.UTC)
+	rec := IdempotencyRecord{Key: "abc", ExpiresAt: expires}
+
+	if rec.Expired(expires.Add(-time.Second)) {
+		t.Error("Expected the record to be valid before ExpiresAt")
+	}
+	if !rec.Expired(expires) {
+		t.Error("Expected the record to be expired at ExpiresAt")
+	}
+}
+
+// TestIdempotencyRecordReusable checks that only unfinished reservations are taken over
+// once their lease has run out.
+func TestIdempotencyRecordReusable(t *testing.T) {
+	now := time.Date(2025, 4, 10, 12, 0, 0, 0, time.UTC)
+	rec := IdempotencyRecord{Key: "abc", ExpiresAt: now.Add(time.Hour), LockedUntil: now.Add(time.Minute)}
+
+	if rec.Reusable(now) {
+		t.Error("Expected a locked reservation not to be reusable")
+	}
+	if !rec.Reusable(now.Add(time.Minute)) {
+		t.Error("Expected a reservation to be reusable once its lease has run out")
+	}
+	rec.Completed = true
+	if rec.Reusable(now.Add(time.Minute)) {
+		t.Error("Expected a completed record to be kept until it expires")
+	}
+	if !rec.Reusable(now.Add(time.Hour)) {
+		t.Error("Expected an expired record to be reusable")
+	}
+}
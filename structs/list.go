@@ -0,0 +1,35 @@
+// File: assignment-2/structs/list.go
+package structs
+
+import "time"
+
+// Default and maximum number of items returned by a single listing request.
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 500
+)
+
+// ListOptions describes one page of a collection listing. The fields refer to the
+// stored (Firestore) field names, so the options can be pushed down into a query.
+type ListOptions struct {
+	Limit      int               // Limit is the page size; values <= 0 select DefaultPageSize.
+	Cursor     string            // Cursor is the ID of the last document of the previous page.
+	OrderBy    string            // OrderBy is the field to sort on; empty sorts by document ID only.
+	Descending bool              // Descending reverses the sort order.
+	Equals     map[string]string // Equals holds field == value filters.
+	Contains   map[string]string // Contains holds filters on array fields that must contain the value.
+	RangeField string            // RangeField is the timestamp field restricted by From/To.
+	From       time.Time         // From is the inclusive lower bound on RangeField (zero = unbounded).
+	To         time.Time         // To is the exclusive upper bound on RangeField (zero = unbounded).
+}
+
+// PageSize returns the effective page size, applying the default and the upper limit.
+func (o ListOptions) PageSize() int {
+	if o.Limit <= 0 {
+		return DefaultPageSize
+	}
+	if o.Limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return o.Limit
+}
@@ -0,0 +1,22 @@
+// File: assignment-2/structs/list_test.go
+package structs
+
+import "testing"
+
+// TestListOptionsPageSize checks the default and the upper bound of the page size.
+func TestListOptionsPageSize(t *testing.T) {
+	cases := []struct {
+		limit int
+		want  int
+	}{
+		{0, DefaultPageSize},
+		{-5, DefaultPageSize},
+		{25, 25},
+		{MaxPageSize + 1, MaxPageSize},
+	}
+	for _, tc := range cases {
+		if got := (ListOptions{Limit: tc.limit}).PageSize(); got != tc.want {
+			t.Errorf("Limit=%d: expected page size %d, got %d", tc.limit, tc.want, got)
+		}
+	}
+}
@@ -1,13 +1,301 @@
 // File: assignment-2/structs/notifications.go
 package structs
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // Notification represents a single webhook registration that is triggered when certain events occur.
 type Notification struct {
 	ID      string    `json:"id,omitempty"`      // ID is the unique identifier for this webhook registration.
 	URL     string    `json:"url"`               // Is the destination for the POST request when an event matching this webhook is triggered.
-	Country string    `json:"country,omitempty"` // Country indicates the country filter. If empty, the webhook applies to all countries.
-	Event   string    `json:"event"`             // Event is the type of event on which the webhook triggers ("REGISTER", "CHANGE", "DELETE", "INVOKE").
+	Country string    `json:"country,omitempty"` // Country is the ISO 3166-1 alpha-2 code of the country filter. If empty, the webhook applies to all countries.
+	Events  []string  `json:"events,omitempty"`  // Events are the types of event on which the webhook triggers ("REGISTER", "CHANGE", "DELETE", "INVOKE", "CONDITION").
 	Created time.Time `json:"created,omitempty"` // Created is the time at which this webhook registration was initially created.
+	// Event is the single event of webhooks stored before Events was introduced. Requests
+	// may still use it instead of Events.
+	Event string `json:"event,omitempty"`
+	// Registrations scopes the webhook to the events of these registration IDs.
+	Registrations []string `json:"registrations,omitempty"`
+	// Tags scopes the webhook to the events of registrations with one of these tags.
+	Tags []string `json:"tags,omitempty"`
+	// PayloadVersion is the schema version of the delivered payloads. Webhooks stored
+	// before it was introduced have none and get version 1.
+	PayloadVersion int `json:"payloadVersion,omitempty"`
+	// Format is how payloads are sent: FormatJSON (the default) or as a CloudEvent.
+	Format string `json:"format,omitempty"`
+	// IncludeData adds a snapshot of the registration to version 2 payloads.
+	IncludeData bool `json:"includeData,omitempty"`
+	// Retry is how failed deliveries are retried. If nil, the service default applies.
+	Retry *RetryPolicy `json:"retry,omitempty"`
+	// Deliveries sums up the delivery log. It is only filled in for a single notification.
+	Deliveries *DeliverySummary `json:"deliveries,omitempty"`
+	// Secret is the signing secret. It is only returned when it is created or rotated.
+	Secret string `json:"secret,omitempty"`
+	// Secrets are the stored signing secrets, the current one first.
+	Secrets []WebhookSecret `json:"-"`
+	// Condition is the expression a CONDITION webhook fires on, e.g. "temperature < -10".
+	Condition string `json:"condition,omitempty"`
+	// Hysteresis is how far a value must move back past the threshold before the
+	// condition can fire again.
+	Hysteresis float64 `json:"hysteresis,omitempty"`
+	// ConditionState is the result of the last evaluation of the condition.
+	ConditionState *ConditionState `json:"conditionState,omitempty"`
+	// Status is WebhookPending until the URL has echoed the verification challenge.
+	Status   string    `json:"status,omitempty"`
+	Verified time.Time `json:"verified,omitempty"` // Verified is when the challenge was echoed.
+}
+
+// Verification states of a webhook
+const (
+	WebhookPending = "pending"
+	WebhookActive  = "active"
+)
+
+// Payload formats of a webhook
+const (
+	FormatJSON              = "json"
+	FormatCloudEvents       = "cloudevents"        // CloudEvents 1.0 in structured mode
+	FormatCloudEventsBinary = "cloudevents-binary" // CloudEvents 1.0 in binary mode
+)
+
+// Schema returns the payload schema version of the webhook.
+func (n Notification) Schema() int {
+	if n.PayloadVersion == 0 {
+		return 1
+	}
+	return n.PayloadVersion
+}
+
+// Active reports whether the webhook receives events. Webhooks stored before verification
+// was introduced have no status and stay active.
+func (n Notification) Active() bool {
+	return n.Status != WebhookPending
+}
+
+// EventList returns the events the webhook triggers on, including the legacy Event.
+func (n Notification) EventList() []string {
+	if n.Event == "" {
+		return n.Events
+	}
+	for _, e := range n.Events {
+		if e == n.Event {
+			return n.Events
+		}
+	}
+	return append([]string{n.Event}, n.Events...)
+}
+
+// Subscribes reports whether the webhook triggers on 'event'.
+func (n Notification) Subscribes(event string) bool {
+	for _, e := range n.EventList() {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// InScope reports whether an event concerns the registrations the webhook is scoped to.
+// A webhook scoped by both registrations and tags needs a match on each.
+func (n Notification) InScope(ev WebhookEvent) bool {
+	return (len(n.Registrations) == 0 || overlaps(n.Registrations, ev.Registrations)) &&
+		(len(n.Tags) == 0 || overlaps(n.Tags, ev.Tags))
+}
+
+// Scope returns the part of an event that concerns the registrations the webhook is
+// scoped to, and whether there is any. For events that carry the tags of each of their
+// registrations, registrations outside the scope are left out and Tags is cut down to the
+// tags of those that remain.
+func (n Notification) Scope(ev WebhookEvent) (WebhookEvent, bool) {
+	if ev.RegistrationTags == nil || len(n.Registrations) == 0 && len(n.Tags) == 0 {
+		return ev, n.InScope(ev)
+	}
+	var ids, tags []string
+	for _, id := range ev.Registrations {
+		regTags := ev.RegistrationTags[id]
+		if (len(n.Registrations) == 0 || overlaps(n.Registrations, []string{id})) &&
+			(len(n.Tags) == 0 || overlaps(n.Tags, regTags)) {
+			ids = append(ids, id)
+			for _, tag := range regTags {
+				if !overlaps(tags, []string{tag}) {
+					tags = append(tags, tag)
+				}
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return ev, false
+	}
+	scoped := ev
+	scoped.Registrations = ids
+	scoped.Tags = tags
+	scoped.RegistrationTags = make(map[string][]string, len(ids))
+	for _, id := range ids {
+		scoped.RegistrationTags[id] = ev.RegistrationTags[id]
+	}
+	return scoped, true
+}
+
+// overlaps reports whether 'a' and 'b' have an element in common.
+func overlaps(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WebhookEvent is an event webhooks are triggered by.
+type WebhookEvent struct {
+	Event   string // Event is one of "REGISTER", "CHANGE", "DELETE" or "INVOKE".
+	Country string // Country is the name or ISO code of the registration's country.
+	// Registrations are the IDs of the registrations concerned: one, all those of a
+	// country created by a bulk import, or none for a dashboard built without one.
+	Registrations []string
+	Tags          []string // Tags are the tags of those registrations.
+	// RegistrationTags maps each registration of an event about several registrations to
+	// its tags, so the event can be scoped per webhook. It is nil for other events.
+	RegistrationTags map[string][]string
+	// Changes is the RFC 6902 JSON Patch from the old to the new registration of a CHANGE.
+	Changes json.RawMessage
+	// Data is the registration after the event, or before it was deleted. It is nil for
+	// events about several registrations or none.
+	Data *Registration
+	// Condition and Value are the condition of a CONDITION event and the value that met it.
+	Condition string
+	Value     *float64
+	Test      bool // Test marks the events sent by POST {id}/test.
+}
+
+// WebhookPayload is the body of a delivery in payload schema version 2.
+type WebhookPayload struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	EventID       string `json:"eventId"` // EventID is unique per event and webhook, and stays the same when a delivery is retried.
+	Event         string `json:"event"`
+	// Time is when the event occurred, in RFC 3339 format.
+	Time      time.Time       `json:"time"`
+	WebhookID string          `json:"webhookId"`
+	Subject   WebhookSubject  `json:"subject"`
+	Changes   json.RawMessage `json:"changes,omitempty"`
+	Condition string          `json:"condition,omitempty"`
+	Value     *float64        `json:"value,omitempty"`
+	Data      *Registration   `json:"data,omitempty"` // Data is only included for webhooks that ask for it.
+	Test      bool            `json:"test,omitempty"`
+}
+
+// WebhookSubject is the resource an event is about: a registration, the registrations of
+// a country created by a bulk import (only those in the webhook's scope), or a country for
+// CONDITION events and dashboard queries.
+type WebhookSubject struct {
+	Type    string   `json:"type"` // Type is "registration", "registrations" or "country".
+	ID      string   `json:"id,omitempty"`
+	IDs     []string `json:"ids,omitempty"`
+	Country string   `json:"country,omitempty"`
+}
+
+// WebhookSecret is a secret deliveries are signed with. A rotated secret stays valid
+// until Expires, so receivers can switch to the new one without rejecting deliveries.
+type WebhookSecret struct {
+	Value   string    `firestore:"value"`
+	Created time.Time `firestore:"created"`
+	Expires time.Time `firestore:"expires"` // Expires is zero for the current secret.
+}
+
+// ActiveAt reports whether deliveries at 't' are signed with the secret.
+func (s WebhookSecret) ActiveAt(t time.Time) bool {
+	return s.Expires.IsZero() || t.Before(s.Expires)
+}
+
+// ConditionState is what a condition webhook remembers between two evaluations.
+type ConditionState struct {
+	Value     float64   `json:"value" firestore:"value"`         // Value is the last evaluated value.
+	Baseline  float64   `json:"baseline" firestore:"baseline"`   // Baseline is the value at the last notification, which changes are measured from.
+	Triggered bool      `json:"triggered" firestore:"triggered"` // Triggered is true from a threshold notification until the value is back past the hysteresis.
+	Evaluated time.Time `json:"evaluated" firestore:"evaluated"`
+	Fired     time.Time `json:"fired,omitempty" firestore:"fired"` // Fired is the time of the last notification.
+}
+
+// RetryPolicy describes how often a failed webhook delivery is attempted again. The delay
+// starts at InitialBackoffSeconds and doubles after every failed attempt, up to
+// MaxBackoffSeconds.
+type RetryPolicy struct {
+	MaxAttempts           int `json:"maxAttempts" firestore:"maxAttempts"`                               // MaxAttempts counts the first attempt, so 1 disables retries.
+	InitialBackoffSeconds int `json:"initialBackoffSeconds,omitempty" firestore:"initialBackoffSeconds"` // InitialBackoffSeconds is the delay after the first failure.
+	MaxBackoffSeconds     int `json:"maxBackoffSeconds,omitempty" firestore:"maxBackoffSeconds"`         // MaxBackoffSeconds caps the delay.
+}
+
+// Backoff returns the delay before the next attempt after 'failed' failed attempts.
+func (p RetryPolicy) Backoff(failed int) time.Duration {
+	delay := time.Duration(p.InitialBackoffSeconds) * time.Second
+	limit := time.Duration(p.MaxBackoffSeconds) * time.Second
+	for i := 1; i < failed && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+// WebhookDelivery is one POST to a webhook that is waiting in the delivery queue, or that
+// was moved to the dead letters after its last attempt failed. The payload is built when
+// the event occurs, so a delayed delivery still reports the original event time.
+type WebhookDelivery struct {
+	ID             string    `json:"id,omitempty" firestore:"-"`
+	NotificationID string    `json:"notificationId" firestore:"notificationId"`
+	URL            string    `json:"url" firestore:"url"`
+	Event          string    `json:"event" firestore:"event"`
+	EventID        string    `json:"eventId,omitempty" firestore:"eventId"`
+	Country        string    `json:"country,omitempty" firestore:"country"`
+	Payload        []byte    `json:"payload" firestore:"payload"`
+	Enqueued       time.Time `json:"enqueued" firestore:"enqueued"`
+	// Retry is the policy of the webhook at the time of the event
+	Retry       RetryPolicy `json:"retry" firestore:"retry"`
+	Attempts    int         `json:"attempts" firestore:"attempts"`
+	NextAttempt time.Time   `json:"nextAttempt,omitempty" firestore:"nextAttempt"`
+	LastError   string      `json:"lastError,omitempty" firestore:"lastError"`
+	Failed      time.Time   `json:"failed,omitempty" firestore:"failed"` // Failed is when it became a dead letter
+	// Headers are sent with the payload, such as its Content-Type and CloudEvents attributes
+	Headers map[string]string `json:"headers,omitempty" firestore:"headers"`
+}
+
+// DeliveryAttempt is one logged attempt to deliver a webhook.
+type DeliveryAttempt struct {
+	ID             string    `json:"id,omitempty" firestore:"-"`
+	NotificationID string    `json:"notificationId" firestore:"notificationId"`
+	DeliveryID     string    `json:"deliveryId,omitempty" firestore:"deliveryId"`
+	URL            string    `json:"url" firestore:"url"`
+	Event          string    `json:"event" firestore:"event"`
+	EventID        string    `json:"eventId,omitempty" firestore:"eventId"`
+	Country        string    `json:"country,omitempty" firestore:"country"`
+	Payload        []byte    `json:"payload,omitempty" firestore:"payload"`
+	Attempt        int       `json:"attempt" firestore:"attempt"`                 // Attempt is 1 for the first attempt of a delivery.
+	Time           time.Time `json:"time" firestore:"time"`                       // Time is when the request was sent.
+	StatusCode     int       `json:"statusCode,omitempty" firestore:"statusCode"` // StatusCode is 0 if no response was received.
+	LatencyMs      int64     `json:"latencyMs" firestore:"latencyMs"`
+	Error          string    `json:"error,omitempty" firestore:"error"`
+	Success        bool      `json:"success" firestore:"success"`
+}
+
+// DeliverySummary sums up the logged delivery attempts of a webhook.
+type DeliverySummary struct {
+	Attempts    int              `json:"attempts"`
+	Successes   int              `json:"successes"`
+	SuccessRate float64          `json:"successRate"` // SuccessRate is Successes / Attempts, 0 without attempts.
+	LastAttempt *DeliveryAttempt `json:"lastAttempt,omitempty"`
+}
+
+// WebhookTestResult is the receiver's response to a test event.
+type WebhookTestResult struct {
+	Success    bool              `json:"success"`
+	StatusCode int               `json:"statusCode,omitempty"` // StatusCode is 0 if no response was received.
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body,omitempty"` // Body is cut off after 4 KB.
+	LatencyMs  int64             `json:"latencyMs"`
+	Error      string            `json:"error,omitempty"`
 }
@@ -106,3 +106,123 @@ func TestNotificationStruct(t *testing.T) {
 		}
 	})
 }
+
+// TestRetryPolicyBackoff checks that the delay doubles and is capped.
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 6, InitialBackoffSeconds: 30, MaxBackoffSeconds: 100}
+	want := []time.Duration{30 * time.Second, 60 * time.Second, 100 * time.Second, 100 * time.Second}
+	for i, w := range want {
+		if got := p.Backoff(i + 1); got != w {
+			t.Errorf("After %d failures: expected %v, got %v", i+1, w, got)
+		}
+	}
+}
+
+// TestWebhookSecretActiveAt checks the grace period of rotated secrets.
+func TestWebhookSecretActiveAt(t *testing.T) {
+	now := time.Now()
+	if !(WebhookSecret{Value: "current"}).ActiveAt(now) {
+		t.Error("Expected a secret without expiry to be active")
+	}
+	rotated := WebhookSecret{Value: "old", Expires: now.Add(time.Hour)}
+	if !rotated.ActiveAt(now) || rotated.ActiveAt(now.Add(2*time.Hour)) {
+		t.Error("Expected a rotated secret to be active until it expires")
+	}
+}
+
+// TestNotificationActive checks that only pending webhooks are inactive.
+func TestNotificationActive(t *testing.T) {
+	for status, want := range map[string]bool{"": true, WebhookActive: true, WebhookPending: false} {
+		if got := (Notification{Status: status}).Active(); got != want {
+			t.Errorf("Status %q: expected Active() = %v, got %v", status, want, got)
+		}
+	}
+}
+
+// TestNotificationSubscribes checks that the legacy single event counts with the list.
+func TestNotificationSubscribes(t *testing.T) {
+	n := Notification{Event: "REGISTER", Events: []string{"CHANGE", "DELETE"}}
+	if got := n.EventList(); !reflect.DeepEqual(got, []string{"REGISTER", "CHANGE", "DELETE"}) {
+		t.Errorf("Expected the legacy event first, got %v", got)
+	}
+	if !n.Subscribes("REGISTER") || !n.Subscribes("DELETE") || n.Subscribes("INVOKE") {
+		t.Errorf("Unexpected subscriptions of %+v", n)
+	}
+	if got := (Notification{Event: "CHANGE", Events: []string{"CHANGE"}}).EventList(); len(got) != 1 {
+		t.Errorf("Expected no duplicate, got %v", got)
+	}
+}
+
+// TestNotificationInScope checks scoping by registration and by tag.
+func TestNotificationInScope(t *testing.T) {
+	ev := WebhookEvent{Event: "INVOKE", Registrations: []string{"a"}, Tags: []string{"ops", "team-a"}}
+	cases := []struct {
+		n    Notification
+		want bool
+	}{
+		{Notification{}, true},
+		{Notification{Registrations: []string{"b", "a"}}, true},
+		{Notification{Registrations: []string{"b"}}, false},
+		{Notification{Tags: []string{"team-a"}}, true},
+		{Notification{Tags: []string{"team-b"}}, false},
+		{Notification{Registrations: []string{"a"}, Tags: []string{"team-b"}}, false},
+	}
+	for _, c := range cases {
+		if got := c.n.InScope(ev); got != c.want {
+			t.Errorf("%+v: expected %v, got %v", c.n, c.want, got)
+		}
+	}
+	if (Notification{Tags: []string{"ops"}}).InScope(WebhookEvent{Event: "INVOKE"}) {
+		t.Error("Expected a scoped webhook to ignore events without registrations")
+	}
+}
+
+// TestNotificationScope checks that events about several registrations are cut down to
+// the registrations a webhook is scoped to.
+func TestNotificationScope(t *testing.T) {
+	ev := WebhookEvent{
+		Event: "REGISTER", Country: "NO", Registrations: []string{"a", "b", "c"}, Tags: []string{"ops", "team-a", "team-b"},
+		RegistrationTags: map[string][]string{"a": {"ops", "team-a"}, "b": {"team-b"}, "c": nil},
+	}
+	cases := []struct {
+		n       Notification
+		ids     []string
+		tags    []string
+		inScope bool
+	}{
+		{Notification{}, []string{"a", "b", "c"}, []string{"ops", "team-a", "team-b"}, true},
+		{Notification{Registrations: []string{"c", "b"}}, []string{"b", "c"}, []string{"team-b"}, true},
+		{Notification{Tags: []string{"team-a"}}, []string{"a"}, []string{"ops", "team-a"}, true},
+		{Notification{Registrations: []string{"b"}, Tags: []string{"team-a"}}, nil, nil, false},
+		{Notification{Tags: []string{"team-c"}}, nil, nil, false},
+	}
+	for _, c := range cases {
+		got, ok := c.n.Scope(ev)
+		if ok != c.inScope {
+			t.Errorf("%+v: expected in scope %v, got %v", c.n, c.inScope, ok)
+			continue
+		}
+		if ok && (!reflect.DeepEqual(got.Registrations, c.ids) || !reflect.DeepEqual(got.Tags, c.tags)) {
+			t.Errorf("%+v: expected %v with tags %v, got %v with tags %v", c.n, c.ids, c.tags, got.Registrations, got.Tags)
+		}
+	}
+	if len(ev.Registrations) != 3 {
+		t.Errorf("Expected the original event to be left unchanged, got %v", ev.Registrations)
+	}
+
+	// Events without per-registration tags are matched as a whole
+	single := WebhookEvent{Event: "CHANGE", Registrations: []string{"a"}, Tags: []string{"ops"}}
+	if got, ok := (Notification{Tags: []string{"ops"}}).Scope(single); !ok || !reflect.DeepEqual(got, single) {
+		t.Errorf("Expected the event unchanged, got %+v (%v)", got, ok)
+	}
+}
+
+// TestNotificationSchema checks that webhooks without a payload version get version 1.
+func TestNotificationSchema(t *testing.T) {
+	if v := (Notification{}).Schema(); v != 1 {
+		t.Errorf("Expected version 1, got %d", v)
+	}
+	if v := (Notification{PayloadVersion: 2}).Schema(); v != 2 {
+		t.Errorf("Expected version 2, got %d", v)
+	}
+}
@@ -6,9 +6,20 @@ import "time"
 // Registration describes a configuration used for building a dashboard.
 
 type Registration struct {
-	ID         string    `json:"id,omitempty"`      // ID is the unique identifier
-	Country    string    `json:"country,omitempty"` // Country is the name.
-	ISOCode    string    `json:"isoCode,omitempty"` // ISOCode is the ISO country code.
-	Features   Features  `json:"features"`          // Features holds the boolean flags and target currencies that specify.
-	LastChange time.Time `json:"lastChange"`        // LastChange indicates when this registration was last updated.
+	ID         string    `json:"id,omitempty"`        // ID is the unique identifier
+	Country    string    `json:"country,omitempty"`   // Country is the name.
+	ISOCode    string    `json:"isoCode,omitempty"`   // ISOCode is the ISO country code.
+	Countries  []string  `json:"countries,omitempty"` // Countries lists the names or ISO codes of a comparison dashboard.
+	Region     string    `json:"region,omitempty"`    // Region is a REST Countries region or subregion to compare.
+	Snapshot   string    `json:"snapshot,omitempty"`  // Snapshot is the schedule on which dashboard snapshots are stored, if any.
+	Features   Features  `json:"features"`            // Features holds the boolean flags and target currencies that specify.
+	Tags       []string  `json:"tags,omitempty"`      // Tags label the registration, e.g. with the team it belongs to.
+	LastChange time.Time `json:"lastChange"`          // LastChange indicates when this registration was last updated.
+	Revision   int64     `json:"revision"`            // Revision is incremented on every write and exposed as the ETag.
+}
+
+// IsComparison reports whether the registration compares several countries instead of
+// describing a single one.
+func (r Registration) IsComparison() bool {
+	return len(r.Countries) > 0 || r.Region != ""
 }
@@ -90,4 +90,16 @@ func TestRegistrationStruct(t *testing.T) {
 				original.LastChange, parsed.LastChange)
 		}
 	})
+
+	t.Run("IsComparison", func(t *testing.T) {
+		if (Registration{Country: "Norway"}).IsComparison() {
+			t.Error("A single-country registration is not a comparison")
+		}
+		if !(Registration{Countries: []string{"Norway", "Sweden"}}).IsComparison() {
+			t.Error("A registration with countries is a comparison")
+		}
+		if !(Registration{Region: "Northern Europe"}).IsComparison() {
+			t.Error("A registration with a region is a comparison")
+		}
+	})
 }
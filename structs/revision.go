@@ -0,0 +1,23 @@
+// File: assignment-2/structs/revision.go
+package structs
+
+import "time"
+
+// Operations recorded in the revision history of a registration.
+const (
+	OperationCreate  = "create"
+	OperationImport  = "import"
+	OperationUpdate  = "update"
+	OperationPatch   = "patch"
+	OperationDelete  = "delete"
+	OperationRestore = "restore"
+)
+
+// RegistrationRevision is an immutable entry in the history of a registration.
+type RegistrationRevision struct {
+	Revision     int64        `json:"revision"`        // Revision is the registration revision this entry produced.
+	Operation    string       `json:"operation"`       // Operation is one of the Operation* constants.
+	Actor        string       `json:"actor,omitempty"` // Actor identifies who made the change, if known.
+	Time         time.Time    `json:"time"`            // Time is when the change was stored.
+	Registration Registration `json:"registration"`    // Registration is the state after the change (for deletes: the deleted state).
+}
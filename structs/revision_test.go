@@ -0,0 +1,30 @@
+// File: assignment-2/structs/revision_test.go
+package structs
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRegistrationRevisionJSON checks the JSON layout of a revision entry.
+func TestRegistrationRevisionJSON(t *testing.T) {
+	rev := RegistrationRevision{
+		Revision:     2,
+		Operation:    OperationPatch,
+		Time:         time.Date(2025, 4, 10, 12, 0, 0, 0, time.UTC),
+		Registration: Registration{ID: "abc", Country: "Norway", Revision: 2},
+	}
+	raw, err := json.Marshal(rev)
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+	s := string(raw)
+	if !strings.Contains(s, `"operation":"patch"`) || !strings.Contains(s, `"registration":{"id":"abc"`) {
+		t.Errorf("Unexpected JSON: %s", s)
+	}
+	if strings.Contains(s, "actor") {
+		t.Errorf("Expected an unknown actor to be omitted: %s", s)
+	}
+}
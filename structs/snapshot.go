@@ -0,0 +1,42 @@
+// File: assignment-2/structs/snapshot.go
+package structs
+
+import "time"
+
+// Snapshot schedules a registration can use.
+const (
+	SnapshotHourly = "hourly"
+	SnapshotDaily  = "daily"
+)
+
+// SnapshotPeriod returns the interval between snapshots for a schedule, and false for
+// schedules that are not known.
+func SnapshotPeriod(schedule string) (time.Duration, bool) {
+	switch schedule {
+	case SnapshotHourly:
+		return time.Hour, true
+	case SnapshotDaily:
+		return 24 * time.Hour, true
+	}
+	return 0, false
+}
+
+// DashboardSnapshot is a dashboard stored by the snapshot scheduler.
+type DashboardSnapshot struct {
+	ID             string    `json:"id,omitempty" firestore:"-"`
+	RegistrationID string    `json:"registrationId" firestore:"registrationId"`
+	Time           time.Time `json:"time" firestore:"time"`
+	Dashboard      Dashboard `json:"dashboard" firestore:"dashboard"`
+	// Values holds the numeric features that were retrieved, per country, keyed like the
+	// comparison aggregates (e.g. "temperature", "targetCurrencies.EUR"). Unlike the
+	// dashboard it tells a missing value apart from a zero.
+	Values map[string]map[string]float64 `json:"values,omitempty" firestore:"values"`
+}
+
+// SeriesPoint is one value of a feature over time. Unit is the unit the value was
+// stored in, which follows the registration's unit options at the time of the snapshot.
+type SeriesPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+	Unit  string    `json:"unit,omitempty"`
+}
@@ -0,0 +1,27 @@
+// File: assignment-2/structs/snapshot_test.go
+package structs
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSnapshotPeriod checks the known schedules.
+func TestSnapshotPeriod(t *testing.T) {
+	cases := []struct {
+		schedule string
+		want     time.Duration
+		ok       bool
+	}{
+		{SnapshotHourly, time.Hour, true},
+		{SnapshotDaily, 24 * time.Hour, true},
+		{"", 0, false},
+		{"weekly", 0, false},
+	}
+	for _, tc := range cases {
+		got, ok := SnapshotPeriod(tc.schedule)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("%q: expected %v %v, got %v %v", tc.schedule, tc.want, tc.ok, got, ok)
+		}
+	}
+}
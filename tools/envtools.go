@@ -0,0 +1,41 @@
+// File: assignment-2/tools/envtools.go
+package tools
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// GetEnvInt returns the positive integer stored in the environment variable 'name', or
+// the provided fallback if the variable is unset, empty or not a positive integer.
+
+func GetEnvInt(name string, fallback int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Printf("Warning: ignoring invalid %s=%q, using %d\n", name, v, fallback)
+		return fallback
+	}
+	return n
+}
+
+// GetEnvList returns the comma-separated entries of the environment variable 'name',
+// trimmed and without empty ones, or the provided fallback if the variable is unset.
+func GetEnvList(name string, fallback []string) []string {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback
+	}
+	var list []string
+	for _, entry := range strings.Split(v, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
@@ -0,0 +1,53 @@
+// File: assignment-2/tools/envtools_test.go
+package tools
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestGetEnvInt verifies that GetEnvInt parses positive integers and otherwise
+// falls back to the default.
+
+func TestGetEnvInt(t *testing.T) {
+	const name = "ASSIGNMENT2_TEST_INT"
+	defer os.Unsetenv(name)
+
+	cases := []struct {
+		value string
+		want  int
+	}{
+		{"", 24},
+		{"48", 48},
+		{"abc", 24},
+		{"0", 24},
+		{"-3", 24},
+	}
+	for _, tc := range cases {
+		os.Setenv(name, tc.value)
+		if got := GetEnvInt(name, 24); got != tc.want {
+			t.Errorf("%s=%q: expected %d, got %d", name, tc.value, tc.want, got)
+		}
+	}
+}
+
+// TestGetEnvList verifies that GetEnvList splits and trims the entries and only falls
+// back to the default if the variable is unset.
+func TestGetEnvList(t *testing.T) {
+	const name = "ASSIGNMENT2_TEST_LIST"
+	defer os.Unsetenv(name)
+
+	os.Unsetenv(name)
+	if got := GetEnvList(name, []string{"https"}); !reflect.DeepEqual(got, []string{"https"}) {
+		t.Errorf("Expected the fallback, got %v", got)
+	}
+	os.Setenv(name, " 10.0.0.0/8, ,example.org ")
+	if got := GetEnvList(name, nil); !reflect.DeepEqual(got, []string{"10.0.0.0/8", "example.org"}) {
+		t.Errorf("Expected the trimmed entries, got %v", got)
+	}
+	os.Setenv(name, "")
+	if got := GetEnvList(name, []string{"https"}); len(got) != 0 {
+		t.Errorf("Expected an empty list, got %v", got)
+	}
+}
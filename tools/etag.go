@@ -0,0 +1,78 @@
+// File: assignment-2/tools/etag.go
+package tools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RevisionETag formats a revision number as a strong entity tag, e.g. "3".
+func RevisionETag(revision int64) string {
+	return fmt.Sprintf("\"%d\"", revision)
+}
+
+// IfMatchSatisfied reports whether 'etag' satisfies the given If-Match header value.
+// The header may be "*" or a comma-separated list of entity tags. As required by
+// RFC 9110, weak tags never match.
+func IfMatchSatisfied(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ContentETag returns a strong entity tag derived from the SHA-256 hash of the JSON
+// encoding of 'data', so equal content always yields the same tag.
+func ContentETag(data interface{}) (string, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return "\"" + hex.EncodeToString(sum[:16]) + "\"", nil
+}
+
+// IfNoneMatchSatisfied reports whether 'etag' is listed in the given If-None-Match header
+// value. Weak comparison is used, so W/"x" matches "x".
+func IfNoneMatchSatisfied(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// NotModified evaluates the conditional GET headers of 'r' against the current
+// representation. If-None-Match takes precedence; If-Modified-Since is only consulted
+// when the request carries no If-None-Match and 'lastModified' is known.
+func NotModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etag != "" && IfNoneMatchSatisfied(inm, etag)
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || lastModified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	// HTTP dates have second precision
+	return !lastModified.Truncate(time.Second).After(since)
+}
@@ -0,0 +1,83 @@
+// File: assignment-2/tools/etag_test.go
+package tools
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRevisionETag checks the quoting of revision entity tags.
+func TestRevisionETag(t *testing.T) {
+	if got := RevisionETag(7); got != `"7"` {
+		t.Errorf("Expected \"7\" (quoted), got %s", got)
+	}
+}
+
+// TestIfMatchSatisfied covers wildcards, lists and weak tags.
+func TestIfMatchSatisfied(t *testing.T) {
+	cases := []struct {
+		header string
+		etag   string
+		want   bool
+	}{
+		{`"3"`, `"3"`, true},
+		{`"2"`, `"3"`, false},
+		{`*`, `"3"`, true},
+		{`"1", "3"`, `"3"`, true},
+		{`W/"3"`, `"3"`, false},
+		{``, `"3"`, false},
+	}
+	for _, tc := range cases {
+		if got := IfMatchSatisfied(tc.header, tc.etag); got != tc.want {
+			t.Errorf("IfMatchSatisfied(%q, %q) = %v, expected %v", tc.header, tc.etag, got, tc.want)
+		}
+	}
+}
+
+// TestContentETag checks that equal content hashes to the same tag and different content does not.
+func TestContentETag(t *testing.T) {
+	a, err := ContentETag(map[string]int{"x": 1})
+	if err != nil {
+		t.Fatalf("ContentETag error: %v", err)
+	}
+	b, _ := ContentETag(map[string]int{"x": 1})
+	c, _ := ContentETag(map[string]int{"x": 2})
+	if a != b {
+		t.Errorf("Expected identical tags for identical content, got %s and %s", a, b)
+	}
+	if a == c {
+		t.Error("Expected different tags for different content")
+	}
+}
+
+// TestNotModified covers If-None-Match, If-Modified-Since and their precedence.
+func TestNotModified(t *testing.T) {
+	lastMod := time.Date(2025, 4, 10, 12, 0, 0, 500, time.UTC)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", `W/"abc", "def"`)
+	if !NotModified(req, `"abc"`, lastMod) {
+		t.Error("Expected weak If-None-Match match to report not modified")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", `"other"`)
+	req.Header.Set("If-Modified-Since", lastMod.Add(time.Hour).Format(http.TimeFormat))
+	if NotModified(req, `"abc"`, lastMod) {
+		t.Error("If-None-Match mismatch must win over If-Modified-Since")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-Modified-Since", lastMod.Format(http.TimeFormat))
+	if !NotModified(req, `"abc"`, lastMod) {
+		t.Error("Expected not modified when If-Modified-Since equals Last-Modified")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-Modified-Since", lastMod.Add(-time.Minute).Format(http.TimeFormat))
+	if NotModified(req, `"abc"`, lastMod) {
+		t.Error("Expected modified when the resource changed after If-Modified-Since")
+	}
+}
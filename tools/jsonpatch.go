@@ -0,0 +1,366 @@
+// File: assignment-2/tools/jsonpatch.go
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrMalformedPatch is returned when the patch document itself cannot be parsed.
+var ErrMalformedPatch = errors.New("malformed patch document")
+
+// ErrPatchTestFailed is returned by ApplyJSONPatch when a "test" operation does not match.
+var ErrPatchTestFailed = errors.New("json patch test operation failed")
+
+// MergePatch applies an RFC 7396 JSON Merge Patch to the JSON document 'doc'.
+// Members set to null in the patch are removed, objects are merged recursively,
+// and any other value (including arrays) replaces the target value.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target interface{}
+	if len(doc) > 0 {
+		if err := json.Unmarshal(doc, &target); err != nil {
+			return nil, fmt.Errorf("invalid target document: %v", err)
+		}
+	}
+	var p interface{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedPatch, err)
+	}
+	return json.Marshal(mergeValue(target, p))
+}
+
+// mergeValue is the recursive MergePatch algorithm from RFC 7396, section 2.
+func mergeValue(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+	for key, val := range patchObj {
+		if val == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergeValue(targetObj[key], val)
+	}
+	return targetObj
+}
+
+// patchOperation is a single RFC 6902 operation.
+type patchOperation struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// ApplyJSONPatch applies an RFC 6902 JSON Patch (a list of add, remove, replace,
+// move, copy and test operations) to the JSON document 'doc'. Operations are
+// applied in order and the whole patch fails if any single operation fails.
+func ApplyJSONPatch(doc, patch []byte) ([]byte, error) {
+	var ops []patchOperation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedPatch, err)
+	}
+	var root interface{}
+	if err := json.Unmarshal(doc, &root); err != nil {
+		return nil, fmt.Errorf("invalid target document: %v", err)
+	}
+
+	for i, op := range ops {
+		if op.Path == nil {
+			return nil, fmt.Errorf("%w: operation %d is missing 'path'", ErrMalformedPatch, i)
+		}
+		path, err := parsePointer(*op.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: operation %d: %v", ErrMalformedPatch, i, err)
+		}
+
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, fmt.Errorf("%w: operation %d is missing 'value'", ErrMalformedPatch, i)
+			}
+			var val interface{}
+			if err := json.Unmarshal(*op.Value, &val); err != nil {
+				return nil, fmt.Errorf("%w: operation %d has an invalid 'value': %v", ErrMalformedPatch, i, err)
+			}
+			switch op.Op {
+			case "add":
+				root, err = addValue(root, path, val)
+			case "replace":
+				if _, err = getValue(root, path); err == nil {
+					root, err = removeValue(root, path)
+				}
+				if err == nil {
+					root, err = addValue(root, path, val)
+				}
+			case "test":
+				var current interface{}
+				current, err = getValue(root, path)
+				if err == nil && !reflect.DeepEqual(current, val) {
+					err = ErrPatchTestFailed
+				}
+			}
+		case "remove":
+			root, err = removeValue(root, path)
+		case "move", "copy":
+			if op.From == nil {
+				return nil, fmt.Errorf("%w: operation %d is missing 'from'", ErrMalformedPatch, i)
+			}
+			from, perr := parsePointer(*op.From)
+			if perr != nil {
+				return nil, fmt.Errorf("%w: operation %d: %v", ErrMalformedPatch, i, perr)
+			}
+			var val interface{}
+			val, err = getValue(root, from)
+			if err == nil && op.Op == "move" {
+				if strings.HasPrefix(*op.Path+"/", *op.From+"/") && *op.Path != *op.From {
+					err = fmt.Errorf("cannot move a value into one of its children")
+				} else {
+					root, err = removeValue(root, from)
+				}
+			}
+			if err == nil {
+				root, err = addValue(root, path, deepCopy(val))
+			}
+		default:
+			return nil, fmt.Errorf("%w: operation %d has unsupported op '%s'", ErrMalformedPatch, i, op.Op)
+		}
+
+		if err != nil {
+			if errors.Is(err, ErrPatchTestFailed) {
+				return nil, fmt.Errorf("operation %d: %w", i, err)
+			}
+			return nil, fmt.Errorf("operation %d (%s %s): %v", i, op.Op, *op.Path, err)
+		}
+	}
+	return json.Marshal(root)
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped reference tokens.
+func parsePointer(ptr string) ([]string, error) {
+	if ptr == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer '%s'", ptr)
+	}
+	tokens := strings.Split(ptr[1:], "/")
+	for i, t := range tokens {
+		t = strings.ReplaceAll(t, "~1", "/")
+		tokens[i] = strings.ReplaceAll(t, "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex resolves a reference token against an array of length n.
+// When allowEnd is true the "-" token and index n (append) are accepted.
+func arrayIndex(token string, n int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return n, nil
+	}
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index '%s'", token)
+	}
+	if idx > n || (idx == n && !allowEnd) {
+		return 0, fmt.Errorf("array index %d out of bounds", idx)
+	}
+	return idx, nil
+}
+
+func getValue(node interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch cur := node.(type) {
+		case map[string]interface{}:
+			val, ok := cur[token]
+			if !ok {
+				return nil, fmt.Errorf("path member '%s' not found", token)
+			}
+			node = val
+		case []interface{}:
+			idx, err := arrayIndex(token, len(cur), false)
+			if err != nil {
+				return nil, err
+			}
+			node = cur[idx]
+		default:
+			return nil, fmt.Errorf("cannot traverse into a scalar at '%s'", token)
+		}
+	}
+	return node, nil
+}
+
+func addValue(node interface{}, path []string, val interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return val, nil
+	}
+	token := path[0]
+	switch cur := node.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			cur[token] = val
+			return cur, nil
+		}
+		child, ok := cur[token]
+		if !ok {
+			return nil, fmt.Errorf("path member '%s' not found", token)
+		}
+		updated, err := addValue(child, path[1:], val)
+		if err != nil {
+			return nil, err
+		}
+		cur[token] = updated
+		return cur, nil
+	case []interface{}:
+		if len(path) == 1 {
+			idx, err := arrayIndex(token, len(cur), true)
+			if err != nil {
+				return nil, err
+			}
+			cur = append(cur, nil)
+			copy(cur[idx+1:], cur[idx:])
+			cur[idx] = val
+			return cur, nil
+		}
+		idx, err := arrayIndex(token, len(cur), false)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := addValue(cur[idx], path[1:], val)
+		if err != nil {
+			return nil, err
+		}
+		cur[idx] = updated
+		return cur, nil
+	default:
+		return nil, fmt.Errorf("cannot add into a scalar at '%s'", token)
+	}
+}
+
+func removeValue(node interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("cannot remove the document root")
+	}
+	token := path[0]
+	switch cur := node.(type) {
+	case map[string]interface{}:
+		child, ok := cur[token]
+		if !ok {
+			return nil, fmt.Errorf("path member '%s' not found", token)
+		}
+		if len(path) == 1 {
+			delete(cur, token)
+			return cur, nil
+		}
+		updated, err := removeValue(child, path[1:])
+		if err != nil {
+			return nil, err
+		}
+		cur[token] = updated
+		return cur, nil
+	case []interface{}:
+		idx, err := arrayIndex(token, len(cur), false)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 1 {
+			return append(cur[:idx], cur[idx+1:]...), nil
+		}
+		updated, err := removeValue(cur[idx], path[1:])
+		if err != nil {
+			return nil, err
+		}
+		cur[idx] = updated
+		return cur, nil
+	default:
+		return nil, fmt.Errorf("cannot remove from a scalar at '%s'", token)
+	}
+}
+
+// deepCopy clones a decoded JSON value so that "copy" does not alias the source.
+func deepCopy(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			out[k] = deepCopy(child)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, child := range v {
+			out[i] = deepCopy(child)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// DiffJSON returns an RFC 6902 JSON Patch that turns the JSON document 'from' into 'to'.
+// Objects are compared member by member (in sorted key order); any other differing
+// value, including arrays, is replaced as a whole. Applying the result with
+// ApplyJSONPatch to 'from' yields 'to'.
+func DiffJSON(from, to []byte) ([]byte, error) {
+	var a, b interface{}
+	if err := json.Unmarshal(from, &a); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(to, &b); err != nil {
+		return nil, err
+	}
+	ops := diffValue("", a, b, []map[string]interface{}{})
+	return json.Marshal(ops)
+}
+
+// diffValue appends the operations turning 'a' into 'b' at pointer 'path' to 'ops'.
+func diffValue(path string, a, b interface{}, ops []map[string]interface{}) []map[string]interface{} {
+	objA, okA := a.(map[string]interface{})
+	objB, okB := b.(map[string]interface{})
+	if !okA || !okB {
+		if !reflect.DeepEqual(a, b) {
+			ops = append(ops, map[string]interface{}{"op": "replace", "path": path, "value": b})
+		}
+		return ops
+	}
+
+	keys := make([]string, 0, len(objA)+len(objB))
+	for k := range objA {
+		keys = append(keys, k)
+	}
+	for k := range objB {
+		if _, ok := objA[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		child := path + "/" + escapePointerToken(k)
+		va, inA := objA[k]
+		vb, inB := objB[k]
+		switch {
+		case !inB:
+			ops = append(ops, map[string]interface{}{"op": "remove", "path": child})
+		case !inA:
+			ops = append(ops, map[string]interface{}{"op": "add", "path": child, "value": vb})
+		default:
+			ops = diffValue(child, va, vb, ops)
+		}
+	}
+	return ops
+}
+
+// escapePointerToken escapes a member name for use in an RFC 6901 JSON Pointer.
+func escapePointerToken(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
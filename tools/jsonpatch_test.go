@@ -0,0 +1,157 @@
+// File: assignment-2/tools/jsonpatch_test.go
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// jsonEqual compares two JSON documents semantically.
+func jsonEqual(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("Result is not valid JSON: %v", err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatalf("Expected value is not valid JSON: %v", err)
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("JSON mismatch.\nExpected: %s\nGot:      %s", want, got)
+	}
+}
+
+// TestMergePatch covers the RFC 7396 examples that matter for registrations.
+func TestMergePatch(t *testing.T) {
+	doc := []byte(`{"country":"Norway","features":{"temperature":true,"capital":true,"targetCurrencies":["EUR"]}}`)
+
+	t.Run("OmittedMembersAreKept", func(t *testing.T) {
+		out, err := MergePatch(doc, []byte(`{"features":{"capital":false}}`))
+		if err != nil {
+			t.Fatalf("MergePatch error: %v", err)
+		}
+		jsonEqual(t, out, `{"country":"Norway","features":{"temperature":true,"capital":false,"targetCurrencies":["EUR"]}}`)
+	})
+
+	t.Run("NullRemovesMember", func(t *testing.T) {
+		out, err := MergePatch(doc, []byte(`{"features":{"targetCurrencies":null}}`))
+		if err != nil {
+			t.Fatalf("MergePatch error: %v", err)
+		}
+		jsonEqual(t, out, `{"country":"Norway","features":{"temperature":true,"capital":true}}`)
+	})
+
+	t.Run("ArraysAreReplaced", func(t *testing.T) {
+		out, err := MergePatch(doc, []byte(`{"features":{"targetCurrencies":[]}}`))
+		if err != nil {
+			t.Fatalf("MergePatch error: %v", err)
+		}
+		jsonEqual(t, out, `{"country":"Norway","features":{"temperature":true,"capital":true,"targetCurrencies":[]}}`)
+	})
+
+	t.Run("InvalidPatch", func(t *testing.T) {
+		if _, err := MergePatch(doc, []byte(`{"features":`)); !errors.Is(err, ErrMalformedPatch) {
+			t.Errorf("Expected ErrMalformedPatch, got %v", err)
+		}
+	})
+}
+
+// TestApplyJSONPatch checks each RFC 6902 operation and the error paths.
+func TestApplyJSONPatch(t *testing.T) {
+	doc := []byte(`{"country":"Norway","features":{"temperature":true,"targetCurrencies":["EUR","USD"]}}`)
+
+	cases := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{"Add", `[{"op":"add","path":"/isoCode","value":"NO"}]`,
+			`{"country":"Norway","isoCode":"NO","features":{"temperature":true,"targetCurrencies":["EUR","USD"]}}`},
+		{"AddArrayEnd", `[{"op":"add","path":"/features/targetCurrencies/-","value":"SEK"}]`,
+			`{"country":"Norway","features":{"temperature":true,"targetCurrencies":["EUR","USD","SEK"]}}`},
+		{"AddArrayIndex", `[{"op":"add","path":"/features/targetCurrencies/0","value":"SEK"}]`,
+			`{"country":"Norway","features":{"temperature":true,"targetCurrencies":["SEK","EUR","USD"]}}`},
+		{"Remove", `[{"op":"remove","path":"/features/targetCurrencies/1"}]`,
+			`{"country":"Norway","features":{"temperature":true,"targetCurrencies":["EUR"]}}`},
+		{"Replace", `[{"op":"replace","path":"/features/temperature","value":false}]`,
+			`{"country":"Norway","features":{"temperature":false,"targetCurrencies":["EUR","USD"]}}`},
+		{"Move", `[{"op":"move","from":"/country","path":"/isoCode"}]`,
+			`{"isoCode":"Norway","features":{"temperature":true,"targetCurrencies":["EUR","USD"]}}`},
+		{"Copy", `[{"op":"copy","from":"/country","path":"/isoCode"}]`,
+			`{"country":"Norway","isoCode":"Norway","features":{"temperature":true,"targetCurrencies":["EUR","USD"]}}`},
+		{"TestThenReplace", `[{"op":"test","path":"/country","value":"Norway"},{"op":"replace","path":"/country","value":"Sweden"}]`,
+			`{"country":"Sweden","features":{"temperature":true,"targetCurrencies":["EUR","USD"]}}`},
+		{"EscapedPointer", `[{"op":"add","path":"/a~1b","value":1}]`,
+			`{"country":"Norway","a/b":1,"features":{"temperature":true,"targetCurrencies":["EUR","USD"]}}`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := ApplyJSONPatch(doc, []byte(tc.patch))
+			if err != nil {
+				t.Fatalf("ApplyJSONPatch error: %v", err)
+			}
+			jsonEqual(t, out, tc.want)
+		})
+	}
+
+	t.Run("TestFails", func(t *testing.T) {
+		_, err := ApplyJSONPatch(doc, []byte(`[{"op":"test","path":"/country","value":"Sweden"}]`))
+		if !errors.Is(err, ErrPatchTestFailed) {
+			t.Errorf("Expected ErrPatchTestFailed, got %v", err)
+		}
+	})
+
+	t.Run("ReplaceMissingPath", func(t *testing.T) {
+		if _, err := ApplyJSONPatch(doc, []byte(`[{"op":"replace","path":"/missing","value":1}]`)); err == nil {
+			t.Error("Expected error replacing a missing member, got nil")
+		}
+	})
+
+	t.Run("UnknownOp", func(t *testing.T) {
+		if _, err := ApplyJSONPatch(doc, []byte(`[{"op":"frobnicate","path":"/country"}]`)); !errors.Is(err, ErrMalformedPatch) {
+			t.Errorf("Expected ErrMalformedPatch for unknown op, got %v", err)
+		}
+	})
+
+	t.Run("NotAnArray", func(t *testing.T) {
+		if _, err := ApplyJSONPatch(doc, []byte(`{"op":"add"}`)); err == nil {
+			t.Error("Expected error when patch is not an array, got nil")
+		}
+	})
+}
+
+// TestDiffJSON checks that the generated patch transforms the first document into the second.
+func TestDiffJSON(t *testing.T) {
+	from := []byte(`{"country":"Norway","features":{"capital":true,"area":false,"targetCurrencies":["EUR"]},"a/b":1}`)
+	to := []byte(`{"country":"Norway","features":{"capital":false,"area":false,"targetCurrencies":["EUR","USD"]},"isoCode":"NO"}`)
+
+	patch, err := DiffJSON(from, to)
+	if err != nil {
+		t.Fatalf("DiffJSON error: %v", err)
+	}
+	want := `[{"op":"remove","path":"/a~1b"},` +
+		`{"op":"replace","path":"/features/capital","value":false},` +
+		`{"op":"replace","path":"/features/targetCurrencies","value":["EUR","USD"]},` +
+		`{"op":"add","path":"/isoCode","value":"NO"}]`
+	if string(patch) != want {
+		t.Errorf("Unexpected patch:\n got  %s\n want %s", patch, want)
+	}
+
+	applied, err := ApplyJSONPatch(from, patch)
+	if err != nil {
+		t.Fatalf("ApplyJSONPatch error: %v", err)
+	}
+	var got, expected interface{}
+	json.Unmarshal(applied, &got)
+	json.Unmarshal(to, &expected)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Patched document differs:\n got  %s\n want %s", applied, to)
+	}
+
+	same, _ := DiffJSON(from, from)
+	if string(same) != "[]" {
+		t.Errorf("Expected an empty patch for equal documents, got %s", same)
+	}
+}
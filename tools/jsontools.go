@@ -26,3 +26,27 @@ func WriteJsonErrorResponse(w http.ResponseWriter, statusCode int, errMsg string
 	resp := map[string]string{"error": errMsg}
 	json.NewEncoder(w).Encode(resp) // Encode this map to JSON and include it in the response body.
 }
+
+// SelectFields converts 'data' to its JSON object form and keeps only the listed top-level
+// keys. A nil 'fields' keeps every key. Keys that are absent (e.g. omitted empty values)
+// are simply not present in the result.
+func SelectFields(data interface{}, fields []string) (map[string]interface{}, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		return obj, nil
+	}
+	selected := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		if v, ok := obj[f]; ok {
+			selected[f] = v
+		}
+	}
+	return selected, nil
+}
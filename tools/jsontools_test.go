@@ -135,3 +135,25 @@ func TestWriteJsonErrorResponse(t *testing.T) {
 		}
 	})
 }
+
+// TestSelectFields checks that only the requested keys survive the projection.
+func TestSelectFields(t *testing.T) {
+	data := struct {
+		ID      string `json:"id"`
+		Country string `json:"country"`
+		Empty   string `json:"empty,omitempty"`
+	}{ID: "abc", Country: "Norway"}
+
+	all, err := SelectFields(data, nil)
+	if err != nil {
+		t.Fatalf("SelectFields error: %v", err)
+	}
+	if len(all) != 2 {
+		t.Errorf("Expected all (non-omitted) keys, got %v", all)
+	}
+
+	some, _ := SelectFields(data, []string{"id", "empty"})
+	if !reflect.DeepEqual(some, map[string]interface{}{"id": "abc"}) {
+		t.Errorf("Expected only the id key, got %v", some)
+	}
+}
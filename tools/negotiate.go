@@ -0,0 +1,99 @@
+// File: assignment-2/tools/negotiate.go
+package tools
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrUnknownFormat is returned when ?format= names a format the endpoint does not offer.
+var ErrUnknownFormat = errors.New("unknown format")
+
+// ErrNotAcceptable is returned when none of the offered formats matches the Accept header.
+var ErrNotAcceptable = errors.New("no acceptable format")
+
+// formatMediaTypes maps the format names used with ?format= to their media types.
+var formatMediaTypes = map[string][]string{
+	"json":   {"application/json"},
+	"ndjson": {"application/x-ndjson"},
+	"csv":    {"text/csv"},
+	"xml":    {"application/xml", "text/xml"},
+	"html":   {"text/html"},
+}
+
+// NegotiateFormat picks the response format from 'offered' (format names such as "json"
+// or "csv"; the first one is the default). A ?format= parameter overrides the Accept
+// header. Accept entries are tried by descending quality, and wildcards select the first
+// offered format that matches them.
+func NegotiateFormat(r *http.Request, offered ...string) (string, error) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		for _, o := range offered {
+			if o == f {
+				return f, nil
+			}
+		}
+		return "", ErrUnknownFormat
+	}
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return offered[0], nil
+	}
+
+	type acceptEntry struct {
+		mediaType string
+		quality   float64
+	}
+	var entries []acceptEntry
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality > 0 {
+			entries = append(entries, acceptEntry{mediaType, quality})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].quality > entries[j].quality })
+
+	for _, e := range entries {
+		for _, o := range offered {
+			for _, mediaType := range formatMediaTypes[o] {
+				if mediaTypeMatches(e.mediaType, mediaType) {
+					return o, nil
+				}
+			}
+		}
+	}
+	return "", ErrNotAcceptable
+}
+
+// mediaTypeMatches reports whether the Accept range 'pattern' (e.g. "text/*") covers 'mediaType'.
+func mediaTypeMatches(pattern, mediaType string) bool {
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(pattern, "/*")
+	return ok && strings.HasPrefix(mediaType, prefix+"/")
+}
+
+// ContentTypeOf returns the Content-Type header value for a format name.
+func ContentTypeOf(format string) string {
+	types, ok := formatMediaTypes[format]
+	if !ok {
+		return "application/octet-stream"
+	}
+	if strings.HasPrefix(types[0], "text/") {
+		return types[0] + "; charset=utf-8"
+	}
+	return types[0]
+}
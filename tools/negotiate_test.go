@@ -0,0 +1,49 @@
+// File: assignment-2/tools/negotiate_test.go
+package tools
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestNegotiateFormat checks the format override, quality ordering and wildcards.
+func TestNegotiateFormat(t *testing.T) {
+	offered := []string{"json", "csv", "xml", "html"}
+	cases := []struct {
+		name, target, accept, want string
+		err                        error
+	}{
+		{"NoAccept", "/", "", "json", nil},
+		{"Exact", "/", "text/csv", "csv", nil},
+		{"TextXML", "/", "text/xml", "xml", nil},
+		{"Browser", "/", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "html", nil},
+		{"Quality", "/", "text/html;q=0.5, application/xml", "xml", nil},
+		{"Wildcard", "/", "*/*", "json", nil},
+		{"TypeWildcard", "/", "text/*", "csv", nil},
+		{"FormatOverridesAccept", "/?format=xml", "text/html", "xml", nil},
+		{"UnknownFormat", "/?format=pdf", "", "", ErrUnknownFormat},
+		{"NotAcceptable", "/", "application/pdf", "", ErrNotAcceptable},
+		{"ZeroQuality", "/", "text/csv;q=0", "", ErrNotAcceptable},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", tc.target, nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		got, err := NegotiateFormat(req, offered...)
+		if got != tc.want || !errors.Is(err, tc.err) {
+			t.Errorf("%s: expected %q %v, got %q %v", tc.name, tc.want, tc.err, got, err)
+		}
+	}
+}
+
+// TestContentTypeOf checks the Content-Type of each format.
+func TestContentTypeOf(t *testing.T) {
+	if got := ContentTypeOf("csv"); got != "text/csv; charset=utf-8" {
+		t.Errorf("Unexpected CSV content type %q", got)
+	}
+	if got := ContentTypeOf("xml"); got != "application/xml" {
+		t.Errorf("Unexpected XML content type %q", got)
+	}
+}
@@ -0,0 +1,228 @@
+// File: assignment-2/tools/urlpolicy.go
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrURLNotAllowed is returned for URLs that the URL policy does not allow.
+var ErrURLNotAllowed = errors.New("URL not allowed")
+
+// reservedNets are the address ranges outgoing requests may not reach unless they are
+// allowed explicitly: loopback, private (RFC 1918 and unique local), link-local (which
+// includes cloud metadata endpoints), shared, unspecified, multicast and broadcast.
+var reservedNets = mustParseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4",
+	"240.0.0.0/4", "::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+// URLPolicy decides which URLs outgoing requests may target. Hosts and addresses on the
+// allow list are always allowed; otherwise hosts on the deny list, "localhost" and
+// addresses in a deny-listed or reserved range are refused.
+type URLPolicy struct {
+	schemes    map[string]bool
+	allowHosts []string // host names, "*.example.com" matches every subdomain
+	allowNets  []*net.IPNet
+	denyHosts  []string
+	denyNets   []*net.IPNet
+	resolver   *net.Resolver
+}
+
+// NewURLPolicy builds a policy allowing the URL schemes in 'schemes'. The entries of
+// 'allow' and 'deny' are host names, IP addresses or CIDR ranges.
+func NewURLPolicy(schemes, allow, deny []string) (*URLPolicy, error) {
+	p := &URLPolicy{schemes: make(map[string]bool), resolver: net.DefaultResolver}
+	for _, s := range schemes {
+		p.schemes[strings.ToLower(s)] = true
+	}
+	var err error
+	if p.allowHosts, p.allowNets, err = parseHostList(allow); err != nil {
+		return nil, err
+	}
+	if p.denyHosts, p.denyNets, err = parseHostList(deny); err != nil {
+		return nil, err
+	}
+	p.denyHosts = append(p.denyHosts, "localhost", "*.localhost")
+	return p, nil
+}
+
+// parseHostList splits a list of host names, IP addresses and CIDR ranges.
+func parseHostList(entries []string) ([]string, []*net.IPNet, error) {
+	var hosts []string
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+		case strings.Contains(entry, "/"):
+			_, n, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid CIDR range %q", entry)
+			}
+			nets = append(nets, n)
+		case net.ParseIP(entry) != nil:
+			ip := net.ParseIP(entry)
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		default:
+			hosts = append(hosts, entry)
+		}
+	}
+	return hosts, nets, nil
+}
+
+// mustParseCIDRs parses built-in CIDR ranges.
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// matchHost reports whether 'host' matches one of the host name patterns.
+func matchHost(patterns []string, host string) bool {
+	for _, p := range patterns {
+		if p == host || (strings.HasPrefix(p, "*.") && strings.HasSuffix(host, p[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsIP reports whether one of the ranges contains 'ip'.
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHost applies the host name rules. It reports whether the host is allowed by name,
+// in which case its addresses are not checked.
+func (p *URLPolicy) checkHost(host string) (bool, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if matchHost(p.allowHosts, host) {
+		return true, nil
+	}
+	if matchHost(p.denyHosts, host) {
+		return false, fmt.Errorf("%w: host %s is denied", ErrURLNotAllowed, host)
+	}
+	return false, nil
+}
+
+// CheckIP returns an error if requests may not connect to 'ip'.
+func (p *URLPolicy) CheckIP(ip net.IP) error {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if containsIP(p.allowNets, ip) {
+		return nil
+	}
+	if containsIP(p.denyNets, ip) {
+		return fmt.Errorf("%w: address %s is denied", ErrURLNotAllowed, ip)
+	}
+	if containsIP(reservedNets, ip) {
+		return fmt.Errorf("%w: address %s is private or reserved", ErrURLNotAllowed, ip)
+	}
+	return nil
+}
+
+// CheckURL returns an error if requests may not target 'raw'. Host names are resolved
+// and every address is checked. A name that does not resolve passes, since the dialer
+// checks the address it actually connects to anyway.
+func (p *URLPolicy) CheckURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Hostname() == "" {
+		return fmt.Errorf("%w: %q is not an absolute URL", ErrURLNotAllowed, raw)
+	}
+	if !p.schemes[strings.ToLower(u.Scheme)] {
+		return fmt.Errorf("%w: scheme %q is not allowed", ErrURLNotAllowed, u.Scheme)
+	}
+	host := u.Hostname()
+	if allowed, err := p.checkHost(host); allowed || err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return p.CheckIP(ip)
+	}
+	addrs, err := p.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if err := p.CheckIP(addr.IP); err != nil {
+			return fmt.Errorf("%w (resolved from %s)", err, host)
+		}
+	}
+	return nil
+}
+
+// DialContext connects like net.Dialer, but checks the address after the host name has
+// been resolved, right before connecting. A host that resolves to a public address when
+// it is registered and to a private one later (DNS rebinding) is refused.
+func (p *URLPolicy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := p.checkHost(host)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !allowed {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("%w: cannot check address %s", ErrURLNotAllowed, address)
+			}
+			return p.CheckIP(ip)
+		}
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// Client returns an HTTP client that only connects to addresses the policy allows. It
+// uses no proxy, follows at most 'maxRedirects' redirects and checks every redirect
+// target like the original URL.
+func (p *URLPolicy) Client(timeout time.Duration, maxRedirects int) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         p.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return p.CheckURL(req.Context(), req.URL.String())
+		},
+	}
+}
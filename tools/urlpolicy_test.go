@@ -0,0 +1,118 @@
+// File: assignment-2/tools/urlpolicy_test.go
+package tools
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestURLPolicyCheckURL checks schemes, host names and address ranges.
+func TestURLPolicyCheckURL(t *testing.T) {
+	p, err := NewURLPolicy([]string{"https", "http"}, []string{"10.1.2.0/24", "*.intranet.example", "fd00::1"},
+		[]string{"blocked.example", "203.0.113.0/24"})
+	if err != nil {
+		t.Fatalf("NewURLPolicy failed: %v", err)
+	}
+	allowed := []string{
+		"https://93.184.215.14/hook",
+		"http://[2606:4700::1111]:8080/hook",
+		"https://10.1.2.3/hook",             // allow-listed range
+		"https://hooks.intranet.example/x",  // allow-listed domain
+		"https://[fd00::1]/hook",            // allow-listed address
+		"https://does-not-resolve.invalid/", // checked again when dialing
+	}
+	for _, u := range allowed {
+		if err := p.CheckURL(context.Background(), u); err != nil {
+			t.Errorf("Expected %s to be allowed, got %v", u, err)
+		}
+	}
+	denied := []string{
+		"ftp://93.184.215.14/hook",
+		"file:///etc/passwd",
+		"/relative/hook",
+		"https://localhost:8080/hook",
+		"https://api.localhost/hook",
+		"https://blocked.example/hook",
+		"https://127.0.0.1/hook",
+		"https://[::1]/hook",
+		"https://[::ffff:127.0.0.1]/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"https://10.0.0.5/hook",
+		"https://172.16.8.1/hook",
+		"https://192.168.1.1/hook",
+		"https://[fe80::1]/hook",
+		"https://0.0.0.0/hook",
+		"https://203.0.113.7/hook", // deny-listed range
+	}
+	for _, u := range denied {
+		if err := p.CheckURL(context.Background(), u); !errors.Is(err, ErrURLNotAllowed) {
+			t.Errorf("Expected %s to be denied, got %v", u, err)
+		}
+	}
+
+	if _, err := NewURLPolicy(nil, []string{"10.0.0.0/33"}, nil); err == nil {
+		t.Error("Expected an invalid CIDR range to be rejected")
+	}
+}
+
+// TestURLPolicyClient checks that the client refuses to connect to a loopback server,
+// even through a redirect, unless loopback is allowed, and limits redirects.
+func TestURLPolicyClient(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	hops := 0
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hops++
+		http.Redirect(w, r, r.URL.Path+"x", http.StatusFound)
+	}))
+	defer redirector.Close()
+
+	strict, _ := NewURLPolicy([]string{"http"}, nil, nil)
+	resp, err := strict.Client(time.Second, 3).Get(target.URL)
+	if err == nil {
+		resp.Body.Close()
+	}
+	if !errors.Is(err, ErrURLNotAllowed) {
+		t.Errorf("Expected the dialer to refuse the loopback address, got %v", err)
+	}
+
+	loopback, _ := NewURLPolicy([]string{"http"}, []string{"127.0.0.0/8"}, nil)
+	client := loopback.Client(time.Second, 3)
+	resp, err = client.Get(target.URL)
+	if err != nil {
+		t.Fatalf("Expected an allowed loopback address to connect, got %v", err)
+	}
+	resp.Body.Close()
+
+	_, err = client.Get(redirector.URL + "/")
+	if err == nil || !strings.Contains(err.Error(), "stopped after 3 redirects") || hops != 4 {
+		t.Errorf("Expected the redirects to stop after 3, got %v after %d hops", err, hops)
+	}
+
+	// A redirect from an allowed host to a denied one is refused
+	onlyRedirector, _ := NewURLPolicy([]string{"http"}, []string{"127.0.0.1"}, []string{"localhost"})
+	away := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://localhost/", http.StatusFound)
+	}))
+	defer away.Close()
+	if _, err := onlyRedirector.Client(time.Second, 3).Get(away.URL); !errors.Is(err, ErrURLNotAllowed) {
+		t.Errorf("Expected the redirect to a denied host to be refused, got %v", err)
+	}
+}
+
+// TestURLPolicyCheckIP checks IPv4-mapped addresses and the allow list precedence.
+func TestURLPolicyCheckIP(t *testing.T) {
+	p, _ := NewURLPolicy(nil, []string{"192.168.5.5"}, nil)
+	if err := p.CheckIP(net.ParseIP("::ffff:192.168.5.5")); err != nil {
+		t.Errorf("Expected the allow-listed address to pass, got %v", err)
+	}
+	if err := p.CheckIP(net.ParseIP("192.168.5.6")); err == nil {
+		t.Error("Expected a private address outside the allow list to be denied")
+	}
+}